@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Account statuses. Locked is an admin lock, which may be timed; suspended
+// holds an account pending review until an admin lifts it; deleted marks a
+// soft-deleted or anonymized account. The automatic lockout after failed
+// logins is not a status, as it clears itself.
+const (
+	UserStatusActive    = middleware.AccountActive
+	UserStatusSuspended = middleware.AccountSuspended
+	UserStatusLocked    = middleware.AccountLocked
+	UserStatusDeleted   = "deleted"
+)
+
+// userStatusTransitions lists the statuses each status may move to. A lock
+// can be replaced, for instance to change its duration, but a suspension is
+// only lifted or followed by deletion, and a deleted account is only
+// restored.
+var userStatusTransitions = map[string][]string{
+	UserStatusActive:    {UserStatusSuspended, UserStatusLocked, UserStatusDeleted},
+	UserStatusLocked:    {UserStatusActive, UserStatusLocked, UserStatusSuspended, UserStatusDeleted},
+	UserStatusSuspended: {UserStatusActive, UserStatusDeleted},
+	UserStatusDeleted:   {UserStatusActive},
+}
+
+// statusTransitionError is a status change the account's current status
+// doesn't allow
+type statusTransitionError struct {
+	From, To string
+}
+
+func (e *statusTransitionError) Error() string {
+	return fmt.Sprintf("cannot change account status from %s to %s", e.From, e.To)
+}
+
+// CurrentStatus is the account's status, reading a timed admin lock that has
+// run out as active
+func (u *User) CurrentStatus() string {
+	switch {
+	case u.Status == "":
+		return UserStatusActive
+	case u.Status == UserStatusLocked && !u.IsAdminLocked():
+		return UserStatusActive
+	}
+	return u.Status
+}
+
+// IsActive reports whether the account may log in and act
+func (u *User) IsActive() bool {
+	return u.CurrentStatus() == UserStatusActive
+}
+
+// setUserStatus moves user to status, applying fields in the same update,
+// once the transition has been checked against userStatusTransitions
+func setUserStatus(tx *gorm.DB, user *User, status string, fields map[string]interface{}) error {
+	from := user.CurrentStatus()
+	allowed := false
+	for _, to := range userStatusTransitions[from] {
+		allowed = allowed || to == status
+	}
+	if !allowed {
+		return &statusTransitionError{From: from, To: status}
+	}
+	fields["status"] = status
+	return tx.Model(user).Updates(fields).Error
+}
+
+// respondInactiveAccount refuses a login or refresh for an account that may
+// not act, with an error naming its status
+func respondInactiveAccount(c *gin.Context, user *User) {
+	middleware.RespondInactiveAccount(c, middleware.AccountState{
+		Exists:      true,
+		Status:      user.CurrentStatus(),
+		LockedUntil: user.AdminLockedUntil,
+	})
+}
+
+// inactiveLoginOutcome is the login event outcome for an account refused by
+// respondInactiveAccount
+func inactiveLoginOutcome(user *User) string {
+	if user.CurrentStatus() == UserStatusSuspended {
+		return LoginOutcomeSuspended
+	}
+	return LoginOutcomeAdminLocked
+}
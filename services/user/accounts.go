@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type RestoreAccountRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+func accountDeletionGracePeriod() time.Duration {
+	return getEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour)
+}
+
+// Account deletion strategies
+const (
+	// DeletionPurge soft-deletes the account; it can be restored during the
+	// grace period and is then hard-deleted along with its addresses
+	DeletionPurge = "purge"
+	// DeletionAnonymize immediately replaces personal data with placeholders,
+	// keeping the row's id and created_at. It cannot be restored.
+	DeletionAnonymize = "anonymize"
+)
+
+// deletionStrategy returns the ?strategy= of a delete request, falling back
+// to ACCOUNT_DELETION_STRATEGY
+func deletionStrategy(c *gin.Context) (string, bool) {
+	strategy := c.DefaultQuery("strategy", getEnv("ACCOUNT_DELETION_STRATEGY", DeletionPurge))
+	return strategy, strategy == DeletionPurge || strategy == DeletionAnonymize
+}
+
+// anonymizedFirstName and anonymizedLastName replace the names of anonymized accounts
+const (
+	anonymizedFirstName = "Deleted"
+	anonymizedLastName  = "User"
+)
+
+// anonymizeAccount strips personal data from the user, their addresses and
+// login history, and marks the account deleted. The email and username are
+// nulled so they can be registered again, and the password hash is cleared so
+// the account can never sign in.
+func anonymizeAccount(tx *gorm.DB, user *User) error {
+	now := time.Now()
+	if err := setUserStatus(tx, user, UserStatusDeleted, map[string]interface{}{
+		"email":                         nil,
+		"username":                      nil,
+		"password":                      "",
+		"first_name":                    anonymizedFirstName,
+		"last_name":                     anonymizedLastName,
+		"phone_number":                  "",
+		"phone_verified":                false,
+		"phone_verification_code_hash":  "",
+		"phone_verification_expires_at": nil,
+		"date_of_birth":                 nil,
+		"profile_picture":               "",
+		"avatar_key":                    "",
+		"bio":                           "",
+		"password_reset_token_hash":     "",
+		"reset_token_expires_at":        nil,
+		"verification_token":            "",
+		"pending_email":                 "",
+		"email_change_token_hash":       "",
+		"email_change_expires_at":       nil,
+		"totp_secret":                   "",
+		"two_factor_enabled":            false,
+		"anonymized_at":                 now,
+		"deleted_at":                    now,
+	}); err != nil {
+		return err
+	}
+	// Addresses deleted earlier are still restorable, so they are covered too
+	if err := tx.Unscoped().Model(&Address{}).Where("user_id = ?", user.ID).Updates(map[string]interface{}{
+		"street":      "",
+		"postal_code": "",
+		"deleted_at":  gorm.Expr("COALESCE(deleted_at, ?)", now),
+	}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&LoginEvent{}).Where("user_id = ?", user.ID).Updates(map[string]interface{}{
+		"identifier": "",
+		"ip_address": "",
+		"user_agent": "",
+	}).Error; err != nil {
+		return err
+	}
+	// Sessions record IP addresses and user agents
+	if err := tx.Where("user_id = ?", user.ID).Delete(&RefreshToken{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&UserEmail{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("user_id = ?", user.ID).Delete(&UserPreferences{}).Error
+}
+
+// accountStore answers whether a user still has a live (not soft-deleted)
+// account, and what its status is
+type accountStore struct {
+	db *gorm.DB
+}
+
+func (s *accountStore) AccountState(ctx context.Context, userID string) (middleware.AccountState, error) {
+	var user User
+	err := retryRead(ctx, func() error {
+		return s.db.WithContext(ctx).
+			Select("id", "status", "admin_locked_at", "admin_locked_until").
+			Where("id = ?", userID).
+			Limit(1).
+			Find(&user).Error
+	})
+	if err != nil || user.ID == uuid.Nil {
+		return middleware.AccountState{}, err
+	}
+	return middleware.AccountState{Exists: true, Status: user.CurrentStatus(), LockedUntil: user.AdminLockedUntil}, nil
+}
+
+// RestoreAccount undoes a soft delete within the grace period. A deleted user
+// can no longer log in, so the request is authenticated with the password.
+func RestoreAccount(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req RestoreAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		var user User
+		if err := db.Unscoped().
+			Where("email = ? AND deleted_at IS NOT NULL", normalizeEmail(req.Email)).
+			First(&user).Error; err != nil {
+			respondLookupError(c, err, "ACCOUNT_NOT_FOUND", "No deleted account found", "Database error")
+			return
+		}
+
+		if err := user.ComparePassword(req.Password); err != nil {
+			respondError(c, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Invalid credentials")
+			return
+		}
+
+		if time.Since(user.DeletedAt.Time) > accountDeletionGracePeriod() {
+			respondError(c, http.StatusGone, "RESTORE_WINDOW_EXPIRED", "Restore window has expired")
+			return
+		}
+
+		if err := setUserStatus(db.Unscoped(), &user, UserStatusActive, map[string]interface{}{"deleted_at": nil}); err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to restore account")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Account restored successfully"})
+	}
+}
+
+// purgeDeletedAccounts hard-deletes accounts whose grace period has elapsed,
+// together with their addresses and tokens
+func purgeDeletedAccounts(ctx context.Context, db *gorm.DB) (int64, error) {
+	cutoff := time.Now().Add(-accountDeletionGracePeriod())
+	var purged int64
+	err := WithTransaction(ctx, db, func(tx *gorm.DB) error {
+		// Anonymized accounts are kept for good
+		expired := tx.Unscoped().Model(&User{}).
+			Select("id").
+			Where("deleted_at IS NOT NULL AND deleted_at < ? AND anonymized_at IS NULL", cutoff)
+		var err error
+		purged, err = hardDeleteAccounts(tx, expired)
+		return err
+	})
+	return purged, err
+}
+
+// hardDeleteAccounts removes the users whose ids match (a slice or a
+// subquery) along with the rows that reference them
+func hardDeleteAccounts(tx *gorm.DB, ids interface{}) (int64, error) {
+	if err := tx.Unscoped().Where("user_id IN (?)", ids).Delete(&Address{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("user_id IN (?)", ids).Delete(&RefreshToken{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("user_id IN (?)", ids).Delete(&UserPreferences{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("user_id IN (?)", ids).Delete(&UserEmail{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("user_id IN (?)", ids).Delete(&RecoveryCode{}).Error; err != nil {
+		return 0, err
+	}
+	// Login history holds IP addresses and user agents
+	if err := tx.Where("user_id IN (?)", ids).Delete(&LoginEvent{}).Error; err != nil {
+		return 0, err
+	}
+	result := tx.Unscoped().Where("id IN (?)", ids).Delete(&User{})
+	return result.RowsAffected, result.Error
+}
+
+// startAccountPurge runs purgeDeletedAccounts on a ticker until ctx is cancelled
+func startAccountPurge(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("account-purge", defaultHeartbeatMaxAge(interval))
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("account-purge")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("account-purge")
+				if !leadership.Runs("account-purge") {
+					continue
+				}
+				purged, err := purgeDeletedAccounts(ctx, db)
+				if err != nil {
+					zap.L().Error("Failed to purge deleted accounts", zap.Error(err))
+				} else if purged > 0 {
+					zap.L().Info("Purged deleted accounts", zap.Int64("count", purged))
+				}
+			}
+		}
+	}()
+}
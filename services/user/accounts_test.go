@@ -0,0 +1,28 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHardDeleteAccountsRemovesDependentRows(t *testing.T) {
+	db, fake := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		if strings.HasPrefix(query, `DELETE FROM "users"`) {
+			return fakeResult{affected: 1}
+		}
+		return fakeResult{}
+	})
+
+	deleted, err := hardDeleteAccounts(db, []uuid.UUID{uuid.New()})
+	if err != nil || deleted != 1 {
+		t.Fatalf("hardDeleteAccounts = %d, %v; want 1, nil", deleted, err)
+	}
+	for _, table := range []string{"addresses", "refresh_tokens", "user_preferences", "user_emails", "recovery_codes", "login_events", "users"} {
+		if !fake.executed(`DELETE FROM "` + table + `"`) {
+			t.Errorf("rows in %s were not deleted", table)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	bulkStatusCreated  = "created"
+	bulkStatusInvalid  = "invalid"
+	bulkStatusFailed   = "failed"
+	bulkStatusSkipped  = "skipped"
+	bulkStatusDeleted  = "deleted"
+	bulkStatusRestored = "restored"
+	bulkStatusNotFound = "not_found"
+)
+
+var errUnknownAddresses = errors.New("addresses not found")
+
+// BulkAddressResult reports the outcome for one entry of a bulk import
+type BulkAddressResult struct {
+	Index   int          `json:"index"`
+	Status  string       `json:"status"`
+	Address *Address     `json:"address,omitempty"`
+	Errors  []FieldError `json:"errors,omitempty"`
+}
+
+func maxBulkAddresses() int {
+	return getEnvInt("ADDRESS_BULK_MAX", 100)
+}
+
+// BulkAddAddresses inserts many addresses at once. By default the batch is
+// atomic: any invalid entry or insert failure rejects the whole batch. With
+// ?mode=partial valid entries are inserted and failures reported per item.
+func BulkAddAddresses(db *gorm.DB, geocoder Geocoder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID, err := uuid.Parse(c.GetString("user_id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid user ID")
+			return
+		}
+
+		mode := c.DefaultQuery("mode", "atomic")
+		if mode != "atomic" && mode != "partial" {
+			respondError(c, http.StatusBadRequest, "INVALID_MODE", "Mode must be atomic or partial")
+			return
+		}
+		partial := mode == "partial"
+
+		var inputs []AddressInput
+		if err := c.ShouldBindJSON(&inputs); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		addresses := make([]Address, len(inputs))
+		for i, input := range inputs {
+			addresses[i] = input.address()
+		}
+		if len(addresses) == 0 {
+			respondError(c, http.StatusBadRequest, "EMPTY_BATCH", "At least one address is required")
+			return
+		}
+		if max := maxBulkAddresses(); len(addresses) > max {
+			respondError(c, http.StatusBadRequest, "BATCH_TOO_LARGE", fmt.Sprintf("Batch exceeds the maximum of %d addresses", max))
+			return
+		}
+
+		// Validate everything up front; only one entry may claim the default
+		results := make([]BulkAddressResult, len(addresses))
+		defaultIndex := -1
+		invalid := 0
+		for i := range addresses {
+			address := &addresses[i]
+			results[i].Index = i
+			address.Normalize()
+			errs := address.Validate()
+			if address.IsDefault {
+				if defaultIndex >= 0 {
+					errs = append(errs, FieldError{Field: "is_default", Message: "only one address in a batch may be the default"})
+				} else if len(errs) == 0 {
+					defaultIndex = i
+				}
+			}
+			if len(errs) > 0 {
+				results[i].Status = bulkStatusInvalid
+				results[i].Errors = errs
+				invalid++
+			}
+		}
+
+		if invalid > 0 && !partial {
+			for i := range results {
+				if results[i].Status == "" {
+					results[i].Status = bulkStatusSkipped
+				}
+			}
+			respondValidationError(c, "One or more addresses are invalid", results)
+			return
+		}
+
+		created := 0
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			// The whole batch must fit, even in partial mode
+			count, err := reserveAddresses(tx, userID, len(addresses)-invalid)
+			if err != nil {
+				return err
+			}
+			if defaultIndex >= 0 {
+				if err := clearDefaultAddress(tx, userID); err != nil {
+					return err
+				}
+			}
+			needsDefault := count == 0 && defaultIndex < 0
+
+			for i := range addresses {
+				if results[i].Status == bulkStatusInvalid {
+					continue
+				}
+				address := &addresses[i]
+				address.UserID = userID
+				address.Version = 1
+				markGeocodePending(address, geocoder)
+				if needsDefault {
+					address.IsDefault = true
+				}
+
+				// Savepoints let partial mode skip a failed row without aborting the transaction
+				savepoint := fmt.Sprintf("bulk_address_%d", i)
+				if partial {
+					if err := tx.SavePoint(savepoint).Error; err != nil {
+						return err
+					}
+				}
+				err := tx.Omit(clause.Associations).Create(address).Error
+				if err == nil {
+					err = enqueueGeocode(tx, geocoder, address.ID, address.Version)
+				}
+				if err != nil {
+					if !partial {
+						return err
+					}
+					if err := tx.RollbackTo(savepoint).Error; err != nil {
+						return err
+					}
+					results[i].Status = bulkStatusFailed
+					results[i].Errors = []FieldError{{Message: "failed to save address"}}
+					continue
+				}
+				needsDefault = false
+				results[i].Status = bulkStatusCreated
+				results[i].Address = address
+				created++
+			}
+
+			// In partial mode the intended default may have failed to insert after
+			// the old default was cleared, so restore the one-default invariant
+			if partial && created > 0 {
+				var defaults int64
+				if err := tx.Model(&Address{}).Where("user_id = ? AND is_default = ?", userID, true).Count(&defaults).Error; err != nil {
+					return err
+				}
+				if defaults == 0 {
+					return promoteLatestAddress(tx, userID)
+				}
+			}
+			return nil
+		})
+		if respondAddressLimit(c, err) {
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to import addresses")
+			return
+		}
+
+		status := http.StatusCreated
+		if created < len(addresses) {
+			status = http.StatusOK
+		}
+		if created == 0 {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{
+			"created": created,
+			"failed":  len(addresses) - created,
+			"results": results,
+		})
+	}
+}
+
+// BatchAddressRequest names addresses for a batch delete or restore. IDs that
+// don't belong to the caller reject the batch unless SkipUnauthorized is set.
+type BatchAddressRequest struct {
+	IDs              []uint `json:"ids" binding:"required,min=1"`
+	SkipUnauthorized bool   `json:"skip_unauthorized"`
+}
+
+// BatchAddressResult reports the outcome for one ID of a batch delete or restore
+type BatchAddressResult struct {
+	ID     uint   `json:"id"`
+	Status string `json:"status"`
+}
+
+// bindBatchAddressRequest parses and de-duplicates the requested IDs
+func bindBatchAddressRequest(c *gin.Context) (*BatchAddressRequest, bool) {
+	var req BatchAddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondBindError(c, err)
+		return nil, false
+	}
+	seen := make(map[uint]bool, len(req.IDs))
+	ids := req.IDs[:0]
+	for _, id := range req.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	req.IDs = ids
+	if max := maxBulkAddresses(); len(req.IDs) > max {
+		respondError(c, http.StatusBadRequest, "BATCH_TOO_LARGE", fmt.Sprintf("Batch exceeds the maximum of %d addresses", max))
+		return nil, false
+	}
+	return &req, true
+}
+
+// batchAddressResults marks the matched IDs with status and the rest as not found
+func batchAddressResults(ids []uint, matched []Address, status string) ([]BatchAddressResult, int) {
+	found := make(map[uint]bool, len(matched))
+	for _, a := range matched {
+		found[a.ID] = true
+	}
+	results := make([]BatchAddressResult, len(ids))
+	missing := 0
+	for i, id := range ids {
+		results[i] = BatchAddressResult{ID: id, Status: status}
+		if !found[id] {
+			results[i].Status = bulkStatusNotFound
+			missing++
+		}
+	}
+	return results, missing
+}
+
+// respondBatchAddresses writes the outcome of a batch delete or restore
+func respondBatchAddresses(c *gin.Context, err error, results []BatchAddressResult, action string) {
+	if respondAddressLimit(c, err) {
+		return
+	}
+	if errors.Is(err, errUnknownAddresses) {
+		respondError(c, http.StatusNotFound, "ADDRESS_NOT_FOUND", "One or more addresses were not found", results)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to "+action+" addresses")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// BatchDeleteAddresses soft-deletes several of the caller's addresses in one
+// transaction, promoting a remaining address if the default was removed
+func BatchDeleteAddresses(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID, err := uuid.Parse(c.GetString("user_id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid user ID")
+			return
+		}
+		req, ok := bindBatchAddressRequest(c)
+		if !ok {
+			return
+		}
+
+		var results []BatchAddressResult
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			var addresses []Address
+			if err := tx.Where("id IN ? AND user_id = ?", req.IDs, userID).Find(&addresses).Error; err != nil {
+				return err
+			}
+			var missing int
+			results, missing = batchAddressResults(req.IDs, addresses, bulkStatusDeleted)
+			if missing > 0 && !req.SkipUnauthorized {
+				return errUnknownAddresses
+			}
+			if len(addresses) == 0 {
+				return nil
+			}
+
+			wasDefault := false
+			for _, a := range addresses {
+				wasDefault = wasDefault || a.IsDefault
+			}
+			if err := tx.Delete(&addresses).Error; err != nil {
+				return err
+			}
+			if wasDefault {
+				return promoteLatestAddress(tx, userID)
+			}
+			return nil
+		})
+		respondBatchAddresses(c, err, results, "delete")
+	}
+}
+
+// BatchRestoreAddresses undoes the soft delete of several of the caller's
+// addresses. Restored addresses are never the default unless none remains.
+func BatchRestoreAddresses(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID, err := uuid.Parse(c.GetString("user_id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid user ID")
+			return
+		}
+		req, ok := bindBatchAddressRequest(c)
+		if !ok {
+			return
+		}
+
+		var results []BatchAddressResult
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			var addresses []Address
+			if err := tx.Unscoped().
+				Where("id IN ? AND user_id = ? AND deleted_at IS NOT NULL", req.IDs, userID).
+				Find(&addresses).Error; err != nil {
+				return err
+			}
+			var missing int
+			results, missing = batchAddressResults(req.IDs, addresses, bulkStatusRestored)
+			if missing > 0 && !req.SkipUnauthorized {
+				return errUnknownAddresses
+			}
+			if len(addresses) == 0 {
+				return nil
+			}
+			// Restoring counts against the limit like adding
+			if _, err := reserveAddresses(tx, userID, len(addresses)); err != nil {
+				return err
+			}
+
+			ids := make([]uint, len(addresses))
+			for i, a := range addresses {
+				ids[i] = a.ID
+			}
+			if err := tx.Unscoped().Model(&Address{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+				"deleted_at": nil,
+				"is_default": false,
+				"version":    gorm.Expr("version + 1"),
+			}).Error; err != nil {
+				return err
+			}
+
+			var defaults int64
+			if err := tx.Model(&Address{}).Where("user_id = ? AND is_default = ?", userID, true).Count(&defaults).Error; err != nil {
+				return err
+			}
+			if defaults == 0 {
+				return promoteLatestAddress(tx, userID)
+			}
+			return nil
+		})
+		respondBatchAddresses(c, err, results, "restore")
+	}
+}
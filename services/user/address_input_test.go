@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// addressOwner answers the account lookup and address count made when an
+// address is added, and records every INSERT
+func addressOwner(userID string, inserts *[]string) fakeResponder {
+	return func(query string, args []driver.NamedValue) fakeResult {
+		switch {
+		case strings.HasPrefix(query, `SELECT "id","address_limit" FROM "users"`):
+			return fakeResult{columns: []string{"id", "address_limit"}, rows: [][]driver.Value{{userID, nil}}}
+		case strings.HasPrefix(query, "SELECT count(*)"):
+			return fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(0)}}}
+		case strings.HasPrefix(query, "INSERT INTO"):
+			*inserts = append(*inserts, query)
+			return fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(7)}}, affected: 1}
+		}
+		return fakeResult{affected: 1}
+	}
+}
+
+const testAddress = `"street":"1 Main St","city":"Springfield","country":"US","postal_code":"12345"`
+
+// TestAddAddressRejectsServerFields posts the fields of the Address model a
+// client must not set: its ID, and the User association, which GORM would
+// upsert as a new account if the model were bound
+func TestAddAddressRejectsServerFields(t *testing.T) {
+	nested := []string{
+		`"User":{"email":"evil@example.com","role":"admin","is_verified":true}`,
+		`"User":{"id":"` + uuid.NewString() + `"}`,
+		`"ID":99`,
+		`"user_id":"` + uuid.NewString() + `"`,
+	}
+	for _, field := range nested {
+		t.Run(field, func(t *testing.T) {
+			var inserts []string
+			db, _ := newFakeDB(t, addressOwner(uuid.NewString(), &inserts))
+
+			single := serve(AddAddress(db, NopGeocoder{}), "/addresses", http.MethodPost, "/addresses", `{`+testAddress+`,`+field+`}`, uuid.NewString())
+			bulk := serve(BulkAddAddresses(db, NopGeocoder{}), "/addresses/bulk", http.MethodPost, "/addresses/bulk", `[{`+testAddress+`,`+field+`}]`, uuid.NewString())
+			for name, w := range map[string]int{"single": single.Code, "bulk": bulk.Code} {
+				if w != http.StatusBadRequest {
+					t.Errorf("%s: status = %d, want %d", name, w, http.StatusBadRequest)
+				}
+			}
+			if len(inserts) > 0 {
+				t.Errorf("rejected request inserted %v", inserts)
+			}
+		})
+	}
+}
+
+func TestAddAddressInsertsOnlyTheAddress(t *testing.T) {
+	var inserts []string
+	userID := uuid.NewString()
+	db, _ := newFakeDB(t, addressOwner(userID, &inserts))
+
+	w := serve(AddAddress(db, NopGeocoder{}), "/addresses", http.MethodPost, "/addresses", `{`+testAddress+`}`, userID)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d (body %s)", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if len(inserts) != 1 || !strings.HasPrefix(inserts[0], `INSERT INTO "addresses"`) {
+		t.Errorf("inserts = %v, want only the address", inserts)
+	}
+}
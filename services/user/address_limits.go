@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultAddressLimit is how many live addresses a user may have unless an
+// admin set a limit for them (ADDRESS_LIMIT_PER_USER)
+func defaultAddressLimit() int {
+	return getEnvInt("ADDRESS_LIMIT_PER_USER", 50)
+}
+
+// addressLimitError reports that adding addresses would exceed the user's limit
+type addressLimitError struct {
+	Limit   int
+	Current int64
+}
+
+func (e *addressLimitError) Error() string {
+	return fmt.Sprintf("address limit of %d reached", e.Limit)
+}
+
+// effectiveAddressLimit is the user's own limit, or the default
+func effectiveAddressLimit(user *User) int {
+	if user.AddressLimit != nil {
+		return *user.AddressLimit
+	}
+	return defaultAddressLimit()
+}
+
+// reserveAddresses checks that n more addresses fit within the user's limit
+// and returns the current count. It locks the user row, so concurrent adds
+// for the same user wait for each other and can't both pass the check.
+func reserveAddresses(tx *gorm.DB, userID uuid.UUID, n int) (int64, error) {
+	var user User
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id", "address_limit").
+		First(&user, "id = ?", userID).Error; err != nil {
+		return 0, err
+	}
+	var count int64
+	if err := tx.Model(&Address{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	if limit := effectiveAddressLimit(&user); count+int64(n) > int64(limit) {
+		return count, &addressLimitError{Limit: limit, Current: count}
+	}
+	return count, nil
+}
+
+// respondAddressLimit writes the 409 for an addressLimitError and reports
+// whether err was one
+func respondAddressLimit(c *gin.Context, err error) bool {
+	var limitErr *addressLimitError
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+	respondError(c, http.StatusConflict, "ADDRESS_LIMIT_REACHED", "Address limit reached",
+		gin.H{"limit": limitErr.Limit, "current": limitErr.Current})
+	return true
+}
+
+// AddressLimitRequest sets a user's address limit; null restores the default
+type AddressLimitRequest struct {
+	AddressLimit *int `json:"address_limit" binding:"omitempty,min=0,max=100000"`
+}
+
+// AdminSetAddressLimit raises or lowers one user's address limit. Lowering
+// it below their current count only blocks further adds.
+func AdminSetAddressLimit(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid user ID")
+			return
+		}
+		var req AddressLimitRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		result := db.Model(&User{}).Where("id = ?", userID).Update("address_limit", req.AddressLimit)
+		if result.Error != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update address limit")
+			return
+		}
+		if result.RowsAffected == 0 {
+			respondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":       userID,
+			"address_limit": req.AddressLimit,
+			"effective":     effectiveAddressLimit(&User{AddressLimit: req.AddressLimit}),
+		})
+	}
+}
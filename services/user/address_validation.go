@@ -0,0 +1,106 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// isoCountryCodes is the ISO 3166-1 alpha-2 code list
+var isoCountryCodes = toSet(strings.Fields(`
+	AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW
+	BY BZ CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI
+	FJ FK FM FO FR GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN
+	IO IQ IR IS IT JE JM JO JP KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME
+	MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF
+	PG PH PK PL PM PN PR PS PT PW PY QA RE RO RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV
+	SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG UM US UY UZ VA VC VE VG VI VN VU WF WS YE
+	YT ZA ZM ZW
+`))
+
+// postalCodeFormats holds per-country postal code patterns. Countries without
+// an entry only require a non-empty postal code; add entries with
+// RegisterPostalCodeFormat.
+var postalCodeFormats = map[string]*regexp.Regexp{
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+	"CA": regexp.MustCompile(`^[A-Za-z]\d[A-Za-z] ?\d[A-Za-z]\d$`),
+	"GB": regexp.MustCompile(`^[A-Za-z]{1,2}\d[A-Za-z\d]? ?\d[A-Za-z]{2}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Za-z]{2}$`),
+	"IN": regexp.MustCompile(`^\d{6}$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+	"BR": regexp.MustCompile(`^\d{5}-?\d{3}$`),
+}
+
+// RegisterPostalCodeFormat adds or replaces the postal code pattern for a country
+func RegisterPostalCodeFormat(country, pattern string) {
+	postalCodeFormats[strings.ToUpper(country)] = regexp.MustCompile(pattern)
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// Normalize trims whitespace and upper-cases the country code
+func (a *Address) Normalize() {
+	a.Street = strings.TrimSpace(a.Street)
+	a.City = strings.TrimSpace(a.City)
+	a.State = strings.TrimSpace(a.State)
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	a.PostalCode = strings.TrimSpace(a.PostalCode)
+}
+
+// Validate returns field-level problems with the address; call Normalize first
+func (a *Address) Validate() []FieldError {
+	var errs []FieldError
+	required := []struct {
+		field string
+		value string
+	}{
+		{"street", a.Street},
+		{"city", a.City},
+		{"country", a.Country},
+		{"postal_code", a.PostalCode},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			errs = append(errs, FieldError{Field: r.field, Message: "is required"})
+		}
+	}
+
+	if a.Country != "" {
+		if _, ok := isoCountryCodes[a.Country]; !ok {
+			errs = append(errs, FieldError{Field: "country", Message: "must be an ISO 3166-1 alpha-2 code"})
+		} else if format, ok := postalCodeFormats[a.Country]; ok && a.PostalCode != "" && !format.MatchString(a.PostalCode) {
+			errs = append(errs, FieldError{Field: "postal_code", Message: "is not a valid postal code for " + a.Country})
+		}
+	}
+	return errs
+}
+
+// rejectInvalidAddress normalizes and validates the address, writing a 422
+// with field details and reporting whether it was rejected
+func rejectInvalidAddress(c *gin.Context, address *Address) bool {
+	address.Normalize()
+	errs := address.Validate()
+	if len(errs) == 0 {
+		return false
+	}
+	respondValidationError(c, "Invalid address", errs)
+	return true
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const RoleAdmin = "admin"
+
+var userSortColumns = map[string]string{
+	"created_at": "created_at",
+	"email":      "email",
+	"last_name":  "last_name",
+}
+
+// AdminUser is the admin view of a user; it deliberately has no secret fields
+type AdminUser struct {
+	ID               uuid.UUID `json:"id"`
+	Email            string    `json:"email"`
+	Username         *string   `json:"username,omitempty"`
+	FirstName        string    `json:"first_name"`
+	LastName         string    `json:"last_name"`
+	Role             string    `json:"role"`
+	IsVerified       bool      `json:"is_verified"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	Status           string    `json:"status"`
+	// FailedLoginAttempts counts towards the automatic lockout, which lasts
+	// until LockedUntil
+	FailedLoginAttempts int        `json:"failed_login_attempts"`
+	LockedUntil         *time.Time `json:"locked_until,omitempty"`
+	AdminLocked         bool       `json:"admin_locked"`
+	AdminLockedUntil    *time.Time `json:"admin_locked_until,omitempty"`
+	AdminLockReason     string     `json:"admin_lock_reason,omitempty"`
+	SuspendedAt         *time.Time `json:"suspended_at,omitempty"`
+	SuspensionReason    string     `json:"suspension_reason,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+// CursorPage is a page of results in cursor mode. NextCursor is empty on the
+// last page.
+type CursorPage[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PageSize   int    `json:"page_size"`
+}
+
+func toAdminUser(u User) AdminUser {
+	return AdminUser{
+		ID:                  u.ID,
+		Email:               u.Email,
+		Username:            u.Username,
+		FirstName:           u.FirstName,
+		LastName:            u.LastName,
+		Role:                u.Role,
+		IsVerified:          u.IsVerified,
+		TwoFactorEnabled:    u.TwoFactorEnabled,
+		Status:              u.CurrentStatus(),
+		FailedLoginAttempts: u.FailedLoginAttempts,
+		LockedUntil:         u.LockedUntil,
+		AdminLocked:         u.IsAdminLocked(),
+		AdminLockedUntil:    u.AdminLockedUntil,
+		AdminLockReason:     u.AdminLockReason,
+		SuspendedAt:         u.SuspendedAt,
+		SuspensionReason:    u.SuspensionReason,
+		CreatedAt:           u.CreatedAt,
+		UpdatedAt:           u.UpdatedAt,
+	}
+}
+
+// likeEscaper escapes LIKE wildcards so user input only matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// parseTimeParam accepts an RFC 3339 timestamp or a YYYY-MM-DD date
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// adminUserFilters applies the ?email, ?role, ?status, ?is_verified,
+// ?created_after and ?created_before filters; all values are bound as query
+// parameters
+func adminUserFilters(c *gin.Context) (func(*gorm.DB) *gorm.DB, string) {
+	var scopes []func(*gorm.DB) *gorm.DB
+
+	if email := strings.TrimSpace(c.Query("email")); email != "" {
+		pattern := "%" + likeEscaper.Replace(strings.ToLower(email)) + "%"
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where("email LIKE ?", pattern) })
+	}
+	if role := c.Query("role"); role != "" {
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where("role = ?", role) })
+	}
+	// Timed locks that have run out count as active, as in User.CurrentStatus
+	switch status, now := c.Query("status"), time.Now(); status {
+	case "":
+	case UserStatusActive:
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB {
+			return db.Where("(status = ? OR (status = ? AND admin_locked_until <= ?))", UserStatusActive, UserStatusLocked, now)
+		})
+	case UserStatusLocked:
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB {
+			return db.Where("status = ? AND (admin_locked_until IS NULL OR admin_locked_until > ?)", UserStatusLocked, now)
+		})
+	case UserStatusSuspended:
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where("status = ?", UserStatusSuspended) })
+	default:
+		return nil, "status must be active, suspended or locked"
+	}
+	if value := c.Query("is_verified"); value != "" {
+		verified, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, "is_verified must be true or false"
+		}
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where("is_verified = ?", verified) })
+	}
+	if value := c.Query("created_after"); value != "" {
+		after, err := parseTimeParam(value)
+		if err != nil {
+			return nil, "created_after must be an RFC 3339 timestamp or YYYY-MM-DD date"
+		}
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where("created_at >= ?", after) })
+	}
+	if value := c.Query("created_before"); value != "" {
+		before, err := parseTimeParam(value)
+		if err != nil {
+			return nil, "created_before must be an RFC 3339 timestamp or YYYY-MM-DD date"
+		}
+		scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where("created_at < ?", before) })
+	}
+
+	return func(db *gorm.DB) *gorm.DB { return db.Scopes(scopes...) }, ""
+}
+
+// AdminListUsers returns a filtered, sorted page of users, for admin consoles.
+// Passing ?cursor (empty for the first page) switches to keyset pagination,
+// which stays fast on deep pages; ?page is only meant for small result sets.
+// Either way ?fields= trims each user to the named adminUserFields.
+func AdminListUsers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		filters, problem := adminUserFilters(c)
+		if problem != "" {
+			respondError(c, http.StatusBadRequest, "INVALID_FILTER", problem)
+			return
+		}
+		fields, ok := parseFields(c, adminUserFields)
+		if !ok {
+			return
+		}
+		if cursor, ok := c.GetQuery("cursor"); ok {
+			listUsersByCursor(c, db, filters, fields, cursor)
+			return
+		}
+
+		pagination := parsePagination(c)
+		order, ok := parseSort(c, userSortColumns, "created_at DESC")
+		if !ok {
+			respondError(c, http.StatusBadRequest, "INVALID_SORT", "Invalid sort field")
+			return
+		}
+
+		var total int64
+		var users []User
+		err := retryRead(c.Request.Context(), func() error {
+			if err := db.Model(&User{}).Scopes(filters).Count(&total).Error; err != nil {
+				return err
+			}
+			users = []User{}
+			return db.Scopes(filters).
+				Order(order + ", id ASC").
+				Scopes(paginate(pagination)).
+				Find(&users).Error
+		})
+		if err != nil {
+			respondDBError(c, err, "Failed to fetch users")
+			return
+		}
+
+		items := make([]AdminUser, len(users))
+		for i, u := range users {
+			items[i] = toAdminUser(u)
+		}
+		body, err := selectPageFields(newPage(items, total, pagination), fields)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch users")
+			return
+		}
+		c.JSON(http.StatusOK, body)
+	}
+}
+
+// listUsersByCursor serves one page in cursor mode, newest first. The order
+// is fixed to (created_at, id) so the cursor always matches the sort key.
+func listUsersByCursor(c *gin.Context, db *gorm.DB, filters func(*gorm.DB) *gorm.DB, fields []string, token string) {
+	if sort := c.Query("sort"); sort != "" && sort != "-created_at" {
+		respondError(c, http.StatusBadRequest, "INVALID_SORT", "Cursor pagination only supports sort=-created_at")
+		return
+	}
+	pageSize := parsePagination(c).PageSize
+
+	query := db.Scopes(filters)
+	if token != "" {
+		cursor, err := decodeCursor(token)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_CURSOR", "Invalid cursor")
+			return
+		}
+		query = query.Where("(created_at, id) < (?, ?)", cursor.CreatedAt, cursor.ID)
+	}
+	// A session so each retry starts from the filters alone
+	query = query.Session(&gorm.Session{})
+
+	// Fetch one extra row to learn whether another page follows
+	var users []User
+	err := retryRead(c.Request.Context(), func() error {
+		users = []User{}
+		return query.Order("created_at DESC, id DESC").Limit(pageSize + 1).Find(&users).Error
+	})
+	if err != nil {
+		respondDBError(c, err, "Failed to fetch users")
+		return
+	}
+
+	resp := CursorPage[AdminUser]{PageSize: pageSize}
+	if len(users) > pageSize {
+		users = users[:pageSize]
+		last := users[len(users)-1]
+		resp.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	resp.Items = make([]AdminUser, len(users))
+	for i, u := range users {
+		resp.Items[i] = toAdminUser(u)
+	}
+	body, err := selectCursorPageFields(resp, fields)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch users")
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Admin actions recorded in the admin audit log
+const (
+	AdminActionVerify            = "verify"
+	AdminActionLock              = "lock"
+	AdminActionUnlock            = "unlock"
+	AdminActionResetFailedLogins = "reset_failed_logins"
+	AdminActionSuspend           = "suspend"
+	AdminActionUnsuspend         = "unsuspend"
+)
+
+// AdminAuditEvent is one change an admin made to an account
+type AdminAuditEvent struct {
+	ID        uint       `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+	AdminID   uuid.UUID  `gorm:"type:uuid;index;not null" json:"admin_id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index;not null" json:"user_id"`
+	Action    string     `gorm:"not null" json:"action"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+var (
+	errAdminTargetNotFound = errors.New("user not found")
+	errCannotLockSelf      = errors.New("admins cannot lock their own account")
+	errNotSuspended        = errors.New("account is not suspended")
+)
+
+// AdminLockRequest locks an account until an admin unlocks it, or for
+// duration_seconds
+type AdminLockRequest struct {
+	Reason          string `json:"reason" binding:"required,max=500"`
+	DurationSeconds int64  `json:"duration_seconds" binding:"omitempty,min=1"`
+}
+
+// AdminSuspendRequest suspends an account pending review
+type AdminSuspendRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// adminAction applies change to the user named by :id and records it as done
+// by the calling admin, in one transaction so no change goes unaudited
+func adminAction(c *gin.Context, db *gorm.DB, action string, change func(tx *gorm.DB, user *User, event *AdminAuditEvent) error) (*User, bool) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid user ID")
+		return nil, false
+	}
+	adminID, err := uuid.Parse(c.GetString("user_id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid user ID")
+		return nil, false
+	}
+
+	var user User
+	err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errAdminTargetNotFound
+			}
+			return err
+		}
+		event := AdminAuditEvent{AdminID: adminID, UserID: user.ID, Action: action}
+		if err := change(tx, &user, &event); err != nil {
+			return err
+		}
+		return tx.Create(&event).Error
+	})
+	var transition *statusTransitionError
+	switch {
+	case err == nil:
+		return &user, true
+	case errors.Is(err, errAdminTargetNotFound):
+		respondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+	case errors.Is(err, errCannotLockSelf):
+		respondError(c, http.StatusConflict, "CANNOT_LOCK_SELF", "Admins cannot lock their own account")
+	case errors.Is(err, errNotSuspended):
+		respondError(c, http.StatusConflict, "NOT_SUSPENDED", "Account is not suspended")
+	case errors.As(err, &transition):
+		respondError(c, http.StatusConflict, "INVALID_STATUS_TRANSITION", "Account status does not allow this change",
+			gin.H{"from": transition.From, "to": transition.To})
+	default:
+		respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update user")
+	}
+	return nil, false
+}
+
+// AdminVerifyUser marks a user's email address as verified, for support
+// cases where the link never arrived
+func AdminVerifyUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		user, ok := adminAction(c, db, AdminActionVerify, func(tx *gorm.DB, user *User, _ *AdminAuditEvent) error {
+			if err := tx.Model(user).Updates(map[string]interface{}{
+				"is_verified":        true,
+				"verification_token": "",
+			}).Error; err != nil {
+				return err
+			}
+			return syncPrimaryEmail(tx, user)
+		})
+		if ok {
+			c.JSON(http.StatusOK, toAdminUser(*user))
+		}
+	}
+}
+
+// AdminLockUser locks an account and ends its sessions. Unlike the automatic
+// lockout it survives a correct password and only ends when an admin unlocks
+// the account or the optional duration passes.
+func AdminLockUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req AdminLockRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		user, ok := adminAction(c, db, AdminActionLock, func(tx *gorm.DB, user *User, event *AdminAuditEvent) error {
+			if user.ID.String() == c.GetString("user_id") {
+				return errCannotLockSelf
+			}
+			now := time.Now()
+			var until *time.Time
+			if req.DurationSeconds > 0 {
+				expires := now.Add(time.Duration(req.DurationSeconds) * time.Second)
+				until = &expires
+			}
+			if err := setUserStatus(tx, user, UserStatusLocked, map[string]interface{}{
+				"admin_locked_at":    now,
+				"admin_locked_until": until,
+				"admin_lock_reason":  req.Reason,
+			}); err != nil {
+				return err
+			}
+			event.Reason = req.Reason
+			event.ExpiresAt = until
+			return revokeUserRefreshTokens(tx, user.ID)
+		})
+		if ok {
+			c.JSON(http.StatusOK, toAdminUser(*user))
+		}
+	}
+}
+
+// AdminUnlockUser lifts an admin lock and any automatic lockout. A suspension
+// stays in place; it is lifted with AdminUnsuspendUser.
+func AdminUnlockUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		user, ok := adminAction(c, db, AdminActionUnlock, func(tx *gorm.DB, user *User, _ *AdminAuditEvent) error {
+			fields := map[string]interface{}{
+				"admin_locked_at":       nil,
+				"admin_locked_until":    nil,
+				"admin_lock_reason":     "",
+				"locked_until":          nil,
+				"failed_login_attempts": 0,
+			}
+			if user.CurrentStatus() == UserStatusLocked {
+				return setUserStatus(tx, user, UserStatusActive, fields)
+			}
+			// A timed lock that ran out already reads as active
+			if user.Status == UserStatusLocked {
+				fields["status"] = UserStatusActive
+			}
+			return tx.Model(user).Updates(fields).Error
+		})
+		if ok {
+			c.JSON(http.StatusOK, toAdminUser(*user))
+		}
+	}
+}
+
+// AdminSuspendUser suspends an account pending review and ends its sessions.
+// A suspended account can't log in or use existing tokens until an admin
+// lifts the suspension; deleted accounts can't be suspended.
+func AdminSuspendUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req AdminSuspendRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		user, ok := adminAction(c, db, AdminActionSuspend, func(tx *gorm.DB, user *User, event *AdminAuditEvent) error {
+			if user.ID.String() == c.GetString("user_id") {
+				return errCannotLockSelf
+			}
+			if err := setUserStatus(tx, user, UserStatusSuspended, map[string]interface{}{
+				"suspended_at":      time.Now(),
+				"suspension_reason": req.Reason,
+			}); err != nil {
+				return err
+			}
+			event.Reason = req.Reason
+			return revokeUserRefreshTokens(tx, user.ID)
+		})
+		if ok {
+			c.JSON(http.StatusOK, toAdminUser(*user))
+		}
+	}
+}
+
+// AdminUnsuspendUser lifts a suspension, making the account active again
+func AdminUnsuspendUser(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		user, ok := adminAction(c, db, AdminActionUnsuspend, func(tx *gorm.DB, user *User, _ *AdminAuditEvent) error {
+			if user.Status != UserStatusSuspended {
+				return errNotSuspended
+			}
+			return setUserStatus(tx, user, UserStatusActive, map[string]interface{}{
+				"suspended_at":      nil,
+				"suspension_reason": "",
+			})
+		})
+		if ok {
+			c.JSON(http.StatusOK, toAdminUser(*user))
+		}
+	}
+}
+
+// AdminResetFailedLogins clears a user's failed login counter, so they get
+// the full number of attempts before the next automatic lockout
+func AdminResetFailedLogins(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		user, ok := adminAction(c, db, AdminActionResetFailedLogins, func(tx *gorm.DB, user *User, _ *AdminAuditEvent) error {
+			return tx.Model(user).Update("failed_login_attempts", 0).Error
+		})
+		if ok {
+			c.JSON(http.StatusOK, toAdminUser(*user))
+		}
+	}
+}
+
+// AdminListAuditEvents returns a page of admin actions, newest first,
+// optionally for one ?user_id or ?admin_id
+func AdminListAuditEvents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var scopes []func(*gorm.DB) *gorm.DB
+		for _, param := range []string{"user_id", "admin_id"} {
+			value := c.Query(param)
+			if value == "" {
+				continue
+			}
+			id, err := uuid.Parse(value)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, "INVALID_FILTER", param+" must be a UUID")
+				return
+			}
+			column := param
+			scopes = append(scopes, func(db *gorm.DB) *gorm.DB { return db.Where(column+" = ?", id) })
+		}
+		pagination := parsePagination(c)
+
+		var total int64
+		if err := db.Model(&AdminAuditEvent{}).Scopes(scopes...).Count(&total).Error; err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch audit events")
+			return
+		}
+		events := []AdminAuditEvent{}
+		if err := db.Scopes(scopes...).
+			Order("created_at DESC, id DESC").
+			Scopes(paginate(pagination)).
+			Find(&events).Error; err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch audit events")
+			return
+		}
+		c.JSON(http.StatusOK, newPage(events, total, pagination))
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// allowedAvatarTypes maps accepted image content types to file extensions
+var allowedAvatarTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// avatarMaxBytes is the largest accepted upload (AVATAR_MAX_BYTES, default 2MB)
+func avatarMaxBytes() int64 {
+	return int64(getEnvInt("AVATAR_MAX_BYTES", 2<<20))
+}
+
+// defaultAvatarURL is shown for users without an uploaded avatar
+func defaultAvatarURL() string {
+	return getEnv("AVATAR_DEFAULT_URL", "")
+}
+
+// UploadAvatar stores a multipart "avatar" image and sets it as the profile picture
+func UploadAvatar(db *gorm.DB, storage Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+		maxBytes := avatarMaxBytes()
+		// Leave room for multipart headers around the file itself
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+64<<10)
+
+		header, err := c.FormFile("avatar")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				respondError(c, http.StatusRequestEntityTooLarge, "FILE_TOO_LARGE", "Avatar is too large", gin.H{"max_bytes": maxBytes})
+				return
+			}
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Missing avatar file")
+			return
+		}
+		if header.Size > maxBytes {
+			respondError(c, http.StatusRequestEntityTooLarge, "FILE_TOO_LARGE", "Avatar is too large", gin.H{"max_bytes": maxBytes})
+			return
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Failed to read avatar")
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Failed to read avatar")
+			return
+		}
+
+		// Sniff the content rather than trusting the client-supplied header
+		contentType := http.DetectContentType(data)
+		ext, ok := allowedAvatarTypes[contentType]
+		if !ok {
+			respondError(c, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "Avatar must be a JPEG, PNG, GIF or WebP image")
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+
+		key := "avatars/" + userID + "/" + uuid.NewString() + ext
+		url, err := storage.Put(c.Request.Context(), key, contentType, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			middleware.Logger(c).Error("Failed to store avatar", zap.Error(err))
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to store avatar")
+			return
+		}
+
+		oldKey := user.AvatarKey
+		if err := db.Model(&user).Updates(map[string]interface{}{"profile_picture": url, "avatar_key": key}).Error; err != nil {
+			storage.Delete(c.Request.Context(), key)
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update profile")
+			return
+		}
+		removeAvatar(c, storage, oldKey)
+
+		c.JSON(http.StatusOK, gin.H{"profile_picture": url})
+	}
+}
+
+// DeleteAvatar removes the uploaded avatar and reverts to the default picture
+func DeleteAvatar(db *gorm.DB, storage Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+
+		oldKey := user.AvatarKey
+		if err := db.Model(&user).Updates(map[string]interface{}{"profile_picture": "", "avatar_key": ""}).Error; err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update profile")
+			return
+		}
+		removeAvatar(c, storage, oldKey)
+
+		c.JSON(http.StatusOK, gin.H{"profile_picture": defaultAvatarURL()})
+	}
+}
+
+// removeAvatar deletes a replaced avatar; failures only leave an orphaned file
+func removeAvatar(c *gin.Context, storage Storage, key string) {
+	if key == "" {
+		return
+	}
+	if err := storage.Delete(c.Request.Context(), key); err != nil {
+		middleware.Logger(c).Warn("Failed to delete old avatar", zap.String("key", key), zap.Error(err))
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// errUnsupportedMediaType means a body was sent in an encoding bindBody
+// doesn't read
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// errCookieAuthNeedsJSON means a cookie-authenticated request sent something
+// other than an explicit JSON body
+var errCookieAuthNeedsJSON = errors.New("cookie-authenticated requests must send application/json")
+
+// unknownFieldError is a form key the request struct has no field for,
+// rejected like an unknown JSON field
+type unknownFieldError struct {
+	Field string
+}
+
+func (e *unknownFieldError) Error() string {
+	return "unknown field " + e.Field
+}
+
+// bindBody binds a JSON, URL-encoded or multipart form body, chosen by
+// Content-Type, and validates it with the same binding rules either way. A
+// missing Content-Type is read as JSON, which is what clients sent before
+// forms were accepted; any other type is errUnsupportedMediaType. Form
+// fields are named by the struct's form tags, which match its JSON names.
+//
+// A cross-site page can make the browser send a form, or a body without a
+// Content-Type, along with the auth cookie, but not an application/json one
+// without a CORS preflight. Cookie-authenticated requests must therefore
+// declare JSON.
+func bindBody(c *gin.Context, obj interface{}) error {
+	if c.GetBool(middleware.CookieAuthKey) && c.ContentType() != binding.MIMEJSON {
+		return errCookieAuthNeedsJSON
+	}
+	switch c.ContentType() {
+	case "", binding.MIMEJSON:
+		return c.ShouldBindJSON(obj)
+	case binding.MIMEPOSTForm:
+		if err := c.ShouldBindWith(obj, binding.FormPost); err != nil {
+			return err
+		}
+	case binding.MIMEMultipartPOSTForm:
+		if err := c.ShouldBindWith(obj, binding.FormMultipart); err != nil {
+			return err
+		}
+	default:
+		return errUnsupportedMediaType
+	}
+	known := formFields(reflect.TypeOf(obj))
+	for key := range c.Request.PostForm {
+		if !known[key] {
+			return &unknownFieldError{Field: key}
+		}
+	}
+	return nil
+}
+
+// formFields lists the form keys a struct binds, including those of
+// embedded structs
+func formFields(t reflect.Type) map[string]bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	fields := map[string]bool{}
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("form"), ",")
+		switch {
+		case name == "-" || !field.IsExported():
+		case name != "":
+			fields[name] = true
+		case field.Anonymous:
+			for key := range formFields(field.Type) {
+				fields[key] = true
+			}
+		default:
+			fields[field.Name] = true
+		}
+	}
+	return fields
+}
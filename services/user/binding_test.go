@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindBodyCookieAuthNeedsJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		cookieAuth  bool
+		wantStatus  int
+	}{
+		{name: "form with bearer token", contentType: "application/x-www-form-urlencoded", body: "email=ada%40example.com", wantStatus: http.StatusOK},
+		{name: "form with cookie", contentType: "application/x-www-form-urlencoded", body: "email=ada%40example.com", cookieAuth: true, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "multipart with cookie", contentType: "multipart/form-data; boundary=x", body: "--x--\r\n", cookieAuth: true, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "no content type with cookie", body: `{"email":"ada@example.com"}`, cookieAuth: true, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "text/plain with cookie", contentType: "text/plain", body: `{"email":"ada@example.com"}`, cookieAuth: true, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "JSON with cookie", contentType: "application/json", body: `{"email":"ada@example.com"}`, cookieAuth: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.POST("/", func(c *gin.Context) {
+				if tt.cookieAuth {
+					c.Set(middleware.CookieAuthKey, true)
+				}
+				var req struct {
+					Email string `json:"email" form:"email"`
+				}
+				if err := bindBody(c, &req); err != nil {
+					respondBindError(c, err)
+					return
+				}
+				c.JSON(http.StatusOK, req)
+			})
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusUnsupportedMediaType && decodeError(t, w).Error.Code != "UNSUPPORTED_MEDIA_TYPE" {
+				t.Errorf("body = %s, want UNSUPPORTED_MEDIA_TYPE", w.Body.String())
+			}
+		})
+	}
+}
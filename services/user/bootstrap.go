@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var errBootstrapNotConfigured = errors.New("BOOTSTRAP_ADMIN_EMAIL and BOOTSTRAP_ADMIN_PASSWORD must be set")
+
+// bootstrapAdmin creates a verified admin from BOOTSTRAP_ADMIN_EMAIL and
+// BOOTSTRAP_ADMIN_PASSWORD unless an admin already exists. It is safe to run
+// on every start; replicas racing to create the account are resolved by the
+// unique email index.
+func bootstrapAdmin(db *gorm.DB) error {
+	email := normalizeEmail(getEnv("BOOTSTRAP_ADMIN_EMAIL", ""))
+	password := getEnv("BOOTSTRAP_ADMIN_PASSWORD", "")
+	if email == "" || password == "" {
+		return errBootstrapNotConfigured
+	}
+	if problems := currentPasswordPolicy().Validate(password); len(problems) > 0 {
+		return fmt.Errorf("BOOTSTRAP_ADMIN_PASSWORD is too weak: %s", strings.Join(problems, "; "))
+	}
+
+	var admins int64
+	if err := db.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins > 0 {
+		zap.L().Info("Admin bootstrap skipped, an admin already exists")
+		return nil
+	}
+
+	// Never promote an existing account; its owner may not be the operator
+	if taken, err := emailInUse(db, email); err != nil {
+		return err
+	} else if taken {
+		zap.L().Warn("Admin bootstrap skipped, the email belongs to an existing non-admin account", zap.String("email", email))
+		return nil
+	}
+
+	admin := User{
+		Email:      email,
+		Password:   password,
+		FirstName:  getEnv("BOOTSTRAP_ADMIN_FIRST_NAME", "Admin"),
+		LastName:   getEnv("BOOTSTRAP_ADMIN_LAST_NAME", "User"),
+		Role:       RoleAdmin,
+		IsVerified: true,
+	}
+	if err := admin.HashPassword(); err != nil {
+		return err
+	}
+	err := WithTransaction(context.Background(), db, func(tx *gorm.DB) error {
+		if err := tx.Create(&admin).Error; err != nil {
+			return err
+		}
+		return syncPrimaryEmail(tx, &admin)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			zap.L().Info("Admin bootstrap skipped, the account was created concurrently", zap.String("email", email))
+			return nil
+		}
+		return err
+	}
+	zap.L().Info("Created bootstrap admin account", zap.String("email", email), zap.String("user_id", admin.ID.String()))
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// errCaptchaRejected means the provider checked the token and found it
+// invalid, expired or already used
+var errCaptchaRejected = errors.New("captcha rejected")
+
+// CaptchaVerifier checks a CAPTCHA token solved by the client
+type CaptchaVerifier interface {
+	// Verify returns errCaptchaRejected for a bad token and any other error
+	// when the provider couldn't be asked
+	Verify(ctx context.Context, token, remoteIP string) error
+}
+
+// captchaVerifyURLs are the siteverify endpoints of the supported providers,
+// which all share the same protocol
+var captchaVerifyURLs = map[string]string{
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+// NewCaptchaVerifier selects the provider from CAPTCHA_PROVIDER. The default,
+// none, accepts every request without asking for a token.
+func NewCaptchaVerifier() (CaptchaVerifier, error) {
+	provider := getEnv("CAPTCHA_PROVIDER", "none")
+	if provider == "none" {
+		return NopCaptchaVerifier{}, nil
+	}
+	verifyURL, ok := captchaVerifyURLs[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown CAPTCHA_PROVIDER %q", provider)
+	}
+	secret := getEnv("CAPTCHA_SECRET", "")
+	if secret == "" {
+		return nil, fmt.Errorf("CAPTCHA_SECRET is required with CAPTCHA_PROVIDER %q", provider)
+	}
+	return &SiteVerifyCaptcha{
+		verifyURL: getEnv("CAPTCHA_VERIFY_URL", verifyURL),
+		secret:    secret,
+		client:    &http.Client{Timeout: getEnvDuration("CAPTCHA_TIMEOUT", 5*time.Second)},
+	}, nil
+}
+
+// NopCaptchaVerifier accepts every request, for development, tests and
+// internal environments
+type NopCaptchaVerifier struct{}
+
+func (NopCaptchaVerifier) Verify(ctx context.Context, token, remoteIP string) error {
+	return nil
+}
+
+// SiteVerifyCaptcha checks tokens against a reCAPTCHA, hCaptcha or Turnstile
+// siteverify endpoint
+type SiteVerifyCaptcha struct {
+	verifyURL string
+	secret    string
+	client    *http.Client
+}
+
+func (v *SiteVerifyCaptcha) Verify(ctx context.Context, token, remoteIP string) error {
+	form := url.Values{"secret": {v.secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return fmt.Errorf("captcha provider returned %s", resp.Status)
+	}
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return fmt.Errorf("decode captcha response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: %s", errCaptchaRejected, strings.Join(result.ErrorCodes, ","))
+	}
+	return nil
+}
+
+// rejectFailedCaptcha verifies the request's CAPTCHA token, responding 400
+// when it is missing or rejected. A provider outage fails closed with a 503
+// rather than letting bots through.
+func rejectFailedCaptcha(c *gin.Context, captcha CaptchaVerifier, token string) bool {
+	if _, ok := captcha.(NopCaptchaVerifier); ok {
+		return false
+	}
+	if token == "" {
+		captchaVerificationsTotal.WithLabelValues("missing").Inc()
+		respondError(c, http.StatusBadRequest, "CAPTCHA_REQUIRED", "captcha_token is required")
+		return true
+	}
+	err := captcha.Verify(c.Request.Context(), token, middleware.ClientIP(c))
+	switch {
+	case err == nil:
+		captchaVerificationsTotal.WithLabelValues("passed").Inc()
+		return false
+	case errors.Is(err, errCaptchaRejected):
+		captchaVerificationsTotal.WithLabelValues("rejected").Inc()
+		respondError(c, http.StatusBadRequest, "CAPTCHA_INVALID", "CAPTCHA verification failed")
+	default:
+		captchaVerificationsTotal.WithLabelValues("error").Inc()
+		middleware.Logger(c).Error("CAPTCHA verification unavailable", zap.Error(err))
+		respondError(c, http.StatusServiceUnavailable, "CAPTCHA_UNAVAILABLE", "CAPTCHA verification is unavailable, try again later")
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// errVersionConflict means the row changed since the client last read it
+var errVersionConflict = errors.New("version conflict")
+
+// etagFor formats a row version as a strong ETag
+func etagFor(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// setETag exposes the current row version for a later If-Match
+func setETag(c *gin.Context, version int) {
+	c.Header("ETag", etagFor(version))
+}
+
+// expectedVersion returns the version the client based its update on, read
+// from If-Match or, failing that, the version field of the body (0 = absent).
+// It writes 428 or 400 and returns false when there is no usable version.
+func expectedVersion(c *gin.Context, bodyVersion int) (int, bool) {
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+		if err != nil || version < 1 {
+			respondError(c, http.StatusBadRequest, "INVALID_IF_MATCH", "If-Match must be an ETag returned by this API")
+			return 0, false
+		}
+		return version, true
+	}
+	if bodyVersion > 0 {
+		return bodyVersion, true
+	}
+	respondError(c, http.StatusPreconditionRequired, "VERSION_REQUIRED", "Send the current version in If-Match or the version field")
+	return 0, false
+}
+
+// updateVersioned applies updates only if the row is still at expected and
+// bumps its version, returning errVersionConflict otherwise
+func updateVersioned(tx *gorm.DB, model interface{}, expected int, updates map[string]interface{}) error {
+	updates["version"] = gorm.Expr("version + 1")
+	result := tx.Model(model).Where("version = ?", expected).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errVersionConflict
+	}
+	return nil
+}
+
+// respondVersionConflict reports a lost update along with the current version
+func respondVersionConflict(c *gin.Context, current int) {
+	setETag(c, current)
+	respondError(c, http.StatusConflict, "VERSION_CONFLICT", "The resource was modified by another request", gin.H{"current_version": current})
+}
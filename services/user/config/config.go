@@ -0,0 +1,247 @@
+// Package config resolves configuration values from Consul KV, falling back to
+// environment variables and then to caller-provided defaults.
+//
+// Keys use the same names as the environment variables (e.g. JWT_EXPIRY) and
+// live under a per-service KV prefix such as "config/user-service/". Only the
+// keys the service passes to Init are taken from KV; any other key there is
+// ignored, so secrets and destructive switches can only come from the
+// environment. Values are looked up on every call, so anything read at
+// request time picks up KV changes as soon as Watch observes them; values
+// read once at startup (ports, pool sizes) still require a restart.
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"go.uber.org/zap"
+)
+
+// Store holds the latest snapshot of the service's KV prefix
+type Store struct {
+	mu      sync.RWMutex
+	values  map[string]string
+	ignored []string
+	client  *api.Client
+	prefix  string
+	allowed []string
+	index   uint64
+}
+
+var defaultStore = &Store{values: map[string]string{}}
+
+// Init loads the KV prefix into the default store. allowed lists the keys KV
+// may set; an entry ending in "*" allows every key with that prefix (e.g.
+// "FEATURE_*"). A failure leaves the store empty so lookups fall back to the
+// environment.
+func Init(client *api.Client, prefix string, allowed []string) error {
+	defaultStore.client = client
+	defaultStore.prefix = strings.TrimSuffix(prefix, "/") + "/"
+	defaultStore.allowed = allowed
+	return defaultStore.load(context.Background(), 0)
+}
+
+// Watch keeps the default store in sync with Consul using blocking queries
+// until ctx is cancelled
+func Watch(ctx context.Context) {
+	go defaultStore.watch(ctx)
+}
+
+func (s *Store) load(ctx context.Context, waitIndex uint64) error {
+	if s.client == nil {
+		return fmt.Errorf("config store has no consul client")
+	}
+	opts := (&api.QueryOptions{WaitIndex: waitIndex, WaitTime: 5 * time.Minute}).WithContext(ctx)
+	pairs, meta, err := s.client.KV().List(s.prefix, opts)
+	if err != nil {
+		return err
+	}
+
+	values, ignored := s.accept(pairs)
+
+	s.mu.Lock()
+	changed := diffKeys(s.values, values)
+	newlyIgnored := strings.Join(ignored, ",") != strings.Join(s.ignored, ",")
+	s.values = values
+	s.ignored = ignored
+	// Consul may reset its index (e.g. after a snapshot restore); start over
+	if meta.LastIndex < waitIndex {
+		s.index = 0
+	} else {
+		s.index = meta.LastIndex
+	}
+	s.mu.Unlock()
+
+	if len(changed) > 0 && waitIndex != 0 {
+		zap.L().Info("Configuration reloaded from Consul", zap.Strings("keys", changed))
+	}
+	if newlyIgnored && len(ignored) > 0 {
+		zap.L().Warn("Ignoring Consul keys that may only be set in the environment", zap.Strings("keys", ignored))
+	}
+	return nil
+}
+
+// accept normalizes the KV pairs under the prefix, keeping the allowed keys
+// and returning the names of the rest, sorted
+func (s *Store) accept(pairs api.KVPairs) (map[string]string, []string) {
+	values := make(map[string]string, len(pairs))
+	var ignored []string
+	for _, pair := range pairs {
+		key := normalizeKey(strings.TrimPrefix(pair.Key, s.prefix))
+		if key == "" {
+			continue
+		}
+		if !s.isAllowed(key) {
+			ignored = append(ignored, key)
+			continue
+		}
+		values[key] = strings.TrimSpace(string(pair.Value))
+	}
+	sort.Strings(ignored)
+	return values, ignored
+}
+
+func (s *Store) isAllowed(key string) bool {
+	for _, allowed := range s.allowed {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) watch(ctx context.Context) {
+	backoff := time.Second
+	for {
+		s.mu.RLock()
+		index := s.index
+		s.mu.RUnlock()
+
+		err := s.load(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			zap.L().Warn("Failed to watch Consul configuration", zap.Error(err), zap.Duration("retry_in", backoff))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+	}
+}
+
+// normalizeKey maps KV keys like "jwt-expiry" or "jwt/expiry" to "JWT_EXPIRY"
+func normalizeKey(key string) string {
+	return strings.ToUpper(strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(strings.Trim(key, "/")))
+}
+
+func diffKeys(old, updated map[string]string) []string {
+	var changed []string
+	for key, value := range updated {
+		if previous, ok := old[key]; !ok || previous != value {
+			changed = append(changed, key)
+		}
+	}
+	for key := range old {
+		if _, ok := updated[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// Lookup returns the value for key from Consul KV, then the environment
+func Lookup(key string) (string, bool) {
+	defaultStore.mu.RLock()
+	value, ok := defaultStore.values[key]
+	defaultStore.mu.RUnlock()
+	if ok && value != "" {
+		return value, true
+	}
+	if value := os.Getenv(key); value != "" {
+		return value, true
+	}
+	return "", false
+}
+
+// String returns the configured value or fallback when unset
+func String(key, fallback string) string {
+	if value, ok := Lookup(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// Int parses an integer value, falling back on absence or parse error
+func Int(key string, fallback int) int {
+	value, ok := Lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		zap.L().Warn("Invalid integer in configuration, using default", zap.String("key", key), zap.String("value", value), zap.Int("default", fallback))
+		return fallback
+	}
+	return parsed
+}
+
+// Bool parses a boolean value, falling back on absence or parse error
+func Bool(key string, fallback bool) bool {
+	value, ok := Lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		zap.L().Warn("Invalid boolean in configuration, using default", zap.String("key", key), zap.String("value", value), zap.Bool("default", fallback))
+		return fallback
+	}
+	return parsed
+}
+
+// Duration parses a duration value (e.g. "15m"), falling back on absence or parse error
+func Duration(key string, fallback time.Duration) time.Duration {
+	value, ok := Lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		zap.L().Warn("Invalid duration in configuration, using default", zap.String("key", key), zap.String("value", value), zap.Duration("default", fallback))
+		return fallback
+	}
+	return parsed
+}
+
+// Require returns an error naming every key that has no value
+func Require(keys ...string) error {
+	var missing []string
+	for _, key := range keys {
+		if _, ok := Lookup(key); !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required configuration: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+)
+
+func TestAcceptOnlyAllowedKeys(t *testing.T) {
+	s := &Store{prefix: "config/user-service/", allowed: []string{"JWT_EXPIRY", "FEATURE_*"}}
+	pairs := api.KVPairs{
+		{Key: "config/user-service/jwt-expiry", Value: []byte(" 30m ")},
+		{Key: "config/user-service/FEATURE_AVATARS", Value: []byte("true")},
+		{Key: "config/user-service/JWT_SECRET", Value: []byte("attacker")},
+		{Key: "config/user-service/db/reset", Value: []byte("true")},
+		{Key: "config/user-service/BOOTSTRAP_ADMIN_PASSWORD", Value: []byte("pw")},
+		{Key: "config/user-service/", Value: nil},
+	}
+
+	values, ignored := s.accept(pairs)
+	wantValues := map[string]string{"JWT_EXPIRY": "30m", "FEATURE_AVATARS": "true"}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("values = %v, want %v", values, wantValues)
+	}
+	wantIgnored := []string{"BOOTSTRAP_ADMIN_PASSWORD", "DB_RESET", "JWT_SECRET"}
+	if !reflect.DeepEqual(ignored, wantIgnored) {
+		t.Errorf("ignored = %v, want %v", ignored, wantIgnored)
+	}
+}
+
+func TestLookupIgnoresDisallowedKV(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	previous := defaultStore.values
+	t.Cleanup(func() { defaultStore.values = previous })
+
+	s := &Store{prefix: "p/", allowed: []string{"LOG_LEVEL"}}
+	defaultStore.values, _ = s.accept(api.KVPairs{
+		{Key: "p/APP_ENV", Value: []byte("development")},
+		{Key: "p/LOG_LEVEL", Value: []byte("debug")},
+	})
+
+	if got := String("APP_ENV", ""); got != "production" {
+		t.Errorf("APP_ENV = %q, want the environment value", got)
+	}
+	if got := String("LOG_LEVEL", "info"); got != "debug" {
+		t.Errorf("LOG_LEVEL = %q, want the KV value", got)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"go.uber.org/zap"
+)
+
+const serviceID = "user-service"
+
+func initConsul() (*api.Client, error) {
+	config := api.DefaultConfig()
+	config.Address = os.Getenv("CONSUL_HTTP_ADDR")
+	if config.Address == "" {
+		config.Address = "http://localhost:8500"
+	}
+	return api.NewClient(config)
+}
+
+// Consul check types, selected with CONSUL_CHECK_TYPES
+const (
+	// consulCheckHTTP has Consul poll /health/ready
+	consulCheckHTTP = "http"
+	// consulCheckGRPC has Consul call the gRPC health service
+	consulCheckGRPC = "grpc"
+	// consulCheckTTL has the service report its own readiness, for
+	// topologies where Consul can't reach it (see startConsulTTLCheck)
+	consulCheckTTL = "ttl"
+)
+
+// consulTTLCheckID identifies the TTL check the service keeps updated
+const consulTTLCheckID = serviceID + ":ttl"
+
+// consulCheckTypes returns CONSUL_CHECK_TYPES, rejecting unknown types
+func consulCheckTypes() ([]string, error) {
+	types := getEnvList("CONSUL_CHECK_TYPES", consulCheckHTTP+","+consulCheckGRPC)
+	for _, checkType := range types {
+		switch checkType {
+		case consulCheckHTTP, consulCheckGRPC, consulCheckTTL:
+		default:
+			return nil, fmt.Errorf("unknown consul check type %q, want http, grpc or ttl", checkType)
+		}
+	}
+	return types, nil
+}
+
+func consulCheckTTLInterval() time.Duration {
+	return getEnvDuration("CONSUL_CHECK_TTL", 15*time.Second)
+}
+
+// consulChecks builds the checks registered with the service, one per
+// entry of CONSUL_CHECK_TYPES
+func consulChecks(port int) (api.AgentServiceChecks, error) {
+	types, err := consulCheckTypes()
+	if err != nil {
+		return nil, err
+	}
+	var checks api.AgentServiceChecks
+	for _, checkType := range types {
+		switch checkType {
+		case consulCheckHTTP:
+			checks = append(checks, &api.AgentServiceCheck{
+				Name:                           "HTTP readiness",
+				HTTP:                           fmt.Sprintf("http://user-service:%d%s/health/ready", port, routePrefix()),
+				Interval:                       "10s",
+				Timeout:                        "1s",
+				DeregisterCriticalServiceAfter: "30s",
+			})
+		case consulCheckGRPC:
+			checks = append(checks, &api.AgentServiceCheck{
+				Name:                           "gRPC health",
+				GRPC:                           "user-service:" + getEnv("GRPC_PORT", "9002"),
+				Interval:                       "10s",
+				Timeout:                        "1s",
+				DeregisterCriticalServiceAfter: "30s",
+			})
+		case consulCheckTTL:
+			checks = append(checks, &api.AgentServiceCheck{
+				CheckID:                        consulTTLCheckID,
+				Name:                           "TTL readiness",
+				TTL:                            consulCheckTTLInterval().String(),
+				DeregisterCriticalServiceAfter: "30s",
+			})
+		}
+	}
+	return checks, nil
+}
+
+func registerService(client *api.Client) error {
+	port, _ := strconv.Atoi(getEnv("PORT", "8002"))
+	checks, err := consulChecks(port)
+	if err != nil {
+		return err
+	}
+	registration := &api.AgentServiceRegistration{
+		ID:      serviceID,
+		Name:    "user-service",
+		Port:    port,
+		Address: "user-service",
+		Checks:  checks,
+		Tags:    []string{"user", "api", "grpc"},
+		Meta:    map[string]string{"grpc_port": getEnv("GRPC_PORT", "9002")},
+	}
+	return client.Agent().ServiceRegister(registration)
+}
+
+func deregisterService(client *api.Client) error {
+	return client.Agent().ServiceDeregister(serviceID)
+}
+
+// registerServiceWithRetry retries registration with exponential backoff until
+// CONSUL_REGISTER_MAX_ATTEMPTS is exhausted or ctx is cancelled
+func registerServiceWithRetry(ctx context.Context, client *api.Client) error {
+	maxAttempts := getEnvInt("CONSUL_REGISTER_MAX_ATTEMPTS", 5)
+	delay := getEnvDuration("CONSUL_REGISTER_BASE_DELAY", time.Second)
+	const maxDelay = 30 * time.Second
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = registerService(client); err == nil {
+			zap.L().Info("Registered with Consul", zap.Int("attempt", attempt), zap.Int("max_attempts", maxAttempts))
+			return nil
+		}
+		zap.L().Warn("Consul registration attempt failed", zap.Int("attempt", attempt), zap.Int("max_attempts", maxAttempts), zap.Error(err))
+		if attempt == maxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+	return fmt.Errorf("consul registration failed after %d attempts: %w", maxAttempts, err)
+}
+
+// startRegistrationWatcher periodically checks that the service is still known
+// to the local Consul agent and re-registers it if the agent lost it (e.g. after
+// an agent restart)
+func startRegistrationWatcher(ctx context.Context, client *api.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("consul-registration", defaultHeartbeatMaxAge(interval)+5*time.Minute)
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("consul-registration")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("consul-registration")
+				services, err := client.Agent().Services()
+				if err == nil {
+					if _, ok := services[serviceID]; ok {
+						continue
+					}
+					zap.L().Warn("Service missing from Consul agent, re-registering")
+				}
+				if err := registerServiceWithRetry(ctx, client); err != nil {
+					zap.L().Error("Consul re-registration failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// startConsulTTLCheck reports readiness to the TTL check three times per TTL
+// until ctx is cancelled. It does nothing unless CONSUL_CHECK_TYPES includes
+// ttl.
+func startConsulTTLCheck(ctx context.Context, client *api.Client, ready func(context.Context) (bool, string)) {
+	types, _ := consulCheckTypes()
+	enabled := false
+	for _, checkType := range types {
+		enabled = enabled || checkType == consulCheckTTL
+	}
+	if !enabled {
+		return
+	}
+	report := func() {
+		ok, output := ready(ctx)
+		status := api.HealthPassing
+		if !ok {
+			status = api.HealthCritical
+		}
+		// The check is gone while the agent has lost the registration; the
+		// registration watcher restores both
+		if err := client.Agent().UpdateTTL(consulTTLCheckID, output, status); err != nil {
+			zap.L().Warn("Failed to update Consul TTL check", zap.Error(err))
+		}
+	}
+
+	interval := consulCheckTTLInterval() / 3
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("consul-ttl-check", defaultHeartbeatMaxAge(interval))
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("consul-ttl-check")
+		// Report right away; the check stays critical until the first update
+		report()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("consul-ttl-check")
+				report()
+			}
+		}
+	}()
+}
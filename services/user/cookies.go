@@ -0,0 +1,95 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// refreshTokenCookie holds the refresh token for cookie sessions; the access
+// token cookie is named by middleware.AccessTokenCookie
+const refreshTokenCookie = "refresh_token"
+
+// Token delivery modes, chosen per request with ?tokens= or a tokens
+// parameter on Accept (e.g. "Accept: application/json; tokens=cookie")
+const (
+	// tokensInBody returns the tokens in the JSON body, the default
+	tokensInBody = "body"
+	// tokensInCookies sets them as HttpOnly cookies and leaves them out of the
+	// body, so browser scripts never see them
+	tokensInCookies = "cookie"
+	// tokensInBoth does both
+	tokensInBoth = "both"
+)
+
+// tokenDelivery is how the request wants tokens returned. Unknown values
+// fall back to the body.
+func tokenDelivery(c *gin.Context) string {
+	mode := c.Query("tokens")
+	if mode == "" {
+		for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+			if _, params, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && params["tokens"] != "" {
+				mode = params["tokens"]
+				break
+			}
+		}
+	}
+	switch mode {
+	case tokensInCookies, tokensInBoth:
+		return mode
+	}
+	return tokensInBody
+}
+
+// authCookieSameSite is AUTH_COOKIE_SAMESITE. Strict (the default) keeps the
+// cookies off cross-site requests, which is what protects cookie sessions
+// against CSRF; none should only be used with a CSRF defense in front.
+func authCookieSameSite() http.SameSite {
+	switch strings.ToLower(getEnv("AUTH_COOKIE_SAMESITE", "strict")) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteStrictMode
+}
+
+// setAuthCookie writes one HttpOnly auth cookie scoped to the API's paths; a
+// negative maxAge deletes it
+func setAuthCookie(c *gin.Context, name, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     routePrefix() + "/",
+		Domain:   getEnv("AUTH_COOKIE_DOMAIN", ""),
+		MaxAge:   maxAge,
+		Secure:   getEnvBool("AUTH_COOKIE_SECURE", true),
+		HttpOnly: true,
+		SameSite: authCookieSameSite(),
+	})
+}
+
+// respondTokens writes the token pair in the given delivery mode, merged
+// into body. Cookie-only responses keep expires_in so clients know when to
+// refresh.
+func respondTokens(c *gin.Context, mode string, tokens *TokenPair, body gin.H) {
+	if mode != tokensInBody {
+		setAuthCookie(c, middleware.AccessTokenCookie, tokens.AccessToken, int(tokens.ExpiresIn))
+		setAuthCookie(c, refreshTokenCookie, tokens.RefreshToken, int(refreshTokenTTL().Seconds()))
+	}
+	if mode != tokensInCookies {
+		body["token"] = tokens.AccessToken
+		body["refresh_token"] = tokens.RefreshToken
+	}
+	body["expires_in"] = tokens.ExpiresIn
+	c.JSON(http.StatusOK, body)
+}
+
+// clearAuthCookies removes both auth cookies
+func clearAuthCookies(c *gin.Context) {
+	setAuthCookie(c, middleware.AccessTokenCookie, "", -1)
+	setAuthCookie(c, refreshTokenCookie, "", -1)
+}
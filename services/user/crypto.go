@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// encryptedPrefix marks a value written by encryptSecret, formatted as
+	// enc:<key id>:base64(nonce|ciphertext)
+	encryptedPrefix = "enc:"
+	// legacyKeyID names SECRET_ENCRYPTION_KEY in the keyring
+	legacyKeyID = "legacy"
+)
+
+var errNoEncryptionKey = errors.New("no encryption key configured: set SECRET_ENCRYPTION_KEYS or SECRET_ENCRYPTION_KEY")
+
+// keyring holds every key that can decrypt stored values and the ID of the
+// one used for new writes
+type keyring struct {
+	keys     map[string][]byte
+	activeID string
+}
+
+func decodeKey(name, encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not valid base64: %w", name, err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%s must decode to 32 bytes", name)
+	}
+	return key, nil
+}
+
+// loadKeyring reads SECRET_ENCRYPTION_KEYS ("id:base64key,...") and the older
+// single SECRET_ENCRYPTION_KEY. New values use SECRET_ENCRYPTION_KEY_ID, or
+// the first listed key; old keys stay listed so existing data can be read.
+func loadKeyring() (*keyring, error) {
+	ring := &keyring{keys: map[string][]byte{}}
+	for _, entry := range getEnvList("SECRET_ENCRYPTION_KEYS", "") {
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || strings.Contains(id, ":") {
+			return nil, errors.New("SECRET_ENCRYPTION_KEYS entries must be id:base64key")
+		}
+		key, err := decodeKey("SECRET_ENCRYPTION_KEYS["+id+"]", encoded)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[id] = key
+		if ring.activeID == "" {
+			ring.activeID = id
+		}
+	}
+	if encoded := getEnv("SECRET_ENCRYPTION_KEY", ""); encoded != "" {
+		key, err := decodeKey("SECRET_ENCRYPTION_KEY", encoded)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[legacyKeyID] = key
+		if ring.activeID == "" {
+			ring.activeID = legacyKeyID
+		}
+	}
+	if id := getEnv("SECRET_ENCRYPTION_KEY_ID", ""); id != "" {
+		if _, ok := ring.keys[id]; !ok {
+			return nil, fmt.Errorf("SECRET_ENCRYPTION_KEY_ID %q is not in the keyring", id)
+		}
+		ring.activeID = id
+	}
+	if ring.activeID == "" {
+		return nil, errNoEncryptionKey
+	}
+	return ring, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret seals plaintext with AES-GCM under the active key
+func encryptSecret(plaintext string) (string, error) {
+	ring, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(ring.keys[ring.activeID])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + ring.activeID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret reverses encryptSecret using the key named in the value
+func decryptSecret(value string) (string, error) {
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok || !strings.HasPrefix(value, encryptedPrefix) {
+		return "", errors.New("value is not encrypted")
+	}
+	ring, err := loadKeyring()
+	if err != nil {
+		return "", err
+	}
+	key, ok := ring.keys[id]
+	if !ok {
+		return "", fmt.Errorf("encryption key %q is not configured", id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// EncryptedString is a string column encrypted at rest. It holds plaintext in
+// memory and is sealed on write, so handlers use it like any other string,
+// including in map updates. Empty strings are stored as-is, and values
+// written before the column was encrypted are read back unchanged.
+type EncryptedString string
+
+// Value implements driver.Valuer
+func (s EncryptedString) Value() (driver.Value, error) {
+	if s == "" {
+		return "", nil
+	}
+	return encryptSecret(string(s))
+}
+
+// Scan implements sql.Scanner
+func (s *EncryptedString) Scan(value interface{}) error {
+	var stored string
+	switch v := value.(type) {
+	case nil:
+		*s = ""
+		return nil
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into EncryptedString", value)
+	}
+	if !strings.HasPrefix(stored, encryptedPrefix) {
+		*s = EncryptedString(stored)
+		return nil
+	}
+	plaintext, err := decryptSecret(stored)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plaintext)
+	return nil
+}
+
+// phoneBackfillBatchSize bounds how many users encryptPlaintextPhoneNumbers
+// rewrites per query
+const phoneBackfillBatchSize = 500
+
+// encryptPlaintextPhoneNumbers seals phone numbers written before the column
+// was encrypted. A row is only rewritten while it still holds the plaintext
+// that was read, so a concurrent profile update is never overwritten; the
+// backfill is idempotent and safe to run on every migration.
+func encryptPlaintextPhoneNumbers(db *gorm.DB) error {
+	total := 0
+	for {
+		var rows []struct {
+			ID          uuid.UUID
+			PhoneNumber string
+		}
+		err := db.Table("users").Select("id, phone_number").
+			Where("phone_number <> '' AND phone_number NOT LIKE ?", encryptedPrefix+"%").
+			Order("id").Limit(phoneBackfillBatchSize).Find(&rows).Error
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			sealed, err := encryptSecret(row.PhoneNumber)
+			if err != nil {
+				return err
+			}
+			if err := db.Table("users").
+				Where("id = ? AND phone_number = ?", row.ID, row.PhoneNumber).
+				Update("phone_number", sealed).Error; err != nil {
+				return err
+			}
+		}
+		total += len(rows)
+	}
+	if total > 0 {
+		zap.L().Info("Encrypted plaintext phone numbers", zap.Int("count", total))
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func setKeyring(t *testing.T, keys, activeID string) {
+	t.Helper()
+	t.Setenv("SECRET_ENCRYPTION_KEYS", keys)
+	t.Setenv("SECRET_ENCRYPTION_KEY_ID", activeID)
+	t.Setenv("SECRET_ENCRYPTION_KEY", "")
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	setKeyring(t, "k1:"+testKey(1), "")
+
+	sealed, err := encryptSecret("+15551234567")
+	if err != nil {
+		t.Fatalf("encryptSecret: %v", err)
+	}
+	if !strings.HasPrefix(sealed, encryptedPrefix+"k1:") || strings.Contains(sealed, "5551234567") {
+		t.Fatalf("sealed value %q is not encrypted under k1", sealed)
+	}
+	if again, _ := encryptSecret("+15551234567"); again == sealed {
+		t.Error("encrypting twice produced the same ciphertext")
+	}
+	plaintext, err := decryptSecret(sealed)
+	if err != nil || plaintext != "+15551234567" {
+		t.Fatalf("decryptSecret = %q, %v; want the original", plaintext, err)
+	}
+
+	// Tampering with the ciphertext is detected
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, encryptedPrefix+"k1:"))
+	raw[len(raw)-1] ^= 1
+	if _, err := decryptSecret(encryptedPrefix + "k1:" + base64.StdEncoding.EncodeToString(raw)); err == nil {
+		t.Error("decryptSecret accepted a tampered ciphertext")
+	}
+}
+
+func TestEncryptSecretKeyRotation(t *testing.T) {
+	setKeyring(t, "k1:"+testKey(1), "")
+	old, err := encryptSecret("123456")
+	if err != nil {
+		t.Fatalf("encryptSecret: %v", err)
+	}
+
+	// k2 becomes active; values sealed under k1 still decrypt
+	setKeyring(t, "k1:"+testKey(1)+",k2:"+testKey(2), "k2")
+	if plaintext, err := decryptSecret(old); err != nil || plaintext != "123456" {
+		t.Fatalf("decryptSecret(old) after rotation = %q, %v", plaintext, err)
+	}
+	fresh, err := encryptSecret("123456")
+	if err != nil {
+		t.Fatalf("encryptSecret: %v", err)
+	}
+	if !strings.HasPrefix(fresh, encryptedPrefix+"k2:") {
+		t.Errorf("new value %q is not sealed under the active key k2", fresh)
+	}
+
+	// Once k1 is retired its values can no longer be read
+	setKeyring(t, "k2:"+testKey(2), "")
+	if _, err := decryptSecret(old); err == nil {
+		t.Error("decryptSecret succeeded with the key removed from the keyring")
+	}
+	if plaintext, err := decryptSecret(fresh); err != nil || plaintext != "123456" {
+		t.Errorf("decryptSecret(fresh) = %q, %v", plaintext, err)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     string
+		activeID string
+		legacy   string
+		wantErr  bool
+		wantID   string
+	}{
+		{name: "none", wantErr: true},
+		{name: "first key active", keys: "a:" + testKey(1) + ",b:" + testKey(2), wantID: "a"},
+		{name: "explicit active key", keys: "a:" + testKey(1) + ",b:" + testKey(2), activeID: "b", wantID: "b"},
+		{name: "legacy key", legacy: testKey(3), wantID: legacyKeyID},
+		{name: "unknown active key", keys: "a:" + testKey(1), activeID: "z", wantErr: true},
+		{name: "missing id", keys: testKey(1), wantErr: true},
+		{name: "short key", keys: "a:" + base64.StdEncoding.EncodeToString([]byte("short")), wantErr: true},
+		{name: "not base64", keys: "a:!!!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setKeyring(t, tt.keys, tt.activeID)
+			t.Setenv("SECRET_ENCRYPTION_KEY", tt.legacy)
+			ring, err := loadKeyring()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("loadKeyring succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadKeyring: %v", err)
+			}
+			if ring.activeID != tt.wantID {
+				t.Errorf("activeID = %q, want %q", ring.activeID, tt.wantID)
+			}
+		})
+	}
+
+	setKeyring(t, "", "")
+	if _, err := encryptSecret("x"); !errors.Is(err, errNoEncryptionKey) {
+		t.Errorf("encryptSecret without keys err = %v, want %v", err, errNoEncryptionKey)
+	}
+}
+
+func TestEncryptedStringScan(t *testing.T) {
+	setKeyring(t, "k1:"+testKey(1), "")
+	sealed, err := EncryptedString("+15551234567").Value()
+	if err != nil {
+		t.Fatalf("Value: %v", err)
+	}
+
+	for _, stored := range []interface{}{sealed, []byte(sealed.(string)), "+15551234567"} {
+		var got EncryptedString
+		if err := got.Scan(stored); err != nil || got != "+15551234567" {
+			t.Errorf("Scan(%v) = %q, %v", stored, got, err)
+		}
+	}
+	if empty, _ := EncryptedString("").Value(); empty != "" {
+		t.Errorf("empty value stored as %q", empty)
+	}
+}
+
+func TestEncryptPlaintextPhoneNumbers(t *testing.T) {
+	setKeyring(t, "k1:"+testKey(1), "")
+	id := "7d3d0f6e-2c1b-4b8e-9a44-6f1f3a2b9c10"
+	var updates [][]driver.NamedValue
+	selects := 0
+	db, _ := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		switch {
+		case strings.HasPrefix(query, "SELECT"):
+			selects++
+			if selects > 1 {
+				return fakeResult{columns: []string{"id", "phone_number"}}
+			}
+			return fakeResult{columns: []string{"id", "phone_number"}, rows: [][]driver.Value{{id, "+15551234567"}}}
+		case strings.HasPrefix(query, "UPDATE"):
+			updates = append(updates, args)
+			return fakeResult{affected: 1}
+		}
+		return fakeResult{}
+	})
+
+	if err := encryptPlaintextPhoneNumbers(db); err != nil {
+		t.Fatalf("encryptPlaintextPhoneNumbers: %v", err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("ran %d updates, want 1", len(updates))
+	}
+	sealed, _ := updates[0][0].Value.(string)
+	if plaintext, err := decryptSecret(sealed); err != nil || plaintext != "+15551234567" {
+		t.Errorf("stored %q, which decrypts to %q, %v", sealed, plaintext, err)
+	}
+	// The rewrite is guarded by the plaintext read, so concurrent edits win
+	if guard := updates[0][len(updates[0])-1].Value; guard != "+15551234567" {
+		t.Errorf("update guarded on %v, want the plaintext value", guard)
+	}
+}
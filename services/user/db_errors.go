@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// pgUniqueViolation is the SQLSTATE Postgres reports for a unique constraint violation
+const pgUniqueViolation = "23505"
+
+// Unique indexes on users and user_emails, as named by GORM's uniqueIndex tag
+const (
+	usersEmailIndex          = "idx_users_email"
+	usersUsernameIndex       = "idx_users_username"
+	userEmailsEmailIndex     = "idx_user_emails_email"
+	userEmailsUserEmailIndex = "idx_user_emails_user_email"
+)
+
+// UniqueViolationError is a unique constraint violation that keeps the
+// constraint name, which the driver's own translation drops. It still
+// matches gorm.ErrDuplicatedKey with errors.Is.
+type UniqueViolationError struct {
+	Constraint string
+	err        error
+}
+
+func (e *UniqueViolationError) Error() string { return e.err.Error() }
+
+func (e *UniqueViolationError) Unwrap() error { return e.err }
+
+func (e *UniqueViolationError) Is(target error) bool { return target == gorm.ErrDuplicatedKey }
+
+// translatingDialector wraps the postgres dialector so unique violations
+// surface as UniqueViolationError
+type translatingDialector struct {
+	*postgres.Dialector
+}
+
+func (d translatingDialector) Translate(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation {
+		return &UniqueViolationError{Constraint: pgErr.ConstraintName, err: err}
+	}
+	return d.Dialector.Translate(err)
+}
+
+// uniqueViolation returns the violated constraint when err is a unique violation
+func uniqueViolation(err error) (string, bool) {
+	var violation *UniqueViolationError
+	if errors.As(err, &violation) {
+		return violation.Constraint, true
+	}
+	return "", errors.Is(err, gorm.ErrDuplicatedKey)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// errCircuitOpen is returned without touching the database while the breaker is open
+var errCircuitOpen = errors.New("database circuit breaker is open")
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+// isTransientDBError reports whether err looks like a lost or refused
+// connection (e.g. during failover) rather than a problem with the query
+func isTransientDBError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection exceptions; 57P01-03 are shutdowns and
+		// startup; 53300 is too many connections
+		return strings.HasPrefix(pgErr.Code, "08") ||
+			pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03" || pgErr.Code == "53300"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || pgconn.SafeToRetry(err)
+}
+
+// circuitBreaker fails database calls fast after consecutive transient
+// errors, then lets a single probe through once the cooldown has passed
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		threshold: getEnvInt("DB_BREAKER_THRESHOLD", 5),
+		cooldown:  getEnvDuration("DB_BREAKER_COOLDOWN", 10*time.Second),
+	}
+}
+
+// State is closed, open, or half_open while a probe is allowed
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state()
+}
+
+func (b *circuitBreaker) state() string {
+	switch {
+	case b.failures < b.threshold:
+		return circuitClosed
+	case time.Since(b.openedAt) < b.cooldown:
+		return circuitOpen
+	}
+	return circuitHalfOpen
+}
+
+// Allow reports whether a call may go to the database
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state() {
+	case circuitClosed:
+		return true
+	case circuitHalfOpen:
+		if !b.probing {
+			b.probing = true
+			return true
+		}
+	}
+	return false
+}
+
+// Record updates the breaker with a call's outcome; only transient errors count
+func (b *circuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !isTransientDBError(err) {
+		if b.failures >= b.threshold {
+			zap.L().Info("Database circuit breaker closed")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			zap.L().Warn("Database circuit breaker opened", zap.Error(err))
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+// Name implements gorm.Plugin
+func (b *circuitBreaker) Name() string { return "circuit_breaker" }
+
+// Initialize implements gorm.Plugin, guarding every statement GORM executes
+func (b *circuitBreaker) Initialize(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		if !b.Allow() {
+			tx.AddError(errCircuitOpen)
+		}
+	}
+	after := func(tx *gorm.DB) {
+		if !errors.Is(tx.Error, errCircuitOpen) {
+			b.Record(tx.Error)
+		}
+	}
+	cb := db.Callback()
+	for _, register := range []func() error{
+		func() error { return cb.Create().Before("gorm:create").Register("breaker:before_create", before) },
+		func() error { return cb.Create().After("gorm:create").Register("breaker:after_create", after) },
+		func() error { return cb.Query().Before("gorm:query").Register("breaker:before_query", before) },
+		func() error { return cb.Query().After("gorm:query").Register("breaker:after_query", after) },
+		func() error { return cb.Update().Before("gorm:update").Register("breaker:before_update", before) },
+		func() error { return cb.Update().After("gorm:update").Register("breaker:after_update", after) },
+		func() error { return cb.Delete().Before("gorm:delete").Register("breaker:before_delete", before) },
+		func() error { return cb.Delete().After("gorm:delete").Register("breaker:after_delete", after) },
+		func() error { return cb.Row().Before("gorm:row").Register("breaker:before_row", before) },
+		func() error { return cb.Row().After("gorm:row").Register("breaker:after_row", after) },
+		func() error { return cb.Raw().Before("gorm:raw").Register("breaker:before_raw", before) },
+		func() error { return cb.Raw().After("gorm:raw").Register("breaker:after_raw", after) },
+	} {
+		if err := register(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryRead runs an idempotent read, retrying transient failures a few times
+// with jittered exponential backoff (DB_READ_RETRIES). Never use it for writes:
+// a write whose connection dropped may still have committed.
+func retryRead(ctx context.Context, read func() error) error {
+	retries := getEnvInt("DB_READ_RETRIES", 2)
+	backoff := 50 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := read()
+		if err == nil || attempt >= retries || !isTransientDBError(err) {
+			return err
+		}
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
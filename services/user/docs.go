@@ -0,0 +1,87 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPISpec describes the HTTP API. It is maintained by hand; update it
+// alongside any route, request or response change.
+//
+//go:embed docs/openapi.json
+var openAPISpec []byte
+
+// Swagger UI is loaded from a CDN at a pinned version, never a floating tag.
+// The Subresource Integrity hashes make browsers refuse the assets if the CDN
+// serves different bytes; update them with the version, computing each as
+// "sha384-" + $(curl -s <url> | openssl dgst -sha384 -binary | openssl base64 -A).
+// The page omits the integrity attribute while a hash is empty.
+const (
+	swaggerUIVersion   = "5.17.14"
+	swaggerUICSSURL    = "https://unpkg.com/swagger-ui-dist@" + swaggerUIVersion + "/swagger-ui.css"
+	swaggerUIBundleURL = "https://unpkg.com/swagger-ui-dist@" + swaggerUIVersion + "/swagger-ui-bundle.js"
+	swaggerUICSSSRI    = ""
+	swaggerUIBundleSRI = ""
+)
+
+// docsPage renders the spec with Swagger UI
+var docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>User Service API</title>
+  <link rel="stylesheet" href="` + swaggerUICSSURL + `"` + integrityAttrs(swaggerUICSSSRI) + `>
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="` + swaggerUIBundleURL + `"` + integrityAttrs(swaggerUIBundleSRI) + `></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// integrityAttrs renders the integrity and crossorigin attributes for an
+// asset with hash sri, or nothing when it has none
+func integrityAttrs(sri string) string {
+	if sri == "" {
+		return ""
+	}
+	return ` integrity="` + sri + `" crossorigin="anonymous"`
+}
+
+// docsEnabled reports whether /openapi.json and /docs are served. They are on
+// by default except in production.
+func docsEnabled() bool {
+	return getEnvBool("DOCS_ENABLED", !isProduction())
+}
+
+// specForPrefix points the spec's server URL at the latest stable version
+// under the route prefix, so the docs UI's requests use versioned paths
+func specForPrefix(prefix string) []byte {
+	var spec map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return openAPISpec
+	}
+	spec["servers"] = []map[string]string{{"url": prefix + "/" + latestStableVersion()}}
+	body, err := json.Marshal(spec)
+	if err != nil {
+		return openAPISpec
+	}
+	return body
+}
+
+// registerDocs serves the OpenAPI spec and an interactive UI for it
+func registerDocs(r gin.IRoutes, prefix string) {
+	spec := specForPrefix(prefix)
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", spec)
+	})
+	r.GET("/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+	})
+}
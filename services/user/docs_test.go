@@ -0,0 +1,32 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestDocsPagePinsSwaggerUI(t *testing.T) {
+	exact := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+	if !exact.MatchString(swaggerUIVersion) {
+		t.Fatalf("swaggerUIVersion = %q, want an exact x.y.z version", swaggerUIVersion)
+	}
+	assets := regexp.MustCompile(`(?:href|src)="(https://[^"]+)"`).FindAllStringSubmatch(docsPage, -1)
+	if len(assets) != 2 {
+		t.Fatalf("docs page loads %d CDN assets, want 2", len(assets))
+	}
+	for _, asset := range assets {
+		if !strings.Contains(asset[1], "@"+swaggerUIVersion+"/") {
+			t.Errorf("%s is not pinned to %s", asset[1], swaggerUIVersion)
+		}
+	}
+
+	for _, sri := range []string{swaggerUICSSSRI, swaggerUIBundleSRI} {
+		if sri == "" {
+			continue
+		}
+		if !strings.HasPrefix(sri, "sha384-") || !strings.Contains(docsPage, `integrity="`+sri+`" crossorigin="anonymous"`) {
+			t.Errorf("integrity %q is malformed or missing from the page", sri)
+		}
+	}
+}
@@ -1,54 +1,392 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"net/smtp"
-	"os"
+	"sync"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+const emailMaxBackoff = time.Hour
+
+// OutboxEmail is an outbox row holding one email to send. Rows are written
+// in the same transaction as the change that triggers them, so an email is
+// never sent for a rolled-back change and never lost after a commit. The row
+// keeps the template and its data rather than the rendered message; the data
+// carries reset and verification tokens, so it is encrypted and cleared once
+// the email is delivered or given up on.
+type OutboxEmail struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	CreatedAt time.Time
+	// DedupKey collapses identical emails queued while an earlier copy is
+	// still undelivered, e.g. from a retried request
+	DedupKey      string          `gorm:"not null;uniqueIndex:idx_outbox_emails_pending_dedup_key,where:delivered_at IS NULL"`
+	Recipient     string          `gorm:"not null;index:idx_outbox_emails_recipient_delivered_at,priority:1"`
+	Template      string          `gorm:"not null"`
+	Locale        string          `gorm:"not null;default:''"`
+	Payload       EncryptedString `gorm:"not null;default:''"`
+	Attempts      int             `gorm:"default:0;not null"`
+	NextAttemptAt time.Time       `gorm:"index;not null"`
+	DeliveredAt   *time.Time      `gorm:"index;index:idx_outbox_emails_recipient_delivered_at,priority:2"`
+	LastError     string
+}
+
+func (e OutboxEmail) outboxID() uuid.UUID { return e.ID }
+
+// EmailService writes emails to the outbox and delivers them from a
+// background dispatcher that retries failed sends with exponential backoff
 type EmailService struct {
-	host     string
-	port     string
-	username string
-	password string
-	from     string
+	db        *gorm.DB
+	sender    EmailSender
+	templates *EmailTemplates
+
+	maxAttempts int
+	batchSize   int
+	retryDelay  time.Duration
+	timeout     time.Duration
+
+	// Sends past either limit stay queued until the limit allows them
+	sendRate        float64
+	sendBurst       int
+	recipientMax    int
+	recipientWindow time.Duration
+
+	wg sync.WaitGroup
 }
 
-func NewEmailService() *EmailService {
+// NewEmailService reads EMAIL_* delivery settings
+func NewEmailService(db *gorm.DB, sender EmailSender, templates *EmailTemplates) *EmailService {
+	batchSize := getEnvInt("EMAIL_BATCH_SIZE", 20)
 	return &EmailService{
-		host:     os.Getenv("SMTP_HOST"),
-		port:     os.Getenv("SMTP_PORT"),
-		username: os.Getenv("SMTP_USERNAME"),
-		password: os.Getenv("SMTP_PASSWORD"),
-		from:     os.Getenv("SMTP_FROM"),
-	}
-}
-
-func (e *EmailService) SendPasswordResetEmail(to, resetToken string) error {
-	// Create authentication
-	auth := smtp.PlainAuth("", e.username, e.password, e.host)
-
-	// Compose email
-	subject := "Password Reset Request"
-	resetLink := fmt.Sprintf("%s/reset-password?token=%s", os.Getenv("APP_URL"), resetToken)
-	body := fmt.Sprintf(`
-		<html>
-			<body>
-				<h2>Password Reset Request</h2>
-				<p>You have requested to reset your password. Click the link below to proceed:</p>
-				<p><a href="%s">Reset Password</a></p>
-				<p>This link will expire in 15 minutes.</p>
-				<p>If you did not request this reset, please ignore this email.</p>
-			</body>
-		</html>
-	`, resetLink)
-
-	// Format email headers
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	msg := fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: %s\r\n%s\r\n%s",
-		to, e.from, subject, mime, body)
-
-	// Send email
-	addr := fmt.Sprintf("%s:%s", e.host, e.port)
-	return smtp.SendMail(addr, auth, e.from, []string{to}, []byte(msg))
+		db:              db,
+		sender:          sender,
+		templates:       templates,
+		maxAttempts:     getEnvInt("EMAIL_MAX_ATTEMPTS", 5),
+		batchSize:       batchSize,
+		retryDelay:      getEnvDuration("EMAIL_RETRY_BASE_DELAY", 30*time.Second),
+		timeout:         getEnvDuration("EMAIL_SEND_TIMEOUT", 30*time.Second),
+		sendRate:        float64(getEnvInt("EMAIL_SENDS_PER_SECOND", 10)),
+		sendBurst:       getEnvInt("EMAIL_SEND_BURST", batchSize),
+		recipientMax:    getEnvInt("EMAIL_RECIPIENT_MAX", 20),
+		recipientWindow: getEnvDuration("EMAIL_RECIPIENT_WINDOW", time.Hour),
+	}
+}
+
+// Send adds an email to the outbox, to be rendered from its template in the
+// language closest to locale when it is delivered. Pass the transaction making
+// the change so the email commits or rolls back with it.
+func (e *EmailService) Send(tx *gorm.DB, to, locale string, data EmailTemplate) error {
+	// Render once now so a broken template fails the request, not the dispatcher
+	if _, err := e.templates.Render(to, locale, data); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(to + "\x00" + locale + "\x00" + data.templateName() + "\x00" + string(payload)))
+	row := OutboxEmail{
+		DedupKey:      hex.EncodeToString(sum[:]),
+		Recipient:     to,
+		Template:      data.templateName(),
+		Locale:        locale,
+		Payload:       EncryptedString(payload),
+		NextAttemptAt: time.Now(),
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if result.Error == nil && result.RowsAffected == 0 {
+		emailDroppedTotal.WithLabelValues("duplicate").Inc()
+	}
+	return result.Error
+}
+
+// Start polls the outbox every interval until ctx is cancelled. At most
+// EMAIL_BATCH_SIZE emails go out per poll, further limited by the send rate
+// and the per-recipient cap.
+func (e *EmailService) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("email-dispatcher", defaultHeartbeatMaxAge(interval)+e.timeout)
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("email-dispatcher")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("email-dispatcher")
+				e.dispatch(ctx)
+			}
+		}
+	}()
+}
+
+// Shutdown waits for the batch in flight to finish until ctx expires.
+// Undelivered rows stay in the outbox for the next start.
+func (e *EmailService) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("email dispatcher still sending: %w", ctx.Err())
+	}
+}
+
+func (e *EmailService) dispatch(ctx context.Context) {
+	var due int64
+	if err := e.db.WithContext(ctx).Model(&OutboxEmail{}).
+		Where("delivered_at IS NULL AND attempts < ? AND next_attempt_at <= ?", e.maxAttempts, time.Now()).
+		Count(&due).Error; err == nil {
+		emailQueueDue.Set(float64(due))
+	}
+
+	batch, err := claimOutbox[OutboxEmail](ctx, e.db, e.maxAttempts, e.batchSize)
+	if err != nil {
+		zap.L().Error("Failed to claim outbox emails", zap.Error(err))
+		return
+	}
+	quota, err := e.loadRecipientQuota(ctx, batch)
+	if err != nil {
+		zap.L().Error("Failed to count recent emails per recipient", zap.Error(err))
+		e.deferEmails(batch, time.Now())
+		return
+	}
+	budget, err := e.loadSendBudget(ctx, len(batch))
+	if err != nil {
+		zap.L().Error("Failed to count recent emails", zap.Error(err))
+		e.deferEmails(batch, time.Now())
+		return
+	}
+
+	lease := newOutboxLease[OutboxEmail](e.db, e.timeout)
+	for i, row := range batch {
+		if ctx.Err() != nil {
+			return
+		}
+		workerHeartbeats.Beat("email-dispatcher")
+		lease.keep(batch[i:])
+		if until, held := quota.hold(row.Recipient, row.Template); held {
+			emailThrottledTotal.WithLabelValues("recipient").Inc()
+			e.deferEmails(batch[i:i+1], until)
+			continue
+		}
+		if until, ok := budget.take(); !ok {
+			// The rest of the batch goes back rather than holding its claim
+			// while waiting for the window to move
+			emailThrottledTotal.WithLabelValues("rate").Add(float64(len(batch) - i))
+			e.deferEmails(batch[i:], until)
+			return
+		}
+		msg, err := e.render(row)
+		if err == nil {
+			// Not bound to ctx, so a shutdown lets the current send finish
+			sendCtx, cancel := context.WithTimeout(context.Background(), e.timeout)
+			err = e.sender.Send(sendCtx, msg)
+			cancel()
+		}
+
+		attempts := row.Attempts + 1
+		backoff := outboxBackoff(e.retryDelay, emailMaxBackoff, attempts)
+		if err != nil {
+			log := zap.L().With(zap.String("email_id", row.ID.String()), zap.String("template", row.Template), zap.Int("attempt", attempts), zap.Error(err))
+			if attempts >= e.maxAttempts {
+				log.Error("Giving up on email delivery")
+				emailDroppedTotal.WithLabelValues("exhausted").Inc()
+			} else {
+				log.Warn("Email delivery failed, will retry", zap.Duration("retry_in", backoff))
+			}
+		} else {
+			quota.sent(row.Recipient)
+		}
+		recordOutboxAttempt(e.db, row, "email", attempts, e.maxAttempts, backoff, err)
+		if err == nil || attempts >= e.maxAttempts {
+			e.purgePayload(row)
+		}
+	}
+}
+
+// render rebuilds the message for an outbox row from its template and data
+func (e *EmailService) render(row OutboxEmail) (EmailMessage, error) {
+	data, err := decodeEmailTemplate(row.Template, []byte(row.Payload))
+	if err != nil {
+		return EmailMessage{}, err
+	}
+	return e.templates.Render(row.Recipient, row.Locale, data)
+}
+
+// purgePayload clears the template data of a row that will not be sent
+// again, so tokens do not outlive delivery in the retained row
+func (e *EmailService) purgePayload(row OutboxEmail) {
+	if err := e.db.Model(&OutboxEmail{}).Where("id = ?", row.ID).Update("payload", "").Error; err != nil {
+		zap.L().Error("Failed to clear outbox email payload", zap.String("email_id", row.ID.String()), zap.Error(err))
+	}
+}
+
+// deferEmails returns claimed rows to the queue until until, without counting
+// an attempt
+func (e *EmailService) deferEmails(rows []OutboxEmail, until time.Time) {
+	ids := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	if err := e.db.Model(&OutboxEmail{}).Where("id IN ?", ids).Update("next_attempt_at", until).Error; err != nil {
+		zap.L().Error("Failed to defer outbox emails", zap.Int("count", len(ids)), zap.Error(err))
+	}
+}
+
+// recipientQuota enforces EMAIL_RECIPIENT_MAX deliveries to one address per
+// EMAIL_RECIPIENT_WINDOW. Counts come from delivered outbox rows, so the cap
+// holds across replicas.
+type recipientQuota struct {
+	max    int
+	window time.Duration
+	counts map[string]int
+	oldest map[string]time.Time
+}
+
+// loadRecipientQuota counts recent deliveries to the recipients in batch
+func (e *EmailService) loadRecipientQuota(ctx context.Context, batch []OutboxEmail) (*recipientQuota, error) {
+	quota := &recipientQuota{max: e.recipientMax, window: e.recipientWindow, counts: map[string]int{}, oldest: map[string]time.Time{}}
+	if quota.max <= 0 || quota.window <= 0 || len(batch) == 0 {
+		quota.max = 0
+		return quota, nil
+	}
+	recipients := make([]string, 0, len(batch))
+	for _, row := range batch {
+		recipients = append(recipients, row.Recipient)
+	}
+
+	var rows []struct {
+		Recipient string
+		Sent      int
+		Oldest    time.Time
+	}
+	if err := e.db.WithContext(ctx).Model(&OutboxEmail{}).
+		Select("recipient, COUNT(*) AS sent, MIN(delivered_at) AS oldest").
+		Where("recipient IN ? AND delivered_at > ?", recipients, time.Now().Add(-quota.window)).
+		Group("recipient").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		quota.counts[row.Recipient] = row.Sent
+		quota.oldest[row.Recipient] = row.Oldest
+	}
+	return quota, nil
+}
+
+// sendBudget enforces EMAIL_SENDS_PER_SECOND: at most EMAIL_SEND_BURST
+// deliveries in any window of EMAIL_SEND_BURST / EMAIL_SENDS_PER_SECOND. Like
+// recipientQuota it counts delivered outbox rows, so the rate holds across
+// replicas rather than multiplying with them.
+type sendBudget struct {
+	unlimited bool
+	left      int
+	window    time.Duration
+	oldest    time.Time
+}
+
+// loadSendBudget counts the deliveries in the current window. An empty batch
+// needs no budget.
+func (e *EmailService) loadSendBudget(ctx context.Context, batch int) (*sendBudget, error) {
+	if e.sendRate <= 0 || batch == 0 {
+		return &sendBudget{unlimited: true}, nil
+	}
+	burst := e.sendBurst
+	if burst < 1 {
+		burst = 1
+	}
+	budget := &sendBudget{window: time.Duration(float64(burst) / e.sendRate * float64(time.Second))}
+
+	var row struct {
+		Sent   int
+		Oldest *time.Time
+	}
+	if err := e.db.WithContext(ctx).Model(&OutboxEmail{}).
+		Select("COUNT(*) AS sent, MIN(delivered_at) AS oldest").
+		Where("delivered_at > ?", time.Now().Add(-budget.window)).
+		Scan(&row).Error; err != nil {
+		return nil, err
+	}
+	budget.left = burst - row.Sent
+	if row.Oldest != nil {
+		budget.oldest = *row.Oldest
+	}
+	return budget, nil
+}
+
+// take spends one send, or reports roughly when the oldest delivery in the
+// window ages out and frees one
+func (b *sendBudget) take() (time.Time, bool) {
+	if b.unlimited {
+		return time.Time{}, true
+	}
+	if b.left > 0 {
+		b.left--
+		if b.oldest.IsZero() {
+			b.oldest = time.Now()
+		}
+		return time.Time{}, true
+	}
+	if b.oldest.IsZero() {
+		return time.Now().Add(b.window), false
+	}
+	return b.oldest.Add(b.window), false
+}
+
+// unthrottledEmailTemplates carry a link or code the user is waiting for and
+// that expires, so holding them would leave the user with a dead token. They
+// still count towards the recipient's deliveries.
+var unthrottledEmailTemplates = map[string]bool{
+	PasswordResetEmail{}.templateName():              true,
+	VerificationEmail{}.templateName():               true,
+	EmailChangeConfirmationEmail{}.templateName():    true,
+	SecondaryEmailVerificationEmail{}.templateName(): true,
+}
+
+// hold reports whether an email from template to recipient must wait for the
+// cap and, if so, roughly when the oldest delivery in the window ages out
+func (q *recipientQuota) hold(recipient, template string) (time.Time, bool) {
+	if q.max <= 0 || unthrottledEmailTemplates[template] || q.counts[recipient] < q.max {
+		return time.Time{}, false
+	}
+	if oldest, ok := q.oldest[recipient]; ok {
+		return oldest.Add(q.window), true
+	}
+	return time.Now().Add(q.window), true
+}
+
+// sent counts a delivery made during this batch
+func (q *recipientQuota) sent(recipient string) {
+	q.counts[recipient]++
+	if _, ok := q.oldest[recipient]; !ok {
+		q.oldest[recipient] = time.Now()
+	}
+}
+
+// sendSecurityNotification queues an account security email outside any
+// transaction. Delivery is best effort: a failure is logged and never fails
+// the request.
+func sendSecurityNotification(c *gin.Context, db *gorm.DB, emailService *EmailService, user *User, data EmailTemplate) {
+	if err := emailService.Send(db, user.Email, user.EffectiveLocale(), data); err != nil {
+		middleware.Logger(c).Error("Failed to queue security notification", zap.String("template", data.templateName()), zap.Error(err))
+	}
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// emailChangeTokenTTL is how long a change-email link stays valid (EMAIL_CHANGE_TTL)
+func emailChangeTokenTTL() time.Duration {
+	return getEnvDuration("EMAIL_CHANGE_TTL", time.Hour)
+}
+
+// emailInUse reports whether any account, including ones pending deletion,
+// uses email as its primary or a secondary address
+func emailInUse(db *gorm.DB, email string) (bool, error) {
+	var count int64
+	if err := db.Unscoped().Model(&User{}).Where("email = ?", email).Count(&count).Error; err != nil || count > 0 {
+		return count > 0, err
+	}
+	// Unverified secondary addresses are only claims; verifying one settles
+	// who owns it
+	err := db.Model(&UserEmail{}).Where("email = ? AND verified_at IS NOT NULL", email).Count(&count).Error
+	return count > 0, err
+}
+
+// RequestEmailChange sends a confirmation link to the new address; the current
+// address stays in effect until the link is followed
+func RequestEmailChange(db *gorm.DB, emailService *EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+
+		var req ChangeEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		newEmail, err := validateEmail(req.NewEmail)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_EMAIL", "Invalid email address")
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+		if err := user.ComparePassword(req.Password); err != nil {
+			respondError(c, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Incorrect password")
+			return
+		}
+		if newEmail == user.Email {
+			respondError(c, http.StatusBadRequest, "EMAIL_UNCHANGED", "New email matches the current one")
+			return
+		}
+
+		inUse, err := emailInUse(db, newEmail)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+		if inUse {
+			respondError(c, http.StatusConflict, "EMAIL_TAKEN", "Email already registered")
+			return
+		}
+
+		token, err := linkTokenPolicy.Generate()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate token")
+			return
+		}
+		expiresAt := time.Now().Add(emailChangeTokenTTL())
+
+		// Only the hash is stored so a database leak can't be used to take over accounts
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"pending_email":           newEmail,
+				"email_change_token_hash": hashToken(token),
+				"email_change_expires_at": expiresAt,
+			}).Error; err != nil {
+				return err
+			}
+			if err := emailService.Send(tx, newEmail, user.EffectiveLocale(), NewEmailChangeConfirmationEmail(token)); err != nil {
+				return err
+			}
+			return emailService.Send(tx, user.Email, user.EffectiveLocale(), EmailChangeNoticeEmail{NewEmail: newEmail})
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to save email change")
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":    "Confirmation sent to the new email address",
+			"expires_at": expiresAt,
+		})
+	}
+}
+
+// ConfirmEmailChange switches the account to the pending address
+func ConfirmEmailChange(db *gorm.DB, emailService *EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		token := c.Query("token")
+		if token == "" {
+			respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Missing token")
+			return
+		}
+
+		var user User
+		if err := db.Where("email_change_token_hash = ?", hashToken(token)).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Invalid token")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+		if user.EmailChangeExpiresAt == nil || time.Now().After(*user.EmailChangeExpiresAt) {
+			respondError(c, http.StatusBadRequest, "TOKEN_EXPIRED", "Token has expired")
+			return
+		}
+
+		// Following the link proves ownership of the new address
+		oldEmail := user.Email
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"email":                   user.PendingEmail,
+				"is_verified":             true,
+				"pending_email":           "",
+				"email_change_token_hash": "",
+				"email_change_expires_at": nil,
+			}).Error; err != nil {
+				return err
+			}
+			if err := syncPrimaryEmail(tx, &user); err != nil {
+				return err
+			}
+			return emailService.Send(tx, oldEmail, user.EffectiveLocale(), EmailChangedEmail{NewEmail: user.Email})
+		})
+		if err != nil {
+			// Someone registered the address after the change was requested
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				respondError(c, http.StatusConflict, "EMAIL_TAKEN", "Email already registered")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to change email")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email changed successfully", "email": user.Email})
+	}
+}
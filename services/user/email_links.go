@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// tokenPlaceholder marks where a link path template takes the token
+const tokenPlaceholder = "{token}"
+
+// emailLinkPath is one kind of link sent by email. The frontend owns its
+// routes, so each path is a template set with its own variable.
+type emailLinkPath struct {
+	key      string
+	fallback string
+}
+
+var (
+	resetPasswordLink        = emailLinkPath{"FRONTEND_RESET_PASSWORD_PATH", "/reset-password?token={token}"}
+	verifyEmailLink          = emailLinkPath{"FRONTEND_VERIFY_EMAIL_PATH", "/verify-email?token={token}"}
+	confirmEmailChangeLink   = emailLinkPath{"FRONTEND_CONFIRM_EMAIL_CHANGE_PATH", "/confirm-email-change?token={token}"}
+	verifySecondaryEmailLink = emailLinkPath{"FRONTEND_VERIFY_SECONDARY_EMAIL_PATH", "/verify-secondary-email?token={token}"}
+)
+
+var allEmailLinkPaths = []emailLinkPath{resetPasswordLink, verifyEmailLink, confirmEmailChangeLink, verifySecondaryEmailLink}
+
+// frontendBaseURL is where email links point, e.g. https://app.example.com.
+// APP_URL is still read for deployments that predate FRONTEND_BASE_URL.
+func frontendBaseURL() string {
+	base := getEnv("FRONTEND_BASE_URL", "")
+	if base == "" {
+		base = getEnv("APP_URL", "")
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+// emailLink builds the link for a token, escaping it for the URL
+func emailLink(path emailLinkPath, token string) string {
+	template := getEnv(path.key, path.fallback)
+	return frontendBaseURL() + strings.ReplaceAll(template, tokenPlaceholder, url.QueryEscape(token))
+}
+
+// validateEmailLinks checks the base URL and path templates at startup, so
+// a bad value fails the deploy instead of every email sent
+func validateEmailLinks() error {
+	base := frontendBaseURL()
+	if base == "" {
+		return errors.New("FRONTEND_BASE_URL is required")
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("FRONTEND_BASE_URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("FRONTEND_BASE_URL must be an absolute http(s) URL, got %q", base)
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return fmt.Errorf("FRONTEND_BASE_URL must not have a query or fragment, got %q", base)
+	}
+	if isProduction() && parsed.Scheme != "https" {
+		return fmt.Errorf("FRONTEND_BASE_URL must use https in production, got %q", base)
+	}
+
+	for _, path := range allEmailLinkPaths {
+		template := getEnv(path.key, path.fallback)
+		if !strings.HasPrefix(template, "/") && !strings.HasPrefix(template, "#") {
+			return fmt.Errorf("%s must start with / or #, got %q", path.key, template)
+		}
+		if !strings.Contains(template, tokenPlaceholder) {
+			return fmt.Errorf("%s must contain %s, got %q", path.key, tokenPlaceholder, template)
+		}
+		if _, err := url.Parse(base + strings.ReplaceAll(template, tokenPlaceholder, "token")); err != nil {
+			return fmt.Errorf("%s: %w", path.key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// EmailMessage is a single outgoing email
+type EmailMessage struct {
+	To       string
+	Subject  string
+	HTMLBody string
+	TextBody string
+}
+
+// EmailSender delivers a message through a specific transport
+type EmailSender interface {
+	Send(ctx context.Context, msg EmailMessage) error
+}
+
+// EmailHealthChecker is implemented by senders that can check their
+// provider is reachable without sending anything
+type EmailHealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// dialCheck opens and closes a TCP connection to addr
+func dialCheck(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// NewEmailSender selects the transport from EMAIL_PROVIDER (smtp, sendgrid or log)
+func NewEmailSender() (EmailSender, error) {
+	from := getEnv("EMAIL_FROM", getEnv("SMTP_FROM", ""))
+	switch provider := getEnv("EMAIL_PROVIDER", "smtp"); provider {
+	case "smtp":
+		return &SMTPSender{
+			host:     getEnv("SMTP_HOST", ""),
+			port:     getEnv("SMTP_PORT", ""),
+			username: getEnv("SMTP_USERNAME", ""),
+			password: getEnv("SMTP_PASSWORD", ""),
+			from:     from,
+		}, nil
+	case "sendgrid":
+		apiKey := getEnv("SENDGRID_API_KEY", "")
+		if apiKey == "" {
+			return nil, fmt.Errorf("SENDGRID_API_KEY is required when EMAIL_PROVIDER=sendgrid")
+		}
+		return &SendGridSender{
+			apiKey: apiKey,
+			from:   from,
+			client: &http.Client{Timeout: 15 * time.Second},
+		}, nil
+	case "log":
+		return &LogSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_PROVIDER %q", provider)
+	}
+}
+
+// SMTPSender sends mail through an SMTP relay using PLAIN auth
+type SMTPSender struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg EmailMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Create authentication
+	auth := smtp.PlainAuth("", s.username, s.password, s.host)
+
+	// Send email
+	addr := fmt.Sprintf("%s:%s", s.host, s.port)
+	return smtp.SendMail(addr, auth, s.from, []string{msg.To}, buildMIMEMessage(s.from, msg))
+}
+
+// CheckHealth checks that the SMTP relay accepts connections
+func (s *SMTPSender) CheckHealth(ctx context.Context) error {
+	return dialCheck(ctx, net.JoinHostPort(s.host, s.port))
+}
+
+// buildMIMEMessage formats msg as multipart/alternative with plain-text and
+// HTML parts
+func buildMIMEMessage(from string, msg EmailMessage) []byte {
+	// Writes to a bytes.Buffer can't fail, so errors are ignored throughout
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", msg.TextBody},
+		{"text/html; charset=UTF-8", msg.HTMLBody},
+	} {
+		w, _ := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(part.content))
+		qp.Close()
+	}
+	parts.Close()
+
+	var raw bytes.Buffer
+	fmt.Fprintf(&raw, "To: %s\r\nFrom: %s\r\nSubject: %s\r\n", msg.To, from, mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&raw, "MIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	raw.Write(body.Bytes())
+	return raw.Bytes()
+}
+
+// SendGridSender sends mail through the SendGrid v3 HTTP API
+type SendGridSender struct {
+	apiKey string
+	from   string
+	client *http.Client
+}
+
+func (s *SendGridSender) Send(ctx context.Context, msg EmailMessage) error {
+	payload := map[string]interface{}{
+		"personalizations": []map[string]interface{}{
+			{"to": []map[string]string{{"email": msg.To}}},
+		},
+		"from":    map[string]string{"email": s.from},
+		"subject": msg.Subject,
+		// SendGrid requires text/plain to come before text/html
+		"content": []map[string]string{
+			{"type": "text/plain", "value": msg.TextBody},
+			{"type": "text/html", "value": msg.HTMLBody},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.sendgrid.com/v3/mail/send", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sendgrid returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// CheckHealth checks that the SendGrid API accepts connections
+func (s *SendGridSender) CheckHealth(ctx context.Context) error {
+	return dialCheck(ctx, "api.sendgrid.com:443")
+}
+
+// LogSender logs the recipient and subject of emails instead of sending
+// them, for local development and tests. Bodies are never logged: they carry
+// verification and reset tokens.
+type LogSender struct{}
+
+func (s *LogSender) Send(ctx context.Context, msg EmailMessage) error {
+	zap.L().Info("Email (log provider, not sent)",
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject))
+	return nil
+}
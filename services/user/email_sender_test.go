@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observeLogs routes the global zap logger to an observer for the test
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	restore := zap.ReplaceGlobals(zap.New(core))
+	t.Cleanup(restore)
+	return logs
+}
+
+func TestLogSenderOmitsBody(t *testing.T) {
+	logs := observeLogs(t)
+	msg := EmailMessage{
+		To:       "ada@example.com",
+		Subject:  "Reset your password",
+		TextBody: "https://example.com/reset?token=secret-token",
+		HTMLBody: "<a href=\"https://example.com/reset?token=secret-token\">Reset</a>",
+	}
+	if err := (&LogSender{}).Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d entries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["to"] != msg.To || fields["subject"] != msg.Subject {
+		t.Errorf("fields = %v, want to and subject", fields)
+	}
+	for key, value := range fields {
+		if s, ok := value.(string); ok && strings.Contains(s, "secret-token") {
+			t.Errorf("field %q leaks the message body: %q", key, s)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// defaultEmailTemplates holds the built-in layout and one <name>.html and
+// <name>.txt per email, plus their translations in es/ and de/. The .txt file
+// also defines the subject.
+//
+//go:embed templates/email/*
+var defaultEmailTemplates embed.FS
+
+// EmailTemplate is the typed data for one kind of email
+type EmailTemplate interface {
+	templateName() string
+}
+
+// PasswordResetEmail links to the password reset form. Code is set when
+// reset tokens are numeric codes, to be typed in rather than followed.
+type PasswordResetEmail struct {
+	Link             string
+	Code             string
+	ExpiresInMinutes int
+}
+
+// VerificationEmail links to the email verification endpoint, and carries the
+// code to type in when verification tokens are numeric
+type VerificationEmail struct {
+	Link             string
+	Code             string
+	ExpiresInMinutes int
+}
+
+// EmailChangeConfirmationEmail is sent to the new address of an email change
+type EmailChangeConfirmationEmail struct {
+	Link string
+}
+
+// SecondaryEmailVerificationEmail confirms an address added alongside the primary one
+type SecondaryEmailVerificationEmail struct {
+	Link string
+}
+
+// EmailChangeNoticeEmail warns the current address about a requested change
+type EmailChangeNoticeEmail struct {
+	NewEmail string
+}
+
+// PasswordChangedEmail confirms a password change
+type PasswordChangedEmail struct{}
+
+// EmailChangedEmail tells the old address that the change went through
+type EmailChangedEmail struct {
+	NewEmail string
+}
+
+// LoginAlertEmail reports a sign-in from an unrecognized device
+type LoginAlertEmail struct {
+	Time      string
+	IPAddress string
+	Device    string
+}
+
+func (PasswordResetEmail) templateName() string              { return "password_reset" }
+func (VerificationEmail) templateName() string               { return "verification" }
+func (EmailChangeConfirmationEmail) templateName() string    { return "email_change_confirmation" }
+func (SecondaryEmailVerificationEmail) templateName() string { return "secondary_email_verification" }
+func (EmailChangeNoticeEmail) templateName() string          { return "email_change_notice" }
+func (PasswordChangedEmail) templateName() string            { return "password_changed" }
+func (EmailChangedEmail) templateName() string               { return "email_changed" }
+func (LoginAlertEmail) templateName() string                 { return "login_alert" }
+
+// allEmailTemplates lists every email so templates can be checked at startup
+var allEmailTemplates = []EmailTemplate{
+	PasswordResetEmail{}, VerificationEmail{}, EmailChangeConfirmationEmail{}, SecondaryEmailVerificationEmail{},
+	EmailChangeNoticeEmail{}, PasswordChangedEmail{}, EmailChangedEmail{}, LoginAlertEmail{},
+}
+
+// decodeEmailTemplate rebuilds the data for the named template from the JSON
+// stored in the outbox
+func decodeEmailTemplate(name string, payload []byte) (EmailTemplate, error) {
+	for _, template := range allEmailTemplates {
+		if template.templateName() != name {
+			continue
+		}
+		data := reflect.New(reflect.TypeOf(template))
+		if err := json.Unmarshal(payload, data.Interface()); err != nil {
+			return nil, fmt.Errorf("email template %s: %w", name, err)
+		}
+		return data.Elem().Interface().(EmailTemplate), nil
+	}
+	return nil, fmt.Errorf("unknown email template %s", name)
+}
+
+func NewPasswordResetEmail(resetToken string) PasswordResetEmail {
+	email := PasswordResetEmail{
+		Link:             emailLink(resetPasswordLink, resetToken),
+		ExpiresInMinutes: int(passwordResetTTL().Minutes()),
+	}
+	if passwordResetTokens.Policy().Numeric() {
+		email.Code = resetToken
+	}
+	return email
+}
+
+func NewVerificationEmail(verificationToken string) VerificationEmail {
+	email := VerificationEmail{Link: emailLink(verifyEmailLink, verificationToken)}
+	if verificationTokens.Policy().Numeric() {
+		email.Code = verificationToken
+		email.ExpiresInMinutes = int(verificationTokenTTL().Minutes())
+	}
+	return email
+}
+
+func NewEmailChangeConfirmationEmail(token string) EmailChangeConfirmationEmail {
+	return EmailChangeConfirmationEmail{Link: emailLink(confirmEmailChangeLink, token)}
+}
+
+func NewSecondaryEmailVerificationEmail(token string) SecondaryEmailVerificationEmail {
+	return SecondaryEmailVerificationEmail{Link: emailLink(verifySecondaryEmailLink, token)}
+}
+
+// NewLoginAlertEmail reports a sign-in at the given time, shown in the
+// time zone of at
+func NewLoginAlertEmail(ipAddress, userAgent string, at time.Time) LoginAlertEmail {
+	return LoginAlertEmail{Time: at.Format(time.RFC1123), IPAddress: ipAddress, Device: userAgent}
+}
+
+// overlayFS serves files from override when present, otherwise from base
+type overlayFS struct {
+	override, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if f, err := o.override.Open(name); err == nil {
+		return f, nil
+	}
+	return o.base.Open(name)
+}
+
+type parsedEmailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// EmailTemplates renders emails from the built-in templates, with any file
+// present in the override directory taking precedence. Translations live in
+// a subdirectory per language (es/, de/, pt-BR/); a file missing there falls
+// back to the untranslated one.
+type EmailTemplates struct {
+	// templates is keyed by language, then template name; the untranslated
+	// templates are under ""
+	templates map[string]map[string]parsedEmailTemplate
+}
+
+// LoadEmailTemplates parses every email template in every language, reading
+// overrides from dir (EMAIL_TEMPLATE_DIR) for white-labeling and for adding
+// languages. Each template is rendered once with empty data so a reference to
+// a missing field fails at startup, not on send.
+func LoadEmailTemplates(dir string) (*EmailTemplates, error) {
+	files, err := fs.Sub(defaultEmailTemplates, "templates/email")
+	if err != nil {
+		return nil, err
+	}
+	languages := emailTemplateLanguages(files)
+	if dir != "" {
+		override := os.DirFS(dir)
+		languages = append(languages, emailTemplateLanguages(override)...)
+		files = overlayFS{override: override, base: files}
+	}
+
+	appName := getEnv("EMAIL_APP_NAME", "User Service")
+	funcs := map[string]interface{}{"appName": func() string { return appName }}
+
+	t := &EmailTemplates{templates: make(map[string]map[string]parsedEmailTemplate)}
+	for _, lang := range append([]string{""}, languages...) {
+		if _, ok := t.templates[lang]; ok {
+			continue
+		}
+		t.templates[lang] = make(map[string]parsedEmailTemplate)
+		for _, tmpl := range allEmailTemplates {
+			name := tmpl.templateName()
+			html := htmltemplate.New("layout.html").Funcs(funcs).Option("missingkey=error")
+			if err := parseLocalized(files, lang, []string{"layout.html", name + ".html"}, func(body string) error {
+				_, err := html.Parse(body)
+				return err
+			}); err != nil {
+				return nil, fmt.Errorf("email template %s: %w", path.Join(lang, name), err)
+			}
+			text := texttemplate.New("layout.txt").Funcs(funcs).Option("missingkey=error")
+			if err := parseLocalized(files, lang, []string{"layout.txt", name + ".txt"}, func(body string) error {
+				_, err := text.Parse(body)
+				return err
+			}); err != nil {
+				return nil, fmt.Errorf("email template %s: %w", path.Join(lang, name), err)
+			}
+			t.templates[lang][name] = parsedEmailTemplate{html: html, text: text}
+			if _, err := t.Render("", lang, tmpl); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// emailTemplateLanguages lists the translation directories in files
+func emailTemplateLanguages(files fs.FS) []string {
+	entries, _ := fs.ReadDir(files, ".")
+	var languages []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			languages = append(languages, entry.Name())
+		}
+	}
+	return languages
+}
+
+// parseLocalized hands each of names to parse in turn, read from the lang
+// directory when translated there and from the top level otherwise. The
+// layout comes first; the files after it only add definitions.
+func parseLocalized(files fs.FS, lang string, names []string, parse func(body string) error) error {
+	for _, name := range names {
+		content, err := fs.ReadFile(files, path.Join(lang, name))
+		if lang != "" && errors.Is(err, fs.ErrNotExist) {
+			content, err = fs.ReadFile(files, name)
+		}
+		if err != nil {
+			return err
+		}
+		if err := parse(string(content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// language picks the translation for a BCP 47 locale: an exact match such as
+// pt-BR, then its base language, then the untranslated templates
+func (t *EmailTemplates) language(locale string) string {
+	base, _, _ := strings.Cut(locale, "-")
+	for _, candidate := range []string{locale, base} {
+		for lang := range t.templates {
+			if lang != "" && strings.EqualFold(lang, candidate) {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+// Render builds the message for data addressed to to, in the language
+// closest to locale
+func (t *EmailTemplates) Render(to, locale string, data EmailTemplate) (EmailMessage, error) {
+	name := data.templateName()
+	parsed, ok := t.templates[t.language(locale)][name]
+	if !ok {
+		return EmailMessage{}, fmt.Errorf("unknown email template %s", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := parsed.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return EmailMessage{}, fmt.Errorf("email template %s: %w", name, err)
+	}
+	if err := parsed.text.Execute(&text, data); err != nil {
+		return EmailMessage{}, fmt.Errorf("email template %s: %w", name, err)
+	}
+	if err := parsed.html.Execute(&html, data); err != nil {
+		return EmailMessage{}, fmt.Errorf("email template %s: %w", name, err)
+	}
+	return EmailMessage{
+		To:       to,
+		Subject:  strings.TrimSpace(subject.String()),
+		HTMLBody: html.String(),
+		TextBody: strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// captureSender records the messages it is asked to send
+type captureSender struct {
+	mu   sync.Mutex
+	sent []EmailMessage
+}
+
+func (s *captureSender) Send(ctx context.Context, msg EmailMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+const testResetLink = "https://example.com/reset?token=secret-token"
+
+func TestSendStoresEncryptedTemplateData(t *testing.T) {
+	setKeyring(t, "k1:"+testKey(1), "")
+	var inserted []driver.NamedValue
+	db, _ := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		if strings.HasPrefix(query, `INSERT INTO "outbox_emails"`) {
+			inserted = args
+			return fakeResult{affected: 1, columns: []string{"id"}, rows: [][]driver.Value{{"7d3d0f6e-2c1b-4b8e-9a44-6f1f3a2b9c10"}}}
+		}
+		return fakeResult{}
+	})
+
+	email := PasswordResetEmail{Link: testResetLink, ExpiresInMinutes: 30}
+	if err := newTestEmailService(t, db).Send(db, "ada@example.com", "en", email); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if inserted == nil {
+		t.Fatal("no outbox row was inserted")
+	}
+	var payload string
+	for _, arg := range inserted {
+		s := fmt.Sprint(arg.Value)
+		if strings.Contains(s, "secret-token") {
+			t.Errorf("outbox row stores the token in plaintext: %q", s)
+		}
+		if strings.HasPrefix(s, encryptedPrefix) {
+			payload = s
+		}
+	}
+	decrypted, err := decryptSecret(payload)
+	if err != nil {
+		t.Fatalf("no encrypted payload in %v: %v", inserted, err)
+	}
+	data, err := decodeEmailTemplate("password_reset", []byte(decrypted))
+	if err != nil || data != email {
+		t.Errorf("payload decodes to %+v, %v; want %+v", data, err, email)
+	}
+}
+
+func TestDispatchRendersAndPurgesPayload(t *testing.T) {
+	setKeyring(t, "k1:"+testKey(1), "")
+	t.Setenv("EMAIL_RECIPIENT_MAX", "0")
+	payload, err := EncryptedString(`{"Link":"` + testResetLink + `","ExpiresInMinutes":30}`).Value()
+	if err != nil {
+		t.Fatalf("encrypt payload: %v", err)
+	}
+	claimed := false
+	db, fake := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		if strings.Contains(query, "SKIP LOCKED") && !claimed {
+			claimed = true
+			return fakeResult{
+				columns: []string{"id", "recipient", "template", "locale", "payload", "attempts", "next_attempt_at"},
+				rows:    [][]driver.Value{{"7d3d0f6e-2c1b-4b8e-9a44-6f1f3a2b9c10", "ada@example.com", "password_reset", "en", payload, int64(0), time.Now()}},
+			}
+		}
+		return fakeResult{affected: 1}
+	})
+	sender := &captureSender{}
+	service := newTestEmailService(t, db)
+	service.sender = sender
+
+	service.dispatch(context.Background())
+
+	if len(sender.sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sender.sent))
+	}
+	if msg := sender.sent[0]; msg.To != "ada@example.com" || !strings.Contains(msg.TextBody, testResetLink) {
+		t.Errorf("sent %+v, want the reset email rendered for ada@example.com", msg)
+	}
+	if !fake.executed(`SET "payload"=`) {
+		t.Error("payload was not cleared after delivery")
+	}
+}
+
+func TestOutboxLeaseKeep(t *testing.T) {
+	rows := []OutboxEmail{{}, {}}
+	db, fake := newFakeDB(t, nil)
+	lease := newOutboxLease[OutboxEmail](db, 30*time.Second)
+
+	lease.keep(rows)
+	if fake.executed(`SET "next_attempt_at"=`) {
+		t.Fatal("lease renewed with most of the claim left")
+	}
+
+	// Less than one send timeout left: the remaining rows are claimed again
+	lease.expires = time.Now().Add(20 * time.Second)
+	lease.keep(rows)
+	if !fake.executed(`SET "next_attempt_at"=`) {
+		t.Fatal("lease not renewed before it could lapse mid-send")
+	}
+	if left := time.Until(lease.expires); left < outboxClaimLease-time.Second {
+		t.Errorf("renewed lease expires in %v, want at least %v", left, outboxClaimLease)
+	}
+}
+
+// TestDispatchSharesSendRate checks the send rate counts deliveries made by
+// every instance, as found in the outbox, not just this one's
+func TestDispatchSharesSendRate(t *testing.T) {
+	setKeyring(t, "k1:"+testKey(1), "")
+	t.Setenv("EMAIL_RECIPIENT_MAX", "0")
+	payload, err := EncryptedString(`{"Link":"` + testResetLink + `","ExpiresInMinutes":30}`).Value()
+	if err != nil {
+		t.Fatalf("encrypt payload: %v", err)
+	}
+	tests := []struct {
+		name     string
+		sentElse int64
+		wantSent int
+	}{
+		{name: "window empty", wantSent: 2},
+		{name: "window half spent", sentElse: 1, wantSent: 1},
+		{name: "window spent by other instances", sentElse: 2, wantSent: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claimed := false
+			db, fake := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+				switch {
+				case strings.Contains(query, "SKIP LOCKED") && !claimed:
+					claimed = true
+					columns := []string{"id", "recipient", "template", "locale", "payload", "attempts", "next_attempt_at"}
+					return fakeResult{columns: columns, rows: [][]driver.Value{
+						{uuid.NewString(), "ada@example.com", "password_reset", "en", payload, int64(0), time.Now()},
+						{uuid.NewString(), "grace@example.com", "password_reset", "en", payload, int64(0), time.Now()},
+					}}
+				case strings.HasPrefix(query, "SELECT COUNT(*) AS sent"):
+					var oldest driver.Value
+					if tt.sentElse > 0 {
+						oldest = time.Now().Add(-time.Second)
+					}
+					return fakeResult{columns: []string{"sent", "oldest"}, rows: [][]driver.Value{{tt.sentElse, oldest}}}
+				}
+				return fakeResult{affected: 1}
+			})
+			sender := &captureSender{}
+			service := newTestEmailService(t, db)
+			service.sender = sender
+			service.sendRate, service.sendBurst = 1, 2
+
+			service.dispatch(context.Background())
+
+			if len(sender.sent) != tt.wantSent {
+				t.Fatalf("sent %d emails, want %d", len(sender.sent), tt.wantSent)
+			}
+			// Claiming and deferring both move next_attempt_at by id; a lease
+			// renewal also filters on delivered_at
+			moved := 0
+			for _, query := range fake.queries {
+				if strings.Contains(query, `SET "next_attempt_at"=`) && !strings.Contains(query, "delivered_at") {
+					moved++
+				}
+			}
+			if deferred := moved > 1; deferred != (tt.wantSent < 2) {
+				t.Errorf("deferred = %v, want %v", deferred, tt.wantSent < 2)
+			}
+		})
+	}
+}
+
+func TestRecipientQuotaExemptsExpiringLinks(t *testing.T) {
+	quota := &recipientQuota{max: 1, window: time.Hour, counts: map[string]int{}, oldest: map[string]time.Time{}}
+	quota.sent("ada@example.com")
+
+	for _, template := range allEmailTemplates {
+		name := template.templateName()
+		_, held := quota.hold("ada@example.com", name)
+		if want := !unthrottledEmailTemplates[name]; held != want {
+			t.Errorf("%s: held = %v, want %v", name, held, want)
+		}
+	}
+	for _, name := range []string{"password_reset", "verification"} {
+		if !unthrottledEmailTemplates[name] {
+			t.Errorf("%s emails are throttled, their token would expire while held", name)
+		}
+	}
+	if _, held := quota.hold("grace@example.com", "login_alert"); held {
+		t.Error("a recipient under the cap was held")
+	}
+}
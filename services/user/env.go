@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arohanajit/user-service/config"
+)
+
+// The helpers below resolve a setting from Consul KV, then the environment,
+// then the fallback. See the config package for reload semantics.
+
+// consulOverridableKeys are the settings Consul KV may override: operational
+// tunables that are safe to change at runtime. Secrets, credentials, the
+// environment name, trust settings and destructive switches (DB_RESET,
+// JWT_SECRET, APP_ENV, SECRET_ENCRYPTION_KEYS, BOOTSTRAP_ADMIN_*,
+// TRUSTED_PROXIES, INTERNAL_SERVICE_TOKENS and the like) are deliberately
+// absent and only ever come from the environment.
+var consulOverridableKeys = []string{
+	"FEATURE_*",
+	"LOG_LEVEL", "LOG_REQUEST_BODY_BYTES", "SLOW_REQUEST_THRESHOLD",
+	"REQUEST_TIMEOUT", "MAX_BODY_BYTES", "AVATAR_MAX_BYTES",
+	"MAX_CONCURRENT_REQUESTS", "CONCURRENCY_QUEUE_TIMEOUT", "CONCURRENCY_RETRY_AFTER",
+	"RATE_LIMIT_DEFAULT_PER_MINUTE", "RATE_LIMIT_DEFAULT_BURST",
+	"RATE_LIMIT_STRICT_PER_MINUTE", "RATE_LIMIT_STRICT_BURST",
+	"GZIP_ENABLED", "GZIP_LEVEL", "GZIP_MIN_BYTES",
+	"JWT_EXPIRY", "REFRESH_TOKEN_TTL",
+	"LOGIN_MAX_FAILED_ATTEMPTS", "LOGIN_LOCKOUT_DURATION", "OTP_MAX_ATTEMPTS",
+	"PASSWORD_MIN_LENGTH", "PASSWORD_REQUIRE_UPPER", "PASSWORD_REQUIRE_LETTER",
+	"PASSWORD_REQUIRE_DIGIT", "PASSWORD_REQUIRE_SYMBOL",
+	"PASSWORD_RESET_TTL", "PASSWORD_RESET_MAX_REQUESTS", "PASSWORD_RESET_WINDOW",
+	"EMAIL_VERIFICATION_TTL", "EMAIL_CHANGE_TTL", "VERIFICATION_RESEND_INTERVAL",
+	"PHONE_VERIFICATION_TTL", "PHONE_VERIFICATION_RESEND_INTERVAL",
+	"ADDRESS_LIMIT_PER_USER", "ADDRESS_BULK_MAX", "MAX_EMAILS_PER_USER", "USERS_BATCH_MAX_IDS",
+	"EMAIL_BATCH_SIZE", "EMAIL_POLL_INTERVAL", "EMAIL_MAX_ATTEMPTS", "EMAIL_RETRY_BASE_DELAY",
+	"EMAIL_SEND_TIMEOUT", "EMAIL_SENDS_PER_SECOND", "EMAIL_SEND_BURST",
+	"EMAIL_RECIPIENT_MAX", "EMAIL_RECIPIENT_WINDOW",
+	"EVENT_POLL_INTERVAL", "EVENT_MAX_ATTEMPTS", "EVENT_RETRY_BASE_DELAY", "EVENT_PUBLISH_TIMEOUT",
+	"WEBHOOK_POLL_INTERVAL", "WEBHOOK_MAX_ATTEMPTS", "WEBHOOK_RETRY_BASE_DELAY", "WEBHOOK_TIMEOUT",
+	"GEOCODER_POLL_INTERVAL", "GEOCODER_MAX_ATTEMPTS", "GEOCODER_RETRY_BASE_DELAY", "GEOCODER_TIMEOUT",
+	"GEOCODER_REQUESTS_PER_SECOND",
+	"FEATURE_FLAG_CACHE_TTL", "FEATURE_FLAG_REFRESH_INTERVAL",
+	"DB_READ_RETRIES", "DB_BREAKER_THRESHOLD", "DB_BREAKER_COOLDOWN",
+}
+
+// getEnv returns the configured string value or the fallback if unset
+func getEnv(key, fallback string) string {
+	return config.String(key, fallback)
+}
+
+// getEnvInt returns the configured integer value or the fallback
+func getEnvInt(key string, fallback int) int {
+	return config.Int(key, fallback)
+}
+
+// getEnvBool returns the configured boolean value or the fallback
+func getEnvBool(key string, fallback bool) bool {
+	return config.Bool(key, fallback)
+}
+
+// getEnvDuration returns the configured duration value (e.g. "15m") or the fallback
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	return config.Duration(key, fallback)
+}
+
+// routePrefix is the path every API route is mounted under (ROUTE_PREFIX),
+// normalized to "/segment" form; empty means the root
+func routePrefix() string {
+	prefix := strings.Trim(getEnv("ROUTE_PREFIX", ""), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// getEnvList splits a comma-separated value, dropping empty entries
+func getEnvList(key, fallback string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, fallback), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// internalServiceTokens are the tokens internal callers may present
+// (INTERNAL_SERVICE_TOKENS, comma-separated). INTERNAL_SERVICE_TOKEN, the
+// single token used before rotation was supported, is still accepted.
+func internalServiceTokens() []string {
+	tokens := getEnvList("INTERNAL_SERVICE_TOKENS", "")
+	if legacy := getEnv("INTERNAL_SERVICE_TOKEN", ""); legacy != "" {
+		tokens = append(tokens, legacy)
+	}
+	return tokens
+}
+
+// minServiceTokenBytes is the shortest internal service token accepted, so a
+// shared token can't be guessed
+const minServiceTokenBytes = 32
+
+// validateServiceTokens rejects internal service tokens shorter than
+// minServiceTokenBytes. Tokens are named by position, never by value.
+func validateServiceTokens() error {
+	var errs []error
+	for i, token := range getEnvList("INTERNAL_SERVICE_TOKENS", "") {
+		if len(token) < minServiceTokenBytes {
+			errs = append(errs, fmt.Errorf("INTERNAL_SERVICE_TOKENS entry %d is shorter than %d bytes", i+1, minServiceTokenBytes))
+		}
+	}
+	if legacy := getEnv("INTERNAL_SERVICE_TOKEN", ""); legacy != "" && len(legacy) < minServiceTokenBytes {
+		errs = append(errs, fmt.Errorf("INTERNAL_SERVICE_TOKEN is shorter than %d bytes", minServiceTokenBytes))
+	}
+	return errors.Join(errs...)
+}
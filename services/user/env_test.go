@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateServiceTokens(t *testing.T) {
+	long := strings.Repeat("a", minServiceTokenBytes)
+	tests := []struct {
+		name, tokens, legacy string
+		wantErr              bool
+	}{
+		{name: "unset"},
+		{name: "long enough", tokens: long + "," + long + "b", legacy: long},
+		{name: "one short in the list", tokens: long + ",tok3n", wantErr: true},
+		{name: "one byte short", tokens: long[1:], wantErr: true},
+		{name: "short legacy token", legacy: "tok3n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("INTERNAL_SERVICE_TOKENS", tt.tokens)
+		t.Setenv("INTERNAL_SERVICE_TOKEN", tt.legacy)
+		err := validateServiceTokens()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && strings.Contains(err.Error(), "tok3n") {
+			t.Errorf("%s: error %q reveals a token", tt.name, err)
+		}
+	}
+}
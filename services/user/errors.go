@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// respondError writes the standard error envelope; pass at most one details value
+func respondError(c *gin.Context, status int, code, message string, details ...interface{}) {
+	var detail interface{}
+	if len(details) > 0 {
+		detail = details[0]
+	}
+	middleware.RespondError(c, status, code, message, detail)
+}
+
+// respondValidationError reports a well-formed request whose fields failed
+// validation: 422 VALIDATION_FAILED with the problems in details. Every
+// VALIDATION_FAILED goes through here so the status never varies.
+func respondValidationError(c *gin.Context, message string, details interface{}) {
+	respondValidationErrorLogging(c, message, details, details)
+}
+
+// respondValidationErrorLogging is respondValidationError logging input, the
+// values that were refused, instead of the details sent to the client
+func respondValidationErrorLogging(c *gin.Context, message string, details, input interface{}) {
+	logRejectedInput(c, message, "input", input)
+	respondError(c, http.StatusUnprocessableEntity, "VALIDATION_FAILED", message, details)
+}
+
+// logRejectedInput records why a request's input was refused. The input can
+// hold credentials, so it is only ever logged through RedactedField.
+func logRejectedInput(c *gin.Context, reason, key string, input interface{}) {
+	middleware.Logger(c).Info("Request input rejected", zap.String("reason", reason), middleware.RedactedField(key, input))
+}
+
+// respondBindError reports a request body that failed to bind: 413 when it
+// exceeded the body limit, 415 for an encoding bindBody doesn't read, 422
+// when fields failed validation, otherwise 400
+func respondBindError(c *gin.Context, err error) {
+	if errors.Is(err, errCookieAuthNeedsJSON) {
+		respondError(c, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "Requests authenticated by cookie must send a JSON body",
+			gin.H{"content_type": c.ContentType()})
+		return
+	}
+	if errors.Is(err, errUnsupportedMediaType) {
+		respondError(c, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "Request body must be JSON or form encoded",
+			gin.H{"content_type": c.ContentType()})
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(c, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "Request body is too large", gin.H{"max_bytes": tooLarge.Limit})
+		return
+	}
+	// Binding uses DisallowUnknownFields; encoding/json has no typed error for it
+	field, unknown := strings.CutPrefix(err.Error(), "json: unknown field ")
+	var formErr *unknownFieldError
+	if errors.As(err, &formErr) {
+		field, unknown = formErr.Field, true
+	}
+	if unknown {
+		logRejectedInput(c, "Request contains an unknown field", "field", strings.Trim(field, `"`))
+		respondError(c, http.StatusBadRequest, "UNKNOWN_FIELD", "Request contains an unknown field",
+			[]FieldError{{Field: strings.Trim(field, `"`), Message: middleware.Localize(c, "unknown field")}})
+		return
+	}
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		details := make([]FieldError, len(validationErrs))
+		values := make(map[string]interface{}, len(validationErrs))
+		for i, fe := range validationErrs {
+			details[i] = FieldError{Field: toSnakeCase(fe.Field()), Message: middleware.Localize(c, "failed %s validation", fe.Tag())}
+			values[details[i].Field] = fe.Value()
+		}
+		respondValidationErrorLogging(c, "Request validation failed", details, values)
+		return
+	}
+	logRejectedInput(c, "Malformed request body", "error", err.Error())
+	respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Malformed request body")
+}
+
+// respondRetryAfter writes an error the client may retry after wait; see
+// middleware.RespondRetryAfter
+func respondRetryAfter(c *gin.Context, status int, code, message string, wait time.Duration) {
+	middleware.RespondRetryAfter(c, status, code, message, wait)
+}
+
+// respondDBError logs a failed query and reports it: 503 while the database
+// circuit breaker is open, otherwise 500 with message
+func respondDBError(c *gin.Context, err error, message string) {
+	middleware.Logger(c).Error(message, zap.Error(err))
+	if errors.Is(err, errCircuitOpen) {
+		cooldown := getEnvDuration("DB_BREAKER_COOLDOWN", 10*time.Second)
+		respondRetryAfter(c, http.StatusServiceUnavailable, "DATABASE_UNAVAILABLE", "Database is temporarily unavailable", cooldown)
+		return
+	}
+	respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", message)
+}
+
+// respondLookupError reports a failed lookup of a single record: 404 with code
+// and message when it doesn't exist, otherwise respondDBError with failure.
+// Only genuine failures are logged; a miss is the client's doing.
+func respondLookupError(c *gin.Context, err error, code, message, failure string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		respondError(c, http.StatusNotFound, code, message)
+		return
+	}
+	respondDBError(c, err, failure)
+}
+
+// toSnakeCase maps Go field names like PostalCode to their JSON names
+func toSnakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 && !(name[i-1] >= 'A' && name[i-1] <= 'Z') {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r + ('a' - 'A'))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestValidationFailedEnvelope checks that every path producing
+// VALIDATION_FAILED answers with the same status and a details list
+func TestValidationFailedEnvelope(t *testing.T) {
+	bindCode := func(c *gin.Context) {
+		var req TwoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+		}
+	}
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		body    string
+	}{
+		{name: "binding", handler: bindCode, body: `{}`},
+		{name: "refresh without token", handler: RefreshAccessToken(emptyDB(t), nil), body: `{}`},
+		{name: "preferences", handler: UpdatePreferences(emptyDB(t)), body: `{"language":"not a tag"}`},
+		{name: "address", handler: func(c *gin.Context) {
+			rejectInvalidAddress(c, &Address{Country: "US", PostalCode: "not-a-zip"})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.handler, "/", http.MethodPost, "/", tt.body, "user-1")
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, http.StatusUnprocessableEntity, w.Body.String())
+			}
+			envelope := decodeError(t, w)
+			if envelope.Error.Code != "VALIDATION_FAILED" {
+				t.Errorf("code = %q, want VALIDATION_FAILED", envelope.Error.Code)
+			}
+			var details []FieldError
+			if err := json.Unmarshal(envelope.Error.Details, &details); err != nil || len(details) == 0 || details[0].Field == "" {
+				t.Errorf("details = %s, want a list of field errors", envelope.Error.Details)
+			}
+		})
+	}
+}
+
+func TestBindErrorEnvelope(t *testing.T) {
+	handler := func(c *gin.Context) {
+		var req TwoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+		}
+	}
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{body: `{"code":`, wantStatus: http.StatusBadRequest, wantCode: "INVALID_REQUEST"},
+		{body: `{"code":"1","extra":true}`, wantStatus: http.StatusBadRequest, wantCode: "UNKNOWN_FIELD"},
+		{body: `{"code":""}`, wantStatus: http.StatusUnprocessableEntity, wantCode: "VALIDATION_FAILED"},
+	}
+
+	for _, tt := range tests {
+		w := serve(handler, "/", http.MethodPost, "/", tt.body, "")
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.body, w.Code, tt.wantStatus)
+			continue
+		}
+		if got := decodeError(t, w).Error.Code; got != tt.wantCode {
+			t.Errorf("%s: code = %q, want %q", tt.body, got, tt.wantCode)
+		}
+	}
+}
+
+func TestBindErrorLogsRedactedInput(t *testing.T) {
+	const secret = "hunter2-do-not-log"
+	bind := func(c *gin.Context) {
+		var req struct {
+			Email       string `json:"email" binding:"required,email"`
+			Password    string `json:"password" binding:"min=32"`
+			ResetToken  string `json:"reset_token" binding:"len=64"`
+			DisplayName string `json:"display_name" binding:"max=3"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+		}
+	}
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "validation", body: `{"email":"ada@example.com","password":"` + secret + `","reset_token":"` + secret + `","display_name":"Ada Lovelace"}`},
+		{name: "malformed", body: `{"password":"` + secret + `",`},
+		{name: "unknown field", body: `{"email":"ada@example.com","password":"` + secret + `","otp":"` + secret + `"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := observeLogs(t)
+			serve(bind, "/", http.MethodPost, "/", tt.body, "")
+
+			entries := logs.FilterMessage("Request input rejected").All()
+			if len(entries) != 1 {
+				t.Fatalf("logged %d rejections, want 1", len(entries))
+			}
+			for key, value := range entries[0].ContextMap() {
+				if logged := fmt.Sprint(value); strings.Contains(logged, secret) {
+					t.Errorf("field %q leaks a credential: %s", key, logged)
+				}
+			}
+		})
+	}
+
+	// Non-sensitive values are kept so clients can be debugged
+	logs := observeLogs(t)
+	serve(bind, "/", http.MethodPost, "/", tests[0].body, "")
+	input, _ := logs.All()[0].ContextMap()["input"].(map[string]interface{})
+	if input["display_name"] != "Ada Lovelace" || input["password"] != "[REDACTED]" || input["reset_token"] != "[REDACTED]" {
+		t.Errorf("logged input = %v, want display_name kept and credentials redacted", input)
+	}
+}
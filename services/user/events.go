@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// domainEventVersion is the schema version of DomainEvent and its data.
+// Consumers should ignore versions they don't know; an incompatible change
+// bumps it.
+const domainEventVersion = 1
+
+const (
+	// eventRelayBatchSize is how many events one relay tick claims
+	eventRelayBatchSize = 100
+	eventMaxBackoff     = 10 * time.Minute
+)
+
+// domainEventNames maps lifecycle event types to the names published on the
+// broker
+var domainEventNames = map[string]string{
+	EventUserRegistered: "UserRegistered",
+	EventUserUpdated:    "ProfileUpdated",
+	EventUserDeleted:    "AccountDeleted",
+}
+
+// DomainEvent is the JSON envelope published to the broker. ID is the same
+// as the matching webhook's, and stays the same across redeliveries, so
+// consumers drop duplicates by it.
+type DomainEvent struct {
+	ID         uuid.UUID   `json:"id"`
+	Type       string      `json:"type"`
+	Version    int         `json:"version"`
+	Source     string      `json:"source"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	UserID     uuid.UUID   `json:"user_id"`
+	Data       interface{} `json:"data"`
+}
+
+// OutboxEvent is an outbox row: one domain event bound for the broker. Like
+// webhook deliveries, rows commit with the change they describe and stay
+// until the broker acknowledges them.
+type OutboxEvent struct {
+	ID            uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	CreatedAt     time.Time
+	EventID       uuid.UUID  `gorm:"type:uuid;uniqueIndex;not null"`
+	EventType     string     `gorm:"not null"`
+	Topic         string     `gorm:"not null"`
+	PartitionKey  string     `gorm:"not null"`
+	Payload       []byte     `gorm:"not null"`
+	Attempts      int        `gorm:"default:0;not null"`
+	NextAttemptAt time.Time  `gorm:"index;not null"`
+	DeliveredAt   *time.Time `gorm:"index"`
+	LastError     string
+}
+
+func (e OutboxEvent) outboxID() uuid.UUID { return e.ID }
+
+// EventPublisher sends a message to a broker topic. The key picks the
+// partition, so all of one user's events go to the same one.
+type EventPublisher interface {
+	Publish(ctx context.Context, topic, key string, payload []byte) error
+}
+
+// NewEventPublisher selects the broker from EVENT_BROKER: none (the default)
+// or kafka-rest. It is read once at startup; handlers and the relay are given
+// the result.
+func NewEventPublisher() (EventPublisher, error) {
+	switch broker := getEnv("EVENT_BROKER", "none"); broker {
+	case "none":
+		return NopEventPublisher{}, nil
+	case "kafka-rest":
+		baseURL := getEnv("EVENT_BROKER_URL", "")
+		if baseURL == "" {
+			return nil, fmt.Errorf("EVENT_BROKER_URL is required with EVENT_BROKER %q", broker)
+		}
+		return &KafkaRESTPublisher{
+			baseURL:  strings.TrimRight(baseURL, "/"),
+			username: getEnv("EVENT_BROKER_USERNAME", ""),
+			password: getEnv("EVENT_BROKER_PASSWORD", ""),
+			client:   &http.Client{Timeout: getEnvDuration("EVENT_PUBLISH_TIMEOUT", 10*time.Second)},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_BROKER %q", broker)
+	}
+}
+
+// NopEventPublisher drops events; with it none are queued
+type NopEventPublisher struct{}
+
+// eventsEnabled reports whether publisher sends events anywhere
+func eventsEnabled(publisher EventPublisher) bool {
+	_, nop := publisher.(NopEventPublisher)
+	return publisher != nil && !nop
+}
+
+func (NopEventPublisher) Publish(ctx context.Context, topic, key string, payload []byte) error {
+	return nil
+}
+
+// KafkaRESTPublisher produces to Kafka through the v2 REST Proxy API, as
+// served by the Confluent REST Proxy and Redpanda's HTTP proxy
+type KafkaRESTPublisher struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+}
+
+func (p *KafkaRESTPublisher) Publish(ctx context.Context, topic, key string, payload []byte) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{"key": key, "value": json.RawMessage(payload)}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if p.username != "" {
+		req.SetBasicAuth(p.username, p.password)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return fmt.Errorf("broker returned %s", resp.Status)
+	}
+
+	// The proxy answers 200 even when a record fails, reporting it per offset
+	var result struct {
+		Offsets []struct {
+			Error *string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil {
+		return fmt.Errorf("decode broker response: %w", err)
+	}
+	for _, offset := range result.Offsets {
+		if offset.Error != nil {
+			return fmt.Errorf("broker rejected event: %s", *offset.Error)
+		}
+	}
+	return nil
+}
+
+// enqueueDomainEvent queues event for the broker, keyed by user. Called by
+// publishUserEvent inside the transaction making the change.
+func enqueueDomainEvent(tx *gorm.DB, publisher EventPublisher, event WebhookEvent, userID uuid.UUID) error {
+	if !eventsEnabled(publisher) {
+		return nil
+	}
+	payload, err := json.Marshal(DomainEvent{
+		ID:         event.ID,
+		Type:       domainEventNames[event.Type],
+		Version:    domainEventVersion,
+		Source:     serviceID,
+		OccurredAt: event.Timestamp,
+		UserID:     userID,
+		Data:       event.Data,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Create(&OutboxEvent{
+		EventID:       event.ID,
+		EventType:     domainEventNames[event.Type],
+		Topic:         getEnv("EVENT_TOPIC", "user-events"),
+		PartitionKey:  userID.String(),
+		Payload:       payload,
+		NextAttemptAt: event.Timestamp,
+	}).Error
+}
+
+// EventRelay publishes queued domain events with exponential backoff
+type EventRelay struct {
+	db          *gorm.DB
+	publisher   EventPublisher
+	maxAttempts int
+	baseDelay   time.Duration
+	timeout     time.Duration
+}
+
+// NewEventRelay reads EVENT_* settings
+func NewEventRelay(db *gorm.DB, publisher EventPublisher) *EventRelay {
+	return &EventRelay{
+		db:          db,
+		publisher:   publisher,
+		maxAttempts: getEnvInt("EVENT_MAX_ATTEMPTS", 20),
+		baseDelay:   getEnvDuration("EVENT_RETRY_BASE_DELAY", 5*time.Second),
+		timeout:     getEnvDuration("EVENT_PUBLISH_TIMEOUT", 10*time.Second),
+	}
+}
+
+// Start polls the outbox every interval until ctx is cancelled. With no
+// broker configured nothing is queued and no worker is started.
+func (r *EventRelay) Start(ctx context.Context, interval time.Duration) {
+	if !eventsEnabled(r.publisher) {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("event-relay", defaultHeartbeatMaxAge(interval)+r.timeout)
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("event-relay")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("event-relay")
+				r.relay(ctx)
+			}
+		}
+	}()
+}
+
+// relay publishes one batch. Events of one user are published in order: once
+// one fails, the user's later events in the batch wait until it is retried.
+func (r *EventRelay) relay(ctx context.Context) {
+	batch, err := claimOutbox[OutboxEvent](ctx, r.db, r.maxAttempts, eventRelayBatchSize)
+	if err != nil {
+		zap.L().Error("Failed to claim domain events", zap.Error(err))
+		return
+	}
+
+	lease := newOutboxLease[OutboxEvent](r.db, r.timeout)
+	blocked := map[string]bool{}
+	for i, event := range batch {
+		if ctx.Err() != nil {
+			return
+		}
+		if blocked[event.PartitionKey] {
+			continue
+		}
+		workerHeartbeats.Beat("event-relay")
+		lease.keep(unblockedEvents(batch[i:], blocked))
+		err := r.publisher.Publish(ctx, event.Topic, event.PartitionKey, event.Payload)
+		attempts := event.Attempts + 1
+		backoff := outboxBackoff(r.baseDelay, eventMaxBackoff, attempts)
+		if err != nil {
+			log := zap.L().With(zap.String("event_id", event.EventID.String()), zap.String("topic", event.Topic), zap.Int("attempt", attempts), zap.Error(err))
+			if attempts >= r.maxAttempts {
+				log.Error("Giving up on publishing domain event")
+			} else {
+				log.Warn("Publishing domain event failed, will retry", zap.Duration("retry_in", backoff))
+				blocked[event.PartitionKey] = true
+				r.deferEvents(batch[i+1:], event.PartitionKey, time.Now().Add(backoff))
+			}
+		}
+		recordOutboxAttempt(r.db, event, "event", attempts, r.maxAttempts, backoff, err)
+	}
+}
+
+// deferEvents moves the rows for key to until, when the failed event before
+// them is retried. They aren't attempted before it, so no attempt is counted.
+func (r *EventRelay) deferEvents(rows []OutboxEvent, key string, until time.Time) {
+	var ids []uuid.UUID
+	for _, row := range rows {
+		if row.PartitionKey == key {
+			ids = append(ids, row.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if err := r.db.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("next_attempt_at", until).Error; err != nil {
+		zap.L().Error("Failed to defer domain events", zap.Int("count", len(ids)), zap.Error(err))
+	}
+}
+
+// unblockedEvents drops the rows whose partition key is blocked, so renewing
+// the lease doesn't undo their deferral
+func unblockedEvents(rows []OutboxEvent, blocked map[string]bool) []OutboxEvent {
+	if len(blocked) == 0 {
+		return rows
+	}
+	unblocked := make([]OutboxEvent, 0, len(rows))
+	for _, row := range rows {
+		if !blocked[row.PartitionKey] {
+			unblocked = append(unblocked, row)
+		}
+	}
+	return unblocked
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptedPublisher fails the payloads in fail and records every attempt
+type scriptedPublisher struct {
+	fail      map[string]bool
+	published []string
+}
+
+func (p *scriptedPublisher) Publish(ctx context.Context, topic, key string, payload []byte) error {
+	p.published = append(p.published, string(payload))
+	if p.fail[string(payload)] {
+		return errors.New("broker unavailable")
+	}
+	return nil
+}
+
+func TestRelayKeepsPerUserOrder(t *testing.T) {
+	const (
+		laterA = "7d3d0f6e-2c1b-4b8e-9a44-6f1f3a2b9c13"
+		userA  = "0b8f2a51-0000-4000-8000-00000000000a"
+		userB  = "0b8f2a51-0000-4000-8000-00000000000b"
+	)
+	claimed := false
+	var deferred []driver.NamedValue
+	db, _ := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		switch {
+		case strings.Contains(query, "SKIP LOCKED") && !claimed:
+			claimed = true
+			now := time.Now()
+			return fakeResult{
+				columns: []string{"id", "topic", "partition_key", "payload", "attempts", "next_attempt_at"},
+				rows: [][]driver.Value{
+					{"7d3d0f6e-2c1b-4b8e-9a44-6f1f3a2b9c11", "user-events", userA, []byte("a1"), int64(0), now},
+					{"7d3d0f6e-2c1b-4b8e-9a44-6f1f3a2b9c12", "user-events", userB, []byte("b1"), int64(0), now},
+					{laterA, "user-events", userA, []byte("a2"), int64(0), now},
+				},
+			}
+		case strings.HasPrefix(query, `UPDATE "outbox_events" SET "next_attempt_at"=$1 WHERE id IN`):
+			deferred = args
+		}
+		return fakeResult{affected: 1}
+	})
+	publisher := &scriptedPublisher{fail: map[string]bool{"a1": true}}
+	relay := NewEventRelay(db, publisher)
+
+	relay.relay(context.Background())
+
+	if got := strings.Join(publisher.published, ","); got != "a1,b1" {
+		t.Fatalf("published %s, want a1,b1: a2 must wait for a1", got)
+	}
+	if len(deferred) != 2 || deferred[1].Value != laterA {
+		t.Fatalf("deferred %v, want only %s", deferred, laterA)
+	}
+	if until, ok := deferred[0].Value.(time.Time); !ok || !until.After(time.Now()) {
+		t.Errorf("deferred until %v, want the failed event's retry time", deferred[0].Value)
+	}
+}
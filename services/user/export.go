@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// DataExport is everything the service stores about a user, minus secrets
+type DataExport struct {
+	ExportedAt time.Time `json:"exported_at"`
+	Profile    User      `json:"profile"`
+	Addresses  []Address `json:"addresses"`
+	// Emails is left out of the CSV, whose profile row has the primary one
+	Emails []UserEmail `json:"emails"`
+}
+
+// ExportUserData returns the caller's data as a downloadable JSON or CSV file,
+// optionally wrapped in a ZIP archive (?zip=true)
+func ExportUserData(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+		format := c.DefaultQuery("format", "json")
+		if format != "json" && format != "csv" {
+			respondError(c, http.StatusBadRequest, "INVALID_FORMAT", "Format must be json or csv")
+			return
+		}
+
+		export := DataExport{ExportedAt: time.Now().UTC()}
+		// Read everything from one snapshot so it is consistent
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.First(&export.Profile, "id = ?", userID).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", userID).Order("created_at ASC").Find(&export.Emails).Error; err != nil {
+				return err
+			}
+			return tx.Where("user_id = ?", userID).Order("created_at ASC").Find(&export.Addresses).Error
+		}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to export data")
+			return
+		}
+
+		var body []byte
+		contentType := "application/json"
+		if format == "csv" {
+			body, err = export.CSV()
+			contentType = "text/csv"
+		} else {
+			body, err = json.MarshalIndent(export, "", "  ")
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to export data")
+			return
+		}
+
+		filename := fmt.Sprintf("user-data-%s.%s", userID, format)
+		if c.Query("zip") == "true" {
+			body, err = zipFile(filename, body)
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to export data")
+				return
+			}
+			filename += ".zip"
+			contentType = "application/zip"
+		}
+
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		c.Data(http.StatusOK, contentType, body)
+	}
+}
+
+// CSV renders the export as a profile section followed by an addresses section
+func (e *DataExport) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	dateOfBirth := ""
+	if e.Profile.DateOfBirth != nil {
+		dateOfBirth = e.Profile.DateOfBirth.Format("2006-01-02")
+	}
+	username := ""
+	if e.Profile.Username != nil {
+		username = *e.Profile.Username
+	}
+	rows := [][]string{
+		{"# profile"},
+		{"id", "email", "username", "first_name", "last_name", "phone_number", "role", "date_of_birth", "bio", "preferred_language", "timezone", "locale", "is_verified", "created_at"},
+		{
+			e.Profile.ID.String(), e.Profile.Email, username, e.Profile.FirstName, e.Profile.LastName, string(e.Profile.PhoneNumber),
+			e.Profile.Role, dateOfBirth, e.Profile.Bio, e.Profile.PreferredLanguage, e.Profile.Timezone, e.Profile.Locale,
+			strconv.FormatBool(e.Profile.IsVerified), e.Profile.CreatedAt.Format(time.RFC3339),
+		},
+		{},
+		{"# addresses"},
+		{"id", "street", "city", "state", "country", "postal_code", "is_default", "created_at"},
+	}
+	for _, a := range e.Addresses {
+		rows = append(rows, []string{
+			strconv.FormatUint(uint64(a.ID), 10), a.Street, a.City, a.State, a.Country, a.PostalCode,
+			strconv.FormatBool(a.IsDefault), a.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func zipFile(name string, content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	f, err := zw.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// fakeResult is the scripted answer to one statement: rows for queries, the
+// affected count for execs, or an error for either
+type fakeResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+// fakeResponder answers a statement; returning the zero fakeResult means no
+// rows and nothing affected
+type fakeResponder func(query string, args []driver.NamedValue) fakeResult
+
+// fakeDB is a database/sql driver that answers every statement from a
+// responder, so handlers can be exercised without a Postgres server. It
+// records the statements it saw.
+type fakeDB struct {
+	respond fakeResponder
+
+	mu      sync.Mutex
+	queries []string
+}
+
+// newFakeDB opens gorm over a fakeDB with the same dialect and error
+// translation the service uses
+func newFakeDB(t *testing.T, respond fakeResponder) (*gorm.DB, *fakeDB) {
+	t.Helper()
+	fake := &fakeDB{respond: respond}
+	sqlDB := sql.OpenDB(fakeConnector{fake})
+	t.Cleanup(func() { sqlDB.Close() })
+
+	dialector := translatingDialector{postgres.New(postgres.Config{Conn: sqlDB}).(*postgres.Dialector)}
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("open fake db: %v", err)
+	}
+	return db, fake
+}
+
+// failingDB answers every statement with err
+func failingDB(t *testing.T, err error) *gorm.DB {
+	db, _ := newFakeDB(t, func(string, []driver.NamedValue) fakeResult { return fakeResult{err: err} })
+	return db
+}
+
+// emptyDB finds no rows for any query
+func emptyDB(t *testing.T) *gorm.DB {
+	db, _ := newFakeDB(t, nil)
+	return db
+}
+
+func (f *fakeDB) answer(query string, args []driver.NamedValue) fakeResult {
+	f.mu.Lock()
+	f.queries = append(f.queries, query)
+	f.mu.Unlock()
+	if f.respond == nil {
+		return fakeResult{}
+	}
+	return f.respond(query, args)
+}
+
+// executed reports whether a statement containing fragment was run
+func (f *fakeDB) executed(fragment string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, query := range f.queries {
+		if strings.Contains(query, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: c.db}, nil }
+
+func (c fakeConnector) Driver() driver.Driver { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakeDriver is only usable through fakeConnector")
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) Ping(context.Context) error { return nil }
+
+// CheckNamedValue resolves driver.Valuer arguments, as pgx does, and passes
+// everything else through as is
+func (c *fakeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if valuer, ok := nv.Value.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return err
+		}
+		nv.Value = value
+	}
+	return nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.answer(query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &fakeRows{columns: result.columns, rows: result.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result := c.db.answer(query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return driver.RowsAffected(result.affected), nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error { return nil }
+
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error { return nil }
+
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// testDatabase opens the Postgres database named by TEST_DATABASE_URL with
+// the schema applied, skipping the test when none is configured
+func testDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(translatingDialector{postgres.Open(dsn).(*postgres.Dialector)}, &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	if err := migrateSchema(db); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// knownFeatures lists every feature flag and whether it is on while
+// unconfigured. A flag is configured with FEATURE_<NAME> in Consul KV or the
+// environment; ship a route dark by adding it here as false.
+var knownFeatures = map[string]bool{
+	"secondary_emails": true,
+}
+
+// FeatureFlag is a parsed FEATURE_<NAME> value. A plain boolean switches the
+// feature for everyone; a JSON object can limit it to some roles and/or a
+// percentage of users, e.g. {"enabled":true,"roles":["admin"],"percent":10}.
+type FeatureFlag struct {
+	Enabled bool     `json:"enabled"`
+	Roles   []string `json:"roles,omitempty"`
+	// Percent of users, picked by a hash of their ID, who get the feature
+	Percent *int `json:"percent,omitempty"`
+	// OffStatus is 404 (the default) to hide the routes, or 503
+	OffStatus int `json:"off_status,omitempty"`
+}
+
+// parseFeatureFlag reads a boolean or JSON flag value
+func parseFeatureFlag(value string) (FeatureFlag, error) {
+	if enabled, err := strconv.ParseBool(value); err == nil {
+		return FeatureFlag{Enabled: enabled}, nil
+	}
+	var flag FeatureFlag
+	err := json.Unmarshal([]byte(value), &flag)
+	return flag, err
+}
+
+// allows reports whether the flag is on for a caller. With neither roles nor
+// a percentage it is on for everyone; otherwise the caller needs a listed
+// role or a rollout bucket under the percentage.
+func (f FeatureFlag) allows(name, userID, role string) bool {
+	if !f.Enabled {
+		return false
+	}
+	if len(f.Roles) == 0 && f.Percent == nil {
+		return true
+	}
+	for _, r := range f.Roles {
+		if role != "" && r == role {
+			return true
+		}
+	}
+	if f.Percent == nil {
+		return false
+	}
+	if *f.Percent >= 100 {
+		return true
+	}
+	return userID != "" && rolloutBucket(name, userID) < *f.Percent
+}
+
+// rolloutBucket places a user in 0-99 for a flag. The flag name is part of
+// the hash so each rollout starts with a different set of users.
+func rolloutBucket(name, userID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + ":" + userID))
+	return int(h.Sum32() % 100)
+}
+
+// loadFeatureFlag reads a flag's current configuration, using its default
+// when unset or invalid
+func loadFeatureFlag(name string) FeatureFlag {
+	fallback := FeatureFlag{Enabled: knownFeatures[name]}
+	key := "FEATURE_" + strings.ToUpper(name)
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	flag, err := parseFeatureFlag(value)
+	if err != nil {
+		zap.L().Warn("Invalid feature flag, using default", zap.String("key", key), zap.Bool("default", fallback.Enabled), zap.Error(err))
+		return fallback
+	}
+	return flag
+}
+
+type cachedFeatureFlag struct {
+	flag     FeatureFlag
+	loadedAt time.Time
+}
+
+// featureFlagStore caches parsed flags for FEATURE_FLAG_CACHE_TTL. Start
+// refreshes them in the background so requests rarely have to parse one.
+type featureFlagStore struct {
+	mu    sync.RWMutex
+	flags map[string]cachedFeatureFlag
+	ttl   time.Duration
+}
+
+func newFeatureFlagStore() *featureFlagStore {
+	return &featureFlagStore{
+		flags: map[string]cachedFeatureFlag{},
+		ttl:   getEnvDuration("FEATURE_FLAG_CACHE_TTL", 10*time.Second),
+	}
+}
+
+func (s *featureFlagStore) flag(name string) FeatureFlag {
+	s.mu.RLock()
+	cached, ok := s.flags[name]
+	s.mu.RUnlock()
+	if ok && time.Since(cached.loadedAt) < s.ttl {
+		return cached.flag
+	}
+	return s.refresh(name)
+}
+
+func (s *featureFlagStore) refresh(name string) FeatureFlag {
+	flag := loadFeatureFlag(name)
+	s.mu.Lock()
+	s.flags[name] = cachedFeatureFlag{flag: flag, loadedAt: time.Now()}
+	s.mu.Unlock()
+	return flag
+}
+
+func (s *featureFlagStore) Enabled(name, userID, role string) bool {
+	return s.flag(name).allows(name, userID, role)
+}
+
+func (s *featureFlagStore) OffStatus(name string) int {
+	if s.flag(name).OffStatus == http.StatusServiceUnavailable {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusNotFound
+}
+
+// Start reloads every known flag each interval until ctx is cancelled
+func (s *featureFlagStore) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("feature-flag-refresh", defaultHeartbeatMaxAge(interval))
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("feature-flag-refresh")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("feature-flag-refresh")
+				for name := range knownFeatures {
+					s.refresh(name)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestAdminUserFieldsMatchResponse keeps adminUserFields in step with the
+// AdminUser JSON, so every listed name is selectable and no name leaks more
+func TestAdminUserFieldsMatchResponse(t *testing.T) {
+	var keys []string
+	typ := reflect.TypeOf(AdminUser{})
+	for i := 0; i < typ.NumField(); i++ {
+		keys = append(keys, strings.Split(typ.Field(i).Tag.Get("json"), ",")[0])
+	}
+	allowed := append([]string(nil), adminUserFields...)
+	sort.Strings(keys)
+	sort.Strings(allowed)
+	if !reflect.DeepEqual(allowed, keys) {
+		t.Errorf("adminUserFields = %v, want the AdminUser keys %v", allowed, keys)
+	}
+}
+
+func TestAdminListUsersFields(t *testing.T) {
+	db, _ := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		if strings.HasPrefix(query, "SELECT count(*)") {
+			return fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(1)}}}
+		}
+		return fakeResult{
+			columns: []string{"id", "email", "password", "first_name", "created_at"},
+			rows:    [][]driver.Value{{uuid.NewString(), "ada@example.com", "$2a$10$hash", "Ada", time.Now()}},
+		}
+	})
+
+	for _, target := range []string{"/admin/users?fields=id,email", "/admin/users?cursor=&fields=id,email"} {
+		w := serve(AdminListUsers(db), "/admin/users", http.MethodGet, target, "", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, want %d (body %s)", target, w.Code, http.StatusOK, w.Body.String())
+		}
+		var page struct {
+			Items []map[string]json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("GET %s: decode: %v", target, err)
+		}
+		if len(page.Items) != 1 {
+			t.Fatalf("GET %s: %d items, want 1", target, len(page.Items))
+		}
+		var keys []string
+		for key := range page.Items[0] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, []string{"email", "id"}) {
+			t.Errorf("GET %s: item keys = %v, want [email id]", target, keys)
+		}
+	}
+
+	// Columns of the users table outside AdminUser can't be named
+	for _, field := range []string{"password", "totp_secret", "verification_token"} {
+		w := serve(AdminListUsers(db), "/admin/users", http.MethodGet, "/admin/users?fields=id,"+field, "", "")
+		if w.Code != http.StatusBadRequest || decodeError(t, w).Error.Code != "INVALID_FIELDS" {
+			t.Errorf("fields=id,%s: status = %d (body %s), want 400 INVALID_FIELDS", field, w.Code, w.Body.String())
+		}
+	}
+}
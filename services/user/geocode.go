@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Address.GeocodeStatus values. Coordinates are only set once resolved.
+const (
+	GeocodePending  = "pending"
+	GeocodeResolved = "resolved"
+	GeocodeNotFound = "not_found"
+	GeocodeFailed   = "failed"
+)
+
+const (
+	// geocodeBatchSize is how many jobs one dispatcher tick claims
+	geocodeBatchSize  = 20
+	geocodeMaxBackoff = time.Hour
+)
+
+// errNoGeocodeResult means the provider doesn't know the address. It is a
+// final answer, unlike other errors, which are retried.
+var errNoGeocodeResult = errors.New("no geocoding result")
+
+// Coordinates is a WGS 84 position
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Geocoder resolves an address to coordinates
+type Geocoder interface {
+	Geocode(ctx context.Context, address *Address) (Coordinates, error)
+}
+
+// NewGeocoder selects the provider from GEOCODER_PROVIDER: none (the
+// default) or nominatim. It is read once at startup; handlers and the
+// dispatcher are given the result.
+func NewGeocoder() (Geocoder, error) {
+	switch provider := getEnv("GEOCODER_PROVIDER", "none"); provider {
+	case "none":
+		return NopGeocoder{}, nil
+	case "nominatim":
+		return &NominatimGeocoder{
+			baseURL:   getEnv("GEOCODER_URL", "https://nominatim.openstreetmap.org/search"),
+			apiKey:    getEnv("GEOCODER_API_KEY", ""),
+			userAgent: getEnv("GEOCODER_USER_AGENT", "user-service"),
+			client:    &http.Client{Timeout: getEnvDuration("GEOCODER_TIMEOUT", 10*time.Second)},
+			limiter:   middleware.NewMemoryRateLimitStore(),
+			limit: middleware.RateLimit{
+				Name:  "geocoder",
+				Rate:  float64(getEnvInt("GEOCODER_REQUESTS_PER_SECOND", 1)),
+				Burst: 1,
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown GEOCODER_PROVIDER %q", provider)
+	}
+}
+
+// NopGeocoder resolves nothing; with it addresses are stored without
+// coordinates and no geocoding jobs are queued
+type NopGeocoder struct{}
+
+// geocodingEnabled reports whether geocoder looks anything up
+func geocodingEnabled(geocoder Geocoder) bool {
+	_, nop := geocoder.(NopGeocoder)
+	return geocoder != nil && !nop
+}
+
+func (NopGeocoder) Geocode(ctx context.Context, address *Address) (Coordinates, error) {
+	return Coordinates{}, errNoGeocodeResult
+}
+
+// NominatimGeocoder uses the Nominatim search API, which OpenStreetMap and
+// several hosted providers serve. GEOCODER_API_KEY is sent as the key
+// parameter those providers expect. Requests are spaced to
+// GEOCODER_REQUESTS_PER_SECOND, as the public instance allows one a second.
+type NominatimGeocoder struct {
+	baseURL   string
+	apiKey    string
+	userAgent string
+	client    *http.Client
+	limiter   *middleware.MemoryRateLimitStore
+	limit     middleware.RateLimit
+}
+
+func (g *NominatimGeocoder) Geocode(ctx context.Context, address *Address) (Coordinates, error) {
+	if err := g.wait(ctx); err != nil {
+		return Coordinates{}, err
+	}
+	query := url.Values{"format": {"jsonv2"}, "limit": {"1"}}
+	for key, value := range map[string]string{
+		"street":     address.Street,
+		"city":       address.City,
+		"state":      address.State,
+		"country":    address.Country,
+		"postalcode": address.PostalCode,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if g.apiKey != "" {
+		query.Set("key", g.apiKey)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.baseURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return Coordinates{}, err
+	}
+	// Nominatim's usage policy requires an identifying user agent
+	req.Header.Set("User-Agent", g.userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return Coordinates{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return Coordinates{}, fmt.Errorf("geocoder returned %s", resp.Status)
+	}
+
+	var results []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&results); err != nil {
+		return Coordinates{}, fmt.Errorf("decode geocoder response: %w", err)
+	}
+	if len(results) == 0 {
+		return Coordinates{}, errNoGeocodeResult
+	}
+	lat, err := strconv.ParseFloat(results[0].Lat, 64)
+	if err != nil {
+		return Coordinates{}, fmt.Errorf("invalid latitude %q", results[0].Lat)
+	}
+	lon, err := strconv.ParseFloat(results[0].Lon, 64)
+	if err != nil {
+		return Coordinates{}, fmt.Errorf("invalid longitude %q", results[0].Lon)
+	}
+	return Coordinates{Latitude: lat, Longitude: lon}, nil
+}
+
+// wait blocks until the rate limit allows another request; a zero rate
+// removes the limit
+func (g *NominatimGeocoder) wait(ctx context.Context) error {
+	if g.limit.Rate <= 0 {
+		return nil
+	}
+	for {
+		allowed, wait := g.limiter.Allow(g.limit.Name, g.limit)
+		if allowed {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// maxWait is the longest wait will block for a request
+func (g *NominatimGeocoder) maxWait() time.Duration {
+	if g.limit.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / g.limit.Rate)
+}
+
+// GeocodeJob is an outbox row asking for one version of an address to be
+// geocoded. It is written in the transaction that saves the address, so the
+// write never waits on the provider. A job for a version that has since
+// changed is dropped, as the newer version has its own job.
+type GeocodeJob struct {
+	ID             uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	CreatedAt      time.Time
+	AddressID      uint       `gorm:"index;not null"`
+	AddressVersion int        `gorm:"not null"`
+	Attempts       int        `gorm:"default:0;not null"`
+	NextAttemptAt  time.Time  `gorm:"index;not null"`
+	DeliveredAt    *time.Time `gorm:"index"`
+	LastError      string
+}
+
+func (j GeocodeJob) outboxID() uuid.UUID { return j.ID }
+
+// markGeocodePending clears any coordinates on address, including ones a
+// client sent, and marks it pending when geocoder looks addresses up. Call it
+// before saving new address fields, then enqueueGeocode once saved.
+func markGeocodePending(address *Address, geocoder Geocoder) {
+	address.Latitude = nil
+	address.Longitude = nil
+	address.GeocodedAt = nil
+	address.GeocodeStatus = ""
+	if geocodingEnabled(geocoder) {
+		address.GeocodeStatus = GeocodePending
+	}
+}
+
+// geocodeResetFields are the column updates matching markGeocodePending
+func geocodeResetFields(geocoder Geocoder) map[string]interface{} {
+	var address Address
+	markGeocodePending(&address, geocoder)
+	return map[string]interface{}{
+		"latitude":       nil,
+		"longitude":      nil,
+		"geocoded_at":    nil,
+		"geocode_status": address.GeocodeStatus,
+	}
+}
+
+// enqueueGeocode queues address at version for geocoding. Pass the
+// transaction saving the address so the job commits with it.
+func enqueueGeocode(tx *gorm.DB, geocoder Geocoder, addressID uint, version int) error {
+	if !geocodingEnabled(geocoder) {
+		return nil
+	}
+	return tx.Create(&GeocodeJob{AddressID: addressID, AddressVersion: version, NextAttemptAt: time.Now()}).Error
+}
+
+// GeocodeDispatcher works through the geocoding jobs with exponential backoff
+type GeocodeDispatcher struct {
+	db          *gorm.DB
+	geocoder    Geocoder
+	maxAttempts int
+	baseDelay   time.Duration
+	// timeout bounds one lookup, including the wait for the rate limit
+	timeout time.Duration
+}
+
+// NewGeocodeDispatcher reads GEOCODER_* settings
+func NewGeocodeDispatcher(db *gorm.DB, geocoder Geocoder) *GeocodeDispatcher {
+	timeout := getEnvDuration("GEOCODER_TIMEOUT", 10*time.Second)
+	if nominatim, ok := geocoder.(*NominatimGeocoder); ok {
+		timeout += nominatim.maxWait()
+	}
+	return &GeocodeDispatcher{
+		db:          db,
+		geocoder:    geocoder,
+		maxAttempts: getEnvInt("GEOCODER_MAX_ATTEMPTS", 5),
+		baseDelay:   getEnvDuration("GEOCODER_RETRY_BASE_DELAY", 30*time.Second),
+		timeout:     timeout,
+	}
+}
+
+// Start polls for jobs every interval until ctx is cancelled. With no
+// geocoder configured there is nothing to do and no worker is started.
+func (d *GeocodeDispatcher) Start(ctx context.Context, interval time.Duration) {
+	if !geocodingEnabled(d.geocoder) {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("address-geocoder", defaultHeartbeatMaxAge(interval)+d.timeout)
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("address-geocoder")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("address-geocoder")
+				d.dispatch(ctx)
+			}
+		}
+	}()
+}
+
+func (d *GeocodeDispatcher) dispatch(ctx context.Context) {
+	batch, err := claimOutbox[GeocodeJob](ctx, d.db, d.maxAttempts, geocodeBatchSize)
+	if err != nil {
+		zap.L().Error("Failed to claim geocoding jobs", zap.Error(err))
+		return
+	}
+
+	lease := newOutboxLease[GeocodeJob](d.db, d.timeout)
+	for i, job := range batch {
+		if ctx.Err() != nil {
+			return
+		}
+		workerHeartbeats.Beat("address-geocoder")
+		lease.keep(batch[i:])
+		err := d.geocode(ctx, job)
+		attempts := job.Attempts + 1
+		backoff := outboxBackoff(d.baseDelay, geocodeMaxBackoff, attempts)
+		if err != nil {
+			log := zap.L().With(zap.Uint("address_id", job.AddressID), zap.Int("attempt", attempts), zap.Error(err))
+			if attempts >= d.maxAttempts {
+				log.Error("Giving up on geocoding address")
+				d.apply(ctx, job, map[string]interface{}{"geocode_status": GeocodeFailed})
+			} else {
+				log.Warn("Geocoding failed, will retry", zap.Duration("retry_in", backoff))
+			}
+		}
+		recordOutboxAttempt(d.db, job, "geocode", attempts, d.maxAttempts, backoff, err)
+	}
+}
+
+// geocode resolves the job's address and stores the result. A deleted or
+// since changed address needs nothing.
+func (d *GeocodeDispatcher) geocode(ctx context.Context, job GeocodeJob) error {
+	var address Address
+	err := d.db.WithContext(ctx).Where("id = ? AND version = ?", job.AddressID, job.AddressVersion).First(&address).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	coordinates, err := d.geocoder.Geocode(ctx, &address)
+	if errors.Is(err, errNoGeocodeResult) {
+		d.apply(ctx, job, map[string]interface{}{"geocode_status": GeocodeNotFound})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.apply(ctx, job, map[string]interface{}{
+		"latitude":       coordinates.Latitude,
+		"longitude":      coordinates.Longitude,
+		"geocoded_at":    time.Now(),
+		"geocode_status": GeocodeResolved,
+	})
+	return nil
+}
+
+// apply updates the job's address if it is still at the job's version. The
+// version is left alone: coordinates are derived data, and bumping it would
+// make clients holding an ETag see a conflict for a change they didn't make.
+func (d *GeocodeDispatcher) apply(ctx context.Context, job GeocodeJob, updates map[string]interface{}) {
+	if err := d.db.WithContext(ctx).Model(&Address{}).
+		Where("id = ? AND version = ?", job.AddressID, job.AddressVersion).
+		UpdateColumns(updates).Error; err != nil {
+		zap.L().Error("Failed to store geocoding result", zap.Uint("address_id", job.AddressID), zap.Error(err))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+)
+
+func TestNominatimGeocoderRateLimit(t *testing.T) {
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, time.Now())
+		w.Write([]byte(`[{"lat":"51.5","lon":"-0.1"}]`))
+	}))
+	defer server.Close()
+
+	geocoder := &NominatimGeocoder{
+		baseURL: server.URL,
+		client:  server.Client(),
+		limiter: middleware.NewMemoryRateLimitStore(),
+		limit:   middleware.RateLimit{Name: "geocoder", Rate: 10, Burst: 1},
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := geocoder.Geocode(context.Background(), &Address{City: "London"}); err != nil {
+			t.Fatalf("Geocode: %v", err)
+		}
+	}
+	for i := 1; i < len(requests); i++ {
+		if gap := requests[i].Sub(requests[i-1]); gap < 90*time.Millisecond {
+			t.Errorf("request %d came %v after the previous one, want at least 100ms at 10 per second", i, gap)
+		}
+	}
+
+	// A cancelled lookup stops waiting for the limiter
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := geocoder.Geocode(ctx, &Address{City: "London"}); err == nil {
+		t.Error("Geocode with a cancelled context succeeded")
+	}
+}
+
+func TestMarkGeocodePending(t *testing.T) {
+	latitude := 1.0
+	tests := []struct {
+		name       string
+		geocoder   Geocoder
+		wantStatus string
+	}{
+		{name: "none", geocoder: NopGeocoder{}, wantStatus: ""},
+		{name: "nominatim", geocoder: &NominatimGeocoder{}, wantStatus: GeocodePending},
+	}
+
+	for _, tt := range tests {
+		address := Address{Latitude: &latitude, Longitude: &latitude, GeocodeStatus: GeocodeResolved}
+		markGeocodePending(&address, tt.geocoder)
+		if address.Latitude != nil || address.Longitude != nil {
+			t.Errorf("%s: coordinates were kept", tt.name)
+		}
+		if address.GeocodeStatus != tt.wantStatus {
+			t.Errorf("%s: status = %q, want %q", tt.name, address.GeocodeStatus, tt.wantStatus)
+		}
+	}
+}
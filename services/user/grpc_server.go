@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime/debug"
+	"strings"
+
+	"github.com/arohanajit/user-service/middleware"
+	userv1 "github.com/arohanajit/user-service/proto/user/v1"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// userGRPCServer implements userv1.UserServiceServer on the same database and
+// token rules as the REST API
+type userGRPCServer struct {
+	userv1.UnimplementedUserServiceServer
+	db           *gorm.DB
+	tokenService *TokenService
+}
+
+// serviceTokenInterceptor requires one of the shared service tokens
+// (x-service-token metadata) on every call except health checks
+func serviceTokenInterceptor(tokens func() []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, "/grpc.health.v1.Health/") {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		presented := ""
+		if values := md.Get(strings.ToLower(middleware.ServiceTokenHeader)); len(values) > 0 {
+			presented = values[0]
+		}
+		if !middleware.ValidServiceToken(tokens(), presented) {
+			zap.L().Warn("Rejected gRPC call without a valid service token", zap.String("method", info.FullMethod))
+			return nil, status.Error(codes.Unauthenticated, "invalid service credentials")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// recoveryInterceptor turns a handler panic into an Internal error instead of
+// crashing the process. Like the HTTP Recovery middleware it logs the method
+// and stack but not the request, which may carry tokens.
+func recoveryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				zap.L().Error("panic recovered",
+					zap.Any("panic", recovered),
+					zap.String("method", info.FullMethod),
+					zap.ByteString("stack", debug.Stack()),
+				)
+				resp, err = nil, status.Error(codes.Internal, "internal server error")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// newGRPCServer builds the gRPC server with the user and health services.
+// Recovery runs outermost so a panic anywhere in the chain is contained.
+func newGRPCServer(db *gorm.DB, tokenService *TokenService, serviceTokens func() []string) (*grpc.Server, *health.Server) {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(recoveryInterceptor(), serviceTokenInterceptor(serviceTokens)))
+	userv1.RegisterUserServiceServer(srv, &userGRPCServer{db: db, tokenService: tokenService})
+
+	// Consul's gRPC check calls the standard health service
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(srv, healthServer)
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	return srv, healthServer
+}
+
+// serveGRPC listens on GRPC_PORT until the server is stopped
+func serveGRPC(srv *grpc.Server, port string) error {
+	lis, err := net.Listen("tcp", "0.0.0.0:"+port)
+	if err != nil {
+		return err
+	}
+	zap.L().Info("gRPC server listening", zap.String("port", port))
+	return srv.Serve(lis)
+}
+
+func (s *userGRPCServer) GetUser(ctx context.Context, req *userv1.GetUserRequest) (*userv1.GetUserResponse, error) {
+	if _, err := uuid.Parse(req.GetUserId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user_id")
+	}
+
+	var user User
+	err := retryRead(ctx, func() error {
+		return s.db.WithContext(ctx).First(&user, "id = ?", req.GetUserId()).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		if errors.Is(err, errCircuitOpen) {
+			return nil, status.Error(codes.Unavailable, "database unavailable")
+		}
+		return nil, status.Error(codes.Internal, "failed to fetch user")
+	}
+	return &userv1.GetUserResponse{User: userToProto(&user)}, nil
+}
+
+func (s *userGRPCServer) ValidateToken(ctx context.Context, req *userv1.ValidateTokenRequest) (*userv1.ValidateTokenResponse, error) {
+	claims, err := middleware.ParseAccessToken(ctx, req.GetToken(), s.tokenService.secret, s.tokenService.issuer, &revocationStore{db: s.db})
+	if errors.Is(err, middleware.ErrTokenCheckFailed) {
+		return nil, status.Error(codes.Unavailable, "failed to verify token")
+	}
+	if err != nil {
+		return &userv1.ValidateTokenResponse{Valid: false, Reason: err.Error()}, nil
+	}
+	state, err := (&accountStore{db: s.db}).AccountState(ctx, claims.UserID)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, "failed to verify token")
+	}
+	if reason := inactiveTokenReason(state); reason != "" {
+		return &userv1.ValidateTokenResponse{Valid: false, Reason: reason}, nil
+	}
+	return &userv1.ValidateTokenResponse{
+		Valid:     true,
+		UserId:    claims.UserID,
+		Role:      claims.Role,
+		ExpiresAt: timestamppb.New(claims.ExpiresAt),
+	}, nil
+}
+
+func (s *userGRPCServer) ListAddresses(ctx context.Context, req *userv1.ListAddressesRequest) (*userv1.ListAddressesResponse, error) {
+	if _, err := uuid.Parse(req.GetUserId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user_id")
+	}
+	pagination := newPagination(int(req.GetPage()), int(req.GetPageSize()))
+	addresses, total, err := queryAddresses(s.db.WithContext(ctx), req.GetUserId(), pagination, "created_at ASC")
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to fetch addresses")
+	}
+
+	resp := &userv1.ListAddressesResponse{Total: total, Page: int32(pagination.Page), PageSize: int32(pagination.PageSize)}
+	for _, a := range addresses {
+		resp.Addresses = append(resp.Addresses, &userv1.Address{
+			Id:         uint64(a.ID),
+			Street:     a.Street,
+			City:       a.City,
+			State:      a.State,
+			Country:    a.Country,
+			PostalCode: a.PostalCode,
+			IsDefault:  a.IsDefault,
+			CreatedAt:  timestamppb.New(a.CreatedAt),
+		})
+	}
+	return resp, nil
+}
+
+func userToProto(u *User) *userv1.User {
+	pb := &userv1.User{
+		Id:          u.ID.String(),
+		Email:       u.Email,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		PhoneNumber: string(u.PhoneNumber),
+		Role:        u.Role,
+		IsVerified:  u.IsVerified,
+		CreatedAt:   timestamppb.New(u.CreatedAt),
+		UpdatedAt:   timestamppb.New(u.UpdatedAt),
+	}
+	if u.Username != nil {
+		pb.Username = *u.Username
+	}
+	return pb
+}
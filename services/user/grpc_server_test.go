@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.v1.UserService/GetUser"}
+	_, err := recoveryInterceptor()(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("err = %v, want code %v", err, codes.Internal)
+	}
+}
+
+func TestServiceTokenInterceptor(t *testing.T) {
+	tokens := func() []string { return []string{"0123456789abcdef0123456789abcdef"} }
+	ok := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   codes.Code
+	}{
+		{name: "valid token", method: "/user.v1.UserService/GetUser", token: tokens()[0], want: codes.OK},
+		{name: "missing token", method: "/user.v1.UserService/GetUser", want: codes.Unauthenticated},
+		{name: "wrong token", method: "/user.v1.UserService/GetUser", token: "nope", want: codes.Unauthenticated},
+		{name: "health check", method: "/grpc.health.v1.Health/Check", want: codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-service-token", tt.token))
+			}
+			_, err := serviceTokenInterceptor(tokens)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, ok)
+			if status.Code(err) != tt.want {
+				t.Errorf("err = %v, want code %v", err, tt.want)
+			}
+		})
+	}
+}
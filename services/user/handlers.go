@@ -2,28 +2,39 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/arohanajit/user-service/middleware"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// LoginRequest accepts an email or username in Identifier; Email is kept for
+// older clients
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Identifier string `json:"identifier" form:"identifier"`
+	Email      string `json:"email" form:"email"`
+	Password   string `json:"password" form:"password" binding:"required"`
 }
 
 type RegisterRequest struct {
-	Email       string `json:"email" binding:"required,email"`
-	Password    string `json:"password" binding:"required,min=6"`
-	FirstName   string `json:"first_name" binding:"required"`
-	LastName    string `json:"last_name" binding:"required"`
-	PhoneNumber string `json:"phone_number"`
+	Email       string `json:"email" form:"email" binding:"required"`
+	Username    string `json:"username" form:"username"`
+	Password    string `json:"password" form:"password" binding:"required"`
+	FirstName   string `json:"first_name" form:"first_name" binding:"required"`
+	LastName    string `json:"last_name" form:"last_name" binding:"required"`
+	PhoneNumber string `json:"phone_number" form:"phone_number"`
+	// CaptchaToken is required when CAPTCHA_PROVIDER is set
+	CaptchaToken string `json:"captcha_token" form:"captcha_token"`
 }
 
 type UpdateProfileRequest struct {
@@ -34,55 +45,150 @@ type UpdateProfileRequest struct {
 	ProfilePicture    string     `json:"profile_picture"`
 	Bio               string     `json:"bio"`
 	PreferredLanguage string     `json:"preferred_language"`
+	Timezone          string     `json:"timezone"`
+	Locale            string     `json:"locale"`
+	Version           int        `json:"version"`
+}
+
+// AddressInput is the client-settable part of an Address. Requests bind into
+// it rather than the model, whose ID, owner and User association must only
+// ever be set by the server.
+type AddressInput struct {
+	Street     string `json:"street" form:"street"`
+	City       string `json:"city" form:"city"`
+	State      string `json:"state" form:"state"`
+	Country    string `json:"country" form:"country"`
+	PostalCode string `json:"postal_code" form:"postal_code"`
+	IsDefault  bool   `json:"is_default" form:"is_default"`
+	Version    int    `json:"version" form:"version"`
+}
+
+// address builds an unsaved Address from the input
+func (in AddressInput) address() Address {
+	return Address{
+		Street:     in.Street,
+		City:       in.City,
+		State:      in.State,
+		Country:    in.Country,
+		PostalCode: in.PostalCode,
+		IsDefault:  in.IsDefault,
+		Version:    in.Version,
+	}
 }
 
 type RequestPasswordResetRequest struct {
+	Email        string `json:"email" binding:"required"`
+	CaptchaToken string `json:"captcha_token"`
+}
+
+// RefreshTokenRequest carries the refresh token; cookie sessions may send an
+// empty body and rely on the refresh_token cookie instead
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ResendVerificationRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
 
 type ResetPasswordRequest struct {
 	Token    string `json:"token" binding:"required"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"`
+	// Email is required when reset tokens are numeric codes
+	Email string `json:"email" binding:"omitempty,email"`
 }
 
-func Register(db *gorm.DB) gin.HandlerFunc {
+func Register(db *gorm.DB, emailService *EmailService, captcha CaptchaVerifier, events EventPublisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		var req RegisterRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := bindBody(c, &req); err != nil {
+			respondBindError(c, err)
 			return
 		}
-
-		// Check if user already exists
-		var existingUser User
-		if err := db.Where("email = ?", req.Email).First(&existingUser).Error; err != nil {
-			if !errors.Is(err, gorm.ErrRecordNotFound) {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		if rejectFailedCaptcha(c, captcha, req.CaptchaToken) {
+			return
+		}
+		email, err := validateEmail(req.Email)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_EMAIL", "Invalid email address")
+			return
+		}
+		var username *string
+		if req.Username != "" {
+			normalized, err := validateUsername(req.Username)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, "INVALID_USERNAME", err.Error())
 				return
 			}
-		} else {
-			c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+			username = &normalized
+		}
+		if req.PhoneNumber != "" {
+			if req.PhoneNumber, err = normalizePhoneNumber(req.PhoneNumber); err != nil {
+				respondError(c, http.StatusBadRequest, "INVALID_PHONE_NUMBER", err.Error())
+				return
+			}
+		}
+		if rejectWeakPassword(c, req.Password) {
 			return
 		}
 
+		// The ID is assigned here rather than by the database so a numeric
+		// verification code can be bound to the account before it is created
 		user := User{
-			Email:       req.Email,
+			ID:          uuid.New(),
+			Email:       email,
+			Username:    username,
 			Password:    req.Password,
 			FirstName:   req.FirstName,
 			LastName:    req.LastName,
-			PhoneNumber: req.PhoneNumber,
+			PhoneNumber: EncryptedString(req.PhoneNumber),
 		}
 
 		if err := user.HashPassword(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to hash password")
+			return
+		}
+
+		verificationToken, err := user.GenerateVerificationToken()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate verification token")
 			return
 		}
 
-		if err := db.Create(&user).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			if err := syncPrimaryEmail(tx, &user); err != nil {
+				return err
+			}
+			if err := emailService.Send(tx, user.Email, user.EffectiveLocale(), NewVerificationEmail(verificationToken)); err != nil {
+				return err
+			}
+			return publishUserEvent(tx, events, EventUserRegistered, &user)
+		})
+		if err != nil {
+			// The unique indexes also cover accounts pending deletion, so they
+			// settle concurrent registrations without a racy pre-check
+			if constraint, ok := uniqueViolation(err); ok {
+				switch constraint {
+				case usersEmailIndex, userEmailsEmailIndex:
+					respondError(c, http.StatusConflict, "EMAIL_TAKEN", "Email already registered")
+				case usersUsernameIndex:
+					respondError(c, http.StatusConflict, "USERNAME_TAKEN", "Username already taken")
+				default:
+					respondError(c, http.StatusConflict, "ACCOUNT_EXISTS", "Email or username already registered")
+				}
+				return
+			}
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to create user")
 			return
 		}
 
+		registrationsTotal.Inc()
+		// The account is read back through /profile once the user signs in
+		c.Header("Location", resourceURL(c, "/profile"))
 		c.JSON(http.StatusCreated, gin.H{
 			"message": "User registered successfully",
 			"user_id": user.ID,
@@ -90,76 +196,344 @@ func Register(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-func Login(db *gorm.DB) gin.HandlerFunc {
+func Login(db *gorm.DB, tokenService *TokenService, emailService *EmailService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		var loginReq LoginRequest
-		if err := c.ShouldBindJSON(&loginReq); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		if err := bindBody(c, &loginReq); err != nil {
+			if errors.Is(err, errUnsupportedMediaType) {
+				respondBindError(c, err)
+				return
+			}
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request")
+			return
+		}
+		identifier := loginReq.Identifier
+		if identifier == "" {
+			identifier = loginReq.Email
+		}
+		if strings.TrimSpace(identifier) == "" {
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request")
 			return
 		}
 
 		var user User
-		if err := db.Where("email = ?", loginReq.Email).First(&user).Error; err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		if err := findUserByIdentifier(db, identifier, &user); err != nil {
+			failedLoginsTotal.WithLabelValues("unknown_user").Inc()
+			recordLoginEvent(c, db, nil, identifier, LoginOutcomeUnknownUser)
+			respondError(c, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Invalid credentials")
+			return
+		}
+
+		// Refuse locked accounts before looking at the password so a correct
+		// guess during lockout is indistinguishable from a wrong one
+		if !user.IsActive() {
+			outcome := inactiveLoginOutcome(&user)
+			failedLoginsTotal.WithLabelValues(outcome).Inc()
+			recordLoginEvent(c, db, &user, identifier, outcome)
+			respondInactiveAccount(c, &user)
+			return
+		}
+		if user.IsLocked() {
+			failedLoginsTotal.WithLabelValues("locked").Inc()
+			recordLoginEvent(c, db, &user, identifier, LoginOutcomeLocked)
+			respondLocked(c, user.LockRemaining())
 			return
 		}
 
 		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginReq.Password)); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			failedLoginsTotal.WithLabelValues("bad_password").Inc()
+			recordLoginEvent(c, db, &user, identifier, LoginOutcomeBadPassword)
+			locked, lockErr := recordFailedLogin(db, &user)
+			if lockErr != nil {
+				respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+				return
+			}
+			if locked {
+				respondLocked(c, user.LockRemaining())
+				return
+			}
+			respondError(c, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Invalid credentials")
+			return
+		}
+		rehashPassword(c, db, &user, loginReq.Password)
+
+		if requireEmailVerification() && !user.IsVerified {
+			failedLoginsTotal.WithLabelValues("unverified").Inc()
+			recordLoginEvent(c, db, &user, identifier, LoginOutcomeUnverified)
+			respondError(c, http.StatusForbidden, "EMAIL_NOT_VERIFIED", "Email address has not been verified")
 			return
 		}
 
-		// Create token with user ID as string
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"user_id": user.ID.String(),
-			"role":    user.Role,
-			"exp":     time.Now().Add(time.Hour * 24).Unix(),
+		// With 2FA on, the password alone only earns a challenge for POST /login/2fa
+		if user.TwoFactorEnabled {
+			challenge, err := tokenService.GenerateChallengeToken(&user)
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate token")
+				return
+			}
+			recordLoginEvent(c, db, &user, identifier, LoginOutcomeChallenge)
+			c.JSON(http.StatusOK, gin.H{
+				"two_factor_required": true,
+				"challenge_token":     challenge,
+				"expires_in":          int64(challengeTokenTimeout.Seconds()),
+			})
+			return
+		}
+
+		completeLogin(c, db, tokenService, emailService, &user)
+	}
+}
+
+// rehashPassword upgrades a verified password's hash to the configured bcrypt
+// cost. Failures are only logged; the old hash still works.
+func rehashPassword(c *gin.Context, db *gorm.DB, user *User, password string) {
+	if !user.PasswordNeedsRehash() {
+		return
+	}
+	upgraded := User{Password: password}
+	if err := upgraded.HashPassword(); err != nil {
+		middleware.Logger(c).Error("Failed to rehash password", zap.String("user_id", user.ID.String()), zap.Error(err))
+		return
+	}
+	// UpdateColumn leaves updated_at alone; the profile itself didn't change
+	if err := db.Model(user).UpdateColumn("password", upgraded.Password).Error; err != nil {
+		middleware.Logger(c).Error("Failed to store rehashed password", zap.String("user_id", user.ID.String()), zap.Error(err))
+		return
+	}
+	user.Password = upgraded.Password
+}
+
+// completeLogin resets the failed attempt counter and issues the token pair
+// once every authentication factor has been checked
+func completeLogin(c *gin.Context, db *gorm.DB, tokenService *TokenService, emailService *EmailService, user *User) {
+	if user.FailedLoginAttempts > 0 || user.LockedUntil != nil {
+		if err := db.Model(user).Updates(map[string]interface{}{
+			"failed_login_attempts": 0,
+			"locked_until":          nil,
+		}).Error; err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+	}
+
+	tokens, err := tokenService.IssueTokenPair(db, user, newSessionMetadata(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate token")
+		return
+	}
+
+	loginsTotal.Inc()
+	// Check before recording, or this login would count as a known device
+	newDevice := false
+	if prefs, err := loadPreferences(db, user.ID); err == nil && prefs.LoginNotifications {
+		newDevice = isNewLoginDevice(c, db, user)
+	}
+	recordLoginEvent(c, db, user, "", LoginOutcomeSuccess)
+	if newDevice {
+		sendSecurityNotification(c, db, emailService, user, NewLoginAlertEmail(middleware.ClientIP(c), c.Request.UserAgent(), time.Now().In(user.Location())))
+	}
+	respondTokens(c, tokenDelivery(c), tokens, gin.H{"user": user})
+}
+
+// RefreshAccessToken exchanges a valid refresh token for a new token pair.
+// The presented refresh token is rotated and cannot be used again.
+func RefreshAccessToken(db *gorm.DB, tokenService *TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req RefreshTokenRequest
+		if c.Request.ContentLength != 0 {
+			// Same rule AuthMiddleware applies to the access token cookie
+			if _, err := c.Cookie(refreshTokenCookie); err == nil && c.ContentType() != binding.MIMEJSON {
+				respondBindError(c, errCookieAuthNeedsJSON)
+				return
+			}
+			if err := c.ShouldBindJSON(&req); err != nil {
+				respondBindError(c, err)
+				return
+			}
+		}
+		delivery := tokenDelivery(c)
+		if req.RefreshToken == "" {
+			req.RefreshToken, _ = c.Cookie(refreshTokenCookie)
+			// The rotated pair has to replace the cookies it came from
+			if delivery == tokensInBody {
+				delivery = tokensInCookies
+			}
+		}
+		if req.RefreshToken == "" {
+			respondValidationError(c, "Request validation failed",
+				[]FieldError{{Field: "refresh_token", Message: middleware.Localize(c, "failed %s validation", "required")}})
+			return
+		}
+
+		var stored RefreshToken
+		if err := db.Where("token_hash = ?", hashToken(req.RefreshToken)).First(&stored).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				respondError(c, http.StatusUnauthorized, "INVALID_REFRESH_TOKEN", "Invalid refresh token")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+
+		// A rotated token being replayed suggests it was stolen, so kill the
+		// whole family. Tokens of a session the user ended are just rejected.
+		if stored.Revoked {
+			if stored.RotatedAt == nil {
+				respondError(c, http.StatusUnauthorized, "REVOKED_REFRESH_TOKEN", "Refresh token has been revoked")
+				return
+			}
+			if err := revokeUserRefreshTokens(db, stored.UserID); err != nil {
+				respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+				return
+			}
+			respondError(c, http.StatusUnauthorized, "REVOKED_REFRESH_TOKEN", "Refresh token has been revoked")
+			return
+		}
+		if !stored.IsActive() {
+			respondError(c, http.StatusUnauthorized, "EXPIRED_REFRESH_TOKEN", "Refresh token has expired")
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", stored.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				respondError(c, http.StatusUnauthorized, "INVALID_REFRESH_TOKEN", "Invalid refresh token")
+				return
+			}
+			respondDBError(c, err, "Database error")
+			return
+		}
+		if !user.IsActive() {
+			respondInactiveAccount(c, &user)
+			return
+		}
+
+		var tokens *TokenPair
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			// Guard against two concurrent refreshes rotating the same token
+			result := tx.Model(&RefreshToken{}).
+				Where("id = ? AND revoked = ?", stored.ID, false).
+				Updates(map[string]interface{}{"revoked": true, "rotated_at": time.Now()})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errRefreshTokenRotated
+			}
+
+			var err error
+			tokens, err = tokenService.IssueTokenPair(tx, &user, sessionMetadata{
+				ID:        stored.SessionID,
+				StartedAt: stored.SessionStartedAt,
+				IPAddress: middleware.ClientIP(c),
+				UserAgent: truncate(c.Request.UserAgent(), maxUserAgentLength),
+			})
+			return err
 		})
+		if err != nil {
+			if errors.Is(err, errRefreshTokenRotated) {
+				respondError(c, http.StatusUnauthorized, "REVOKED_REFRESH_TOKEN", "Refresh token has been revoked")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate token")
+			return
+		}
 
-		tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+		respondTokens(c, delivery, tokens, gin.H{})
+	}
+}
+
+// Logout revokes the presented access token and every refresh token of the
+// user, and clears the auth cookies. Calling it again with the same token is
+// a no-op that still succeeds.
+func Logout(db *gorm.DB, tokenService *TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID, err := uuid.Parse(c.GetString("user_id"))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid user ID")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"token": tokenString,
-			"user":  user,
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if jti := c.GetString("jti"); jti != "" {
+				expiresAt := time.Now().Add(tokenService.expiry)
+				if exp, ok := c.Get("token_exp"); ok {
+					expiresAt = exp.(time.Time)
+				}
+				revoked := RevokedToken{JTI: jti, UserID: userID, ExpiresAt: expiresAt}
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+					return err
+				}
+			}
+			return revokeUserRefreshTokens(tx, userID)
 		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to revoke sessions")
+			return
+		}
+
+		clearAuthCookies(c)
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 	}
 }
 
 func GetProfile(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		userID := c.GetString("user_id")
 		if userID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "User ID not found in token"})
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "User ID not found in token")
+			return
+		}
+		fields, ok := parseFields(c, profileFields)
+		if !ok {
 			return
 		}
 
 		var user User
-		if err := db.Preload("Addresses").First(&user, "id = ?", userID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found", "details": err.Error()})
+		err := retryRead(c.Request.Context(), func() error {
+			return db.Preload("Addresses").First(&user, "id = ?", userID).Error
+		})
+		if err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
 			return
 		}
+		if user.ProfilePicture == "" {
+			user.ProfilePicture = defaultAvatarURL()
+		}
+		localizeProfile(&user)
 
-		c.JSON(http.StatusOK, user)
+		body, err := selectFields(user, fields)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch profile")
+			return
+		}
+		setETag(c, user.Version)
+		c.JSON(http.StatusOK, body)
 	}
 }
 
-func UpdateProfile(db *gorm.DB) gin.HandlerFunc {
+func UpdateProfile(db *gorm.DB, events EventPublisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		userID := c.GetString("user_id")
 
 		var user User
 		if err := db.First(&user, "id = ?", userID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
 			return
 		}
 
 		var req UpdateProfileRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondBindError(c, err)
+			return
+		}
+		expected, ok := expectedVersion(c, req.Version)
+		if !ok {
 			return
 		}
 
@@ -171,7 +545,17 @@ func UpdateProfile(db *gorm.DB) gin.HandlerFunc {
 			updates["last_name"] = req.LastName
 		}
 		if req.PhoneNumber != "" {
-			updates["phone_number"] = req.PhoneNumber
+			phone, err := normalizePhoneNumber(req.PhoneNumber)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, "INVALID_PHONE_NUMBER", err.Error())
+				return
+			}
+			// A new number has to be verified again
+			if phone != string(user.PhoneNumber) {
+				updates["phone_number"] = EncryptedString(phone)
+				updates["phone_verified"] = false
+				updates["phone_verification_code_hash"] = ""
+			}
 		}
 		if req.DateOfBirth != nil {
 			updates["date_of_birth"] = req.DateOfBirth
@@ -185,136 +569,338 @@ func UpdateProfile(db *gorm.DB) gin.HandlerFunc {
 		if req.PreferredLanguage != "" {
 			updates["preferred_language"] = req.PreferredLanguage
 		}
-
-		if err := db.Model(&user).Updates(updates).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
-			return
+		if req.Timezone != "" {
+			timezone, err := normalizeTimezone(req.Timezone)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, "INVALID_TIMEZONE", "Timezone "+err.Error())
+				return
+			}
+			updates["timezone"] = timezone
+		}
+		if req.Locale != "" {
+			locale, err := normalizeLocale(req.Locale)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, "INVALID_LOCALE", "Locale "+err.Error())
+				return
+			}
+			updates["locale"] = locale
 		}
 
-		c.JSON(http.StatusOK, user)
+		saveProfile(c, db, events, &user, expected, updates)
 	}
 }
 
-func AddAddress(db *gorm.DB) gin.HandlerFunc {
+// saveProfile applies updates at the expected version, publishes the change
+// and responds with the updated user
+func saveProfile(c *gin.Context, db *gorm.DB, events EventPublisher, user *User, expected int, updates map[string]interface{}) {
+	err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+		if err := updateVersioned(tx, user, expected, updates); err != nil {
+			return err
+		}
+		return publishUserEvent(tx, events, EventUserUpdated, user)
+	})
+	if errors.Is(err, errVersionConflict) {
+		var current User
+		db.Select("version").First(&current, "id = ?", user.ID)
+		respondVersionConflict(c, current.Version)
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update profile")
+		return
+	}
+
+	user.Version = expected + 1
+	localizeProfile(user)
+	setETag(c, user.Version)
+	c.JSON(http.StatusOK, user)
+}
+
+func AddAddress(db *gorm.DB, geocoder Geocoder) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		userID := c.GetString("user_id")
-		var address Address
-		if err := c.ShouldBindJSON(&address); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		var input AddressInput
+		if err := bindBody(c, &input); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		address := input.address()
+		if rejectInvalidAddress(c, &address) {
 			return
 		}
 
 		userUUID, err := uuid.Parse(userID)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid user ID")
 			return
 		}
 		address.UserID = userUUID
-		if err := db.Create(&address).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add address"})
+		address.Version = 1 // the version is server-managed, ignore any sent by the client
+		markGeocodePending(&address, geocoder)
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			count, err := reserveAddresses(tx, userUUID, 1)
+			if err != nil {
+				return err
+			}
+			// The first address always becomes the default
+			if count == 0 {
+				address.IsDefault = true
+			}
+			if address.IsDefault {
+				if err := clearDefaultAddress(tx, userUUID); err != nil {
+					return err
+				}
+			}
+			if err := tx.Omit(clause.Associations).Create(&address).Error; err != nil {
+				return err
+			}
+			return enqueueGeocode(tx, geocoder, address.ID, address.Version)
+		})
+		if respondAddressLimit(c, err) {
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to add address")
 			return
 		}
 
+		setETag(c, address.Version)
+		c.Header("Location", resourceURL(c, fmt.Sprintf("/addresses/%d", address.ID)))
 		c.JSON(http.StatusCreated, address)
 	}
 }
 
+var addressSortColumns = map[string]string{
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"city":       "city",
+	"country":    "country",
+}
+
 func ListAddresses(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		userID := c.GetString("user_id")
-		var addresses []Address
-		if err := db.Where("user_id = ?", userID).Find(&addresses).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch addresses"})
+		pagination := parsePagination(c)
+		order, ok := parseSort(c, addressSortColumns, "created_at ASC")
+		if !ok {
+			respondError(c, http.StatusBadRequest, "INVALID_SORT", "Invalid sort field")
+			return
+		}
+		fields, ok := parseFields(c, addressFields)
+		if !ok {
+			return
+		}
+
+		addresses, total, err := queryAddresses(db, userID, pagination, order)
+		if err != nil {
+			respondDBError(c, err, "Failed to fetch addresses")
+			return
+		}
+
+		body, err := selectPageFields(newPage(addresses, total, pagination), fields)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch addresses")
 			return
 		}
-		c.JSON(http.StatusOK, addresses)
+		c.JSON(http.StatusOK, body)
 	}
 }
 
-// RequestPasswordReset handles the password reset request
-func RequestPasswordReset(db *gorm.DB, emailService *EmailService) gin.HandlerFunc {
+// queryAddresses returns one page of a user's addresses and their total count;
+// shared by the REST and gRPC APIs
+func queryAddresses(db *gorm.DB, userID string, pagination Pagination, order string) ([]Address, int64, error) {
+	var total int64
+	var addresses []Address
+	err := retryRead(db.Statement.Context, func() error {
+		if err := db.Model(&Address{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+			return err
+		}
+		addresses = []Address{}
+		return db.Where("user_id = ?", userID).
+			Order(order + ", id ASC").
+			Scopes(paginate(pagination)).
+			Find(&addresses).Error
+	})
+	return addresses, total, err
+}
+
+// RequestPasswordReset handles the password reset request. Past request
+// validation it always answers with the same 200, so neither a throttled
+// request, an unknown address nor a server-side failure can be told apart
+// from a sent email; failures are logged instead.
+func RequestPasswordReset(db *gorm.DB, emailService *EmailService, captcha CaptchaVerifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		var req RequestPasswordResetRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondBindError(c, err)
 			return
 		}
-
-		// Find user by email
-		var user User
-		if err := db.Where("email = ?", req.Email).First(&user).Error; err != nil {
-			// Don't reveal if email exists or not for security
-			c.JSON(http.StatusOK, gin.H{"message": "If your email is registered, you will receive a password reset link"})
+		if rejectFailedCaptcha(c, captcha, req.CaptchaToken) {
 			return
 		}
+		issuePasswordReset(c, db, emailService, normalizeEmail(req.Email))
+		c.JSON(http.StatusOK, gin.H{"message": passwordResetMessage})
+	}
+}
 
-		// Generate reset token
-		if err := user.GeneratePasswordResetToken(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate reset token"})
-			return
-		}
+// issuePasswordReset emails a new reset token for the account owning email,
+// unless requests for it are throttled. It reports nothing to the caller:
+// a miss is silent and failures are only logged.
+func issuePasswordReset(c *gin.Context, db *gorm.DB, emailService *EmailService, email string) {
+	log := middleware.Logger(c).With(zap.String("email_hash", hashToken(email)))
+	throttled, err := passwordResetThrottled(db, c, email)
+	if err != nil {
+		passwordResetsTotal.WithLabelValues("failed").Inc()
+		log.Error("Failed to check password reset throttle", zap.Error(err))
+		return
+	}
+	if throttled {
+		passwordResetsTotal.WithLabelValues("throttled").Inc()
+		log.Warn("Password reset request suppressed", zap.String("ip", middleware.ClientIP(c)))
+		return
+	}
 
-		// Save token to database
-		if err := db.Save(&user).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save reset token"})
-			return
+	// Any verified address of the account may receive the link
+	user, err := userForPasswordReset(db, email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			passwordResetsTotal.WithLabelValues("failed").Inc()
+			log.Error("Failed to look up account for password reset", zap.Error(err))
 		}
+		return
+	}
 
-		// Send reset email
-		if err := emailService.SendPasswordResetEmail(user.Email, user.PasswordResetToken); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reset email"})
-			return
-		}
+	// Generate reset token; this replaces and so invalidates any earlier one
+	token, err := user.GeneratePasswordResetToken(passwordResetTTL())
+	if err != nil {
+		passwordResetsTotal.WithLabelValues("failed").Inc()
+		log.Error("Failed to generate reset token", zap.Error(err))
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "If your email is registered, you will receive a password reset link"})
+	// Save the token and queue the reset email together
+	err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		return emailService.Send(tx, email, user.EffectiveLocale(), NewPasswordResetEmail(token))
+	})
+	if err != nil {
+		passwordResetsTotal.WithLabelValues("failed").Inc()
+		log.Error("Failed to save reset token", zap.Error(err))
+		return
 	}
+	passwordResetsTotal.WithLabelValues("requested").Inc()
 }
 
-// ResetPassword handles the password reset
+// errResetTokenInvalid covers unknown, used and expired reset tokens alike
+var errResetTokenInvalid = errors.New("invalid reset token")
+
+// ResetPassword sets a new password from a reset token. The token works once:
+// it is cleared in the same conditional update that sets the password, so
+// concurrent requests with one token can't both succeed. Unknown, used and
+// expired tokens get the same error.
 func ResetPassword(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		var req ResetPasswordRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondBindError(c, err)
+			return
+		}
+		if rejectWeakPassword(c, req.Password) {
 			return
 		}
 
+		// A numeric code is looked up through the account's email, as the code
+		// alone doesn't identify it
+		policy := passwordResetTokens.Policy()
+		token := strings.TrimSpace(req.Token)
 		var user User
-		if err := db.Where("password_reset_token = ?", req.Token).First(&user).Error; err != nil {
+		var err error
+		if policy.Numeric() {
+			if req.Email == "" {
+				respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Email is required with a reset code")
+				return
+			}
+			user, err = userForPasswordReset(db, normalizeEmail(req.Email))
+		} else {
+			err = db.Where("password_reset_token_hash = ?", hashToken(token)).First(&user).Error
+		}
+		if err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"error": "Invalid reset token",
-					"code":  "INVALID_TOKEN",
-				})
+				respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Invalid or expired token")
 				return
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
 			return
 		}
-
-		if !user.IsResetTokenValid(req.Token) {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Token has expired",
-				"code":  "TOKEN_EXPIRED",
-			})
+		// A guess at a numeric code is counted before it is compared, so
+		// concurrent requests can't get more than OTP_MAX_ATTEMPTS at one code
+		discard := map[string]interface{}{"password_reset_token_hash": "", "reset_token_expires_at": nil}
+		if policy.Numeric() && user.PasswordResetTokenHash != "" {
+			reserved, err := reserveOTPAttempt(db, user.ID, "password_reset_attempts", otpMaxAttempts())
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+				return
+			}
+			if !reserved {
+				if err := discardSpentOTP(db, user.ID, "password_reset_attempts", discard); err != nil {
+					middleware.Logger(c).Error("Failed to discard reset code", zap.Error(err))
+				}
+				respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Invalid or expired token")
+				return
+			}
+		}
+		if !user.IsResetTokenValid(token) {
+			if policy.Numeric() && user.PasswordResetTokenHash != "" {
+				if err := discardSpentOTP(db, user.ID, "password_reset_attempts", discard); err != nil {
+					middleware.Logger(c).Error("Failed to discard reset code", zap.Error(err))
+				}
+			}
+			respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Invalid or expired token")
 			return
 		}
+		tokenHash := policy.Digest(user.ID, token)
 
-		// Update password
 		user.Password = req.Password
 		if err := user.HashPassword(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Password hashing failed"})
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Password hashing failed")
 			return
 		}
 
-		// Clear reset token
-		user.ClearResetToken()
-
-		if err := db.Save(&user).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		// Sign out every existing session now that the password has changed
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			result := tx.Model(&User{}).
+				Where("id = ? AND password_reset_token_hash = ? AND reset_token_expires_at > ?", user.ID, tokenHash, time.Now()).
+				Updates(map[string]interface{}{
+					"password":                  user.Password,
+					"password_reset_token_hash": "",
+					"reset_token_expires_at":    nil,
+					"password_reset_attempts":   0,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			// Another request used the token first
+			if result.RowsAffected == 0 {
+				return errResetTokenInvalid
+			}
+			return revokeUserRefreshTokens(tx, user.ID)
+		})
+		if errors.Is(err, errResetTokenInvalid) {
+			respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Invalid or expired token")
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update password")
 			return
 		}
 
+		passwordResetsTotal.WithLabelValues("completed").Inc()
 		c.JSON(http.StatusOK, gin.H{"message": "Password reset successful"})
 	}
 }
@@ -322,39 +908,49 @@ func ResetPassword(db *gorm.DB) gin.HandlerFunc {
 // ChangePasswordRequest represents the request body for changing password
 type ChangePasswordRequest struct {
 	CurrentPassword string `json:"current_password" binding:"required"`
-	NewPassword     string `json:"new_password" binding:"required,min=8"`
+	NewPassword     string `json:"new_password" binding:"required"`
 }
 
-func ChangePassword(db *gorm.DB) gin.HandlerFunc {
+func ChangePassword(db *gorm.DB, emailService *EmailService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		userID := c.GetString("user_id")
 		var req ChangePasswordRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			respondBindError(c, err)
+			return
+		}
+		if rejectWeakPassword(c, req.NewPassword) {
 			return
 		}
 
 		var user User
 		if err := db.First(&user, "id = ?", userID).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
 			return
 		}
 
 		// Verify current password
 		if err := user.ComparePassword(req.CurrentPassword); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+			respondError(c, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Current password is incorrect")
 			return
 		}
 
 		// Update password
 		user.Password = req.NewPassword
 		if err := user.HashPassword(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to hash password")
 			return
 		}
 
-		if err := db.Save(&user).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.Save(&user).Error; err != nil {
+				return err
+			}
+			return emailService.Send(tx, user.Email, user.EffectiveLocale(), PasswordChangedEmail{})
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update password")
 			return
 		}
 
@@ -362,20 +958,77 @@ func ChangePassword(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-func UpdateAddress(db *gorm.DB) gin.HandlerFunc {
+// addressIDParam reads the :id of an address route. An id that can't be an
+// address ID is answered 404 like any other missing address, instead of
+// reaching the database as a malformed query.
+func addressIDParam(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		respondError(c, http.StatusNotFound, "ADDRESS_NOT_FOUND", "Address not found")
+		return "", false
+	}
+	return id, true
+}
+
+// GetAddress returns one of the caller's addresses
+func GetAddress(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+		addressID, ok := addressIDParam(c)
+		if !ok {
+			return
+		}
+		fields, ok := parseFields(c, addressFields)
+		if !ok {
+			return
+		}
+
+		var address Address
+		err := retryRead(c.Request.Context(), func() error {
+			return db.Where("id = ? AND user_id = ?", addressID, userID).First(&address).Error
+		})
+		if err != nil {
+			respondLookupError(c, err, "ADDRESS_NOT_FOUND", "Address not found", "Failed to fetch address")
+			return
+		}
+
+		body, err := selectFields(address, fields)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch address")
+			return
+		}
+		setETag(c, address.Version)
+		c.JSON(http.StatusOK, body)
+	}
+}
+
+func UpdateAddress(db *gorm.DB, geocoder Geocoder) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		userID := c.GetString("user_id")
-		addressID := c.Param("id")
+		addressID, ok := addressIDParam(c)
+		if !ok {
+			return
+		}
 
 		var address Address
 		if err := db.Where("id = ? AND user_id = ?", addressID, userID).First(&address).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+			respondLookupError(c, err, "ADDRESS_NOT_FOUND", "Address not found", "Failed to fetch address")
 			return
 		}
 
-		var updatedAddress Address
-		if err := c.ShouldBindJSON(&updatedAddress); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		var input AddressInput
+		if err := bindBody(c, &input); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		updatedAddress := input.address()
+		if rejectInvalidAddress(c, &updatedAddress) {
+			return
+		}
+		expected, ok := expectedVersion(c, updatedAddress.Version)
+		if !ok {
 			return
 		}
 
@@ -386,28 +1039,78 @@ func UpdateAddress(db *gorm.DB) gin.HandlerFunc {
 			"country":     updatedAddress.Country,
 			"postal_code": updatedAddress.PostalCode,
 		}
+		// Coordinates of the old location are dropped and looked up again
+		relocated := updatedAddress.Street != address.Street || updatedAddress.City != address.City ||
+			updatedAddress.State != address.State || updatedAddress.Country != address.Country ||
+			updatedAddress.PostalCode != address.PostalCode
+		if relocated {
+			for column, value := range geocodeResetFields(geocoder) {
+				updates[column] = value
+			}
+		}
 
-		if err := db.Model(&address).Updates(updates).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update address"})
+		// Only promotion is accepted here; the default moves away by promoting another address
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if updatedAddress.IsDefault && !address.IsDefault {
+				if err := lockUser(tx, userID); err != nil {
+					return err
+				}
+				if err := clearDefaultAddress(tx, address.UserID); err != nil {
+					return err
+				}
+				updates["is_default"] = true
+			}
+			if err := updateVersioned(tx, &address, expected, updates); err != nil {
+				return err
+			}
+			if relocated {
+				return enqueueGeocode(tx, geocoder, address.ID, expected+1)
+			}
+			return nil
+		})
+		if errors.Is(err, errVersionConflict) {
+			var current Address
+			db.Select("version").First(&current, address.ID)
+			respondVersionConflict(c, current.Version)
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update address")
 			return
 		}
 
+		address.Version = expected + 1
+		setETag(c, address.Version)
 		c.JSON(http.StatusOK, address)
 	}
 }
 
 func DeleteAddress(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		userID := c.GetString("user_id")
-		addressID := c.Param("id")
+		addressID, ok := addressIDParam(c)
+		if !ok {
+			return
+		}
 
-		result := db.Where("id = ? AND user_id = ?", addressID, userID).Delete(&Address{})
-		if result.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete address"})
+		var address Address
+		if err := db.Where("id = ? AND user_id = ?", addressID, userID).First(&address).Error; err != nil {
+			respondLookupError(c, err, "ADDRESS_NOT_FOUND", "Address not found", "Failed to delete address")
 			return
 		}
-		if result.RowsAffected == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.Delete(&address).Error; err != nil {
+				return err
+			}
+			if address.IsDefault {
+				return promoteLatestAddress(tx, address.UserID)
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to delete address")
 			return
 		}
 
@@ -415,68 +1118,136 @@ func DeleteAddress(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-func DeleteAccount(db *gorm.DB) gin.HandlerFunc {
+// SetDefaultAddress promotes an address to be the user's default
+func SetDefaultAddress(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
 		userID := c.GetString("user_id")
-		if userID == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		addressID, ok := addressIDParam(c)
+		if !ok {
 			return
 		}
 
-		parsedUUID, err := uuid.Parse(userID)
+		var address Address
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			var err error
+			address, err = promoteAddress(tx, userID, addressID)
+			return err
+		})
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+			respondLookupError(c, err, "ADDRESS_NOT_FOUND", "Address not found", "Failed to set default address")
 			return
 		}
 
-		// Start a transaction
-		tx := db.Begin()
-		if tx.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		c.JSON(http.StatusOK, address)
+	}
+}
+
+// promoteAddress makes addressID the user's only default address, within the
+// caller's transaction so either both flags change or neither does. The user
+// is locked first, so concurrent promotions queue rather than collide on the
+// one-default index, and the target must belong to the user before anything
+// is cleared.
+func promoteAddress(tx *gorm.DB, userID, addressID string) (Address, error) {
+	var address Address
+	if err := lockUser(tx, userID); err != nil {
+		return address, err
+	}
+	if err := tx.Where("id = ? AND user_id = ?", addressID, userID).First(&address).Error; err != nil {
+		return address, err
+	}
+	if err := tx.Model(&Address{}).
+		Where("user_id = ? AND is_default = ? AND id <> ?", address.UserID, true, address.ID).
+		Update("is_default", false).Error; err != nil {
+		return address, err
+	}
+	if address.IsDefault {
+		return address, nil
+	}
+	address.IsDefault = true
+	return address, tx.Model(&address).Update("is_default", true).Error
+}
+
+// clearDefaultAddress unsets the default flag on all of the user's addresses
+func clearDefaultAddress(tx *gorm.DB, userID uuid.UUID) error {
+	return tx.Model(&Address{}).
+		Where("user_id = ? AND is_default = ?", userID, true).
+		Update("is_default", false).Error
+}
+
+// promoteLatestAddress makes the most recently created remaining address the default
+func promoteLatestAddress(tx *gorm.DB, userID uuid.UUID) error {
+	var latest Address
+	err := tx.Where("user_id = ?", userID).Order("created_at DESC").First(&latest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&latest).Update("is_default", true).Error
+}
+
+func DeleteAccount(db *gorm.DB, storage Storage, events EventPublisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+		if userID == "" {
+			respondError(c, http.StatusUnauthorized, "UNAUTHORIZED", "User not authenticated")
 			return
 		}
 
-		// Find the user first to ensure they exist
-		var user User
-		if err := tx.First(&user, parsedUUID).Error; err != nil {
-			tx.Rollback()
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find user"})
-			}
+		parsedUUID, err := uuid.Parse(userID)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Invalid user ID format")
 			return
 		}
 
-		// Delete associated data with proper error handling
-		if err := tx.Where("user_id = ?", parsedUUID).Delete(&Address{}).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Failed to delete user addresses",
-				"details": err.Error(),
-			})
+		strategy, ok := deletionStrategy(c)
+		if !ok {
+			respondError(c, http.StatusBadRequest, "INVALID_STRATEGY", "Strategy must be purge or anonymize")
 			return
 		}
 
-		// Delete the user with hard delete
-		if err := tx.Unscoped().Delete(&user).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Failed to delete user",
-				"details": err.Error(),
-			})
+		var user User
+		var avatarKey string
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.First(&user, parsedUUID).Error; err != nil {
+				return err
+			}
+			avatarKey = user.AvatarKey
+			// Subscribers get the event before the personal data is gone
+			if err := publishUserEvent(tx, events, EventUserDeleted, &user); err != nil {
+				return err
+			}
+			if strategy == DeletionAnonymize {
+				return anonymizeAccount(tx, &user)
+			}
+			// Soft delete the user; addresses are kept until the account is purged
+			// so that a restore within the grace period is lossless
+			if err := setUserStatus(tx, &user, UserStatusDeleted, map[string]interface{}{"deleted_at": time.Now()}); err != nil {
+				return err
+			}
+			return revokeUserRefreshTokens(tx, parsedUUID)
+		})
+		if err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to delete account")
 			return
 		}
 
-		// Commit the transaction
-		if err := tx.Commit().Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error":   "Failed to commit transaction",
-				"details": err.Error(),
-			})
+		if strategy == DeletionAnonymize {
+			if avatarKey != "" {
+				if err := storage.Delete(c.Request.Context(), avatarKey); err != nil {
+					middleware.Logger(c).Warn("Failed to delete avatar of anonymized account", zap.String("key", avatarKey), zap.Error(err))
+				}
+			}
+			c.JSON(http.StatusOK, gin.H{"message": "Account deleted and anonymized", "strategy": strategy})
 			return
 		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "Account deleted successfully"})
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Account deleted successfully",
+			"strategy":    strategy,
+			"purge_after": time.Now().Add(accountDeletionGracePeriod()),
+		})
 	}
 }
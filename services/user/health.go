@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"gorm.io/gorm"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+// Dependency and overall readiness statuses
+const (
+	dependencyUp   = "up"
+	dependencyDown = "down"
+
+	readinessReady       = "ready"
+	readinessDegraded    = "degraded"
+	readinessUnavailable = "unavailable"
+)
+
+// LivenessCheck reports that the process is up and its background workers
+// are making progress
+func LivenessCheck() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// A worker that stopped beating is likely deadlocked; failing lets the
+		// orchestrator restart the instance
+		if stale := workerHeartbeats.Stale(); len(stale) > 0 {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "stalled", "stale_workers": stale})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
+	}
+}
+
+// DependencyStatus is the result of one readiness check. Checks fill in
+// Message, Version and Details; the status, latency and criticality are set
+// by the registry.
+type DependencyStatus struct {
+	Status    string      `json:"status"`
+	Critical  bool        `json:"critical"`
+	LatencyMS float64     `json:"latency_ms"`
+	Message   string      `json:"message,omitempty"`
+	Version   string      `json:"version,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// HealthCheck probes one dependency. A critical dependency being down makes
+// the service unavailable; any other only degrades it, as the service can
+// still serve most requests without it.
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	// Timeout bounds this check alone; zero means healthCheckTimeout
+	Timeout time.Duration
+	Check   func(ctx context.Context) (DependencyStatus, error)
+}
+
+// ReadinessReport rolls the dependency statuses up into one
+type ReadinessReport struct {
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks"`
+}
+
+// Ready reports whether the service should receive traffic; a degraded
+// service still does
+func (r ReadinessReport) Ready() bool {
+	return r.Status != readinessUnavailable
+}
+
+// healthRegistry holds the readiness checks. Components register their own
+// dependencies, so adding one needs no change here.
+type healthRegistry struct {
+	mu     sync.RWMutex
+	checks map[string]HealthCheck
+}
+
+// readinessChecks is the process-wide set of readiness checks
+var readinessChecks = &healthRegistry{checks: make(map[string]HealthCheck)}
+
+// Register adds check, replacing any other of the same name
+func (r *healthRegistry) Register(check HealthCheck) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[check.Name] = check
+}
+
+// Run executes every check concurrently, each under its own timeout, so a
+// hung dependency is reported as down without holding up the others
+func (r *healthRegistry) Run(ctx context.Context) ReadinessReport {
+	r.mu.RLock()
+	checks := make([]HealthCheck, 0, len(r.checks))
+	for _, check := range r.checks {
+		checks = append(checks, check)
+	}
+	r.mu.RUnlock()
+	sort.Slice(checks, func(i, j int) bool { return checks[i].Name < checks[j].Name })
+
+	results := make([]DependencyStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := ReadinessReport{Status: readinessReady, Checks: make(map[string]DependencyStatus, len(checks))}
+	for i, check := range checks {
+		result := results[i]
+		report.Checks[check.Name] = result
+		if result.Status == dependencyUp {
+			continue
+		}
+		if check.Critical {
+			report.Status = readinessUnavailable
+		} else if report.Status == readinessReady {
+			report.Status = readinessDegraded
+		}
+	}
+	return report
+}
+
+// runHealthCheck runs one check, giving up on it at its timeout even if the
+// check itself ignores the context
+func runHealthCheck(ctx context.Context, check HealthCheck) DependencyStatus {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = healthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type outcome struct {
+		status DependencyStatus
+		err    error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		status, err := check.Check(ctx)
+		done <- outcome{status, err}
+	}()
+
+	var result outcome
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result.err = fmt.Errorf("timed out after %s", timeout)
+	}
+	status := result.status
+	status.Critical = check.Critical
+	status.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	status.Status = dependencyUp
+	if result.err != nil {
+		status.Status = dependencyDown
+		status.Message = result.err.Error()
+	}
+	return status
+}
+
+// registerReadinessChecks registers the service's own dependencies: the
+// database and startup migration, which are critical, and Consul and the
+// email provider, which are not, since registration retries in the
+// background and emails wait in the outbox. The leader check is
+// informational and never fails.
+func registerReadinessChecks(db *gorm.DB, migrations *migrationTracker, breaker *circuitBreaker, consul *api.Client, email EmailSender) {
+	readinessChecks.Register(HealthCheck{Name: "database", Critical: true, Check: func(ctx context.Context) (DependencyStatus, error) {
+		circuit := breaker.State()
+		status := DependencyStatus{Details: gin.H{"circuit": circuit}}
+		sqlDB, err := db.DB()
+		if err == nil {
+			err = sqlDB.PingContext(ctx)
+		}
+		if err == nil && circuit == circuitOpen {
+			err = errCircuitOpen
+		}
+		if err == nil {
+			err = db.WithContext(ctx).Raw("SHOW server_version").Scan(&status.Version).Error
+		}
+		return status, err
+	}})
+	readinessChecks.Register(HealthCheck{Name: "migrations", Critical: true, Check: func(ctx context.Context) (DependencyStatus, error) {
+		migration := migrations.Status()
+		status := DependencyStatus{Message: migration.Status, Details: migration}
+		if !migration.Ready() {
+			return status, fmt.Errorf("migration %s", migration.Status)
+		}
+		return status, nil
+	}})
+	readinessChecks.Register(HealthCheck{Name: "leader", Check: func(ctx context.Context) (DependencyStatus, error) {
+		return DependencyStatus{Details: leadership.Status()}, nil
+	}})
+	readinessChecks.Register(HealthCheck{Name: "consul", Check: func(ctx context.Context) (DependencyStatus, error) {
+		self, err := consul.Agent().Self()
+		if err != nil {
+			return DependencyStatus{}, err
+		}
+		version, _ := self["Config"]["Version"].(string)
+		return DependencyStatus{Version: version}, nil
+	}})
+	readinessChecks.Register(HealthCheck{Name: "email", Check: func(ctx context.Context) (DependencyStatus, error) {
+		checker, ok := email.(EmailHealthChecker)
+		if !ok {
+			return DependencyStatus{Message: "not checked"}, nil
+		}
+		return DependencyStatus{}, checker.CheckHealth(ctx)
+	}})
+}
+
+// ReadinessCheck is the public readiness probe: 200 {"status":"up"} when
+// ready or degraded, 503 {"status":"down"} when a critical dependency is
+// down. Dependency names, versions and errors stay out of it; they are in
+// the report served by ReadinessDetails.
+func ReadinessCheck() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if report := readinessChecks.Run(c.Request.Context()); !report.Ready() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": dependencyDown})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": dependencyUp})
+	}
+}
+
+// ReadinessDetails serves the full readiness report, with the same status
+// codes as ReadinessCheck. It is mounted for admins only.
+func ReadinessDetails() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := readinessChecks.Run(c.Request.Context())
+		code := http.StatusOK
+		if !report.Ready() {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, report)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useReadinessChecks swaps in a registry holding only checks for the test
+func useReadinessChecks(t *testing.T, checks ...HealthCheck) {
+	t.Helper()
+	previous := readinessChecks
+	readinessChecks = &healthRegistry{checks: make(map[string]HealthCheck)}
+	for _, check := range checks {
+		readinessChecks.Register(check)
+	}
+	t.Cleanup(func() { readinessChecks = previous })
+}
+
+func TestReadinessCheckHidesDetails(t *testing.T) {
+	const internal = "dial tcp 10.0.3.7:5432: connection refused"
+	tests := []struct {
+		name       string
+		err        error
+		critical   bool
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "ready", wantStatus: http.StatusOK, wantBody: `{"status":"up"}`},
+		{name: "degraded", err: errors.New(internal), wantStatus: http.StatusOK, wantBody: `{"status":"up"}`},
+		{name: "unavailable", err: errors.New(internal), critical: true, wantStatus: http.StatusServiceUnavailable, wantBody: `{"status":"down"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useReadinessChecks(t, HealthCheck{Name: "database", Critical: tt.critical, Check: func(context.Context) (DependencyStatus, error) {
+				return DependencyStatus{Version: "16.2"}, tt.err
+			}})
+
+			w := serve(ReadinessCheck(), "/health", http.MethodGet, "/health", "", "")
+			if w.Code != tt.wantStatus || w.Body.String() != tt.wantBody {
+				t.Errorf("public report = %d %s, want %d %s", w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+
+			// The admin report keeps what the public one leaves out
+			w = serve(ReadinessDetails(), "/admin/health", http.MethodGet, "/admin/health", "", "")
+			if w.Code != tt.wantStatus || !strings.Contains(w.Body.String(), `"version":"16.2"`) {
+				t.Errorf("detailed report = %d %s, want %d with dependency versions", w.Code, w.Body.String(), tt.wantStatus)
+			}
+			if tt.err != nil && !strings.Contains(w.Body.String(), internal) {
+				t.Errorf("detailed report %s leaves out the check error", w.Body.String())
+			}
+		})
+	}
+}
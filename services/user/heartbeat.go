@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workerHeartbeats records when each critical background worker last made
+// progress, so liveness can fail when one has hung
+var workerHeartbeats = &heartbeatRegistry{workers: map[string]*workerHeartbeat{}}
+
+type workerHeartbeat struct {
+	last   time.Time
+	maxAge time.Duration
+}
+
+type heartbeatRegistry struct {
+	mu      sync.Mutex
+	workers map[string]*workerHeartbeat
+}
+
+// defaultHeartbeatMaxAge lets a ticker worker miss a beat, plus a minute for
+// the work itself
+func defaultHeartbeatMaxAge(interval time.Duration) time.Duration {
+	return 2*interval + time.Minute
+}
+
+// staleWorker is a worker whose last heartbeat is older than allowed
+type staleWorker struct {
+	Name     string `json:"name"`
+	LastBeat string `json:"last_beat"`
+	MaxAge   string `json:"max_age"`
+}
+
+// Register starts tracking a worker that must beat at least every maxAge.
+// HEARTBEAT_MAX_AGE_<NAME> overrides maxAge, e.g. HEARTBEAT_MAX_AGE_EMAIL_DISPATCHER.
+func (r *heartbeatRegistry) Register(name string, maxAge time.Duration) {
+	key := "HEARTBEAT_MAX_AGE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workers[name] = &workerHeartbeat{last: time.Now(), maxAge: getEnvDuration(key, maxAge)}
+}
+
+// Unregister stops tracking a worker that exited on purpose
+func (r *heartbeatRegistry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.workers, name)
+}
+
+// Beat records progress by a registered worker
+func (r *heartbeatRegistry) Beat(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w, ok := r.workers[name]; ok {
+		w.last = time.Now()
+	}
+}
+
+// Stale lists the workers that have not beaten within their max age
+func (r *heartbeatRegistry) Stale() []staleWorker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var stale []staleWorker
+	for name, w := range r.workers {
+		if time.Since(w.last) > w.maxAge {
+			stale = append(stale, staleWorker{Name: name, LastBeat: w.last.UTC().Format(time.RFC3339), MaxAge: w.maxAge.String()})
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Name < stale[j].Name })
+	return stale
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// idempotencyLockTimeout bounds how long an unfinished request holds its key,
+// so a crash mid-request doesn't block retries for the full TTL
+const idempotencyLockTimeout = time.Minute
+
+// IdempotencyKey records the response produced for a client-supplied key
+type IdempotencyKey struct {
+	Key         string `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	Fingerprint string `gorm:"not null"`
+	Completed   bool   `gorm:"default:false;not null"`
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+	ExpiresAt   time.Time `gorm:"index;not null"`
+}
+
+// idempotencyKeyTTL is how long responses are replayable (IDEMPOTENCY_KEY_TTL)
+func idempotencyKeyTTL() time.Duration {
+	return getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+}
+
+// idempotencyStore implements middleware.IdempotencyStore on the database.
+// The primary key serializes concurrent requests: only the one whose insert
+// succeeds executes.
+type idempotencyStore struct {
+	db *gorm.DB
+}
+
+func (s *idempotencyStore) Begin(key, fingerprint string) (*middleware.IdempotentResponse, error) {
+	now := time.Now()
+	if err := s.db.Where("key = ? AND expires_at < ?", key, now).Delete(&IdempotencyKey{}).Error; err != nil {
+		return nil, err
+	}
+
+	record := IdempotencyKey{Key: key, Fingerprint: fingerprint, ExpiresAt: now.Add(idempotencyLockTimeout)}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+
+	var existing IdempotencyKey
+	if err := s.db.First(&existing, "key = ?", key).Error; err != nil {
+		return nil, err
+	}
+	if existing.Fingerprint != fingerprint {
+		return nil, middleware.ErrIdempotencyKeyReused
+	}
+	if !existing.Completed {
+		return nil, middleware.ErrIdempotencyInProgress
+	}
+	return &middleware.IdempotentResponse{Status: existing.Status, ContentType: existing.ContentType, Location: existing.Location, Body: existing.Body}, nil
+}
+
+func (s *idempotencyStore) Complete(key string, resp middleware.IdempotentResponse) error {
+	return s.db.Model(&IdempotencyKey{}).Where("key = ?", key).Updates(map[string]interface{}{
+		"completed":    true,
+		"status":       resp.Status,
+		"content_type": resp.ContentType,
+		"location":     resp.Location,
+		"body":         resp.Body,
+		"expires_at":   time.Now().Add(idempotencyKeyTTL()),
+	}).Error
+}
+
+func (s *idempotencyStore) Release(key string) error {
+	return s.db.Where("key = ?", key).Delete(&IdempotencyKey{}).Error
+}
+
+// startIdempotencyKeyCleanup periodically removes expired idempotency keys
+func startIdempotencyKeyCleanup(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("idempotency-key-cleanup", defaultHeartbeatMaxAge(interval))
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("idempotency-key-cleanup")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("idempotency-key-cleanup")
+				if !leadership.Runs("idempotency-key-cleanup") {
+					continue
+				}
+				result := db.Where("expires_at < ?", time.Now()).Delete(&IdempotencyKey{})
+				if result.Error != nil {
+					zap.L().Error("Failed to clean up idempotency keys", zap.Error(result.Error))
+				} else if result.RowsAffected > 0 {
+					zap.L().Info("Removed expired idempotency keys", zap.Int64("count", result.RowsAffected))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"go.uber.org/zap"
+)
+
+// leadership decides which instance runs the singleton background jobs
+// (purges, cleanups, outbox dispatch). Until Start is called every instance
+// counts as leader, which keeps single-instance setups working unchanged.
+// Once it is, the jobs depend on Consul: while no instance can take the lock
+// they run nowhere, so the election is opt-in.
+var leadership = &leaderElection{}
+
+type leaderElection struct {
+	enabled atomic.Bool
+	leader  atomic.Bool
+	// failing is set while the last attempt to take the lock errored
+	failing atomic.Bool
+}
+
+// LeaderStatus is the election state reported by the readiness check
+type LeaderStatus struct {
+	Enabled  bool `json:"enabled"`
+	IsLeader bool `json:"is_leader"`
+}
+
+// leaderElectionEnabled reports whether replicas elect a leader through
+// Consul (LEADER_ELECTION_ENABLED). With it off, the default, every instance
+// runs the jobs.
+func leaderElectionEnabled() bool {
+	return getEnvBool("LEADER_ELECTION_ENABLED", false)
+}
+
+// IsLeader reports whether this instance should run singleton jobs now
+func (l *leaderElection) IsLeader() bool {
+	return !l.enabled.Load() || l.leader.Load()
+}
+
+// Runs reports whether this instance should run the named singleton job now.
+// Followers skip quietly, but a skip while the election is failing is a
+// warning: if Consul is down for every replica, the job runs nowhere.
+func (l *leaderElection) Runs(job string) bool {
+	if l.IsLeader() {
+		return true
+	}
+	if l.failing.Load() {
+		zap.L().Warn("Skipping singleton job, leader election is failing", zap.String("job", job))
+	} else {
+		zap.L().Debug("Skipping singleton job, another instance is leader", zap.String("job", job))
+	}
+	return false
+}
+
+func (l *leaderElection) Status() LeaderStatus {
+	return LeaderStatus{Enabled: l.enabled.Load(), IsLeader: l.IsLeader()}
+}
+
+// Start campaigns for the Consul lock at LEADER_ELECTION_KEY until ctx is
+// cancelled. The lock is tied to a session with a TTL, so if the leader dies
+// the session expires and another instance takes over.
+func (l *leaderElection) Start(ctx context.Context, client *api.Client) {
+	l.enabled.Store(true)
+	holder, _ := os.Hostname()
+	retry := getEnvDuration("LEADER_ELECTION_RETRY", 5*time.Second)
+
+	go func() {
+		for ctx.Err() == nil {
+			lock, err := client.LockOpts(&api.LockOptions{
+				Key:         getEnv("LEADER_ELECTION_KEY", "service/"+serviceID+"/leader"),
+				Value:       []byte(holder),
+				SessionName: serviceID + "-leader",
+				SessionTTL:  getEnv("LEADER_ELECTION_SESSION_TTL", "15s"),
+			})
+			if err == nil {
+				err = l.hold(ctx, lock, holder)
+			}
+			l.failing.Store(err != nil)
+			if err != nil {
+				zap.L().Warn("Leader election failed, singleton jobs paused until it succeeds", zap.Duration("retry_in", retry), zap.Error(err))
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(retry):
+			}
+		}
+	}()
+}
+
+// hold blocks until the lock is acquired, then until it is lost or ctx ends
+func (l *leaderElection) hold(ctx context.Context, lock *api.Lock, holder string) error {
+	lost, err := lock.Lock(ctx.Done())
+	if err != nil || lost == nil {
+		return err
+	}
+	l.failing.Store(false)
+	l.leader.Store(true)
+	zap.L().Info("Acquired leadership, running singleton jobs", zap.String("holder", holder))
+
+	select {
+	case <-lost:
+		l.leader.Store(false)
+		zap.L().Warn("Lost leadership, pausing singleton jobs")
+	case <-ctx.Done():
+		l.leader.Store(false)
+	}
+	// Frees the key right away on shutdown instead of waiting for the session TTL
+	if err := lock.Unlock(); err != nil && err != api.ErrLockNotHeld {
+		zap.L().Warn("Failed to release leader lock", zap.Error(err))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLeaderElectionOffByDefault(t *testing.T) {
+	t.Setenv("LEADER_ELECTION_ENABLED", "")
+	if leaderElectionEnabled() {
+		t.Error("leader election is on without LEADER_ELECTION_ENABLED")
+	}
+}
+
+func TestLeaderElectionRunsLogsSkips(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		leader    bool
+		failing   bool
+		wantRun   bool
+		wantLevel zapcore.Level
+	}{
+		{name: "election off", wantRun: true},
+		{name: "leader", enabled: true, leader: true, wantRun: true},
+		{name: "follower", enabled: true, wantLevel: zapcore.DebugLevel},
+		{name: "election failing", enabled: true, failing: true, wantLevel: zapcore.WarnLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := observeLogs(t)
+			l := &leaderElection{}
+			l.enabled.Store(tt.enabled)
+			l.leader.Store(tt.leader)
+			l.failing.Store(tt.failing)
+
+			if got := l.Runs("account-purge"); got != tt.wantRun {
+				t.Fatalf("Runs = %v, want %v", got, tt.wantRun)
+			}
+			entries := logs.All()
+			if tt.wantRun {
+				if len(entries) != 0 {
+					t.Errorf("logged %d entries for a job that runs", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 || entries[0].Level != tt.wantLevel || entries[0].ContextMap()["job"] != "account-purge" {
+				t.Fatalf("logs = %+v, want one %v entry naming the job", entries, tt.wantLevel)
+			}
+		})
+	}
+}
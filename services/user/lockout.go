@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func maxFailedLoginAttempts() int {
+	return getEnvInt("LOGIN_MAX_FAILED_ATTEMPTS", 5)
+}
+
+func loginLockoutDuration() time.Duration {
+	return getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+}
+
+// recordFailedLogin increments the failed attempt counter and locks the account
+// once the configured threshold is reached. It reports whether the account is
+// now locked.
+func recordFailedLogin(db *gorm.DB, user *User) (bool, error) {
+	attempts := user.FailedLoginAttempts + 1
+	if attempts < maxFailedLoginAttempts() {
+		if err := db.Model(user).Update("failed_login_attempts", gorm.Expr("failed_login_attempts + 1")).Error; err != nil {
+			return false, err
+		}
+		user.FailedLoginAttempts = attempts
+		return false, nil
+	}
+
+	lockedUntil := time.Now().Add(loginLockoutDuration())
+	if err := db.Model(user).Updates(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          lockedUntil,
+	}).Error; err != nil {
+		return false, err
+	}
+	user.FailedLoginAttempts = 0
+	user.LockedUntil = &lockedUntil
+	return true, nil
+}
+
+// respondLocked rejects a login for a locked account with 429 and Retry-After
+func respondLocked(c *gin.Context, remaining time.Duration) {
+	respondRetryAfter(c, http.StatusTooManyRequests, "ACCOUNT_LOCKED", "Account temporarily locked due to too many failed login attempts", remaining)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRespondLockedRetryAfter(t *testing.T) {
+	handler := func(c *gin.Context) { respondLocked(c, 90*time.Second+time.Millisecond) }
+	w := serve(handler, "/login", http.MethodPost, "/login", "", "")
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := decodeError(t, w).Error.Code; got != "ACCOUNT_LOCKED" {
+		t.Errorf("code = %q, want ACCOUNT_LOCKED", got)
+	}
+	if seconds, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || seconds != 91 {
+		t.Errorf("Retry-After = %q, want 91 seconds", w.Header().Get("Retry-After"))
+	}
+}
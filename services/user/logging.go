@@ -0,0 +1,22 @@
+package main
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newLogger builds the JSON logger used across the service. LOG_LEVEL selects
+// the minimum level (debug, info, warn, error).
+func newLogger() (*zap.Logger, error) {
+	config := zap.NewProductionConfig()
+	config.EncoderConfig.TimeKey = "timestamp"
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	level, err := zapcore.ParseLevel(getEnv("LOG_LEVEL", "info"))
+	if err != nil {
+		level = zapcore.InfoLevel
+	}
+	config.Level = zap.NewAtomicLevelAt(level)
+
+	return config.Build(zap.Fields(zap.String("service", serviceID)))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Login outcomes recorded in the audit log
+const (
+	LoginOutcomeSuccess     = "success"
+	LoginOutcomeUnknownUser = "unknown_user"
+	LoginOutcomeBadPassword = "bad_password"
+	LoginOutcomeLocked      = "locked"
+	LoginOutcomeAdminLocked = "admin_locked"
+	LoginOutcomeSuspended   = "suspended"
+	LoginOutcomeUnverified  = "unverified"
+	LoginOutcomeChallenge   = "2fa_challenge"
+	LoginOutcomeBad2FACode  = "bad_2fa_code"
+)
+
+const (
+	maxUserAgentLength       = 512
+	maxLoginIdentifierLength = 255
+)
+
+// LoginEvent is one authentication attempt. UserID is nil when the
+// identifier didn't match an account.
+type LoginEvent struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `gorm:"index" json:"created_at"`
+	UserID     *uuid.UUID `gorm:"type:uuid;index" json:"user_id,omitempty"`
+	Identifier string     `json:"identifier"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	Outcome    string     `gorm:"index;not null" json:"outcome"`
+}
+
+var loginEventSortColumns = map[string]string{
+	"created_at": "created_at",
+	"outcome":    "outcome",
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// recordLoginEvent writes an audit row; failures are logged but never block the login
+func recordLoginEvent(c *gin.Context, db *gorm.DB, user *User, identifier, outcome string) {
+	event := LoginEvent{
+		Identifier: truncate(identifier, maxLoginIdentifierLength),
+		IPAddress:  middleware.ClientIP(c),
+		UserAgent:  truncate(c.Request.UserAgent(), maxUserAgentLength),
+		Outcome:    outcome,
+	}
+	if user != nil {
+		event.UserID = &user.ID
+		if event.Identifier == "" {
+			event.Identifier = user.Email
+		}
+	}
+	if err := db.Create(&event).Error; err != nil {
+		middleware.Logger(c).Error("Failed to record login event", zap.String("outcome", outcome), zap.Error(err))
+	}
+}
+
+// isNewLoginDevice reports whether the user has signed in before, but never
+// from this IP and user agent. A first ever login is not treated as new.
+func isNewLoginDevice(c *gin.Context, db *gorm.DB, user *User) bool {
+	var total, matching int64
+	err := db.Model(&LoginEvent{}).
+		Select("COUNT(*), COUNT(*) FILTER (WHERE ip_address = ? AND user_agent = ?)",
+			middleware.ClientIP(c), truncate(c.Request.UserAgent(), maxUserAgentLength)).
+		Where("user_id = ? AND outcome = ?", user.ID, LoginOutcomeSuccess).
+		Row().Scan(&total, &matching)
+	if err != nil {
+		middleware.Logger(c).Error("Failed to check login history", zap.Error(err))
+		return false
+	}
+	return total > 0 && matching == 0
+}
+
+// listLoginEvents responds with a page of events matching scope
+func listLoginEvents(c *gin.Context, db *gorm.DB, scope func(*gorm.DB) *gorm.DB) {
+	pagination := parsePagination(c)
+	order, ok := parseSort(c, loginEventSortColumns, "created_at DESC")
+	if !ok {
+		respondError(c, http.StatusBadRequest, "INVALID_SORT", "Invalid sort field")
+		return
+	}
+
+	var total int64
+	if err := db.Model(&LoginEvent{}).Scopes(scope).Count(&total).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch login history")
+		return
+	}
+
+	events := []LoginEvent{}
+	if err := db.Scopes(scope).
+		Order(order + ", id DESC").
+		Scopes(paginate(pagination)).
+		Find(&events).Error; err != nil {
+		respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch login history")
+		return
+	}
+
+	c.JSON(http.StatusOK, newPage(events, total, pagination))
+}
+
+// GetLoginHistory returns the caller's own login events, newest first
+func GetLoginHistory(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+		listLoginEvents(c, db, func(tx *gorm.DB) *gorm.DB {
+			return tx.Where("user_id = ?", userID)
+		})
+	}
+}
+
+// AdminListLoginEvents returns login events across users, filterable by
+// ?user_id=, ?outcome= and ?ip_address=
+func AdminListLoginEvents(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.Query("user_id")
+		if userID != "" {
+			if _, err := uuid.Parse(userID); err != nil {
+				respondError(c, http.StatusBadRequest, "INVALID_FILTER", "user_id must be a UUID")
+				return
+			}
+		}
+		outcome, ip := c.Query("outcome"), c.Query("ip_address")
+		listLoginEvents(c, db, func(tx *gorm.DB) *gorm.DB {
+			if userID != "" {
+				tx = tx.Where("user_id = ?", userID)
+			}
+			if outcome != "" {
+				tx = tx.Where("outcome = ?", outcome)
+			}
+			if ip != "" {
+				tx = tx.Where("ip_address = ?", ip)
+			}
+			return tx
+		})
+	}
+}
+
+// loginEventRetention is how long login events are kept (LOGIN_EVENT_RETENTION)
+func loginEventRetention() time.Duration {
+	return getEnvDuration("LOGIN_EVENT_RETENTION", 90*24*time.Hour)
+}
+
+// startLoginEventCleanup periodically deletes events older than the retention period
+func startLoginEventCleanup(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("login-event-cleanup", defaultHeartbeatMaxAge(interval))
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("login-event-cleanup")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("login-event-cleanup")
+				if !leadership.Runs("login-event-cleanup") {
+					continue
+				}
+				result := db.Where("created_at < ?", time.Now().Add(-loginEventRetention())).Delete(&LoginEvent{})
+				if result.Error != nil {
+					zap.L().Error("Failed to clean up login events", zap.Error(result.Error))
+				} else if result.RowsAffected > 0 {
+					zap.L().Info("Removed expired login events", zap.Int64("count", result.RowsAffected))
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// lookupEndpoint is a handler that starts by loading one record, the caller's
+// account or the one named by :id
+type lookupEndpoint struct {
+	name, method, route, target, body string
+	handler                           func(db *gorm.DB) gin.HandlerFunc
+	wantCode, wantMessage             string
+}
+
+var lookupEndpoints = []lookupEndpoint{
+	{name: "get profile", method: http.MethodGet, route: "/profile", target: "/profile",
+		handler: GetProfile, wantCode: "USER_NOT_FOUND", wantMessage: "User not found"},
+	{name: "update profile", method: http.MethodPut, route: "/profile", target: "/profile", body: `{"first_name":"Ada"}`,
+		handler:  func(db *gorm.DB) gin.HandlerFunc { return UpdateProfile(db, NopEventPublisher{}) },
+		wantCode: "USER_NOT_FOUND", wantMessage: "User not found"},
+	{name: "patch profile", method: http.MethodPatch, route: "/profile", target: "/profile", body: `{"first_name":"Ada"}`,
+		handler:  func(db *gorm.DB) gin.HandlerFunc { return PatchProfile(db, NopEventPublisher{}) },
+		wantCode: "USER_NOT_FOUND", wantMessage: "User not found"},
+	{name: "get preferences", method: http.MethodGet, route: "/profile/preferences", target: "/profile/preferences",
+		handler: GetPreferences, wantCode: "USER_NOT_FOUND", wantMessage: "User not found"},
+	{name: "export", method: http.MethodGet, route: "/profile/export", target: "/profile/export",
+		handler: ExportUserData, wantCode: "USER_NOT_FOUND", wantMessage: "User not found"},
+	{name: "get address", method: http.MethodGet, route: "/addresses/:id", target: "/addresses/42",
+		handler: GetAddress, wantCode: "ADDRESS_NOT_FOUND", wantMessage: "Address not found"},
+	{name: "update address", method: http.MethodPut, route: "/addresses/:id", target: "/addresses/42", body: `{"street":"1 Main St","city":"Springfield","country":"US","postal_code":"12345"}`,
+		handler:  func(db *gorm.DB) gin.HandlerFunc { return UpdateAddress(db, NopGeocoder{}) },
+		wantCode: "ADDRESS_NOT_FOUND", wantMessage: "Address not found"},
+	{name: "delete address", method: http.MethodDelete, route: "/addresses/:id", target: "/addresses/42",
+		handler: DeleteAddress, wantCode: "ADDRESS_NOT_FOUND", wantMessage: "Address not found"},
+	{name: "set default address", method: http.MethodPut, route: "/addresses/:id/default", target: "/addresses/42/default",
+		handler: SetDefaultAddress, wantCode: "ADDRESS_NOT_FOUND", wantMessage: "Address not found"},
+	{name: "remove email", method: http.MethodDelete, route: "/profile/emails/:id", target: "/profile/emails/" + uuid.NewString(),
+		handler: RemoveEmail, wantCode: "EMAIL_NOT_FOUND", wantMessage: "Email not found"},
+}
+
+func TestLookupNotFound(t *testing.T) {
+	for _, tt := range lookupEndpoints {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.handler(emptyDB(t)), tt.route, tt.method, tt.target, tt.body, uuid.NewString())
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, http.StatusNotFound, w.Body.String())
+			}
+			if got := decodeError(t, w).Error; got.Code != tt.wantCode || got.Message != tt.wantMessage {
+				t.Errorf("error = %+v, want %s %q", got, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
+
+// TestLookupDatabaseError checks a failed lookup is reported and logged as
+// the failure it is, not as a missing record
+func TestLookupDatabaseError(t *testing.T) {
+	for _, tt := range lookupEndpoints {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := observeLogs(t)
+			db := failingDB(t, errors.New("connection reset by peer"))
+			w := serve(tt.handler(db), tt.route, tt.method, tt.target, tt.body, uuid.NewString())
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, http.StatusInternalServerError, w.Body.String())
+			}
+			if got := decodeError(t, w).Error.Code; got != "INTERNAL_ERROR" {
+				t.Errorf("code = %q, want INTERNAL_ERROR", got)
+			}
+			if logs.FilterField(zap.Error(errors.New("connection reset by peer"))).Len() == 0 {
+				t.Error("the database error was not logged")
+			}
+		})
+	}
+}
+
+// TestAdminListUsersDatabaseError covers both pagination modes of the admin
+// user list: transient failures are retried, others are logged and reported
+func TestAdminListUsersDatabaseError(t *testing.T) {
+	for _, target := range []string{"/admin/users", "/admin/users?cursor="} {
+		t.Run(target, func(t *testing.T) {
+			logs := observeLogs(t)
+			w := serve(AdminListUsers(failingDB(t, errors.New("relation does not exist"))), "/admin/users", http.MethodGet, target, "", "")
+			if w.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, http.StatusInternalServerError, w.Body.String())
+			}
+			if logs.FilterField(zap.Error(errors.New("relation does not exist"))).Len() == 0 {
+				t.Error("the database error was not logged")
+			}
+
+			failed := false
+			db, _ := newFakeDB(t, func(string, []driver.NamedValue) fakeResult {
+				if !failed {
+					failed = true
+					return fakeResult{err: io.ErrUnexpectedEOF}
+				}
+				return fakeResult{}
+			})
+			if w := serve(AdminListUsers(db), "/admin/users", http.MethodGet, target, "", ""); w.Code != http.StatusOK {
+				t.Errorf("after a transient failure: status = %d, want %d (body %s)", w.Code, http.StatusOK, w.Body.String())
+			}
+		})
+	}
+}
@@ -1,146 +1,487 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/arohanajit/user-service/config"
 	"github.com/arohanajit/user-service/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.uber.org/zap"
 
 	"github.com/gin-gonic/gin"
-	"github.com/hashicorp/consul/api"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
 )
 
-func initConsul() (*api.Client, error) {
-	config := api.DefaultConfig()
-	config.Address = os.Getenv("CONSUL_HTTP_ADDR")
-	if config.Address == "" {
-		config.Address = "http://localhost:8500"
+func main() {
+	// Load environment variables
+	envErr := godotenv.Load()
+
+	logger, err := newLogger()
+	if err != nil {
+		log.Fatal("Failed to initialize logger:", err)
 	}
-	return api.NewClient(config)
-}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
 
-func registerService(client *api.Client) error {
-	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	registration := &api.AgentServiceRegistration{
-		ID:      "user-service",
-		Name:    "user-service",
-		Port:    port,
-		Address: "user-service",
-		Check: &api.AgentServiceCheck{
-			HTTP:                           fmt.Sprintf("http://user-service:%d/health", port),
-			Interval:                       "10s",
-			Timeout:                        "1s",
-			DeregisterCriticalServiceAfter: "30s",
-		},
-		Tags: []string{"user", "api"},
-	}
-	return client.Agent().ServiceRegister(registration)
-}
+	if envErr != nil {
+		logger.Fatal("Error loading .env file", zap.Error(envErr))
+	}
+	build := currentBuild()
+	logger.Info("Starting user service",
+		zap.String("version", build.Version),
+		zap.String("commit", build.Commit),
+		zap.String("build_time", build.BuildTime),
+		zap.String("go_version", build.GoVersion))
 
-func main() {
-	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("Error loading .env file")
+	// Initialize Consul client; its address always comes from the environment
+	consulClient, err := initConsul()
+	if err != nil {
+		logger.Fatal("Failed to create Consul client", zap.Error(err))
+	}
+
+	// Background jobs are stopped when the server shuts down
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Load the overridable settings from Consul KV; environment variables
+	// remain the fallback when Consul is unreachable or a key is absent
+	kvPrefix := getEnv("CONFIG_KV_PREFIX", "config/"+serviceID)
+	if err := config.Init(consulClient, kvPrefix, consulOverridableKeys); err != nil {
+		logger.Warn("Failed to load configuration from Consul, using environment", zap.String("prefix", kvPrefix), zap.Error(err))
+	}
+	config.Watch(bgCtx)
+	if err := config.Require("DB_HOST", "DB_USER", "DB_NAME"); err != nil {
+		logger.Fatal("Invalid configuration", zap.Error(err))
+	}
+
+	// Initialize tracing before anything that may create spans
+	shutdownTracing, err := initTracing(context.Background())
+	if err != nil {
+		logger.Fatal("Failed to initialize tracing", zap.Error(err))
 	}
 
 	// Initialize database
-	db, err := initDB()
+	breaker := newCircuitBreaker()
+	db, err := initDB(breaker)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		logger.Fatal("Failed to connect to database", zap.Error(err))
 	}
 
-	// Auto migrate the schema
-	if err := db.AutoMigrate(&User{}, &Address{}); err != nil {
-		log.Fatal("Failed to migrate database:", err)
+	// Encrypted columns are unreadable without the keyring, and migrations
+	// encrypt legacy plaintext values, so it must load before anything else
+	if _, err := loadKeyring(); err != nil {
+		logger.Fatal("Invalid encryption configuration", zap.Error(err))
 	}
 
-	// Initialize Consul client
-	consulClient, err := initConsul()
-	if err != nil {
-		log.Fatal("Failed to create Consul client:", err)
+	// `user-service migrate ...` manages the schema version and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(db, os.Args[2:]); err != nil {
+			logger.Fatal("Migration failed", zap.Error(err))
+		}
+		return
 	}
 
-	// Register service with Consul
-	if err := registerService(consulClient); err != nil {
-		log.Fatal("Failed to register service:", err)
+	// Migrations run once the HTTP server is up so readiness can report
+	// progress. `user-service seed` migrates, creates the first admin and exits.
+	migrations := &migrationTracker{}
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		if err := migrations.Run(db); err != nil {
+			logger.Fatal("Failed to migrate database", zap.Error(err))
+		}
+		if err := bootstrapAdmin(db); err != nil {
+			logger.Fatal("Admin bootstrap failed", zap.Error(err))
+		}
+		return
+	}
+
+	// Without startup migrations the schema must already be current
+	if !runMigrationsEnabled() && !autoMigrateEnabled() && getEnvBool("SCHEMA_VERSION_CHECK", true) {
+		if err := checkSchemaVersion(); err != nil {
+			logger.Fatal("Database schema is not at the expected version", zap.Error(err))
+		}
+	}
+
+	// Initialize token service; refuses to start in production without a secret
+	tokenService, err := NewTokenService()
+	if err != nil {
+		logger.Fatal("Invalid JWT configuration", zap.Error(err))
 	}
 
 	// Initialize email service
-	emailService := NewEmailService()
+	emailSender, err := NewEmailSender()
+	if err != nil {
+		logger.Fatal("Invalid email configuration", zap.Error(err))
+	}
+	if err := validateEmailLinks(); err != nil {
+		logger.Fatal("Invalid email link configuration", zap.Error(err))
+	}
+	if err := validateTokenPolicies(); err != nil {
+		logger.Fatal("Invalid token configuration", zap.Error(err))
+	}
+	emailTemplates, err := LoadEmailTemplates(getEnv("EMAIL_TEMPLATE_DIR", ""))
+	if err != nil {
+		logger.Fatal("Invalid email templates", zap.Error(err))
+	}
+	emailService := NewEmailService(db, emailSender, emailTemplates)
+	registerReadinessChecks(db, migrations, breaker, consulClient, emailSender)
+
+	// Initialize SMS delivery for phone verification
+	smsSender, err := NewSMSSender()
+	if err != nil {
+		logger.Fatal("Invalid SMS configuration", zap.Error(err))
+	}
+
+	// Initialize file storage for uploads such as avatars
+	storage, err := NewStorage()
+	if err != nil {
+		logger.Fatal("Invalid storage configuration", zap.Error(err))
+	}
+
+	// CAPTCHA checks on registration and password reset, off by default
+	captcha, err := NewCaptchaVerifier()
+	if err != nil {
+		logger.Fatal("Invalid CAPTCHA configuration", zap.Error(err))
+	}
+
+	// Domain events for the message broker, off by default
+	eventPublisher, err := NewEventPublisher()
+	if err != nil {
+		logger.Fatal("Invalid event broker configuration", zap.Error(err))
+	}
+
+	// Webhook deliveries are always signed
+	if err := validateWebhookConfig(); err != nil {
+		logger.Fatal("Invalid webhook configuration", zap.Error(err))
+	}
+
+	// Initialize address geocoding, which runs in the background
+	geocoder, err := NewGeocoder()
+	if err != nil {
+		logger.Fatal("Invalid geocoder configuration", zap.Error(err))
+	}
+
+	// Reject misspelled or unexpected JSON fields instead of ignoring them
+	binding.EnableDecoderDisallowUnknownFields = true
 
 	// Initialize router
-	r := gin.Default()
+	r := gin.New()
+	// Honor X-Forwarded-For only from the listed proxies (IPs or CIDRs);
+	// with none, the client IP is the connection's remote address
+	r.RemoteIPHeaders = []string{"X-Forwarded-For"}
+	if err := r.SetTrustedProxies(getEnvList("TRUSTED_PROXIES", "")); err != nil {
+		logger.Fatal("Invalid TRUSTED_PROXIES", zap.Error(err))
+	}
+	r.Use(middleware.Recovery())
+	r.Use(otelgin.Middleware(serviceID))
+	cors := middleware.CORSConfig{
+		AllowedOrigins:   getEnvList("CORS_ALLOWED_ORIGINS", ""),
+		AllowedMethods:   getEnvList("CORS_ALLOWED_METHODS", "GET,POST,PUT,PATCH,DELETE,OPTIONS"),
+		AllowedHeaders:   getEnvList("CORS_ALLOWED_HEADERS", "Authorization,Content-Type,If-Match,Idempotency-Key,X-Request-ID"),
+		ExposedHeaders:   getEnvList("CORS_EXPOSED_HEADERS", "ETag,Location,Retry-After,X-Request-ID"),
+		AllowCredentials: getEnvBool("CORS_ALLOW_CREDENTIALS", false),
+		MaxAge:           getEnvDuration("CORS_MAX_AGE", 10*time.Minute),
+	}
+	if err := cors.Validate(); err != nil {
+		logger.Fatal("Invalid CORS configuration", zap.Error(err))
+	}
+	r.Use(middleware.CORS(cors))
+	r.Use(middleware.RequestID())
+	// Routes that accept larger bodies register their limit in mountAPI
+	bodyLimits := middleware.NewBodyLimits(int64(getEnvInt("MAX_BODY_BYTES", 1<<20)))
+	r.Use(middleware.BodyLimit(bodyLimits))
+	r.Use(middleware.RequestLogger(logger, getEnvInt("LOG_REQUEST_BODY_BYTES", 0)))
+	r.Use(middleware.MetricsMiddleware())
+	// Shed load past MAX_CONCURRENT_REQUESTS; probes and scrapes are exempt
+	if limit := getEnvInt("MAX_CONCURRENT_REQUESTS", 500); limit > 0 {
+		exempt := []string{"/metrics"}
+		for _, path := range []string{"/health", "/health/live", "/health/ready", "/version"} {
+			exempt = append(exempt, path, routePrefix()+path)
+		}
+		r.Use(middleware.ConcurrencyLimit(middleware.ConcurrencyLimitConfig{
+			Max:          limit,
+			QueueTimeout: getEnvDuration("CONCURRENCY_QUEUE_TIMEOUT", 100*time.Millisecond),
+			RetryAfter:   getEnvDuration("CONCURRENCY_RETRY_AFTER", time.Second),
+			ExemptPaths:  exempt,
+		}))
+	}
+	if threshold := getEnvDuration("SLOW_REQUEST_THRESHOLD", time.Second); threshold > 0 {
+		r.Use(middleware.SlowRequests(threshold))
+	}
+	if timeout := getEnvDuration("REQUEST_TIMEOUT", 30*time.Second); timeout > 0 {
+		r.Use(middleware.Timeout(timeout))
+	}
+	if getEnvBool("GZIP_ENABLED", true) {
+		compress, err := middleware.Gzip(middleware.GzipConfig{
+			MinBytes:         getEnvInt("GZIP_MIN_BYTES", 1024),
+			Level:            getEnvInt("GZIP_LEVEL", gzip.DefaultCompression),
+			SkipContentTypes: middleware.DefaultGzipSkipContentTypes,
+		})
+		if err != nil {
+			logger.Fatal("Invalid GZIP_LEVEL", zap.Error(err))
+		}
+		r.Use(compress)
+	}
 
-	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
-	})
+	// Prometheus scrape endpoint, intentionally outside the auth group
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	// Public routes
-	r.POST("/register", Register(db))
-	r.POST("/login", Login(db))
-	r.POST("/forgot-password", RequestPasswordReset(db, emailService))
-	r.POST("/reset-password", ResetPassword(db))
-
-	// Protected routes
-	protected := r.Group("/")
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "your-default-secret-key"
-	}
-	protected.Use(middleware.AuthMiddleware(jwtSecret))
-	{
-		// Profile management
-		protected.GET("/profile", GetProfile(db))
-		protected.PUT("/profile", UpdateProfile(db))
-		protected.PUT("/profile/change-password", ChangePassword(db)) // Changed to POST
-		protected.DELETE("/profile", DeleteAccount(db))
-
-		// Address management
-		protected.POST("/addresses", AddAddress(db))
-		protected.GET("/addresses", ListAddresses(db))
-		protected.PUT("/addresses/:id", UpdateAddress(db))
-		protected.DELETE("/addresses/:id", DeleteAddress(db))
+	// Health check endpoints; /health is kept as an alias of readiness.
+	// They stay at the root for probes and are also mounted under the prefix.
+	// They only say up or down; admins get the details from /admin/health.
+	registerHealth := func(routes gin.IRoutes) {
+		routes.GET("/health", ReadinessCheck())
+		routes.GET("/health/live", LivenessCheck())
+		routes.GET("/health/ready", ReadinessCheck())
+		routes.GET("/version", VersionInfo())
 	}
+	registerHealth(r)
+
+	// Everything else is mounted under ROUTE_PREFIX
+	prefix := routePrefix()
+	api := r.Group(prefix)
+	if prefix != "" {
+		registerHealth(api)
+	}
+
+	// API description and interactive docs
+	if docsEnabled() {
+		registerDocs(api, prefix)
+	}
+
+	// Locally stored uploads are served by the service itself
+	if local, ok := storage.(*LocalStorage); ok {
+		api.Static(localUploadsRoute, local.Dir())
+	}
+
+	// Rate limits for unauthenticated endpoints, keyed by client IP
+	rateLimitStore := middleware.NewMemoryRateLimitStore()
+	strictLimit := middleware.RateLimitMiddleware(rateLimitStore, middleware.PerMinute("auth-strict",
+		getEnvInt("RATE_LIMIT_STRICT_PER_MINUTE", 5), getEnvInt("RATE_LIMIT_STRICT_BURST", 5)))
+	defaultLimit := middleware.RateLimitMiddleware(rateLimitStore, middleware.PerMinute("auth-default",
+		getEnvInt("RATE_LIMIT_DEFAULT_PER_MINUTE", 60), getEnvInt("RATE_LIMIT_DEFAULT_BURST", 20)))
+
+	// Internal routes for other services are authenticated by shared tokens
+	if err := validateServiceTokens(); err != nil {
+		logger.Fatal("Invalid internal service token configuration", zap.Error(err))
+	}
+	if len(internalServiceTokens()) == 0 {
+		logger.Warn("INTERNAL_SERVICE_TOKENS is not set, internal endpoints will reject all requests")
+	}
+	authenticated := []gin.HandlerFunc{
+		middleware.AuthMiddleware(tokenService.secret, tokenService.issuer, &revocationStore{db: db}),
+		middleware.RequireAccount(&accountStore{db: db}),
+	}
+
+	// Feature flags gating routes; see features.go
+	features := newFeatureFlagStore()
+	features.Start(bgCtx, getEnvDuration("FEATURE_FLAG_REFRESH_INTERVAL", 5*time.Second))
+
+	// Versioned API routes; see routes.go for the routing table and policy
+	mountAPI(api, apiRoutes(routeDeps{
+		db:           db,
+		tokenService: tokenService,
+		emailService: emailService,
+		smsSender:    smsSender,
+		storage:      storage,
+		captcha:      captcha,
+		geocoder:     geocoder,
+		events:       eventPublisher,
+		// Idempotency-Key support for POSTs that create records
+		idempotency:  &idempotencyStore{db: db},
+		features:     features,
+		strictLimit:  strictLimit,
+		defaultLimit: defaultLimit,
+	}), map[routeAccess][]gin.HandlerFunc{
+		accessUser:    authenticated,
+		accessAdmin:   append(authenticated[:len(authenticated):len(authenticated)], middleware.RequireRole(RoleAdmin)),
+		accessService: {middleware.InternalAuth(internalServiceTokens)},
+	}, bodyLimits)
 
 	// Run the server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8002"
+	port := getEnv("PORT", "8002")
+	// WriteTimeout should stay above REQUEST_TIMEOUT so timed-out requests
+	// still get their 503 rather than a dropped connection
+	srv := &http.Server{
+		Addr:              "0.0.0.0:" + port,
+		Handler:           r,
+		ReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", time.Minute),
+		WriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", time.Minute),
+		IdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("Server failed", zap.Error(err))
+		}
+	}()
+
+	// Readiness reports 503 until the schema is in place
+	if runMigrationsEnabled() {
+		if err := migrations.Run(db); err != nil {
+			logger.Fatal("Failed to migrate database", zap.Error(err))
+		}
+	} else {
+		logger.Info("RUN_MIGRATIONS is off, assuming the schema is managed by `user-service migrate`")
+		migrations.Skip()
+	}
+
+	// Create the first admin account when BOOTSTRAP_ADMIN_* is configured
+	if err := bootstrapAdmin(db); err != nil && !errors.Is(err, errBootstrapNotConfigured) {
+		logger.Error("Admin bootstrap failed", zap.Error(err))
+	}
+
+	if _, err := consulCheckTypes(); err != nil {
+		logger.Fatal("Invalid CONSUL_CHECK_TYPES", zap.Error(err))
+	}
+	// Register service with Consul. Keep serving even if Consul stays
+	// unreachable; the watcher below registers once it comes back.
+	if err := registerServiceWithRetry(bgCtx, consulClient); err != nil {
+		logger.Error("Giving up on Consul registration for now", zap.Error(err))
 	}
-	r.Run("0.0.0.0:" + port)
+	startRegistrationWatcher(bgCtx, consulClient, getEnvDuration("CONSUL_REREGISTER_INTERVAL", 30*time.Second))
+	startConsulTTLCheck(bgCtx, consulClient, func(ctx context.Context) (bool, string) {
+		report := readinessChecks.Run(ctx)
+		output, _ := json.Marshal(report.Checks)
+		return report.Ready(), string(output)
+	})
+
+	// With LEADER_ELECTION_ENABLED the cleanup jobs below run only on the
+	// elected leader; the outbox dispatchers claim rows with SKIP LOCKED and
+	// run on every instance
+	if leaderElectionEnabled() {
+		leadership.Start(bgCtx, consulClient)
+	}
+	startRevokedTokenCleanup(bgCtx, db, getEnvDuration("REVOKED_TOKEN_CLEANUP_INTERVAL", time.Hour))
+	startAccountPurge(bgCtx, db, getEnvDuration("ACCOUNT_PURGE_INTERVAL", time.Hour))
+	startUnverifiedAccountCleanup(bgCtx, db, eventPublisher, getEnvDuration("UNVERIFIED_ACCOUNT_CLEANUP_INTERVAL", time.Hour))
+	startIdempotencyKeyCleanup(bgCtx, db, getEnvDuration("IDEMPOTENCY_KEY_CLEANUP_INTERVAL", time.Hour))
+	startLoginEventCleanup(bgCtx, db, getEnvDuration("LOGIN_EVENT_CLEANUP_INTERVAL", time.Hour))
+	startPasswordResetCleanup(bgCtx, db, getEnvDuration("PASSWORD_RESET_CLEANUP_INTERVAL", time.Hour))
+	webhooks := NewWebhookDispatcher(db)
+	webhooks.Start(bgCtx, getEnvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second))
+	emailService.Start(bgCtx, getEnvDuration("EMAIL_POLL_INTERVAL", 5*time.Second))
+	events := NewEventRelay(db, eventPublisher)
+	events.Start(bgCtx, getEnvDuration("EVENT_POLL_INTERVAL", time.Second))
+	geocodes := NewGeocodeDispatcher(db, geocoder)
+	geocodes.Start(bgCtx, getEnvDuration("GEOCODER_POLL_INTERVAL", 5*time.Second))
+	startOutboxMaintenance(bgCtx, db, getEnvDuration("OUTBOX_MAINTENANCE_INTERVAL", time.Minute),
+		outboxTable{kind: "webhook", model: &WebhookDelivery{}, maxAttempts: webhooks.maxAttempts},
+		outboxTable{kind: "email", model: &OutboxEmail{}, maxAttempts: emailService.maxAttempts},
+		outboxTable{kind: "event", model: &OutboxEvent{}, maxAttempts: events.maxAttempts},
+		outboxTable{kind: "geocode", model: &GeocodeJob{}, maxAttempts: geocodes.maxAttempts})
+
+	// gRPC API for other services, sharing the database and token settings
+	grpcServer, grpcHealth := newGRPCServer(db, tokenService, internalServiceTokens)
+	go func() {
+		if err := serveGRPC(grpcServer, getEnv("GRPC_PORT", "9002")); err != nil {
+			logger.Fatal("gRPC server failed", zap.Error(err))
+		}
+	}()
+
+	// Wait for interrupt signal to gracefully shut down the server
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	logger.Info("Shutting down", zap.String("signal", sig.String()))
+	stopBackground()
+
+	// Deregister first so Consul stops routing traffic to this instance
+	if err := deregisterService(consulClient); err != nil {
+		logger.Error("Failed to deregister service from Consul", zap.Error(err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second))
+	defer cancel()
+	grpcHealth.Shutdown()
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("Server forced to shutdown", zap.Error(err))
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+		logger.Error("gRPC server forced to stop")
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush traces", zap.Error(err))
+	}
+
+	// Let an email send in progress finish; the rest stay in the outbox
+	emailCtx, cancelEmail := context.WithTimeout(context.Background(), getEnvDuration("EMAIL_DRAIN_TIMEOUT", 10*time.Second))
+	defer cancelEmail()
+	if err := emailService.Shutdown(emailCtx); err != nil {
+		logger.Error("Failed to stop email dispatcher", zap.Error(err))
+	}
+	logger.Info("Server exited")
 }
 
-func initDB() (*gorm.DB, error) {
+// databaseDSN builds the connection string from DB_*; Postgres cancels any
+// statement running longer than statementTimeout, even when the client
+// context has no deadline. Zero leaves the server default.
+func databaseDSN(statementTimeout time.Duration) string {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"),
-		os.Getenv("DB_PORT"),
+		getEnv("DB_HOST", ""),
+		getEnv("DB_USER", ""),
+		getEnv("DB_PASSWORD", ""),
+		getEnv("DB_NAME", ""),
+		getEnv("DB_PORT", "5432"),
 	)
+	if statementTimeout > 0 {
+		dsn += fmt.Sprintf(" statement_timeout=%d", statementTimeout.Milliseconds())
+	}
+	return dsn
+}
+
+func initDB(breaker *circuitBreaker) (*gorm.DB, error) {
+	dsn := databaseDSN(getEnvDuration("DB_STATEMENT_TIMEOUT", 5*time.Second))
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError maps driver errors such as unique violations to gorm.ErrDuplicatedKey
+	dialector := translatingDialector{postgres.Open(dsn).(*postgres.Dialector)}
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
 	if err != nil {
 		return nil, err
 	}
 
-	// Drop existing tables
-	db.Migrator().DropTable(&Address{}, &User{})
-
-	// Enable uuid-ossp extension
-	db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\";")
+	// Record queries as spans; they nest under the request span when the
+	// request context is passed via db.WithContext
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics())); err != nil {
+		return nil, err
+	}
+	// Fail fast instead of queueing on a database that keeps dropping connections
+	if err := db.Use(breaker); err != nil {
+		return nil, err
+	}
 
-	// Auto-migrate with new schema
-	if err := db.AutoMigrate(&User{}, &Address{}); err != nil {
+	// Bound the connection pool so replicas can't exhaust the shared database
+	sqlDB, err := db.DB()
+	if err != nil {
 		return nil, err
 	}
+	sqlDB.SetMaxOpenConns(getEnvInt("DB_MAX_OPEN_CONNS", 25))
+	sqlDB.SetMaxIdleConns(getEnvInt("DB_MAX_IDLE_CONNS", 5))
+	sqlDB.SetConnMaxLifetime(getEnvDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute))
 
 	return db, nil
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// As in main: unknown JSON fields are rejected
+	binding.EnableDecoderDisallowUnknownFields = true
+	os.Exit(m.Run())
+}
+
+// serve runs one request through handler mounted at route, authenticated as
+// userID when it is non-empty
+func serve(handler gin.HandlerFunc, route, method, target, body, userID string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, route, func(c *gin.Context) {
+		if userID != "" {
+			c.Set("user_id", userID)
+		}
+		c.Next()
+	}, handler)
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// errorEnvelope is the standard error body
+type errorEnvelope struct {
+	Error struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Details json.RawMessage `json:"details"`
+	} `json:"error"`
+}
+
+// decodeError parses w's body as the standard error envelope, failing the
+// test when it isn't one
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) errorEnvelope {
+	t.Helper()
+	var envelope errorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error.Code == "" || envelope.Error.Message == "" {
+		t.Fatalf("body %s is not the standard error envelope", w.Body.String())
+	}
+	return envelope
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Business metrics used to alert on abuse patterns
+var (
+	loginsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_logins_total",
+		Help: "Total number of successful logins",
+	})
+
+	failedLoginsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_failed_logins_total",
+		Help: "Total number of rejected login attempts by reason",
+	}, []string{"reason"})
+
+	registrationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "user_registrations_total",
+		Help: "Total number of successful registrations",
+	})
+
+	passwordResetsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_password_resets_total",
+		Help: "Total number of password reset operations by stage",
+	}, []string{"stage"})
+
+	captchaVerificationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_captcha_verifications_total",
+		Help: "Total number of CAPTCHA checks by outcome",
+	}, []string{"outcome"})
+
+	emailQueueDue = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "user_email_queue_due",
+		Help: "Undelivered emails due to be sent, as of the dispatcher's last poll",
+	})
+
+	emailThrottledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_email_throttled_total",
+		Help: "Total number of email sends held back in the outbox by send limit",
+	}, []string{"limit"})
+
+	emailDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_email_dropped_total",
+		Help: "Total number of emails never sent by reason",
+	}, []string{"reason"})
+
+	outboxPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "user_outbox_pending",
+		Help: "Undelivered outbox rows that will still be retried, by kind",
+	}, []string{"kind"})
+
+	outboxFailed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "user_outbox_failed",
+		Help: "Outbox rows that exhausted their delivery attempts, by kind",
+	}, []string{"kind"})
+
+	outboxDeliveriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "user_outbox_deliveries_total",
+		Help: "Total number of outbox delivery attempts by kind and result",
+	}, []string{"kind", "result"})
+)
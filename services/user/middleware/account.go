@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Account statuses that may be told apart to the caller. Only AccountActive
+// accounts may act; deleted accounts are reported as not existing.
+const (
+	AccountActive    = "active"
+	AccountSuspended = "suspended"
+	AccountLocked    = "locked"
+)
+
+// AccountState is the account behind a token
+type AccountState struct {
+	Exists bool
+	Status string
+	// LockedUntil ends a timed admin lock
+	LockedUntil *time.Time
+}
+
+// AccountChecker reports the state of the user behind a token
+type AccountChecker interface {
+	AccountState(ctx context.Context, userID string) (AccountState, error)
+}
+
+// RequireAccount rejects tokens belonging to deleted accounts as if the user
+// did not exist, and tokens of suspended or locked accounts with an error
+// naming the status. It must run after AuthMiddleware.
+func RequireAccount(accounts AccountChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state, err := accounts.AccountState(c.Request.Context(), c.GetString("user_id"))
+		if err != nil {
+			AbortWithError(c, http.StatusInternalServerError, "ACCOUNT_CHECK_FAILED", "Failed to verify account")
+			return
+		}
+		if !state.Exists {
+			AbortWithError(c, http.StatusUnauthorized, "USER_NOT_FOUND", "User not found")
+			return
+		}
+		if state.Status != AccountActive {
+			RespondInactiveAccount(c, state)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RespondInactiveAccount refuses an account that exists but may not act. The
+// 403 says which status applies, as retrying won't help; a timed lock still
+// says when it ends.
+func RespondInactiveAccount(c *gin.Context, state AccountState) {
+	switch state.Status {
+	case AccountSuspended:
+		RespondError(c, http.StatusForbidden, "ACCOUNT_SUSPENDED", "Account suspended pending review", nil)
+	case AccountLocked:
+		if state.LockedUntil != nil {
+			RespondRetryAfter(c, http.StatusForbidden, "ACCOUNT_LOCKED", "Account locked by an administrator", time.Until(*state.LockedUntil))
+			return
+		}
+		RespondError(c, http.StatusForbidden, "ACCOUNT_LOCKED", "Account locked by an administrator", nil)
+	default:
+		RespondError(c, http.StatusForbidden, "ACCOUNT_INACTIVE", "Account is not active", nil)
+	}
+}
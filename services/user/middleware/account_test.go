@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type fixedAccount AccountState
+
+func (a fixedAccount) AccountState(ctx context.Context, userID string) (AccountState, error) {
+	return AccountState(a), nil
+}
+
+func TestRequireAccountStatusCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	until := time.Now().Add(time.Hour)
+	tests := []struct {
+		name           string
+		state          AccountState
+		wantStatus     int
+		wantCode       string
+		wantRetryAfter bool
+	}{
+		{name: "active", state: AccountState{Exists: true, Status: AccountActive}, wantStatus: http.StatusOK},
+		{name: "deleted", state: AccountState{}, wantStatus: http.StatusUnauthorized, wantCode: "USER_NOT_FOUND"},
+		{name: "suspended", state: AccountState{Exists: true, Status: AccountSuspended}, wantStatus: http.StatusForbidden, wantCode: "ACCOUNT_SUSPENDED"},
+		{name: "locked", state: AccountState{Exists: true, Status: AccountLocked}, wantStatus: http.StatusForbidden, wantCode: "ACCOUNT_LOCKED"},
+		{name: "timed lock", state: AccountState{Exists: true, Status: AccountLocked, LockedUntil: &until}, wantStatus: http.StatusForbidden, wantCode: "ACCOUNT_LOCKED", wantRetryAfter: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", RequireAccount(fixedAccount(tt.state)), func(c *gin.Context) { c.Status(http.StatusOK) })
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantCode != "" {
+				if code := decodeErrorCode(t, w.Body.Bytes()); code != tt.wantCode {
+					t.Errorf("error code = %q, want %q", code, tt.wantCode)
+				}
+			}
+			if tt.wantRetryAfter {
+				assertRetryAfter(t, w, 0)
+			}
+		})
+	}
+}
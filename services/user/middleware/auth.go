@@ -1,63 +1,179 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/golang-jwt/jwt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Missing authorization header",
-				"code":  "MISSING_AUTH",
-			})
-			return
+// RevocationChecker reports whether a token ID (jti claim) has been revoked
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+var (
+	ErrInvalidToken     = errors.New("invalid or expired token")
+	ErrInvalidClaims    = errors.New("invalid token claims")
+	ErrTokenRevoked     = errors.New("token has been revoked")
+	ErrTokenCheckFailed = errors.New("failed to verify token")
+)
+
+// AccessClaims are the validated claims of an access token
+type AccessClaims struct {
+	UserID    string
+	Role      string
+	JTI       string
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// ParseAccessToken validates an access token's signature, expiry, issuer and
+// revocation status. An empty issuer disables the issuer check.
+func ParseAccessToken(ctx context.Context, token, jwtSecret, issuer string, revocations RevocationChecker) (*AccessClaims, error) {
+	claims := jwt.MapClaims{}
+	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
+		return []byte(jwtSecret), nil
+	})
+	if err != nil || !parsedToken.Valid {
+		return nil, ErrInvalidToken
+	}
 
-		bearerToken := strings.Split(authHeader, " ")
-		if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid authorization format",
-				"code":  "INVALID_AUTH_FORMAT",
-			})
-			return
+	// MapClaims.Valid accepts tokens without exp, so require it explicitly
+	if _, ok := claims["exp"]; !ok || (issuer != "" && !claims.VerifyIssuer(issuer, true)) {
+		return nil, ErrInvalidToken
+	}
+
+	userID, ok := claims["user_id"].(string)
+	if !ok {
+		return nil, ErrInvalidClaims
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti != "" && revocations != nil {
+		revoked, err := revocations.IsRevoked(ctx, jti)
+		if err != nil {
+			return nil, ErrTokenCheckFailed
 		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	role, _ := claims["role"].(string)
+	if role == "" {
+		role = "user"
+	}
+	sessionID, _ := claims["sid"].(string)
+	result := &AccessClaims{UserID: userID, Role: role, JTI: jti, SessionID: sessionID}
+	if exp, ok := claims["exp"].(float64); ok {
+		result.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	return result, nil
+}
+
+// AccessTokenCookie is the HttpOnly cookie carrying the access token for
+// browser sessions
+const AccessTokenCookie = "access_token"
+
+// CookieAuthKey is set on the context when the access token came from the
+// AccessTokenCookie rather than the Authorization header. Such requests are
+// sent by the browser on its own, so AuthMiddleware only lets them write
+// with a JSON body, which a cross-site page cannot send without a preflight.
+const CookieAuthKey = "cookie_auth"
+
+// CookieUploadKey is set on routes that take a multipart upload, letting
+// cookie-authenticated requests to them send multipart/form-data as well.
+const CookieUploadKey = "cookie_upload"
 
-		token := bearerToken[1]
-		claims := jwt.MapClaims{}
+// AllowCookieUpload marks the route as taking uploads. It has to run before
+// AuthMiddleware.
+func AllowCookieUpload(c *gin.Context) {
+	c.Set(CookieUploadKey, true)
+	c.Next()
+}
+
+// cookieBodyAllowed reports whether a cookie-authenticated request may carry
+// its body. Forms and text/plain are sent cross-site without a preflight, so
+// any write with a body must be JSON, or multipart on upload routes.
+func cookieBodyAllowed(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if c.Request.ContentLength == 0 {
+		return true
+	}
+	switch c.ContentType() {
+	case binding.MIMEJSON:
+		return true
+	case binding.MIMEMultipartPOSTForm:
+		return c.GetBool(CookieUploadKey)
+	}
+	return false
+}
 
-		parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// AuthMiddleware validates the access JWT's signature, expiry and issuer. The
+// token comes from the Authorization bearer header or, when that is absent,
+// the AccessTokenCookie. An empty issuer disables the issuer check.
+func AuthMiddleware(jwtSecret, issuer string, revocations RevocationChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var token string
+		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			bearerToken := strings.Split(authHeader, " ")
+			if len(bearerToken) != 2 || bearerToken[0] != "Bearer" {
+				AbortWithError(c, http.StatusUnauthorized, "INVALID_AUTH_FORMAT", "Invalid authorization format")
+				return
 			}
-			return []byte(jwtSecret), nil
-		})
-
-		if err != nil || !parsedToken.Valid {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid or expired token",
-				"code":  "INVALID_TOKEN",
-			})
+			token = bearerToken[1]
+		} else if cookie, err := c.Cookie(AccessTokenCookie); err == nil && cookie != "" {
+			token = cookie
+			c.Set(CookieAuthKey, true)
+		} else {
+			AbortWithError(c, http.StatusUnauthorized, "MISSING_AUTH", "Missing authorization header")
 			return
 		}
 
-		userID, ok := claims["user_id"].(string)
-		if !ok {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid token claims",
-				"code":  "INVALID_CLAIMS",
-			})
+		claims, err := ParseAccessToken(c.Request.Context(), token, jwtSecret, issuer, revocations)
+		switch {
+		case errors.Is(err, ErrInvalidClaims):
+			AbortWithError(c, http.StatusUnauthorized, "INVALID_CLAIMS", "Invalid token claims")
+			return
+		case errors.Is(err, ErrTokenCheckFailed):
+			AbortWithError(c, http.StatusInternalServerError, "TOKEN_CHECK_FAILED", "Failed to verify token")
+			return
+		case errors.Is(err, ErrTokenRevoked):
+			AbortWithError(c, http.StatusUnauthorized, "TOKEN_REVOKED", "Token has been revoked")
+			return
+		case err != nil:
+			AbortWithError(c, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid or expired token")
+			return
+		}
+		if c.GetBool(CookieAuthKey) && !cookieBodyAllowed(c) {
+			AbortWithError(c, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", "Requests authenticated by cookie must send a JSON body")
 			return
 		}
 
-		c.Set("user_id", userID)
-		c.Set("userID", userID) // Set both formats for backward compatibility
+		c.Set("role", claims.Role)
+		c.Set("jti", claims.JTI)
+		c.Set("session_id", claims.SessionID)
+		if !claims.ExpiresAt.IsZero() {
+			c.Set("token_exp", claims.ExpiresAt)
+		}
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("enduser.id", claims.UserID))
+		c.Set("user_id", claims.UserID)
+		c.Set("userID", claims.UserID) // Set both formats for backward compatibility
 		c.Next()
 	}
 }
@@ -0,0 +1,284 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	testSecret = "test-secret"
+	testIssuer = "user-service"
+)
+
+func signTestToken(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func testClaims(mutate func(jwt.MapClaims)) jwt.MapClaims {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"jti":     "jti-1",
+		"iss":     testIssuer,
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Minute).Unix(),
+		"user_id": "7d3d0f6e-2c1b-4b8e-9a44-6f1f3a2b9c10",
+		"role":    "user",
+	}
+	if mutate != nil {
+		mutate(claims)
+	}
+	return claims
+}
+
+type revokedSet map[string]bool
+
+func (r revokedSet) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	return r[jti], nil
+}
+
+func TestParseAccessToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		claims  jwt.MapClaims
+		issuer  string
+		wantErr error
+	}{
+		{name: "valid", secret: testSecret, claims: testClaims(nil), issuer: testIssuer},
+		{
+			name:    "expired",
+			secret:  testSecret,
+			claims:  testClaims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }),
+			issuer:  testIssuer,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "missing exp",
+			secret:  testSecret,
+			claims:  testClaims(func(c jwt.MapClaims) { delete(c, "exp") }),
+			issuer:  testIssuer,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "wrong issuer",
+			secret:  testSecret,
+			claims:  testClaims(func(c jwt.MapClaims) { c["iss"] = "someone-else" }),
+			issuer:  testIssuer,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "missing issuer",
+			secret:  testSecret,
+			claims:  testClaims(func(c jwt.MapClaims) { delete(c, "iss") }),
+			issuer:  testIssuer,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:   "issuer check disabled",
+			secret: testSecret,
+			claims: testClaims(func(c jwt.MapClaims) { c["iss"] = "someone-else" }),
+		},
+		{
+			name:    "wrong secret",
+			secret:  "other-secret",
+			claims:  testClaims(nil),
+			issuer:  testIssuer,
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "missing user_id",
+			secret:  testSecret,
+			claims:  testClaims(func(c jwt.MapClaims) { delete(c, "user_id") }),
+			issuer:  testIssuer,
+			wantErr: ErrInvalidClaims,
+		},
+		{
+			name:    "revoked",
+			secret:  testSecret,
+			claims:  testClaims(func(c jwt.MapClaims) { c["jti"] = "revoked" }),
+			issuer:  testIssuer,
+			wantErr: ErrTokenRevoked,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signTestToken(t, tt.secret, tt.claims)
+			claims, err := ParseAccessToken(context.Background(), token, testSecret, tt.issuer, revokedSet{"revoked": true})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && claims.UserID != tt.claims["user_id"] {
+				t.Errorf("UserID = %q, want %q", claims.UserID, tt.claims["user_id"])
+			}
+		})
+	}
+}
+
+func TestParseAccessTokenRejectsUnsignedTokens(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims(nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	if _, err := ParseAccessToken(context.Background(), token, testSecret, testIssuer, nil); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("err = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		header   string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "valid", header: "Bearer " + signTestToken(t, testSecret, testClaims(nil)), wantCode: http.StatusOK},
+		{name: "missing", wantCode: http.StatusUnauthorized, wantErr: "MISSING_AUTH"},
+		{name: "malformed header", header: "Token abc", wantCode: http.StatusUnauthorized, wantErr: "INVALID_AUTH_FORMAT"},
+		{
+			name:     "expired",
+			header:   "Bearer " + signTestToken(t, testSecret, testClaims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() })),
+			wantCode: http.StatusUnauthorized,
+			wantErr:  "INVALID_TOKEN",
+		},
+		{
+			name:     "wrong issuer",
+			header:   "Bearer " + signTestToken(t, testSecret, testClaims(func(c jwt.MapClaims) { c["iss"] = "someone-else" })),
+			wantCode: http.StatusUnauthorized,
+			wantErr:  "INVALID_TOKEN",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", AuthMiddleware(testSecret, testIssuer, nil), func(c *gin.Context) {
+				c.String(http.StatusOK, c.GetString("user_id"))
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantErr != "" {
+				if code := decodeErrorCode(t, w.Body.Bytes()); code != tt.wantErr {
+					t.Errorf("error code = %q, want %q", code, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+// decodeErrorCode returns error.code from the standard error envelope
+func decodeErrorCode(t *testing.T, body []byte) string {
+	t.Helper()
+	var envelope struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatalf("decode error envelope %s: %v", body, err)
+	}
+	return envelope.Error.Code
+}
+
+func TestAuthMiddlewareMarksCookieAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	token := signTestToken(t, testSecret, testClaims(nil))
+	for _, viaCookie := range []bool{false, true} {
+		r := gin.New()
+		r.GET("/", AuthMiddleware(testSecret, testIssuer, nil), func(c *gin.Context) {
+			if got := c.GetBool(CookieAuthKey); got != viaCookie {
+				t.Errorf("cookie auth = %v, want %v", got, viaCookie)
+			}
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if viaCookie {
+			req.AddCookie(&http.Cookie{Name: AccessTokenCookie, Value: token})
+		} else {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestAuthMiddlewareCookieWritesNeedJSON(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	token := signTestToken(t, testSecret, testClaims(nil))
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		bearer      bool
+		upload      bool
+		wantStatus  int
+	}{
+		{name: "JSON", method: http.MethodPost, contentType: "application/json", body: "{}", wantStatus: http.StatusOK},
+		{name: "JSON with charset", method: http.MethodPut, contentType: "application/json; charset=utf-8", body: "{}", wantStatus: http.StatusOK},
+		{name: "no body", method: http.MethodPost, wantStatus: http.StatusOK},
+		{name: "GET with form", method: http.MethodGet, contentType: "application/x-www-form-urlencoded", body: "a=b", wantStatus: http.StatusOK},
+		{name: "text/plain", method: http.MethodPost, contentType: "text/plain", body: "{}", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "form", method: http.MethodPatch, contentType: "application/x-www-form-urlencoded", body: "a=b", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "no content type", method: http.MethodDelete, body: "{}", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "multipart", method: http.MethodPost, contentType: "multipart/form-data; boundary=x", body: "--x--\r\n", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "multipart upload", method: http.MethodPost, contentType: "multipart/form-data; boundary=x", body: "--x--\r\n", upload: true, wantStatus: http.StatusOK},
+		{name: "text/plain upload", method: http.MethodPost, contentType: "text/plain", body: "{}", upload: true, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "bearer text/plain", method: http.MethodPost, contentType: "text/plain", body: "{}", bearer: true, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers := []gin.HandlerFunc{AuthMiddleware(testSecret, testIssuer, nil), func(c *gin.Context) { c.Status(http.StatusOK) }}
+			if tt.upload {
+				handlers = append([]gin.HandlerFunc{AllowCookieUpload}, handlers...)
+			}
+			r := gin.New()
+			r.Handle(tt.method, "/", handlers...)
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer "+token)
+			} else {
+				req.AddCookie(&http.Cookie{Name: AccessTokenCookie, Value: token})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus == http.StatusUnsupportedMediaType {
+				if code := decodeErrorCode(t, w.Body.Bytes()); code != "UNSUPPORTED_MEDIA_TYPE" {
+					t.Errorf("error code = %q, want UNSUPPORTED_MEDIA_TYPE", code)
+				}
+			}
+		})
+	}
+}
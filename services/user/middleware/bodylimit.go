@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitedBody fails reads past limit with *http.MaxBytesError
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.read > b.limit {
+		return 0, &http.MaxBytesError{Limit: b.limit}
+	}
+	if remaining := b.limit - b.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), &http.MaxBytesError{Limit: b.limit}
+	}
+	return n, err
+}
+
+func abortTooLarge(c *gin.Context) {
+	AbortWithError(c, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "Request body is too large")
+}
+
+// BodyLimits is the default request body cap plus larger caps for specific
+// routes, e.g. uploads. Routes are added while the router is built, before
+// the server takes traffic.
+type BodyLimits struct {
+	defaultBytes int64
+	routes       map[string]int64
+}
+
+// NewBodyLimits caps every route at defaultBytes until Allow says otherwise
+func NewBodyLimits(defaultBytes int64) *BodyLimits {
+	return &BodyLimits{defaultBytes: defaultBytes, routes: map[string]int64{}}
+}
+
+// Allow sets the cap for one route, given by method and its full path
+// pattern as gin reports it (e.g. "/v1/profile/avatar")
+func (l *BodyLimits) Allow(method, fullPath string, maxBytes int64) {
+	l.routes[method+" "+fullPath] = maxBytes
+}
+
+// Limit is the cap for the route c matched
+func (l *BodyLimits) Limit(c *gin.Context) int64 {
+	if maxBytes, ok := l.routes[c.Request.Method+" "+c.FullPath()]; ok {
+		return maxBytes
+	}
+	return l.defaultBytes
+}
+
+// BodyLimit caps request bodies at the matched route's limit. Requests that
+// declare a larger Content-Length are rejected with 413 up front; others
+// fail when the handler reads past the limit.
+func BodyLimit(limits *BodyLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		maxBytes := limits.Limit(c)
+		if c.Request.ContentLength > maxBytes {
+			abortTooLarge(c)
+			return
+		}
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = &limitedBody{ReadCloser: c.Request.Body, limit: maxBytes}
+		}
+		c.Next()
+	}
+}
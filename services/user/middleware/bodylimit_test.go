@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBodyLimitPerRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limits := NewBodyLimits(10)
+	limits.Allow(http.MethodPost, "/v1/upload/:kind", 100)
+
+	r := gin.New()
+	r.Use(BodyLimit(limits))
+	read := func(c *gin.Context) {
+		var tooLarge *http.MaxBytesError
+		if _, err := io.ReadAll(c.Request.Body); errors.As(err, &tooLarge) {
+			c.String(http.StatusRequestEntityTooLarge, "read past limit")
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	r.POST("/v1/upload/:kind", read)
+	r.POST("/v1/other", read)
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		chunked  bool
+		wantCode int
+	}{
+		{name: "raised route within its limit", path: "/v1/upload/avatar", body: strings.Repeat("a", 50), wantCode: http.StatusOK},
+		{name: "raised route over its limit", path: "/v1/upload/avatar", body: strings.Repeat("a", 101), wantCode: http.StatusRequestEntityTooLarge},
+		{name: "default route over the default", path: "/v1/other", body: strings.Repeat("a", 50), wantCode: http.StatusRequestEntityTooLarge},
+		{name: "undeclared length over the default", path: "/v1/other", body: strings.Repeat("a", 50), chunked: true, wantCode: http.StatusRequestEntityTooLarge},
+		{name: "undeclared length within a raised limit", path: "/v1/upload/avatar", body: strings.Repeat("a", 50), chunked: true, wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d (body %s)", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
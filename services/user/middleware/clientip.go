@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"net"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientIP returns the caller's address. X-Forwarded-For is only honored for
+// hops covered by the engine's trusted proxies; otherwise it is RemoteAddr.
+// IPv4-mapped IPv6 addresses are reported in IPv4 form.
+func ClientIP(c *gin.Context) string {
+	ip := c.ClientIP()
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	concurrentRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_concurrent_requests",
+		Help: "Requests currently holding a concurrency limiter slot",
+	})
+
+	concurrencyLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_concurrency_limit",
+		Help: "Configured maximum of concurrent requests",
+	})
+
+	shedRequestsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "http_requests_shed_total",
+		Help: "Total number of requests rejected because the concurrency limit was reached",
+	})
+)
+
+// ConcurrencyLimitConfig configures ConcurrencyLimit
+type ConcurrencyLimitConfig struct {
+	// Max is the most requests served at once
+	Max int
+	// QueueTimeout is how long a request may wait for a slot before it is
+	// shed; zero sheds at once
+	QueueTimeout time.Duration
+	// RetryAfter is the wait suggested to shed clients
+	RetryAfter time.Duration
+	// ExemptPaths are never limited, so probes and scrapes still answer under
+	// load
+	ExemptPaths []string
+}
+
+// ConcurrencyLimit caps the requests in flight. Past the cap a request waits
+// up to QueueTimeout for a slot and is otherwise answered 503 OVERLOADED with
+// Retry-After, so a spike is turned away instead of slowing every request
+// until the instance falls over.
+func ConcurrencyLimit(config ConcurrencyLimitConfig) gin.HandlerFunc {
+	slots := make(chan struct{}, config.Max)
+	exempt := make(map[string]bool, len(config.ExemptPaths))
+	for _, path := range config.ExemptPaths {
+		exempt[path] = true
+	}
+	concurrencyLimit.Set(float64(config.Max))
+
+	return func(c *gin.Context) {
+		if exempt[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		if !acquireSlot(c, slots, config.QueueTimeout) {
+			shedRequestsTotal.Inc()
+			AbortWithRetryAfter(c, http.StatusServiceUnavailable, "OVERLOADED", "Server is overloaded, try again later", config.RetryAfter)
+			return
+		}
+		concurrentRequests.Inc()
+		defer func() {
+			concurrentRequests.Dec()
+			<-slots
+		}()
+		c.Next()
+	}
+}
+
+// acquireSlot takes a slot, waiting up to timeout or until the client goes
+// away
+func acquireSlot(c *gin.Context, slots chan struct{}, timeout time.Duration) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if timeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CORSConfig describes which cross-origin callers may use the API. An empty
+// AllowedOrigins allows no cross-origin access.
+type CORSConfig struct {
+	// AllowedOrigins holds exact origins, "*" for any, or "https://*.example.com"
+	// for any subdomain. With AllowCredentials only exact origins are valid.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// Validate rejects wildcard origins combined with AllowCredentials, which
+// would let any matching site make authenticated requests with the user's
+// cookies
+func (cfg CORSConfig) Validate() error {
+	if !cfg.AllowCredentials {
+		return nil
+	}
+	for _, allowed := range cfg.AllowedOrigins {
+		if strings.Contains(allowed, "*") {
+			return fmt.Errorf("CORS origin %q is a wildcard, which is not allowed with credentials; list each origin", allowed)
+		}
+	}
+	return nil
+}
+
+func (cfg CORSConfig) allows(origin string) bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		switch {
+		case allowed == "*":
+			return true
+		case strings.Contains(allowed, "://*."):
+			scheme, host, _ := strings.Cut(allowed, "://*.")
+			if strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) {
+				return true
+			}
+		case strings.EqualFold(allowed, origin):
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAny reports whether "*" is among the allowed origins
+func (cfg CORSConfig) allowsAny() bool {
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS answers preflight requests and adds CORS headers for allowed origins.
+// A listed or subdomain-matched origin is echoed back; under "*" the header
+// is a literal "*", so origins that were never listed are not reflected. cfg
+// must pass Validate.
+func CORS(cfg CORSConfig) gin.HandlerFunc {
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !cfg.allows(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		h := c.Writer.Header()
+		if cfg.allowsAny() {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		if exposed != "" {
+			h.Set("Access-Control-Expose-Headers", exposed)
+		}
+		c.Next()
+	}
+}
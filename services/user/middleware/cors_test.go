@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     CORSConfig
+		wantErr bool
+	}{
+		{name: "exact origins with credentials", cfg: CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true}},
+		{name: "any origin without credentials", cfg: CORSConfig{AllowedOrigins: []string{"*"}}},
+		{name: "any origin with credentials", cfg: CORSConfig{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}, wantErr: true},
+		{name: "subdomains with credentials", cfg: CORSConfig{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCORSAllowOrigin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name            string
+		cfg             CORSConfig
+		origin          string
+		wantAllowOrigin string
+		wantCredentials string
+	}{
+		{
+			name:            "listed origin is echoed with credentials",
+			cfg:             CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true},
+			origin:          "https://app.example.com",
+			wantAllowOrigin: "https://app.example.com",
+			wantCredentials: "true",
+		},
+		{
+			name:   "unlisted origin gets no headers",
+			cfg:    CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true},
+			origin: "https://evil.example",
+		},
+		{
+			name:            "any origin is not reflected",
+			cfg:             CORSConfig{AllowedOrigins: []string{"*"}},
+			origin:          "https://evil.example",
+			wantAllowOrigin: "*",
+		},
+		{
+			name:            "subdomain match",
+			cfg:             CORSConfig{AllowedOrigins: []string{"https://*.example.com"}},
+			origin:          "https://shop.example.com",
+			wantAllowOrigin: "https://shop.example.com",
+		},
+		{
+			name:   "suffix of another domain",
+			cfg:    CORSConfig{AllowedOrigins: []string{"https://*.example.com"}},
+			origin: "https://shop.example.com.evil.example",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(CORS(tt.cfg))
+			r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantAllowOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+		})
+	}
+}
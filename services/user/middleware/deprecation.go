@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIVersionHeader names the API version that served the request, so clients
+// using the unversioned paths can tell which version they are on
+const APIVersionHeader = "API-Version"
+
+// DeprecationPolicy describes an endpoint slated for removal
+type DeprecationPolicy struct {
+	// Since is when the endpoint was deprecated
+	Since time.Time
+	// Sunset is when it stops working; zero if not yet scheduled
+	Sunset time.Time
+	// Link points at migration docs or the replacement endpoint
+	Link string
+}
+
+// APIVersionKey is the context key holding the API version serving the request
+const APIVersionKey = "api_version"
+
+// APIVersion sets the API-Version response header and the APIVersionKey
+// context value
+func APIVersion(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(APIVersionKey, version)
+		c.Header(APIVersionHeader, version)
+		c.Next()
+	}
+}
+
+// Deprecation adds the Deprecation (RFC 9745) and Sunset (RFC 8594) headers,
+// plus a Link to the replacement when one is given
+func Deprecation(policy DeprecationPolicy) gin.HandlerFunc {
+	deprecation := fmt.Sprintf("@%d", policy.Since.Unix())
+	sunset := ""
+	if !policy.Sunset.IsZero() {
+		sunset = policy.Sunset.UTC().Format(http.TimeFormat)
+	}
+	link := ""
+	if policy.Link != "" {
+		link = fmt.Sprintf(`<%s>; rel="deprecation"`, policy.Link)
+	}
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecation)
+		if sunset != "" {
+			c.Header("Sunset", sunset)
+		}
+		if link != "" {
+			c.Header("Link", link)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorBody is the machine-readable part of every error response
+type ErrorBody struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ErrorResponse is the envelope for all error responses:
+// {"error": {"code": "...", "message": "...", "details": [...]}}
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// NewErrorResponse builds the envelope; details is omitted when nil
+func NewErrorResponse(code, message string, details interface{}) ErrorResponse {
+	return ErrorResponse{Error: ErrorBody{Code: code, Message: message, Details: details}}
+}
+
+// RespondError writes an error envelope without aborting the handler chain.
+// The message is localized for the request; the code never is.
+func RespondError(c *gin.Context, status int, code, message string, details interface{}) {
+	c.Header("Content-Language", Locale(c))
+	c.JSON(status, NewErrorResponse(code, Localize(c, message), details))
+}
+
+// AbortWithError writes a localized error envelope and stops the handler chain
+func AbortWithError(c *gin.Context, status int, code, message string) {
+	c.Header("Content-Language", Locale(c))
+	c.AbortWithStatusJSON(status, NewErrorResponse(code, Localize(c, message), nil))
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FeatureFlags decides whether a feature is on for a caller. userID and role
+// are empty on public routes.
+type FeatureFlags interface {
+	Enabled(name, userID, role string) bool
+	// OffStatus is the status to answer with while the feature is off for the
+	// caller: 404 to keep it dark, or 503 while it is switched off
+	OffStatus(name string) int
+}
+
+// RequireFeature rejects requests while the named feature is off for the
+// caller. It must run after authentication for role and percentage rollouts
+// to apply.
+func RequireFeature(flags FeatureFlags, name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if flags.Enabled(name, c.GetString("user_id"), c.GetString("role")) {
+			c.Next()
+			return
+		}
+		if flags.OffStatus(name) == http.StatusServiceUnavailable {
+			AbortWithError(c, http.StatusServiceUnavailable, "FEATURE_DISABLED", "This feature is temporarily unavailable")
+			return
+		}
+		AbortWithError(c, http.StatusNotFound, "NOT_FOUND", "Not found")
+	}
+}
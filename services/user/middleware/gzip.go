@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GzipConfig controls response compression
+type GzipConfig struct {
+	// MinBytes is the smallest body worth compressing; smaller ones are sent as is
+	MinBytes int
+	// Level is a compress/gzip level, from gzip.HuffmanOnly to gzip.BestCompression
+	Level int
+	// SkipContentTypes lists media type prefixes that are already compressed
+	SkipContentTypes []string
+}
+
+// DefaultGzipSkipContentTypes covers common formats that don't shrink further
+var DefaultGzipSkipContentTypes = []string{
+	"image/", "video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/pdf", "application/octet-stream",
+}
+
+// Gzip compresses responses for clients that accept gzip. The body is held
+// back until it reaches MinBytes, or the handler flushes, so small responses
+// keep an exact Content-Length and streamed ones go out as they are written.
+func Gzip(cfg GzipConfig) (gin.HandlerFunc, error) {
+	if _, err := gzip.NewWriterLevel(io.Discard, cfg.Level); err != nil {
+		return nil, fmt.Errorf("invalid gzip level %d: %w", cfg.Level, err)
+	}
+	pool := &sync.Pool{New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(io.Discard, cfg.Level)
+		return gz
+	}}
+
+	return func(c *gin.Context) {
+		// Partial content can't be compressed without breaking the byte ranges
+		if c.Request.Method == http.MethodHead || c.GetHeader("Range") != "" || !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		w := &gzipWriter{ResponseWriter: c.Writer, cfg: cfg, pool: pool}
+		c.Writer = w
+		defer func() {
+			w.finish()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}, nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipWriter buffers the start of the body until it knows whether to compress
+type gzipWriter struct {
+	gin.ResponseWriter
+	cfg  GzipConfig
+	pool *sync.Pool
+
+	buf     bytes.Buffer
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(data)
+		}
+		return w.ResponseWriter.Write(data)
+	}
+	w.buf.Write(data)
+	if w.buf.Len() >= w.cfg.MinBytes {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow sends headers immediately, which rules out compression if
+// nothing has been buffered yet
+func (w *gzipWriter) WriteHeaderNow() {
+	if !w.decided {
+		w.decide(w.buf.Len() > 0)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Flush treats the response as a stream: whatever is buffered goes out now,
+// compressed when the content allows it
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide picks compressed or plain output and writes out the buffer
+func (w *gzipWriter) decide(large bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && w.buf.Len() > 0 {
+		// Sniff the plain bytes; net/http would otherwise sniff the gzip stream
+		h.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	if large && w.compressible() {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = w.pool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+		_, err := w.gz.Write(w.buf.Bytes())
+		w.buf.Reset()
+		return err
+	}
+	if !large && w.buf.Len() > 0 {
+		h.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+func (w *gzipWriter) compressible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	switch w.Status() {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	for _, prefix := range w.cfg.SkipContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// finish sends a body that stayed under the threshold, or closes the gzip stream
+func (w *gzipWriter) finish() {
+	if !w.decided {
+		if w.buf.Len() == 0 {
+			return
+		}
+		w.decide(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+		w.gz.Reset(io.Discard)
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
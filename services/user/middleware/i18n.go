@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// supportedLocales are offered to Accept-Language negotiation; the first is
+// the fallback and needs no catalog since messages are written in English
+var supportedLocales = []language.Tag{language.English, language.Spanish, language.German}
+
+var localeMatcher = language.NewMatcher(supportedLocales)
+
+// messageCatalog translates error messages, keyed by locale and then by the
+// English message (gettext style). Missing entries fall back to English.
+var messageCatalog = map[string]map[string]string{
+	"es": {
+		"Request validation failed":                                "La validación de la solicitud falló",
+		"Validation failed":                                        "La validación falló",
+		"Malformed request body":                                   "El cuerpo de la solicitud no es válido",
+		"Request contains an unknown field":                        "La solicitud contiene un campo desconocido",
+		"Request body is too large":                                "El cuerpo de la solicitud es demasiado grande",
+		"Request timed out":                                        "La solicitud superó el tiempo de espera",
+		"failed %s validation":                                     "no superó la validación %s",
+		"unknown field":                                            "campo desconocido",
+		"Invalid request":                                          "Solicitud no válida",
+		"Invalid email address":                                    "Dirección de correo electrónico no válida",
+		"Email already registered":                                 "El correo electrónico ya está registrado",
+		"Username already taken":                                   "El nombre de usuario ya está en uso",
+		"Password does not meet requirements":                      "La contraseña no cumple los requisitos",
+		"Invalid credentials":                                      "Credenciales no válidas",
+		"User not found":                                           "Usuario no encontrado",
+		"Account locked by an administrator":                       "Cuenta bloqueada por un administrador",
+		"Account suspended pending review":                         "Cuenta suspendida pendiente de revisión",
+		"captcha_token is required":                                "Se requiere captcha_token",
+		"CAPTCHA verification failed":                              "La verificación CAPTCHA falló",
+		"CAPTCHA verification is unavailable, try again later":     "La verificación CAPTCHA no está disponible, inténtelo más tarde",
+		"Timezone must be an IANA time zone such as Europe/Berlin": "La zona horaria debe ser una zona IANA como Europe/Berlin",
+		"Locale must be a BCP 47 language tag such as en or pt-BR": "La configuración regional debe ser una etiqueta BCP 47 como en o pt-BR",
+		"Server is overloaded, try again later":                    "El servidor está sobrecargado, inténtelo más tarde",
+		"Unknown fields requested":                                 "Se solicitaron campos desconocidos",
+		"fields must name at least one field":                      "fields debe indicar al menos un campo",
+		"Account is not active":                                    "La cuenta no está activa",
+		"Account is not suspended":                                 "La cuenta no está suspendida",
+		"Account status does not allow this change":                "El estado de la cuenta no permite este cambio",
+		"Address not found":                                        "Dirección no encontrada",
+		"Session not found":                                        "Sesión no encontrada",
+		"Missing authorization header":                             "Falta la cabecera de autorización",
+		"Invalid authorization format":                             "Formato de autorización no válido",
+		"Invalid or expired token":                                 "Token no válido o caducado",
+		"Token has been revoked":                                   "El token ha sido revocado",
+		"Token has expired":                                        "El token ha caducado",
+		"Email is required with a reset code":                      "Se requiere el correo electrónico junto con el código de restablecimiento",
+		"Email is required with a verification code":               "Se requiere el correo electrónico junto con el código de verificación",
+		"Insufficient permissions":                                 "Permisos insuficientes",
+		"Rate limit exceeded":                                      "Se superó el límite de solicitudes",
+		"The resource was modified by another request":             "Otra solicitud modificó el recurso",
+		"Database error":                                           "Error de base de datos",
+		"Internal server error":                                    "Error interno del servidor",
+	},
+	"de": {
+		"Request validation failed":                                "Validierung der Anfrage fehlgeschlagen",
+		"Validation failed":                                        "Validierung fehlgeschlagen",
+		"Malformed request body":                                   "Ungültiger Anfragetext",
+		"Request contains an unknown field":                        "Die Anfrage enthält ein unbekanntes Feld",
+		"Request body is too large":                                "Der Anfragetext ist zu groß",
+		"Request timed out":                                        "Zeitüberschreitung der Anfrage",
+		"failed %s validation":                                     "Validierung %s fehlgeschlagen",
+		"unknown field":                                            "unbekanntes Feld",
+		"Invalid request":                                          "Ungültige Anfrage",
+		"Invalid email address":                                    "Ungültige E-Mail-Adresse",
+		"Email already registered":                                 "E-Mail-Adresse ist bereits registriert",
+		"Username already taken":                                   "Benutzername ist bereits vergeben",
+		"Password does not meet requirements":                      "Das Passwort erfüllt die Anforderungen nicht",
+		"Invalid credentials":                                      "Ungültige Anmeldedaten",
+		"User not found":                                           "Benutzer nicht gefunden",
+		"Account locked by an administrator":                       "Konto von einem Administrator gesperrt",
+		"Account suspended pending review":                         "Konto bis zur Prüfung gesperrt",
+		"captcha_token is required":                                "captcha_token ist erforderlich",
+		"CAPTCHA verification failed":                              "CAPTCHA-Überprüfung fehlgeschlagen",
+		"CAPTCHA verification is unavailable, try again later":     "CAPTCHA-Überprüfung ist nicht verfügbar, bitte später erneut versuchen",
+		"Timezone must be an IANA time zone such as Europe/Berlin": "Die Zeitzone muss eine IANA-Zeitzone wie Europe/Berlin sein",
+		"Locale must be a BCP 47 language tag such as en or pt-BR": "Das Gebietsschema muss ein BCP-47-Sprachtag wie en oder pt-BR sein",
+		"Server is overloaded, try again later":                    "Der Server ist überlastet, bitte später erneut versuchen",
+		"Unknown fields requested":                                 "Unbekannte Felder angefordert",
+		"fields must name at least one field":                      "fields muss mindestens ein Feld angeben",
+		"Account is not active":                                    "Das Konto ist nicht aktiv",
+		"Account is not suspended":                                 "Das Konto ist nicht suspendiert",
+		"Account status does not allow this change":                "Der Kontostatus erlaubt diese Änderung nicht",
+		"Address not found":                                        "Adresse nicht gefunden",
+		"Session not found":                                        "Sitzung nicht gefunden",
+		"Missing authorization header":                             "Authorization-Header fehlt",
+		"Invalid authorization format":                             "Ungültiges Autorisierungsformat",
+		"Invalid or expired token":                                 "Ungültiges oder abgelaufenes Token",
+		"Token has been revoked":                                   "Das Token wurde widerrufen",
+		"Token has expired":                                        "Das Token ist abgelaufen",
+		"Email is required with a reset code":                      "Mit einem Rücksetzcode ist die E-Mail-Adresse erforderlich",
+		"Email is required with a verification code":               "Mit einem Bestätigungscode ist die E-Mail-Adresse erforderlich",
+		"Insufficient permissions":                                 "Unzureichende Berechtigungen",
+		"Rate limit exceeded":                                      "Anfragelimit überschritten",
+		"The resource was modified by another request":             "Die Ressource wurde von einer anderen Anfrage geändert",
+		"Database error":                                           "Datenbankfehler",
+		"Internal server error":                                    "Interner Serverfehler",
+	},
+}
+
+// Locale returns the best supported locale for the request's Accept-Language
+func Locale(c *gin.Context) string {
+	tag, _ := language.MatchStrings(localeMatcher, c.GetHeader("Accept-Language"))
+	base, _ := tag.Base()
+	return base.String()
+}
+
+// Localize translates an English message for the request, formatting args
+// into it. Messages without a translation are returned in English.
+func Localize(c *gin.Context, message string, args ...interface{}) string {
+	if translated, ok := messageCatalog[Locale(c)][message]; ok {
+		message = translated
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
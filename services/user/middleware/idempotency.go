@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyHeader is the request header clients use to make a POST safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+var (
+	// ErrIdempotencyInProgress means another request holding the key has not finished
+	ErrIdempotencyInProgress = errors.New("idempotency key in progress")
+	// ErrIdempotencyKeyReused means the key was first used with a different request body
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+)
+
+// IdempotentResponse is a stored response replayed for repeated keys
+type IdempotentResponse struct {
+	Status      int
+	ContentType string
+	Location    string
+	Body        []byte
+}
+
+// IdempotencyStore claims keys and records the response they produced
+type IdempotencyStore interface {
+	// Begin claims key for a request with the given fingerprint. It returns
+	// the stored response if the key already completed, or nil if the caller
+	// now holds the key and should execute the request.
+	Begin(key, fingerprint string) (*IdempotentResponse, error)
+	Complete(key string, resp IdempotentResponse) error
+	Release(key string) error
+}
+
+type bufferingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bufferingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency replays the stored response when a request repeats an
+// Idempotency-Key. Keys are scoped by endpoint name and, when authenticated,
+// by user. Requests without the header pass through unchanged.
+func Idempotency(store IdempotencyStore, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			AbortWithError(c, http.StatusBadRequest, "INVALID_IDEMPOTENCY_KEY", "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortTooLarge(c)
+			return
+		}
+		if err != nil {
+			AbortWithError(c, http.StatusBadRequest, "INVALID_REQUEST", "Failed to read request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		scopedKey := scope + ":" + c.GetString("user_id") + ":" + key
+		stored, err := store.Begin(scopedKey, fingerprint)
+		switch {
+		case errors.Is(err, ErrIdempotencyInProgress):
+			AbortWithRetryAfter(c, http.StatusConflict, "IDEMPOTENCY_IN_PROGRESS", "A request with this Idempotency-Key is still being processed", time.Second)
+			return
+		case errors.Is(err, ErrIdempotencyKeyReused):
+			AbortWithError(c, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", "Idempotency-Key was already used with a different request")
+			return
+		case err != nil:
+			AbortWithError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to check idempotency key")
+			return
+		}
+		if stored != nil {
+			c.Header("Idempotent-Replayed", "true")
+			if stored.Location != "" {
+				c.Header("Location", stored.Location)
+			}
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		writer := &bufferingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// Server errors are not cached so the client can retry with the same key
+		if writer.Status() >= http.StatusInternalServerError {
+			if err := store.Release(scopedKey); err != nil {
+				Logger(c).Error("Failed to release idempotency key", zap.Error(err))
+			}
+			return
+		}
+		resp := IdempotentResponse{
+			Status:      writer.Status(),
+			ContentType: writer.Header().Get("Content-Type"),
+			Location:    writer.Header().Get("Location"),
+			Body:        writer.body.Bytes(),
+		}
+		if err := store.Complete(scopedKey, resp); err != nil {
+			Logger(c).Error("Failed to store idempotent response", zap.Error(err))
+		}
+	}
+}
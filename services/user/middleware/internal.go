@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServiceTokenHeader carries the shared token internal callers present
+const ServiceTokenHeader = "X-Service-Token"
+
+// ValidServiceToken reports whether presented matches one of the accepted
+// tokens. Every token is compared in constant time, and none is skipped on a
+// match, so timing reveals neither the token nor which one matched. An empty
+// set rejects everything.
+func ValidServiceToken(accepted []string, presented string) bool {
+	if presented == "" {
+		return false
+	}
+	valid := 0
+	for _, token := range accepted {
+		if token != "" {
+			valid |= subtle.ConstantTimeCompare([]byte(token), []byte(presented))
+		}
+	}
+	return valid == 1
+}
+
+// InternalAuth restricts a route to internal callers holding one of the
+// shared service tokens. It is separate from user auth: a user's access token
+// is never accepted here, and a service token never authenticates as a user.
+// Accepting several tokens lets one be rotated without downtime: add the new
+// token, move callers over, then drop the old one. tokens is called on every
+// request so a changed set applies without a restart.
+func InternalAuth(tokens func() []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		presented := c.GetHeader(ServiceTokenHeader)
+		if presented == "" {
+			Logger(c).Warn("Rejected internal request without service credentials")
+			AbortWithError(c, http.StatusUnauthorized, "MISSING_SERVICE_TOKEN", "Missing service credentials")
+			return
+		}
+		if !ValidServiceToken(tokens(), presented) {
+			Logger(c).Warn("Rejected internal request with an invalid service token")
+			AbortWithError(c, http.StatusUnauthorized, "INVALID_SERVICE_TOKEN", "Invalid service credentials")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+	loggerKey       = "logger"
+)
+
+// RequestID propagates the caller's X-Request-ID or generates a new one, and
+// echoes it on the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = uuid.NewString()
+		}
+		c.Set(requestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// RequestLogger attaches a request-scoped logger carrying the request ID to the
+// context and writes one structured line per completed request. It must run
+// after RequestID. With maxBodyBytes above zero, the first maxBodyBytes of a
+// failed request's JSON or form body are logged too; query and body values
+// go through the redaction helpers, so credentials never reach the log.
+func RequestLogger(base *zap.Logger, maxBodyBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		logger := base.With(zap.String("request_id", c.GetString(requestIDKey)))
+		c.Set(loggerKey, logger)
+
+		var body []byte
+		if maxBodyBytes > 0 && c.Request.Body != nil && loggableBody(c.ContentType()) {
+			body, _ = io.ReadAll(io.LimitReader(c.Request.Body, int64(maxBodyBytes)))
+			// Hand the handler the whole body: the part read here, then the rest
+			c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(body), c.Request.Body), c.Request.Body}
+		}
+
+		c.Next()
+
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("route", c.FullPath()),
+			zap.Int("status", c.Writer.Status()),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", ClientIP(c)),
+			zap.String("user_agent", c.Request.UserAgent()),
+		}
+		if userID := c.GetString("user_id"); userID != "" {
+			fields = append(fields, zap.String("user_id", userID))
+		}
+		if c.Request.URL.RawQuery != "" {
+			fields = append(fields, zap.String("query", RedactQuery(c.Request.URL.Query())))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+		if len(body) > 0 && c.Writer.Status() >= 400 {
+			fields = append(fields, zap.Any("body", RedactBody(c.ContentType(), body)))
+		}
+
+		switch status := c.Writer.Status(); {
+		case status >= 500:
+			logger.Error("request completed", fields...)
+		case status >= 400:
+			logger.Warn("request completed", fields...)
+		default:
+			logger.Info("request completed", fields...)
+		}
+	}
+}
+
+// loggableBody reports whether bodies of contentType can be redacted, and so
+// logged
+func loggableBody(contentType string) bool {
+	return contentType == "application/json" || contentType == "application/x-www-form-urlencoded"
+}
+
+// readCloser reads from one reader and closes another
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Logger returns the request-scoped logger, falling back to the global logger
+func Logger(c *gin.Context) *zap.Logger {
+	if logger, ok := c.Get(loggerKey); ok {
+		if l, ok := logger.(*zap.Logger); ok {
+			return l
+		}
+	}
+	return zap.L()
+}
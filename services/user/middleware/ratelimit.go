@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit describes a token bucket: Rate tokens are added per second up to Burst
+type RateLimit struct {
+	Name  string
+	Rate  float64
+	Burst int
+}
+
+// PerMinute builds a RateLimit allowing n requests per minute with the given burst
+func PerMinute(name string, n, burst int) RateLimit {
+	return RateLimit{Name: name, Rate: float64(n) / 60, Burst: burst}
+}
+
+// RateLimitStore keeps bucket state. Allow consumes one token for key and, when
+// the bucket is empty, reports how long until the next token is available.
+// Implementations must be safe for concurrent use.
+type RateLimitStore interface {
+	Allow(key string, limit RateLimit) (bool, time.Duration)
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// MemoryRateLimitStore is a process-local RateLimitStore
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Allow(key string, limit RateLimit) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), lastSeen: now}
+		s.buckets[key] = b
+	}
+
+	// Refill based on elapsed time since the last request
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.lastSeen).Seconds()*limit.Rate)
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if limit.Rate <= 0 {
+		return false, time.Minute
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets idle long enough to have refilled, bounding memory use
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.lastSeen) > 10*time.Minute {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// RateLimitMiddleware throttles requests per client IP using the given limit
+func RateLimitMiddleware(store RateLimitStore, limit RateLimit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, wait := store.Allow(limit.Name+":"+ClientIP(c), limit)
+		if !allowed {
+			AbortWithRetryAfter(c, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", "Rate limit exceeded", wait)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole only lets requests through when the caller's role is one of roles.
+// It must run after AuthMiddleware, which places the role in the context.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	allowed := make(map[string]struct{}, len(roles))
+	for _, role := range roles {
+		allowed[role] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		if _, ok := allowed[role]; !ok {
+			AbortWithError(c, http.StatusForbidden, "FORBIDDEN", "Insufficient permissions")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Recovery turns a handler panic into a 500 error envelope. It replaces
+// gin.Recovery, whose request dump logs cookies and would log any other
+// credential header verbatim; here headers go through RedactHeaders.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// net/http uses this panic to abort a response on purpose
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			Logger(c).Error("panic recovered",
+				zap.Any("panic", recovered),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.Any("headers", RedactHeaders(c.Request.Header)),
+				zap.ByteString("stack", debug.Stack()),
+			)
+			if !c.Writer.Written() {
+				AbortWithError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Internal server error")
+				return
+			}
+			c.Abort()
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Redacted replaces the value of every sensitive field in logs
+const Redacted = "[REDACTED]"
+
+// sensitiveFieldParts mark a field as sensitive when its name contains one,
+// ignoring case, underscores and dashes: password, refresh_token, totp_secret,
+// otp and the like. Short codes are matched by name in sensitiveFields, since
+// "code" alone would also catch postal codes.
+var sensitiveFieldParts = []string{"password", "passwd", "token", "secret", "otp", "authorization", "cookie", "apikey"}
+
+var sensitiveFields = map[string]bool{
+	"code":         true,
+	"recoverycode": true,
+	"resetcode":    true,
+}
+
+// IsSensitiveField reports whether values of the named field or header must
+// never be logged
+func IsSensitiveField(name string) bool {
+	key := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(name))
+	if sensitiveFields[key] {
+		return true
+	}
+	for _, part := range sensitiveFieldParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns a copy of v that is safe to log: structs and maps are
+// converted through their JSON form, and every sensitive field at any depth
+// is replaced with Redacted
+func Redact(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return Redacted
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return Redacted
+	}
+	return redactValue(generic)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, inner := range value {
+			if IsSensitiveField(key) {
+				value[key] = Redacted
+			} else {
+				value[key] = redactValue(inner)
+			}
+		}
+	case []interface{}:
+		for i, inner := range value {
+			value[i] = redactValue(inner)
+		}
+	}
+	return v
+}
+
+// RedactBody makes a request body safe to log. JSON and form bodies keep
+// their structure with sensitive values replaced; anything else is left out,
+// as it can't be checked.
+func RedactBody(contentType string, body []byte) interface{} {
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return Redacted
+		}
+		return RedactQuery(values)
+	case contentType == "" || strings.HasPrefix(contentType, "application/json"):
+		var generic interface{}
+		if err := json.Unmarshal(body, &generic); err != nil {
+			return Redacted
+		}
+		return redactValue(generic)
+	}
+	return Redacted
+}
+
+// RedactQuery renders query or form values with sensitive ones replaced
+func RedactQuery(values url.Values) string {
+	redacted := make(url.Values, len(values))
+	for key, vals := range values {
+		if IsSensitiveField(key) {
+			redacted[key] = []string{Redacted}
+			continue
+		}
+		redacted[key] = vals
+	}
+	return redacted.Encode()
+}
+
+// RedactHeaders returns the headers with credentials replaced
+func RedactHeaders(header http.Header) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for key, vals := range header {
+		if IsSensitiveField(key) {
+			redacted[key] = Redacted
+			continue
+		}
+		redacted[key] = strings.Join(vals, ", ")
+	}
+	return redacted
+}
+
+// RedactedField is zap.Any for values that may hold credentials, such as
+// decoded request input
+func RedactedField(key string, v interface{}) zap.Field {
+	return zap.Any(key, Redact(v))
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRedactedFieldNeverLogsCredentials(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := zap.New(core)
+
+	input := struct {
+		Email        string            `json:"email"`
+		Password     string            `json:"password"`
+		RefreshToken string            `json:"refresh_token"`
+		TOTPSecret   string            `json:"totp_secret"`
+		Code         string            `json:"code"`
+		PostalCode   string            `json:"postal_code"`
+		Nested       map[string]string `json:"nested"`
+	}{
+		Email:        "ada@example.com",
+		Password:     "pw-secret-1",
+		RefreshToken: "rt-secret-2",
+		TOTPSecret:   "totp-secret-3",
+		Code:         "123456",
+		PostalCode:   "94105",
+		Nested:       map[string]string{"otp": "otp-secret-4", "city": "Paris"},
+	}
+	logger.Info("rejected", RedactedField("input", input))
+
+	logged := fmt.Sprint(logs.All()[0].ContextMap()["input"])
+	for _, secret := range []string{"pw-secret-1", "rt-secret-2", "totp-secret-3", "123456", "otp-secret-4"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("logged %s, which contains %q", logged, secret)
+		}
+	}
+	for _, kept := range []string{"ada@example.com", "94105", "Paris"} {
+		if !strings.Contains(logged, kept) {
+			t.Errorf("logged %s, want %q kept", logged, kept)
+		}
+	}
+}
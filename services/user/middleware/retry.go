@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RetryAfterDetails is the details of an error that clients may retry; it
+// repeats the Retry-After header for clients that only read the body
+type RetryAfterDetails struct {
+	RetryAfter int `json:"retry_after"`
+}
+
+// RetryAfterSeconds rounds wait up to whole seconds, and to at least one so
+// clients never retry immediately
+func RetryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// SetRetryAfter sets the Retry-After header and returns its value
+func SetRetryAfter(c *gin.Context, wait time.Duration) RetryAfterDetails {
+	seconds := RetryAfterSeconds(wait)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	return RetryAfterDetails{RetryAfter: seconds}
+}
+
+// RespondRetryAfter writes an error that may be retried after wait, with
+// the delay in both the Retry-After header and the body. Every 429, and
+// every other status that only holds for a while, goes through here.
+func RespondRetryAfter(c *gin.Context, status int, code, message string, wait time.Duration) {
+	RespondError(c, status, code, message, SetRetryAfter(c, wait))
+}
+
+// AbortWithRetryAfter is RespondRetryAfter for middleware: it also stops the
+// handler chain
+func AbortWithRetryAfter(c *gin.Context, status int, code, message string, wait time.Duration) {
+	RespondRetryAfter(c, status, code, message, wait)
+	c.Abort()
+}
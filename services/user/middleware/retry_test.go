@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// assertRetryAfter checks that Retry-After is a whole number of seconds, at
+// least one, and repeated as retry_after in the error body
+func assertRetryAfter(t *testing.T, w *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	header := w.Header().Get("Retry-After")
+	seconds, err := strconv.Atoi(header)
+	if err != nil {
+		t.Fatalf("Retry-After = %q, want numeric seconds", header)
+	}
+	if seconds < 1 || (want > 0 && seconds != want) {
+		t.Errorf("Retry-After = %d, want %d", seconds, want)
+	}
+	var envelope struct {
+		Error struct {
+			Details RetryAfterDetails `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("decode error envelope %s: %v", w.Body.String(), err)
+	}
+	if envelope.Error.Details.RetryAfter != seconds {
+		t.Errorf("details.retry_after = %d, want it to match Retry-After %d", envelope.Error.Details.RetryAfter, seconds)
+	}
+}
+
+func TestRespondRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{wait: 0, want: 1},
+		{wait: -time.Minute, want: 1},
+		{wait: 300 * time.Millisecond, want: 1},
+		{wait: 1500 * time.Millisecond, want: 2},
+		{wait: 15 * time.Minute, want: 900},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wait.String(), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			RespondRetryAfter(c, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", "Rate limit exceeded", tt.wait)
+			if w.Code != http.StatusTooManyRequests {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+			}
+			assertRetryAfter(t, w, tt.want)
+		})
+	}
+}
+
+func TestRateLimitMiddlewareRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", RateLimitMiddleware(NewMemoryRateLimitStore(), PerMinute("test", 1, 1)), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	assertRetryAfter(t, w, 0)
+	if seconds, _ := strconv.Atoi(w.Header().Get("Retry-After")); seconds > 60 {
+		t.Errorf("Retry-After = %d, want at most a minute for one request per minute", seconds)
+	}
+}
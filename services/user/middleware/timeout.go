@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// Timeout gives each request a deadline. Handlers pass the request context
+// to the database, so their queries are cancelled once it passes. A handler
+// that then tries to respond has its response dropped in favour of a 503
+// REQUEST_TIMEOUT. Writes that committed before the deadline stay committed,
+// so clients retrying a create should send an Idempotency-Key.
+func Timeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		headers := c.Writer.Header().Clone()
+		w := &timeoutWriter{ResponseWriter: c.Writer, ctx: ctx}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if w.timedOut || (!w.Written() && errors.Is(ctx.Err(), context.DeadlineExceeded)) {
+			// Keep headers from earlier middleware (CORS, request ID) but
+			// none of the handler's, such as an ETag for the dropped body
+			for key := range w.Header() {
+				w.Header().Del(key)
+			}
+			for key, values := range headers {
+				w.Header()[key] = values
+			}
+			// The writer already recorded the handler's status; reset it
+			c.Writer.WriteHeader(http.StatusServiceUnavailable)
+			RespondError(c, http.StatusServiceUnavailable, "REQUEST_TIMEOUT", "Request timed out", nil)
+			c.Abort()
+		}
+	}
+}
+
+// timeoutWriter passes writes through until the deadline, after which it
+// discards them so Timeout can answer instead
+type timeoutWriter struct {
+	gin.ResponseWriter
+	ctx      context.Context
+	timedOut bool
+}
+
+// expired reports whether the response should be dropped; once anything has
+// gone out the handler's response has to be finished as is
+func (w *timeoutWriter) expired() bool {
+	if !w.timedOut && !w.ResponseWriter.Written() && errors.Is(w.ctx.Err(), context.DeadlineExceeded) {
+		w.timedOut = true
+	}
+	return w.timedOut
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	if w.expired() {
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	if w.expired() {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	if !w.expired() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *timeoutWriter) Flush() {
+	if !w.expired() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// SlowRequests logs a warning for every request that takes longer than
+// threshold, naming the route so slow endpoints are easy to find. It must
+// run after RequestLogger.
+func SlowRequests(threshold time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		if elapsed := time.Since(start); elapsed > threshold {
+			Logger(c).Warn("slow request",
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.String("route", c.FullPath()),
+				zap.Int("status", c.Writer.Status()),
+				zap.Duration("duration", elapsed),
+				zap.Duration("threshold", threshold),
+			)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+
+	"github.com/golang-migrate/migrate/v4"
+	pgxmigrate "github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// migrationFiles holds the versioned up/down SQL migrations
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrateLogger forwards golang-migrate progress to zap
+type migrateLogger struct{}
+
+func (migrateLogger) Printf(format string, v ...interface{}) {
+	zap.L().Info("migrate: " + fmt.Sprintf(format, v...))
+}
+
+func (migrateLogger) Verbose() bool { return false }
+
+func migrationSource() (source.Driver, error) {
+	return iofs.New(migrationFiles, "migrations")
+}
+
+// newMigrator opens a dedicated connection pool without a statement timeout,
+// since DDL on large tables can legitimately be slow. Close releases it.
+func newMigrator() (*migrate.Migrate, error) {
+	src, err := migrationSource()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := sql.Open("pgx", databaseDSN(0))
+	if err != nil {
+		return nil, err
+	}
+	driver, err := pgxmigrate.WithInstance(sqlDB, &pgxmigrate.Config{})
+	if err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "pgx5", driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+	m.Log = migrateLogger{}
+	return m, nil
+}
+
+// latestMigrationVersion is the highest version shipped with this build
+func latestMigrationVersion() (uint, error) {
+	src, err := migrationSource()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	version, err := src.First()
+	for err == nil {
+		var next uint
+		if next, err = src.Next(version); err == nil {
+			version = next
+		}
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	return version, nil
+}
+
+// applyVersionedMigrations runs every pending up migration
+func applyVersionedMigrations() error {
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	from, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return err
+	}
+	if err := m.Up(); err != nil {
+		if errors.Is(err, migrate.ErrNoChange) {
+			zap.L().Info("Schema already up to date", zap.Uint("version", from))
+			return nil
+		}
+		return err
+	}
+	to, _, err := m.Version()
+	if err != nil {
+		return err
+	}
+	zap.L().Info("Applied schema migrations", zap.Uint("from_version", from), zap.Uint("to_version", to))
+	return nil
+}
+
+// checkSchemaVersion fails when the database is dirty or behind the
+// migrations shipped with this build. A newer schema is allowed so a rollback
+// of the service doesn't require a down migration.
+func checkSchemaVersion() error {
+	expected, err := latestMigrationVersion()
+	if err != nil {
+		return err
+	}
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("no migrations applied, expected version %d; run `user-service migrate up`", expected)
+	}
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty; fix it and run `user-service migrate force`", version)
+	}
+	if version < expected {
+		return fmt.Errorf("schema at version %d, expected %d; run `user-service migrate up`", version, expected)
+	}
+	if version > expected {
+		zap.L().Warn("Schema is newer than this build", zap.Uint("version", version), zap.Uint("expected", expected))
+	}
+	return nil
+}
+
+// runMigrateCommand implements `user-service migrate up|down [n]|version|force <version>`.
+// down rolls back one migration unless n is given; up also encrypts any
+// plaintext phone numbers left from before the column was encrypted.
+func runMigrateCommand(db *gorm.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: user-service migrate up|down [n]|version|force <version>")
+	}
+	m, err := newMigrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		err = m.Steps(-steps)
+	case "force":
+		if len(args) < 2 {
+			return errors.New("usage: user-service migrate force <version>")
+		}
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = m.Force(version)
+	case "version":
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	if args[0] == "up" {
+		if err := encryptPlaintextPhoneNumbers(db); err != nil {
+			return err
+		}
+	}
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		zap.L().Info("No migrations applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	zap.L().Info("Schema version", zap.Uint("version", version), zap.Bool("dirty", dirty))
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// TestMigrationsPaired checks that versions run 1..latest without gaps and
+// that every up migration has a down
+func TestMigrationsPaired(t *testing.T) {
+	src, err := migrationSource()
+	if err != nil {
+		t.Fatalf("migrationSource: %v", err)
+	}
+	defer src.Close()
+
+	latest, err := latestMigrationVersion()
+	if err != nil {
+		t.Fatalf("latestMigrationVersion: %v", err)
+	}
+	for version := uint(1); version <= latest; version++ {
+		up, _, err := src.ReadUp(version)
+		if err != nil {
+			t.Errorf("version %d has no up migration: %v", version, err)
+			continue
+		}
+		up.Close()
+		down, _, err := src.ReadDown(version)
+		if err != nil {
+			t.Errorf("version %d has no down migration: %v", version, err)
+			continue
+		}
+		down.Close()
+	}
+	if _, _, err := src.ReadUp(latest + 1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadUp(%d) err = %v, want not exist", latest+1, err)
+	}
+}
+
+// TestDefaultAddressCheckDDL checks that the DDL AutoMigrate re-asserts is
+// migration 000015's, and leaves its data repair to the migration
+func TestDefaultAddressCheckDDL(t *testing.T) {
+	migration, err := migrationFiles.ReadFile("migrations/000015_one_default_address.up.sql")
+	if err != nil {
+		t.Fatalf("read migration: %v", err)
+	}
+	for _, statement := range strings.Split(strings.TrimSpace(defaultAddressCheckDDL), "\n\n") {
+		if !strings.Contains(string(migration), statement) {
+			t.Errorf("migration 000015 no longer contains:\n%s", statement)
+		}
+	}
+	if strings.Contains(defaultAddressCheckDDL, "UPDATE addresses") {
+		t.Error("defaultAddressCheckDDL rewrites addresses on every start")
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	migrationPending = "pending"
+	migrationRunning = "running"
+	migrationApplied = "applied"
+	migrationFailed  = "failed"
+	migrationSkipped = "skipped"
+)
+
+// runMigrationsEnabled reports whether the server migrates on startup
+// (RUN_MIGRATIONS). Off by default in production, where the schema is
+// expected to be applied by a deliberate `user-service migrate up` step.
+func runMigrationsEnabled() bool {
+	return getEnvBool("RUN_MIGRATIONS", !isProduction())
+}
+
+// autoMigrateEnabled reports whether GORM AutoMigrate replaces the versioned
+// migrations (DB_AUTO_MIGRATE), for iterating on models in local development
+func autoMigrateEnabled() bool {
+	return getEnvBool("DB_AUTO_MIGRATE", false)
+}
+
+// schemaModels lists every table, parents first. New columns and tables also
+// need a versioned migration under migrations/.
+func schemaModels() []interface{} {
+	return []interface{}{
+		&User{}, &Address{}, &RefreshToken{}, &RevokedToken{}, &RecoveryCode{}, &IdempotencyKey{},
+		&WebhookDelivery{}, &LoginEvent{}, &PasswordResetAttempt{}, &UserPreferences{}, &OutboxEmail{},
+		&UserEmail{}, &AdminAuditEvent{}, &GeocodeJob{}, &OutboxEvent{},
+	}
+}
+
+// MigrationStatus is the progress of the startup migration, as reported by
+// the readiness check
+type MigrationStatus struct {
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// migrationTracker records whether the schema is ready to serve traffic
+type migrationTracker struct {
+	mu     sync.RWMutex
+	status MigrationStatus
+}
+
+// Status returns a snapshot of the migration progress
+func (t *migrationTracker) Status() MigrationStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.status.Status == "" {
+		return MigrationStatus{Status: migrationPending}
+	}
+	return t.status
+}
+
+func (t *migrationTracker) set(status MigrationStatus) {
+	t.mu.Lock()
+	t.status = status
+	t.mu.Unlock()
+}
+
+// Ready reports whether the schema can serve traffic
+func (s MigrationStatus) Ready() bool {
+	return s.Status == migrationApplied || s.Status == migrationSkipped
+}
+
+// Skip records that the schema is managed outside the service
+func (t *migrationTracker) Skip() {
+	t.set(MigrationStatus{Status: migrationSkipped})
+}
+
+// Run migrates the schema and records the outcome
+func (t *migrationTracker) Run(db *gorm.DB) error {
+	started := time.Now()
+	t.set(MigrationStatus{Status: migrationRunning, StartedAt: &started})
+
+	err := runMigrations(db)
+	if err == nil {
+		err = encryptPlaintextPhoneNumbers(db)
+	}
+	completed := time.Now()
+	status := MigrationStatus{Status: migrationApplied, StartedAt: &started, CompletedAt: &completed}
+	if err != nil {
+		status.Status = migrationFailed
+		status.Error = err.Error()
+	}
+	t.set(status)
+	return err
+}
+
+// runMigrations applies the pending versioned migrations, or AutoMigrate when
+// DB_AUTO_MIGRATE is set. AutoMigrate runs on a single connection with the
+// statement timeout lifted, since DDL on large tables can legitimately be slow.
+func runMigrations(db *gorm.DB) error {
+	if !autoMigrateEnabled() {
+		return applyVersionedMigrations()
+	}
+	if isProduction() {
+		return errors.New("DB_AUTO_MIGRATE is not allowed in production")
+	}
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SET statement_timeout = 0").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("RESET statement_timeout")
+		return migrateSchema(conn)
+	})
+}
+
+// defaultAddressCheckDDL is the schema part of migration 000015, without its
+// one-off repair of existing rows: at most one live default address per user,
+// and at least one while the user has any. Every statement is idempotent.
+const defaultAddressCheckDDL = `
+CREATE UNIQUE INDEX IF NOT EXISTS idx_addresses_one_default ON addresses (user_id) WHERE is_default AND deleted_at IS NULL;
+
+CREATE OR REPLACE FUNCTION check_default_address() RETURNS trigger AS $$
+DECLARE
+    owner uuid;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        owner := OLD.user_id;
+    ELSE
+        owner := NEW.user_id;
+    END IF;
+    IF EXISTS (SELECT 1 FROM addresses WHERE user_id = owner AND deleted_at IS NULL)
+       AND NOT EXISTS (SELECT 1 FROM addresses WHERE user_id = owner AND deleted_at IS NULL AND is_default) THEN
+        RAISE EXCEPTION 'user % has addresses but no default address', owner USING ERRCODE = 'check_violation';
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS addresses_default_check ON addresses;
+CREATE CONSTRAINT TRIGGER addresses_default_check
+    AFTER INSERT OR UPDATE OF is_default, deleted_at OR DELETE ON addresses
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_default_address();
+`
+
+// migrateSchema drops every table first when DB_RESET is set, then runs AutoMigrate
+func migrateSchema(db *gorm.DB) error {
+	models := schemaModels()
+
+	// Drop existing tables only when explicitly requested
+	if getEnvBool("DB_RESET", false) {
+		zap.L().Warn("DB_RESET=true, dropping all tables. ALL EXISTING DATA WILL BE LOST!")
+		reversed := make([]interface{}, len(models))
+		for i, model := range models {
+			reversed[len(models)-1-i] = model
+		}
+		if err := db.Migrator().DropTable(reversed...); err != nil {
+			return err
+		}
+	}
+
+	// Enable uuid-ossp extension
+	db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\";")
+
+	changes, err := pendingSchemaChanges(db, models)
+	if err != nil {
+		return err
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		return err
+	}
+	if len(changes) > 0 {
+		zap.L().Info("Applied schema changes", zap.Strings("changes", changes))
+	} else {
+		zap.L().Info("Schema already up to date")
+	}
+
+	// TOTP secrets sealed before key IDs existed were encrypted with
+	// SECRET_ENCRYPTION_KEY; tag them so the keyring can find that key
+	tagged := db.Exec(`UPDATE users SET totp_secret = ? || totp_secret
+		WHERE totp_secret <> '' AND totp_secret NOT LIKE ?`, encryptedPrefix+legacyKeyID+":", encryptedPrefix+"%")
+	if tagged.Error != nil {
+		return tagged.Error
+	}
+	if tagged.RowsAffected > 0 {
+		zap.L().Info("Tagged legacy TOTP secrets", zap.Int64("count", tagged.RowsAffected))
+	}
+
+	// The default address check is a trigger, which AutoMigrate can't create
+	if err := db.Exec(defaultAddressCheckDDL).Error; err != nil {
+		return err
+	}
+
+	// Trigram index for admin email substring search; optional because
+	// pg_trgm may not be installable on managed databases
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm").Error; err != nil {
+		zap.L().Warn("pg_trgm unavailable, admin email search will scan", zap.Error(err))
+	} else if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING gin (email gin_trgm_ops)").Error; err != nil {
+		zap.L().Warn("Failed to create email search index", zap.Error(err))
+	}
+	return nil
+}
+
+// pendingSchemaChanges lists the tables, columns and indexes AutoMigrate is
+// about to create. Column type changes are not detected.
+func pendingSchemaChanges(db *gorm.DB, models []interface{}) ([]string, error) {
+	migrator := db.Migrator()
+	var changes []string
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		table := stmt.Schema.Table
+		if !migrator.HasTable(model) {
+			changes = append(changes, "create table "+table)
+			continue
+		}
+		for _, column := range stmt.Schema.DBNames {
+			if !migrator.HasColumn(model, column) {
+				changes = append(changes, fmt.Sprintf("add column %s.%s", table, column))
+			}
+		}
+		for name := range stmt.Schema.ParseIndexes() {
+			if !migrator.HasIndex(model, name) {
+				changes = append(changes, fmt.Sprintf("create index %s on %s", name, table))
+			}
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS user_preferences;
+DROP TABLE IF EXISTS password_reset_attempts;
+DROP TABLE IF EXISTS login_events;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS addresses;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,163 @@
+-- Baseline matching the schema AutoMigrate produced. IF NOT EXISTS lets
+-- databases created by AutoMigrate adopt versioned migrations in place.
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS users (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at timestamptz,
+    updated_at timestamptz,
+    version bigint NOT NULL DEFAULT 1,
+    deleted_at timestamptz,
+    email text NOT NULL,
+    username text,
+    password text NOT NULL,
+    first_name text,
+    last_name text,
+    phone_number text,
+    phone_verified boolean NOT NULL DEFAULT false,
+    phone_verification_code_hash text,
+    phone_verification_expires_at timestamptz,
+    phone_verification_sent_at timestamptz,
+    phone_verification_attempts bigint NOT NULL DEFAULT 0,
+    role text DEFAULT 'user',
+    date_of_birth timestamptz,
+    profile_picture text,
+    avatar_key text,
+    bio text,
+    preferred_language text DEFAULT 'en',
+    password_reset_token_hash text,
+    reset_token_expires_at timestamptz,
+    failed_login_attempts bigint NOT NULL DEFAULT 0,
+    locked_until timestamptz,
+    is_verified boolean NOT NULL DEFAULT false,
+    verification_token text,
+    verification_sent_at timestamptz,
+    pending_email text,
+    email_change_token_hash text,
+    email_change_expires_at timestamptz,
+    totp_secret text,
+    two_factor_enabled boolean NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users (created_at);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+CREATE INDEX IF NOT EXISTS idx_users_role ON users (role);
+CREATE INDEX IF NOT EXISTS idx_users_password_reset_token_hash ON users (password_reset_token_hash);
+CREATE INDEX IF NOT EXISTS idx_users_is_verified ON users (is_verified);
+CREATE INDEX IF NOT EXISTS idx_users_verification_token ON users (verification_token);
+CREATE INDEX IF NOT EXISTS idx_users_email_change_token_hash ON users (email_change_token_hash);
+
+CREATE TABLE IF NOT EXISTS addresses (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    version bigint NOT NULL DEFAULT 1,
+    street text,
+    city text,
+    state text,
+    country text,
+    postal_code text,
+    is_default boolean NOT NULL DEFAULT false,
+    user_id uuid,
+    CONSTRAINT fk_users_addresses FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_addresses_deleted_at ON addresses (deleted_at);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at timestamptz,
+    user_id uuid NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    revoked boolean NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti text PRIMARY KEY,
+    created_at timestamptz,
+    user_id uuid,
+    expires_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    user_id uuid NOT NULL,
+    code_hash text NOT NULL,
+    used_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key text PRIMARY KEY,
+    created_at timestamptz,
+    fingerprint text NOT NULL,
+    completed boolean NOT NULL DEFAULT false,
+    status bigint,
+    content_type text,
+    body bytea,
+    expires_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at timestamptz,
+    event_id uuid NOT NULL,
+    event_type text NOT NULL,
+    endpoint text NOT NULL,
+    payload bytea NOT NULL,
+    attempts bigint NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL,
+    delivered_at timestamptz,
+    last_error text
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_event_id ON webhook_deliveries (event_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_next_attempt_at ON webhook_deliveries (next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_delivered_at ON webhook_deliveries (delivered_at);
+
+CREATE TABLE IF NOT EXISTS login_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    user_id uuid,
+    identifier text,
+    ip_address text,
+    user_agent text,
+    outcome text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_login_events_created_at ON login_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_login_events_user_id ON login_events (user_id);
+CREATE INDEX IF NOT EXISTS idx_login_events_outcome ON login_events (outcome);
+
+CREATE TABLE IF NOT EXISTS password_reset_attempts (
+    id bigserial PRIMARY KEY,
+    email_hash text NOT NULL,
+    ip_address varchar(64),
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_password_reset_attempts_email_created ON password_reset_attempts (email_hash, created_at);
+
+CREATE TABLE IF NOT EXISTS user_preferences (
+    user_id uuid PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    marketing_emails boolean NOT NULL,
+    login_notifications boolean NOT NULL
+);
+
+-- Trigram index for admin email substring search; skipped when pg_trgm
+-- can't be installed, e.g. on some managed databases
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS pg_trgm;
+    CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING gin (email gin_trgm_ops);
+EXCEPTION WHEN OTHERS THEN
+    RAISE NOTICE 'pg_trgm unavailable, admin email search will scan: %', SQLERRM;
+END
+$$;
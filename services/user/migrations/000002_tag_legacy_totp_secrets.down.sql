@@ -0,0 +1,2 @@
+UPDATE users SET totp_secret = substr(totp_secret, length('enc:legacy:') + 1)
+WHERE totp_secret LIKE 'enc:legacy:%';
@@ -0,0 +1,4 @@
+-- TOTP secrets sealed before key IDs existed were encrypted with
+-- SECRET_ENCRYPTION_KEY; tag them so the keyring can find that key
+UPDATE users SET totp_secret = 'enc:legacy:' || totp_secret
+WHERE totp_secret <> '' AND totp_secret NOT LIKE 'enc:%';
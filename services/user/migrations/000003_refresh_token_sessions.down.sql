@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_refresh_tokens_session_id;
+
+ALTER TABLE refresh_tokens
+    DROP COLUMN IF EXISTS device_label,
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS ip_address,
+    DROP COLUMN IF EXISTS rotated_at,
+    DROP COLUMN IF EXISTS session_started_at,
+    DROP COLUMN IF EXISTS session_id;
@@ -0,0 +1,13 @@
+ALTER TABLE refresh_tokens
+    ADD COLUMN IF NOT EXISTS session_id uuid,
+    ADD COLUMN IF NOT EXISTS session_started_at timestamptz,
+    ADD COLUMN IF NOT EXISTS rotated_at timestamptz,
+    ADD COLUMN IF NOT EXISTS ip_address text,
+    ADD COLUMN IF NOT EXISTS user_agent text,
+    ADD COLUMN IF NOT EXISTS device_label text;
+
+-- Tokens issued before sessions existed each become their own session
+UPDATE refresh_tokens SET session_id = id, session_started_at = created_at
+WHERE session_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens (session_id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS outbox_emails (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at timestamptz,
+    dedup_key text NOT NULL,
+    recipient text NOT NULL,
+    subject text NOT NULL,
+    html_body text NOT NULL,
+    text_body text NOT NULL,
+    attempts bigint NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL,
+    delivered_at timestamptz,
+    last_error text
+);
+-- Only one undelivered copy of an identical email may be queued
+CREATE UNIQUE INDEX IF NOT EXISTS idx_outbox_emails_pending_dedup_key ON outbox_emails (dedup_key) WHERE delivered_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_emails_next_attempt_at ON outbox_emails (next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_emails_delivered_at ON outbox_emails (delivered_at);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_users_anonymized_at;
+ALTER TABLE users DROP COLUMN IF EXISTS anonymized_at;
+
+-- Give anonymized accounts a unique placeholder so the constraint can return
+UPDATE users SET email = 'anonymized-' || id || '@invalid' WHERE email IS NULL;
+ALTER TABLE users ALTER COLUMN email SET NOT NULL;
@@ -0,0 +1,4 @@
+-- Anonymized accounts keep their row but give up their email address
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS anonymized_at timestamptz;
+CREATE INDEX IF NOT EXISTS idx_users_anonymized_at ON users (anonymized_at);
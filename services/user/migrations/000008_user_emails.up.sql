@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS user_emails (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at timestamptz,
+    updated_at timestamptz,
+    user_id uuid NOT NULL,
+    email text NOT NULL,
+    is_primary boolean NOT NULL DEFAULT false,
+    verified_at timestamptz,
+    verification_token_hash text,
+    verification_sent_at timestamptz
+);
+-- An address belongs to at most one account, as primary or secondary
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_emails_email ON user_emails (email);
+CREATE INDEX IF NOT EXISTS idx_user_emails_user_id ON user_emails (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_emails_primary ON user_emails (user_id) WHERE is_primary;
+CREATE INDEX IF NOT EXISTS idx_user_emails_verification_token_hash ON user_emails (verification_token_hash);
+
+-- Every existing account gets its current address as the primary row
+INSERT INTO user_emails (created_at, updated_at, user_id, email, is_primary, verified_at)
+SELECT created_at, now(), id, email, true, CASE WHEN is_verified THEN updated_at END
+FROM users
+WHERE email IS NOT NULL
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS admin_audit_events;
+ALTER TABLE users DROP COLUMN IF EXISTS admin_lock_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS admin_locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS admin_locked_at;
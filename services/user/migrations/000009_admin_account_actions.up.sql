@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS admin_locked_at timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS admin_locked_until timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS admin_lock_reason text;
+
+CREATE TABLE IF NOT EXISTS admin_audit_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    admin_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    action text NOT NULL,
+    reason text,
+    expires_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_events_created_at ON admin_audit_events (created_at);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_events_admin_id ON admin_audit_events (admin_id);
+CREATE INDEX IF NOT EXISTS idx_admin_audit_events_user_id ON admin_audit_events (user_id);
@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'active';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspension_reason text;
+
+UPDATE users SET status = 'locked' WHERE admin_locked_at IS NOT NULL AND status = 'active';
+UPDATE users SET status = 'deleted' WHERE deleted_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_status ON users (status);
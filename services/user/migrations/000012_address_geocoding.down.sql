@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS geocode_jobs;
+ALTER TABLE addresses DROP COLUMN IF EXISTS geocoded_at;
+ALTER TABLE addresses DROP COLUMN IF EXISTS geocode_status;
+ALTER TABLE addresses DROP COLUMN IF EXISTS longitude;
+ALTER TABLE addresses DROP COLUMN IF EXISTS latitude;
@@ -0,0 +1,18 @@
+ALTER TABLE addresses ADD COLUMN IF NOT EXISTS latitude double precision;
+ALTER TABLE addresses ADD COLUMN IF NOT EXISTS longitude double precision;
+ALTER TABLE addresses ADD COLUMN IF NOT EXISTS geocode_status text;
+ALTER TABLE addresses ADD COLUMN IF NOT EXISTS geocoded_at timestamptz;
+
+CREATE TABLE IF NOT EXISTS geocode_jobs (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    created_at timestamptz,
+    address_id bigint NOT NULL,
+    address_version bigint NOT NULL,
+    attempts bigint NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL,
+    delivered_at timestamptz,
+    last_error text
+);
+CREATE INDEX IF NOT EXISTS idx_geocode_jobs_address_id ON geocode_jobs (address_id);
+CREATE INDEX IF NOT EXISTS idx_geocode_jobs_next_attempt_at ON geocode_jobs (next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_geocode_jobs_delivered_at ON geocode_jobs (delivered_at);
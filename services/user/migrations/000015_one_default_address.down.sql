@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS addresses_default_check ON addresses;
+DROP FUNCTION IF EXISTS check_default_address();
+DROP INDEX IF EXISTS idx_addresses_one_default;
@@ -0,0 +1,45 @@
+-- Repair users left with several default addresses, keeping the newest
+UPDATE addresses a SET is_default = false
+WHERE a.is_default AND a.deleted_at IS NULL AND EXISTS (
+    SELECT 1 FROM addresses b
+    WHERE b.user_id = a.user_id AND b.is_default AND b.deleted_at IS NULL
+      AND (b.created_at, b.id) > (a.created_at, a.id)
+);
+-- and those left with none, promoting their newest address
+UPDATE addresses SET is_default = true
+WHERE id IN (
+    SELECT DISTINCT ON (a.user_id) a.id FROM addresses a
+    WHERE a.deleted_at IS NULL AND NOT EXISTS (
+        SELECT 1 FROM addresses b
+        WHERE b.user_id = a.user_id AND b.is_default AND b.deleted_at IS NULL
+    )
+    ORDER BY a.user_id, a.created_at DESC, a.id DESC
+);
+
+-- At most one live default per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_addresses_one_default ON addresses (user_id) WHERE is_default AND deleted_at IS NULL;
+
+-- And at least one while the user has any live address. Checked at commit,
+-- so a transaction may move the default in several statements.
+CREATE OR REPLACE FUNCTION check_default_address() RETURNS trigger AS $$
+DECLARE
+    owner uuid;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        owner := OLD.user_id;
+    ELSE
+        owner := NEW.user_id;
+    END IF;
+    IF EXISTS (SELECT 1 FROM addresses WHERE user_id = owner AND deleted_at IS NULL)
+       AND NOT EXISTS (SELECT 1 FROM addresses WHERE user_id = owner AND deleted_at IS NULL AND is_default) THEN
+        RAISE EXCEPTION 'user % has addresses but no default address', owner USING ERRCODE = 'check_violation';
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS addresses_default_check ON addresses;
+CREATE CONSTRAINT TRIGGER addresses_default_check
+    AFTER INSERT OR UPDATE OF is_default, deleted_at OR DELETE ON addresses
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_default_address();
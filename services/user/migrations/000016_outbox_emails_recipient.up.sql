@@ -0,0 +1,2 @@
+-- Backs the per-recipient send cap, which counts recent deliveries per address
+CREATE INDEX IF NOT EXISTS idx_outbox_emails_recipient_delivered_at ON outbox_emails (recipient, delivered_at);
@@ -0,0 +1,2 @@
+-- Time step of the last accepted TOTP code, so a code cannot be replayed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_counter bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- The dropped tokens are not restored; outstanding resets have to be requested again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_reset_token text;
+CREATE INDEX IF NOT EXISTS idx_users_password_reset_token ON users (password_reset_token);
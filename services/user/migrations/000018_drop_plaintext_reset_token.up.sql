@@ -0,0 +1,12 @@
+-- Databases created by AutoMigrate before reset tokens were hashed still have
+-- the plaintext password_reset_token column. Clear any outstanding tokens
+-- before dropping it so none survive in the table, even briefly.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'password_reset_token') THEN
+        UPDATE users SET password_reset_token = NULL WHERE password_reset_token IS NOT NULL;
+    END IF;
+END $$;
+DROP INDEX IF EXISTS idx_users_password_reset_token;
+ALTER TABLE users DROP COLUMN IF EXISTS password_reset_token;
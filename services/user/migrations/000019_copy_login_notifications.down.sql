@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS login_notifications boolean NOT NULL DEFAULT true;
+UPDATE users SET login_notifications = p.login_notifications
+FROM user_preferences p WHERE p.user_id = users.id;
@@ -0,0 +1,13 @@
+-- Databases created by AutoMigrate before user_preferences existed keep each
+-- user's login alert toggle in users.login_notifications. Carry opt-outs over
+-- to user_preferences (opting in is the default there), then drop the column.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'login_notifications') THEN
+        INSERT INTO user_preferences (user_id, created_at, updated_at, marketing_emails, login_notifications)
+        SELECT id, now(), now(), false, false FROM users WHERE login_notifications = false
+        ON CONFLICT (user_id) DO NOTHING;
+    END IF;
+END $$;
+ALTER TABLE users DROP COLUMN IF EXISTS login_notifications;
@@ -0,0 +1,8 @@
+-- Queued emails cannot be rendered back into the old columns and are dropped
+DELETE FROM outbox_emails WHERE delivered_at IS NULL;
+ALTER TABLE outbox_emails ADD COLUMN IF NOT EXISTS subject text NOT NULL DEFAULT '';
+ALTER TABLE outbox_emails ADD COLUMN IF NOT EXISTS html_body text NOT NULL DEFAULT '';
+ALTER TABLE outbox_emails ADD COLUMN IF NOT EXISTS text_body text NOT NULL DEFAULT '';
+ALTER TABLE outbox_emails DROP COLUMN IF EXISTS payload;
+ALTER TABLE outbox_emails DROP COLUMN IF EXISTS locale;
+ALTER TABLE outbox_emails DROP COLUMN IF EXISTS template;
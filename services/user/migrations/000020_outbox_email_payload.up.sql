@@ -0,0 +1,13 @@
+-- Outbox emails keep the template name and its encrypted data instead of
+-- the rendered message, which carried reset and verification tokens in
+-- plaintext for the whole retention period. Rendered rows still waiting to
+-- be sent cannot be converted and are dropped; those emails have to be
+-- requested again. Delivered rows stay, minus their bodies, so the
+-- per-recipient cap keeps counting them.
+DELETE FROM outbox_emails WHERE delivered_at IS NULL;
+ALTER TABLE outbox_emails ADD COLUMN IF NOT EXISTS template text NOT NULL DEFAULT '';
+ALTER TABLE outbox_emails ADD COLUMN IF NOT EXISTS locale text NOT NULL DEFAULT '';
+ALTER TABLE outbox_emails ADD COLUMN IF NOT EXISTS payload text NOT NULL DEFAULT '';
+ALTER TABLE outbox_emails DROP COLUMN IF EXISTS subject;
+ALTER TABLE outbox_emails DROP COLUMN IF EXISTS html_body;
+ALTER TABLE outbox_emails DROP COLUMN IF EXISTS text_body;
@@ -0,0 +1,12 @@
+-- Competing unverified claims can't coexist under the old index and are
+-- dropped; the verified or earliest row for each address is kept
+DELETE FROM user_emails a
+USING user_emails b
+WHERE a.email = b.email
+  AND a.id <> b.id
+  AND a.verified_at IS NULL
+  AND NOT a.is_primary
+  AND (b.verified_at IS NOT NULL OR b.is_primary OR b.created_at < a.created_at OR (b.created_at = a.created_at AND b.id < a.id));
+DROP INDEX IF EXISTS idx_user_emails_email;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_emails_email ON user_emails (email);
+DROP INDEX IF EXISTS idx_user_emails_user_email;
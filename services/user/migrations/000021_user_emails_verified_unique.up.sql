@@ -0,0 +1,6 @@
+-- Only a verified address is unique across accounts. Unverified secondary
+-- addresses are claims that any number of accounts may hold until one of
+-- them verifies the address; an account still can't list an address twice.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_emails_user_email ON user_emails (user_id, email);
+DROP INDEX IF EXISTS idx_user_emails_email;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_emails_email ON user_emails (email) WHERE verified_at IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE users ALTER COLUMN preferred_language DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN preferred_language SET DEFAULT 'en';
+UPDATE users SET preferred_language = 'en' WHERE preferred_language = '';
@@ -0,0 +1,7 @@
+-- An unset preferred language is empty, so EffectiveLocale falls through to
+-- DEFAULT_LOCALE. Accounts were created with 'en' by default, which can't be
+-- told apart from an explicit choice; they are reset to unset, which with the
+-- default DEFAULT_LOCALE of en changes nothing.
+ALTER TABLE users ALTER COLUMN preferred_language SET DEFAULT '';
+UPDATE users SET preferred_language = '' WHERE preferred_language IS NULL OR preferred_language = 'en';
+ALTER TABLE users ALTER COLUMN preferred_language SET NOT NULL;
@@ -1,8 +1,7 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"crypto/subtle"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,39 +10,140 @@ import (
 )
 
 type User struct {
-	ID                  uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
-	CreatedAt           time.Time  `json:"created_at"`
-	UpdatedAt           time.Time  `json:"updated_at"`
-	DeletedAt           *time.Time `sql:"index" json:"-"`
-	Email               string     `gorm:"uniqueIndex;not null" json:"email"`
-	Password            string     `gorm:"not null" json:"-"`
-	FirstName           string     `json:"first_name"`
-	LastName            string     `json:"last_name"`
-	PhoneNumber         string     `json:"phone_number"`
-	Role                string     `gorm:"default:'user'" json:"role"`
-	DateOfBirth         *time.Time `json:"date_of_birth"`
-	ProfilePicture      string     `json:"profile_picture"`
-	Bio                 string     `json:"bio"`
-	PreferredLanguage   string     `gorm:"default:'en'" json:"preferred_language"`
-	Addresses           []Address  `gorm:"constraint:OnDelete:CASCADE;" json:"addresses"`
-	PasswordResetToken  string     `gorm:"index" json:"-"`
-	ResetTokenExpiresAt *time.Time `json:"-"`
+	ID        uuid.UUID      `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	CreatedAt time.Time      `gorm:"index" json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Version   int            `gorm:"default:1;not null" json:"version"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	// Email is NULL only for anonymized accounts, freeing it for reuse
+	Email                      string          `gorm:"uniqueIndex" json:"email"`
+	Username                   *string         `gorm:"uniqueIndex" json:"username,omitempty"`
+	Password                   string          `gorm:"not null" json:"-"`
+	FirstName                  string          `json:"first_name"`
+	LastName                   string          `json:"last_name"`
+	PhoneNumber                EncryptedString `json:"phone_number"`
+	PhoneVerified              bool            `gorm:"default:false;not null" json:"phone_verified"`
+	PhoneVerificationCodeHash  string          `json:"-"`
+	PhoneVerificationExpiresAt *time.Time      `json:"-"`
+	PhoneVerificationSentAt    *time.Time      `json:"-"`
+	PhoneVerificationAttempts  int             `gorm:"default:0;not null" json:"-"`
+	Role                       string          `gorm:"default:'user';index" json:"role"`
+	DateOfBirth                *time.Time      `json:"date_of_birth"`
+	ProfilePicture             string          `json:"profile_picture"`
+	AvatarKey                  string          `json:"-"`
+	Bio                        string          `json:"bio"`
+	PreferredLanguage          string          `gorm:"default:'';not null" json:"preferred_language"`
+	Timezone                   string          `gorm:"default:'';not null" json:"timezone"`
+	Locale                     string          `gorm:"default:'';not null" json:"locale"`
+	Addresses                  []Address       `gorm:"constraint:OnDelete:CASCADE;" json:"addresses"`
+	PasswordResetTokenHash     string          `gorm:"index" json:"-"`
+	ResetTokenExpiresAt        *time.Time      `json:"-"`
+	PasswordResetAttempts      int             `gorm:"default:0;not null" json:"-"`
+	FailedLoginAttempts        int             `gorm:"default:0;not null" json:"-"`
+	LockedUntil                *time.Time      `json:"-"`
+	// Status is one of the UserStatus values; see account_status.go
+	Status string `gorm:"default:'active';not null;index" json:"status"`
+	// AdminLockedAt is set while an admin has locked the account; the lock
+	// lasts until AdminLockedUntil, or until unlocked when that is nil
+	AdminLockedAt        *time.Time      `json:"-"`
+	AdminLockedUntil     *time.Time      `json:"-"`
+	AdminLockReason      string          `json:"-"`
+	SuspendedAt          *time.Time      `json:"-"`
+	SuspensionReason     string          `json:"-"`
+	IsVerified           bool            `gorm:"default:false;not null;index" json:"is_verified"`
+	VerificationToken    string          `gorm:"index" json:"-"`
+	VerificationSentAt   *time.Time      `json:"-"`
+	VerificationAttempts int             `gorm:"default:0;not null" json:"-"`
+	PendingEmail         string          `json:"pending_email,omitempty"`
+	EmailChangeTokenHash string          `gorm:"index" json:"-"`
+	EmailChangeExpiresAt *time.Time      `json:"-"`
+	TOTPSecret           EncryptedString `gorm:"column:totp_secret" json:"-"`
+	// TOTPLastCounter is the time step of the last accepted TOTP code; codes
+	// from that step or earlier are refused so each code works only once
+	TOTPLastCounter  int64      `gorm:"column:totp_last_counter;default:0;not null" json:"-"`
+	TwoFactorEnabled bool       `gorm:"default:false;not null" json:"two_factor_enabled"`
+	AnonymizedAt     *time.Time `gorm:"index" json:"-"`
+	// AddressLimit overrides ADDRESS_LIMIT_PER_USER when an admin has set it
+	AddressLimit *int `json:"address_limit,omitempty"`
 }
 
 type Address struct {
 	gorm.Model
-	Street     string    `json:"street"`
-	City       string    `json:"city"`
-	State      string    `json:"state"`
-	Country    string    `json:"country"`
-	PostalCode string    `json:"postal_code"`
-	UserID     uuid.UUID `json:"user_id"`
-	User       User      `gorm:"constraint:OnDelete:CASCADE;"`
+	Version    int    `gorm:"default:1;not null" json:"version" form:"version"`
+	Street     string `json:"street" form:"street"`
+	City       string `json:"city" form:"city"`
+	State      string `json:"state" form:"state"`
+	Country    string `json:"country" form:"country"`
+	PostalCode string `json:"postal_code" form:"postal_code"`
+	IsDefault  bool   `gorm:"default:false;not null" json:"is_default" form:"is_default"`
+	// Coordinates are filled in by the geocoder after the address is saved;
+	// GeocodeStatus is pending until then, and empty when geocoding is off
+	Latitude      *float64   `json:"latitude,omitempty" form:"-"`
+	Longitude     *float64   `json:"longitude,omitempty" form:"-"`
+	GeocodeStatus string     `json:"geocode_status,omitempty" form:"-"`
+	GeocodedAt    *time.Time `json:"geocoded_at,omitempty" form:"-"`
+	// At most one live address per user is the default; a deferred trigger
+	// (see migrations/000015) also requires one while the user has any
+	UserID uuid.UUID `gorm:"uniqueIndex:idx_addresses_one_default,where:is_default AND deleted_at IS NULL" json:"user_id" form:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE;" json:"-" form:"-"`
+}
+
+// RefreshToken is a long-lived credential used to obtain new access tokens.
+// Only the SHA-256 hash of the token is stored. Rotation issues a new token
+// in the same session, carrying the session's start time and device label.
+type RefreshToken struct {
+	ID               uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UserID           uuid.UUID  `gorm:"type:uuid;index;not null" json:"user_id"`
+	SessionID        uuid.UUID  `gorm:"type:uuid;index" json:"session_id"`
+	SessionStartedAt time.Time  `json:"session_started_at"`
+	TokenHash        string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt        time.Time  `gorm:"not null" json:"expires_at"`
+	Revoked          bool       `gorm:"default:false;not null" json:"revoked"`
+	RotatedAt        *time.Time `json:"-"`
+	IPAddress        string     `json:"ip_address"`
+	UserAgent        string     `json:"user_agent"`
+	DeviceLabel      string     `json:"device_label"`
+}
+
+// IsActive reports whether the refresh token can still be exchanged
+func (t *RefreshToken) IsActive() bool {
+	return !t.Revoked && time.Now().Before(t.ExpiresAt)
+}
+
+// RecoveryCode is a hashed single-use code that can stand in for a TOTP code
+type RecoveryCode struct {
+	ID        uint       `gorm:"primary_key" json:"-"`
+	CreatedAt time.Time  `json:"-"`
+	UserID    uuid.UUID  `gorm:"type:uuid;index;not null" json:"-"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"-"`
+}
+
+// RevokedToken records an access token (by jti) that must no longer be accepted
+type RevokedToken struct {
+	JTI       string    `gorm:"primary_key" json:"jti"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uuid.UUID `gorm:"type:uuid;index" json:"user_id"`
+	ExpiresAt time.Time `gorm:"index;not null" json:"expires_at"`
+}
+
+// bcryptCost is the work factor for new password hashes, clamped to the
+// range bcrypt accepts
+func bcryptCost() int {
+	cost := getEnvInt("BCRYPT_COST", bcrypt.DefaultCost)
+	if cost < bcrypt.MinCost {
+		return bcrypt.MinCost
+	}
+	if cost > bcrypt.MaxCost {
+		return bcrypt.MaxCost
+	}
+	return cost
 }
 
 // HashPassword hashes the user's password
 func (u *User) HashPassword() error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcryptCost())
 	if err != nil {
 		return err
 	}
@@ -51,35 +151,102 @@ func (u *User) HashPassword() error {
 	return nil
 }
 
+// PasswordNeedsRehash reports whether the stored hash was made with a lower
+// cost than the one currently configured. Lowering BCRYPT_COST leaves
+// stronger hashes alone rather than weakening them on the next login.
+func (u *User) PasswordNeedsRehash() bool {
+	cost, err := bcrypt.Cost([]byte(u.Password))
+	return err == nil && cost < bcryptCost()
+}
+
 // ComparePassword checks if the provided password matches the hash
 func (u *User) ComparePassword(password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 }
 
-// GeneratePasswordResetToken creates a new password reset token
-func (u *User) GeneratePasswordResetToken() error {
-	token := make([]byte, 32)
-	if _, err := rand.Read(token); err != nil {
-		return err
+// GeneratePasswordResetToken creates a new password reset token, replacing
+// any previous one. Only its digest is stored; the plaintext is returned for
+// the reset email.
+func (u *User) GeneratePasswordResetToken(ttl time.Duration) (string, error) {
+	policy := passwordResetTokens.Policy()
+	token, err := policy.Generate()
+	if err != nil {
+		return "", err
 	}
-	u.PasswordResetToken = base64.URLEncoding.EncodeToString(token)
-	expiresAt := time.Now().Add(15 * time.Minute)
+	u.PasswordResetTokenHash = policy.Digest(u.ID, token)
+	expiresAt := time.Now().Add(ttl)
 	u.ResetTokenExpiresAt = &expiresAt
-	return nil
+	u.PasswordResetAttempts = 0
+	return token, nil
 }
 
-// IsResetTokenValid checks if the reset token is valid and not expired
+// IsResetTokenValid checks if the reset token is valid and not expired. The
+// digests are compared in constant time.
 func (u *User) IsResetTokenValid(token string) bool {
-	if u.PasswordResetToken == "" || u.ResetTokenExpiresAt == nil {
+	if u.PasswordResetTokenHash == "" || u.ResetTokenExpiresAt == nil {
 		return false
 	}
-	return u.PasswordResetToken == token && time.Now().Before(*u.ResetTokenExpiresAt)
+	digest := passwordResetTokens.Policy().Digest(u.ID, token)
+	matches := subtle.ConstantTimeCompare([]byte(u.PasswordResetTokenHash), []byte(digest)) == 1
+	return matches && time.Now().Before(*u.ResetTokenExpiresAt)
 }
 
-// ClearResetToken clears the password reset token and expiration
-func (u *User) ClearResetToken() {
-	u.PasswordResetToken = ""
-	u.ResetTokenExpiresAt = nil
+// GenerateVerificationToken creates a new email verification token and
+// returns it for the verification email
+func (u *User) GenerateVerificationToken() (string, error) {
+	policy := verificationTokens.Policy()
+	token, err := policy.Generate()
+	if err != nil {
+		return "", err
+	}
+	u.VerificationToken = verificationTokenValue(policy, u.ID, token)
+	sentAt := time.Now()
+	u.VerificationSentAt = &sentAt
+	u.VerificationAttempts = 0
+	return token, nil
+}
+
+// verificationTokenValue is what verification_token holds for token. Link
+// tokens are stored as sent so VerifyEmail can look them up; numeric codes
+// are stored as their digest, as a million codes are quickly tried offline.
+func verificationTokenValue(policy TokenPolicy, userID uuid.UUID, token string) string {
+	if policy.Numeric() {
+		return policy.Digest(userID, token)
+	}
+	return token
+}
+
+// VerificationTokenMatches compares token with the outstanding one in
+// constant time
+func (u *User) VerificationTokenMatches(token string) bool {
+	value := verificationTokenValue(verificationTokens.Policy(), u.ID, token)
+	return u.VerificationToken != "" && subtle.ConstantTimeCompare([]byte(u.VerificationToken), []byte(value)) == 1
+}
+
+// IsVerificationTokenValid checks if the verification token matches and has not expired
+func (u *User) IsVerificationTokenValid(token string, ttl time.Duration) bool {
+	if u.VerificationSentAt == nil {
+		return false
+	}
+	return u.VerificationTokenMatches(token) && time.Now().Before(u.VerificationSentAt.Add(ttl))
+}
+
+// IsLocked reports whether the account is currently locked out
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && time.Now().Before(*u.LockedUntil)
+}
+
+// IsAdminLocked reports whether an admin lock is in force
+func (u *User) IsAdminLocked() bool {
+	return u.AdminLockedAt != nil && (u.AdminLockedUntil == nil || time.Now().Before(*u.AdminLockedUntil))
+}
+
+// LockRemaining returns how long the account stays locked
+func (u *User) LockRemaining() time.Duration {
+	if !u.IsLocked() {
+		return 0
+	}
+	return time.Until(*u.LockedUntil)
 }
 
 // GetIDString returns the string representation of the user's UUID
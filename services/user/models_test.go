@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "5")
+	tests := []struct {
+		name       string
+		storedCost int
+		want       bool
+	}{
+		{name: "weaker than configured", storedCost: 4, want: true},
+		{name: "as configured", storedCost: 5, want: false},
+		{name: "stronger than configured", storedCost: 6, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := bcrypt.GenerateFromPassword([]byte("Correct-Horse-9"), tt.storedCost)
+			if err != nil {
+				t.Fatalf("hash: %v", err)
+			}
+			user := &User{Password: string(hash)}
+			if got := user.PasswordNeedsRehash(); got != tt.want {
+				t.Errorf("PasswordNeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A value that isn't a bcrypt hash is never "upgraded"
+	if (&User{Password: "not-a-hash"}).PasswordNeedsRehash() {
+		t.Error("PasswordNeedsRehash() = true for a non-bcrypt value")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// outboxClaimLease keeps a claimed row from being picked up again while it is
+// in flight
+const outboxClaimLease = 2 * time.Minute
+
+// outboxRow is implemented by the outbox models (WebhookDelivery, OutboxEmail)
+type outboxRow interface {
+	outboxID() uuid.UUID
+}
+
+// claimOutbox locks up to limit due rows and pushes their next attempt out by
+// the lease, so concurrent replicas never send the same row at once. Rows due
+// at the same time come oldest first.
+func claimOutbox[T outboxRow](ctx context.Context, db *gorm.DB, maxAttempts, limit int) ([]T, error) {
+	var batch []T
+	err := WithTransaction(ctx, db, func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("delivered_at IS NULL AND attempts < ? AND next_attempt_at <= ?", maxAttempts, now).
+			Order("next_attempt_at ASC, created_at ASC").
+			Limit(limit).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		ids := make([]uuid.UUID, len(batch))
+		for i, row := range batch {
+			ids[i] = row.outboxID()
+		}
+		return tx.Model(new(T)).Where("id IN ?", ids).Update("next_attempt_at", now.Add(outboxClaimLease)).Error
+	})
+	return batch, err
+}
+
+// outboxLease tracks the claim on a batch. A batch can take longer to send
+// than outboxClaimLease, so the dispatcher calls keep before every send to
+// push the claim on the rows it has not reached yet.
+type outboxLease[T outboxRow] struct {
+	db *gorm.DB
+	// timeout bounds one send; the claim is renewed while less than that
+	// (plus a margin) is left
+	timeout time.Duration
+	expires time.Time
+}
+
+// newOutboxLease starts tracking a batch just returned by claimOutbox
+func newOutboxLease[T outboxRow](db *gorm.DB, timeout time.Duration) *outboxLease[T] {
+	return &outboxLease[T]{db: db, timeout: timeout, expires: time.Now().Add(outboxClaimLease)}
+}
+
+// keep renews the claim on rows if it could lapse during the next send
+func (l *outboxLease[T]) keep(rows []T) {
+	const margin = 10 * time.Second
+	if len(rows) == 0 || time.Until(l.expires) > l.timeout+margin {
+		return
+	}
+	ids := make([]uuid.UUID, len(rows))
+	for i, row := range rows {
+		ids[i] = row.outboxID()
+	}
+	lease := max(outboxClaimLease, 2*(l.timeout+margin))
+	expires := time.Now().Add(lease)
+	if err := l.db.Model(new(T)).Where("id IN ? AND delivered_at IS NULL", ids).Update("next_attempt_at", expires).Error; err != nil {
+		zap.L().Error("Failed to renew outbox claim", zap.Int("count", len(ids)), zap.Error(err))
+		return
+	}
+	l.expires = expires
+}
+
+// outboxBackoff doubles base for every failed attempt, capped at max
+func outboxBackoff(base, max time.Duration, attempts int) time.Duration {
+	backoff := base * time.Duration(1<<min(attempts-1, 16))
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// recordOutboxAttempt stores the outcome of one delivery attempt
+func recordOutboxAttempt[T outboxRow](db *gorm.DB, row T, kind string, attempts, maxAttempts int, backoff time.Duration, sendErr error) {
+	updates := map[string]interface{}{"attempts": attempts, "last_error": ""}
+	switch {
+	case sendErr == nil:
+		updates["delivered_at"] = time.Now()
+		outboxDeliveriesTotal.WithLabelValues(kind, "delivered").Inc()
+	case attempts >= maxAttempts:
+		updates["last_error"] = sendErr.Error()
+		outboxDeliveriesTotal.WithLabelValues(kind, "failed").Inc()
+	default:
+		updates["last_error"] = sendErr.Error()
+		updates["next_attempt_at"] = time.Now().Add(backoff)
+		outboxDeliveriesTotal.WithLabelValues(kind, "retry").Inc()
+	}
+	if err := db.Model(new(T)).Where("id = ?", row.outboxID()).Updates(updates).Error; err != nil {
+		zap.L().Error("Failed to record outbox delivery attempt", zap.String("kind", kind), zap.String("id", row.outboxID().String()), zap.Error(err))
+	}
+}
+
+// outboxTable is one outbox covered by cleanup and metrics
+type outboxTable struct {
+	kind        string
+	model       interface{}
+	maxAttempts int
+}
+
+// outboxRetention is how long delivered outbox rows are kept (OUTBOX_RETENTION)
+func outboxRetention() time.Duration {
+	return getEnvDuration("OUTBOX_RETENTION", 7*24*time.Hour)
+}
+
+// startOutboxMaintenance periodically deletes delivered rows older than the
+// retention period and refreshes the pending/failed gauges. Rows that ran out
+// of attempts are kept for inspection.
+func startOutboxMaintenance(ctx context.Context, db *gorm.DB, interval time.Duration, tables ...outboxTable) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("outbox-maintenance", defaultHeartbeatMaxAge(interval))
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("outbox-maintenance")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("outbox-maintenance")
+				if !leadership.Runs("outbox-maintenance") {
+					continue
+				}
+				for _, t := range tables {
+					maintainOutbox(db.WithContext(ctx), t)
+				}
+			}
+		}
+	}()
+}
+
+func maintainOutbox(db *gorm.DB, t outboxTable) {
+	result := db.Where("delivered_at < ?", time.Now().Add(-outboxRetention())).Delete(t.model)
+	if result.Error != nil {
+		zap.L().Error("Failed to clean up outbox", zap.String("kind", t.kind), zap.Error(result.Error))
+	} else if result.RowsAffected > 0 {
+		zap.L().Info("Removed delivered outbox rows", zap.String("kind", t.kind), zap.Int64("count", result.RowsAffected))
+	}
+
+	var pending, failed int64
+	err := db.Model(t.model).
+		Select("COUNT(*) FILTER (WHERE attempts < ?), COUNT(*) FILTER (WHERE attempts >= ?)", t.maxAttempts, t.maxAttempts).
+		Where("delivered_at IS NULL").
+		Row().Scan(&pending, &failed)
+	if err != nil {
+		zap.L().Error("Failed to count outbox rows", zap.String("kind", t.kind), zap.Error(err))
+		return
+	}
+	outboxPending.WithLabelValues(t.kind).Set(float64(pending))
+	outboxFailed.WithLabelValues(t.kind).Set(float64(failed))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// maxPage keeps the row offset far from overflowing
+	maxPage = 1_000_000
+)
+
+// Pagination is a validated offset page request
+type Pagination struct {
+	Page     int
+	PageSize int
+}
+
+// newPagination clamps out-of-range values instead of rejecting them: page
+// runs from 1 to maxPage and page_size falls back to the default or is capped
+func newPagination(page, pageSize int) Pagination {
+	if page < 1 {
+		page = 1
+	}
+	if page > maxPage {
+		page = maxPage
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return Pagination{Page: page, PageSize: pageSize}
+}
+
+// parsePagination reads ?page= and ?page_size=; values that aren't numbers
+// are treated as absent
+func parsePagination(c *gin.Context) Pagination {
+	return newPagination(queryInt(c, "page"), queryInt(c, "page_size"))
+}
+
+// queryInt reads an integer query parameter, 0 when absent or invalid. Atoi
+// returns the clamped value alongside its error on overflow, which would
+// otherwise slip through as a huge page.
+func queryInt(c *gin.Context, key string) int {
+	n, err := strconv.Atoi(c.Query(key))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// paginate is a query scope selecting the rows of p
+func paginate(p Pagination) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Offset((p.Page - 1) * p.PageSize).Limit(p.PageSize)
+	}
+}
+
+// Page is the response envelope shared by every offset-paginated list
+type Page[T any] struct {
+	Items    []T   `json:"items"`
+	Total    int64 `json:"total"`
+	Page     int   `json:"page"`
+	PageSize int   `json:"page_size"`
+}
+
+func newPage[T any](items []T, total int64, p Pagination) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	return Page[T]{Items: items, Total: total, Page: p.Page, PageSize: p.PageSize}
+}
+
+// parseSort turns ?sort=field or ?sort=-field into an ORDER BY clause, accepting
+// only columns in the allow-list so user input never reaches SQL directly
+func parseSort(c *gin.Context, allowed map[string]string, fallback string) (string, bool) {
+	sort := c.Query("sort")
+	if sort == "" {
+		return fallback, true
+	}
+	direction := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		direction = "DESC"
+		sort = strings.TrimPrefix(sort, "-")
+	}
+	column, ok := allowed[sort]
+	if !ok {
+		return "", false
+	}
+	return column + " " + direction, true
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor is the keyset position after the last row of a page. Rows are
+// ordered by (created_at, id), so the pair identifies a position exactly even
+// when timestamps collide.
+type pageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// encodeCursor turns a position into the opaque token returned as next_cursor
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	raw, _ := json.Marshal(pageCursor{CreatedAt: createdAt, ID: id})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor parses a ?cursor= token produced by encodeCursor
+func decodeCursor(token string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil || cursor.ID == uuid.Nil {
+		return nil, errInvalidCursor
+	}
+	return &cursor, nil
+}
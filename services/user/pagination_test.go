@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// dryRunDB builds queries with the postgres dialect without connecting
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("open dry-run db: %v", err)
+	}
+	return db
+}
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		query        string
+		wantPage     int
+		wantPageSize int
+	}{
+		{query: "", wantPage: 1, wantPageSize: defaultPageSize},
+		{query: "page=3&page_size=10", wantPage: 3, wantPageSize: 10},
+		{query: "page=0", wantPage: 1, wantPageSize: defaultPageSize},
+		{query: "page=-5", wantPage: 1, wantPageSize: defaultPageSize},
+		{query: "page=abc&page_size=xyz", wantPage: 1, wantPageSize: defaultPageSize},
+		{query: "page_size=0", wantPage: 1, wantPageSize: defaultPageSize},
+		{query: "page_size=-1", wantPage: 1, wantPageSize: defaultPageSize},
+		{query: "page_size=100", wantPage: 1, wantPageSize: maxPageSize},
+		{query: "page_size=101", wantPage: 1, wantPageSize: maxPageSize},
+		{query: "page_size=1000000", wantPage: 1, wantPageSize: maxPageSize},
+		{query: "page=99999999999999999999", wantPage: 1, wantPageSize: defaultPageSize},
+		{query: "page=9223372036854775807&page_size=100", wantPage: maxPage, wantPageSize: maxPageSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+			got := parsePagination(c)
+			if got.Page != tt.wantPage || got.PageSize != tt.wantPageSize {
+				t.Errorf("parsePagination(%q) = %+v, want page %d size %d", tt.query, got, tt.wantPage, tt.wantPageSize)
+			}
+		})
+	}
+}
+
+func TestPaginateScope(t *testing.T) {
+	tests := []struct {
+		pagination Pagination
+		wantLimit  string
+	}{
+		{pagination: newPagination(1, 20), wantLimit: "LIMIT 20"},
+		{pagination: newPagination(3, 10), wantLimit: "LIMIT 10 OFFSET 20"},
+		{pagination: newPagination(0, 500), wantLimit: "LIMIT 100"},
+	}
+
+	for _, tt := range tests {
+		sql := dryRunDB(t).ToSQL(func(tx *gorm.DB) *gorm.DB {
+			var addresses []Address
+			return tx.Scopes(paginate(tt.pagination)).Find(&addresses)
+		})
+		if !strings.HasSuffix(sql, tt.wantLimit) {
+			t.Errorf("paginate(%+v) built %q, want it to end with %q", tt.pagination, sql, tt.wantLimit)
+		}
+	}
+}
+
+func TestPageEnvelope(t *testing.T) {
+	body, err := json.Marshal(newPage[Address](nil, 0, newPagination(2, 5)))
+	if err != nil {
+		t.Fatalf("marshal page: %v", err)
+	}
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("unmarshal page: %v", err)
+	}
+	want := map[string]string{"items": "[]", "total": "0", "page": "2", "page_size": "5"}
+	if len(got) != len(want) {
+		t.Fatalf("page has keys %s, want exactly items, total, page, page_size", body)
+	}
+	for key, value := range want {
+		if string(got[key]) != value {
+			t.Errorf("%s = %s, want %s", key, got[key], value)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		sort   string
+		want   string
+		wantOK bool
+	}{
+		{sort: "", want: "created_at ASC", wantOK: true},
+		{sort: "city", want: "city ASC", wantOK: true},
+		{sort: "-updated_at", want: "updated_at DESC", wantOK: true},
+		{sort: "password", wantOK: false},
+		{sort: "city; DROP TABLE users", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+		c.Request.URL.RawQuery = "sort=" + url.QueryEscape(tt.sort)
+		got, ok := parseSort(c, addressSortColumns, "created_at ASC")
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("parseSort(%q) = %q, %v; want %q, %v", tt.sort, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	id := uuid.New()
+
+	cursor, err := decodeCursor(encodeCursor(createdAt, id))
+	if err != nil {
+		t.Fatalf("decodeCursor: %v", err)
+	}
+	if !cursor.CreatedAt.Equal(createdAt) || cursor.ID != id {
+		t.Errorf("cursor = %+v, want %v %v", cursor, createdAt, id)
+	}
+
+	for _, token := range []string{"", "not base64!", "e30", encodeCursor(createdAt, uuid.Nil)} {
+		if _, err := decodeCursor(token); err != errInvalidCursor {
+			t.Errorf("decodeCursor(%q) err = %v, want %v", token, err, errInvalidCursor)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PasswordPolicy describes the strength rules a new password must satisfy
+type PasswordPolicy struct {
+	MinLength     int
+	RequireLetter bool
+	RequireDigit  bool
+	RequireUpper  bool
+	RequireSymbol bool
+}
+
+// currentPasswordPolicy builds the policy from the environment
+func currentPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:     getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		RequireLetter: getEnvBool("PASSWORD_REQUIRE_LETTER", true),
+		RequireDigit:  getEnvBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireUpper:  getEnvBool("PASSWORD_REQUIRE_UPPER", false),
+		RequireSymbol: getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+	}
+}
+
+// Validate returns a description of every rule the password fails
+func (p PasswordPolicy) Validate(password string) []string {
+	var hasLetter, hasDigit, hasUpper, hasSymbol bool
+	length := 0
+	for _, r := range password {
+		length++
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+			if unicode.IsUpper(r) {
+				hasUpper = true
+			}
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+
+	var failures []string
+	if length < p.MinLength {
+		failures = append(failures, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.RequireLetter && !hasLetter {
+		failures = append(failures, "must contain at least one letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		failures = append(failures, "must contain at least one digit")
+	}
+	if p.RequireUpper && !hasUpper {
+		failures = append(failures, "must contain at least one uppercase letter")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		failures = append(failures, "must contain at least one symbol")
+	}
+	return failures
+}
+
+// ValidatePassword checks the password against the configured policy
+func ValidatePassword(password string) []string {
+	return currentPasswordPolicy().Validate(password)
+}
+
+// rejectWeakPassword writes a 400 listing the failed rules and reports whether
+// the password was rejected
+func rejectWeakPassword(c *gin.Context, password string) bool {
+	failures := ValidatePassword(password)
+	if len(failures) == 0 {
+		return false
+	}
+	respondError(c, http.StatusBadRequest, "WEAK_PASSWORD", "Password does not meet requirements", failures)
+	return true
+}
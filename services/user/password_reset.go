@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const passwordResetMessage = "If your email is registered, you will receive a password reset link"
+
+// PasswordResetAttempt records one reset request per email. The email is
+// stored hashed so the table reveals nothing about which accounts exist.
+type PasswordResetAttempt struct {
+	ID        uint      `gorm:"primaryKey"`
+	EmailHash string    `gorm:"index:idx_password_reset_attempts_email_created,priority:1;not null"`
+	IPAddress string    `gorm:"size:64"`
+	CreatedAt time.Time `gorm:"index:idx_password_reset_attempts_email_created,priority:2"`
+}
+
+// maxPasswordResetTTL bounds PASSWORD_RESET_TTL; a reset link is as good as
+// the password until it expires
+const maxPasswordResetTTL = time.Hour
+
+// passwordResetTTL is PASSWORD_RESET_TTL, which defaults to 15m for links
+// and to the shorter otpDefaultTTL for numeric codes
+func passwordResetTTL() time.Duration {
+	fallback := 15 * time.Minute
+	if passwordResetTokens.Policy().Numeric() {
+		fallback = otpDefaultTTL
+	}
+	ttl := getEnvDuration("PASSWORD_RESET_TTL", fallback)
+	if ttl <= 0 || ttl > maxPasswordResetTTL {
+		return maxPasswordResetTTL
+	}
+	return ttl
+}
+
+func passwordResetMaxRequests() int {
+	return getEnvInt("PASSWORD_RESET_MAX_REQUESTS", 3)
+}
+
+func passwordResetWindow() time.Duration {
+	return getEnvDuration("PASSWORD_RESET_WINDOW", time.Hour)
+}
+
+// passwordResetThrottled records a reset request for email and reports whether
+// the per-email limit for the current window has already been reached.
+// Requests are counted whether or not the email belongs to an account.
+func passwordResetThrottled(db *gorm.DB, c *gin.Context, email string) (bool, error) {
+	emailHash := hashToken(email)
+	var count int64
+	if err := db.Model(&PasswordResetAttempt{}).
+		Where("email_hash = ? AND created_at > ?", emailHash, time.Now().Add(-passwordResetWindow())).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count >= int64(passwordResetMaxRequests()) {
+		return true, nil
+	}
+	return false, db.Create(&PasswordResetAttempt{EmailHash: emailHash, IPAddress: middleware.ClientIP(c)}).Error
+}
+
+// startPasswordResetCleanup removes reset requests older than the throttle
+// window on a ticker until ctx is cancelled
+func startPasswordResetCleanup(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("password-reset-cleanup", defaultHeartbeatMaxAge(interval))
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("password-reset-cleanup")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("password-reset-cleanup")
+				if !leadership.Runs("password-reset-cleanup") {
+					continue
+				}
+				result := db.Where("created_at < ?", time.Now().Add(-passwordResetWindow())).Delete(&PasswordResetAttempt{})
+				if result.Error != nil {
+					zap.L().Error("Failed to clean up password reset attempts", zap.Error(result.Error))
+				} else if result.RowsAffected > 0 {
+					zap.L().Info("Removed expired password reset attempts", zap.Int64("count", result.RowsAffected))
+				}
+			}
+		}
+	}()
+}
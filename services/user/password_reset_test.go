@@ -0,0 +1,225 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRequestPasswordResetAlwaysSucceeds(t *testing.T) {
+	userID := uuid.NewString()
+	tests := []struct {
+		name      string
+		respond   fakeResponder
+		wantError string
+	}{
+		{name: "unknown email"},
+		{
+			name:      "database down",
+			respond:   func(string, []driver.NamedValue) fakeResult { return fakeResult{err: errors.New("connection refused")} },
+			wantError: "Failed to check password reset throttle",
+		},
+		{
+			name: "lookup fails",
+			respond: func(query string, args []driver.NamedValue) fakeResult {
+				if strings.HasPrefix(query, `SELECT * FROM "users"`) {
+					return fakeResult{err: errors.New("statement timeout")}
+				}
+				return fakeResult{}
+			},
+			wantError: "Failed to look up account for password reset",
+		},
+		{
+			name: "saving the token fails",
+			respond: func(query string, args []driver.NamedValue) fakeResult {
+				switch {
+				case strings.HasPrefix(query, `SELECT * FROM "users"`):
+					return fakeResult{columns: []string{"id", "email"}, rows: [][]driver.Value{{userID, "ada@example.com"}}}
+				case strings.HasPrefix(query, `UPDATE "users"`), strings.HasPrefix(query, `INSERT INTO "users"`):
+					return fakeResult{err: errors.New("disk full")}
+				}
+				return fakeResult{}
+			},
+			wantError: "Failed to save reset token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := observeLogs(t)
+			db, _ := newFakeDB(t, tt.respond)
+			handler := RequestPasswordReset(db, newTestEmailService(t, db), NopCaptchaVerifier{})
+			w := serve(handler, "/forgot-password", http.MethodPost, "/forgot-password", `{"email":"ada@example.com"}`, "")
+
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), passwordResetMessage) {
+				t.Fatalf("response = %d %s, want 200 with the generic message", w.Code, w.Body.String())
+			}
+			errorLogs := logs.FilterLevelExact(zapcore.ErrorLevel).All()
+			if tt.wantError == "" {
+				if len(errorLogs) != 0 {
+					t.Errorf("logged errors %v, want none", errorLogs)
+				}
+				return
+			}
+			if len(errorLogs) != 1 || errorLogs[0].Message != tt.wantError {
+				t.Errorf("logged errors %v, want %q", errorLogs, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestResetPasswordToken(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "4")
+	const token = "reset-token-issued-by-forgot-password"
+	userID := uuid.NewString()
+	tests := []struct {
+		name      string
+		token     string
+		expiresAt time.Time
+		// claimed is how many rows the guarded password update changes; 0
+		// means a concurrent request used the token first
+		claimed    int64
+		wantStatus int
+	}{
+		{name: "valid", token: token, expiresAt: time.Now().Add(time.Hour), claimed: 1, wantStatus: http.StatusOK},
+		{name: "expired", token: token, expiresAt: time.Now().Add(-time.Minute), claimed: 1, wantStatus: http.StatusBadRequest},
+		{name: "tampered", token: token + "x", expiresAt: time.Now().Add(time.Hour), claimed: 1, wantStatus: http.StatusBadRequest},
+		{name: "used concurrently", token: token, expiresAt: time.Now().Add(time.Hour), claimed: 0, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+				switch {
+				case strings.HasPrefix(query, `SELECT * FROM "users"`):
+					// Only the stored hash is looked up, never the token itself
+					if args[0].Value != hashToken(token) {
+						return fakeResult{}
+					}
+					return fakeResult{
+						columns: []string{"id", "email", "password_reset_token_hash", "reset_token_expires_at"},
+						rows:    [][]driver.Value{{userID, "ada@example.com", hashToken(token), tt.expiresAt}},
+					}
+				case strings.HasPrefix(query, `UPDATE "users"`):
+					return fakeResult{affected: tt.claimed}
+				}
+				return fakeResult{affected: 1}
+			})
+
+			body := `{"token":"` + tt.token + `","password":"Correct-Horse-9-Battery"}`
+			w := serve(ResetPassword(db), "/reset-password", http.MethodPost, "/reset-password", body, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				// Expired, invalid and used tokens are indistinguishable
+				if got := decodeError(t, w); got.Error.Code != "INVALID_TOKEN" || got.Error.Message != "Invalid or expired token" {
+					t.Errorf("error = %+v, want INVALID_TOKEN", got.Error)
+				}
+				if fake.executed(`UPDATE "refresh_tokens"`) {
+					t.Error("sessions were revoked for a rejected token")
+				}
+				return
+			}
+			if !fake.executed(`"password_reset_token_hash"=$`) {
+				t.Error("the token was not cleared by the password update")
+			}
+			if !fake.executed(`UPDATE "refresh_tokens"`) {
+				t.Error("existing sessions were not revoked")
+			}
+		})
+	}
+}
+
+func TestResetPasswordTokenIsSingleUse(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "4")
+	const token = "reset-token-issued-by-forgot-password"
+	stored := hashToken(token)
+	db, _ := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		switch {
+		case strings.HasPrefix(query, `SELECT * FROM "users"`):
+			if stored == "" || args[0].Value != stored {
+				return fakeResult{}
+			}
+			return fakeResult{
+				columns: []string{"id", "email", "password_reset_token_hash", "reset_token_expires_at"},
+				rows:    [][]driver.Value{{uuid.NewString(), "ada@example.com", stored, time.Now().Add(time.Hour)}},
+			}
+		case strings.HasPrefix(query, `UPDATE "users"`):
+			// The successful reset clears the stored hash
+			stored = ""
+			return fakeResult{affected: 1}
+		}
+		return fakeResult{affected: 1}
+	})
+
+	body := `{"token":"` + token + `","password":"Correct-Horse-9-Battery"}`
+	if w := serve(ResetPassword(db), "/reset-password", http.MethodPost, "/reset-password", body, ""); w.Code != http.StatusOK {
+		t.Fatalf("first reset: status = %d (body %s)", w.Code, w.Body.String())
+	}
+	w := serve(ResetPassword(db), "/reset-password", http.MethodPost, "/reset-password", body, "")
+	if w.Code != http.StatusBadRequest || decodeError(t, w).Error.Code != "INVALID_TOKEN" {
+		t.Fatalf("reused token: response = %d %s, want 400 INVALID_TOKEN", w.Code, w.Body.String())
+	}
+}
+
+func TestResetPasswordReservesAttempt(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "4")
+	t.Setenv("PASSWORD_RESET_TOKEN_FORMAT", "numeric")
+	tests := []struct {
+		name        string
+		submitted   string
+		reserved    bool
+		wantStatus  int
+		wantReset   bool
+		wantDiscard bool
+	}{
+		{name: "correct code", submitted: "123456", reserved: true, wantStatus: http.StatusOK, wantReset: true},
+		{name: "wrong code", submitted: "654321", reserved: true, wantStatus: http.StatusBadRequest, wantDiscard: true},
+		// Another request took the last attempt after the account was read
+		{name: "attempts used up", submitted: "123456", reserved: false, wantStatus: http.StatusBadRequest, wantDiscard: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := uuid.New()
+			digest := passwordResetTokens.Policy().Digest(userID, "123456")
+			db, fake := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+				switch {
+				case strings.HasPrefix(query, `SELECT * FROM "users"`):
+					return fakeResult{
+						columns: []string{"id", "email", "password_reset_token_hash", "reset_token_expires_at"},
+						rows:    [][]driver.Value{{userID.String(), "ada@example.com", digest, time.Now().Add(time.Hour)}},
+					}
+				case strings.Contains(query, "password_reset_attempts < "):
+					if tt.reserved {
+						return fakeResult{affected: 1}
+					}
+					return fakeResult{}
+				}
+				return fakeResult{affected: 1}
+			})
+
+			body := `{"email":"ada@example.com","token":"` + tt.submitted + `","password":"Correct-Horse-9-Battery"}`
+			w := serve(ResetPassword(db), "/reset-password", http.MethodPost, "/reset-password", body, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if !fake.executed("password_reset_attempts < ") {
+				t.Error("no attempt was reserved before comparing the code")
+			}
+			if reset := fake.executed(`"password"=$`); reset != tt.wantReset {
+				t.Errorf("password updated = %v, want %v", reset, tt.wantReset)
+			}
+			if discard := fake.executed("password_reset_attempts >= "); discard != tt.wantDiscard {
+				t.Errorf("spent code discard attempted = %v, want %v", discard, tt.wantDiscard)
+			}
+		})
+	}
+}
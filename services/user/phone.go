@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// phoneCodeMaxAttempts is how many wrong codes invalidate a pending verification
+const phoneCodeMaxAttempts = 5
+
+var errInvalidPhoneNumber = errors.New("phone number must be in E.164 format, e.g. +14155552671")
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// normalizePhoneNumber strips common formatting characters and checks the
+// result is an E.164 number
+func normalizePhoneNumber(phone string) (string, error) {
+	normalized := strings.NewReplacer(" ", "", "-", "", "(", "", ")", "", ".", "").Replace(strings.TrimSpace(phone))
+	if !e164Pattern.MatchString(normalized) {
+		return "", errInvalidPhoneNumber
+	}
+	return normalized, nil
+}
+
+// phoneVerificationTTL is how long an SMS code stays valid (PHONE_VERIFICATION_TTL)
+func phoneVerificationTTL() time.Duration {
+	return getEnvDuration("PHONE_VERIFICATION_TTL", 10*time.Minute)
+}
+
+// phoneVerificationResendInterval throttles verification SMS (PHONE_VERIFICATION_RESEND_INTERVAL)
+func phoneVerificationResendInterval() time.Duration {
+	return getEnvDuration("PHONE_VERIFICATION_RESEND_INTERVAL", time.Minute)
+}
+
+// SMSSender delivers a text message to an E.164 number
+type SMSSender interface {
+	Send(ctx context.Context, to, body string) error
+}
+
+// NewSMSSender selects the transport from SMS_PROVIDER; only "log" exists so far
+func NewSMSSender() (SMSSender, error) {
+	switch provider := getEnv("SMS_PROVIDER", "log"); provider {
+	case "log":
+		return &LogSMSSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", provider)
+	}
+}
+
+// LogSMSSender writes messages to the log instead of sending them, for local
+// development
+type LogSMSSender struct{}
+
+func (s *LogSMSSender) Send(ctx context.Context, to, body string) error {
+	zap.L().Info("SMS (log provider, not sent)", zap.String("to", to), zap.String("body", body))
+	return nil
+}
+
+// phoneCodeHash binds the code to the user so equal codes hash differently
+func phoneCodeHash(userID, code string) string {
+	return hashToken(userID + ":" + code)
+}
+
+// RequestPhoneVerification sends a verification code to the profile's phone number
+func RequestPhoneVerification(db *gorm.DB, sms SMSSender) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+		if user.PhoneNumber == "" {
+			respondError(c, http.StatusBadRequest, "PHONE_NUMBER_MISSING", "No phone number on profile")
+			return
+		}
+		if user.PhoneVerified {
+			respondError(c, http.StatusConflict, "PHONE_ALREADY_VERIFIED", "Phone number already verified")
+			return
+		}
+
+		if user.PhoneVerificationSentAt != nil {
+			if wait := time.Until(user.PhoneVerificationSentAt.Add(phoneVerificationResendInterval())); wait > 0 {
+				respondRetryAfter(c, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", "Verification code was sent recently, please wait before retrying", wait)
+				return
+			}
+		}
+
+		code, err := smsCodePolicy.Generate()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate code")
+			return
+		}
+		now := time.Now()
+		expiresAt := now.Add(phoneVerificationTTL())
+
+		if err := db.Model(&user).Updates(map[string]interface{}{
+			"phone_verification_code_hash":  phoneCodeHash(userID, code),
+			"phone_verification_expires_at": expiresAt,
+			"phone_verification_sent_at":    now,
+			"phone_verification_attempts":   0,
+		}).Error; err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to save verification code")
+			return
+		}
+
+		body := fmt.Sprintf("Your verification code is %s. It expires in %d minutes.", code, int(phoneVerificationTTL().Minutes()))
+		if err := sms.Send(c.Request.Context(), string(user.PhoneNumber), body); err != nil {
+			middleware.Logger(c).Error("Failed to send verification SMS", zap.Error(err))
+			respondError(c, http.StatusBadGateway, "SMS_SEND_FAILED", "Failed to send verification code")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Verification code sent", "expires_at": expiresAt})
+	}
+}
+
+// VerifyPhone confirms the pending SMS code and marks the phone number verified
+func VerifyPhone(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+
+		var req TwoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+		if user.PhoneVerificationCodeHash == "" || user.PhoneVerificationExpiresAt == nil ||
+			time.Now().After(*user.PhoneVerificationExpiresAt) || user.PhoneVerificationAttempts >= phoneCodeMaxAttempts {
+			respondError(c, http.StatusBadRequest, "CODE_EXPIRED", "No valid verification code, request a new one")
+			return
+		}
+
+		// The attempt is spent before comparing, so concurrent guesses can't
+		// get past phoneCodeMaxAttempts
+		reserved, err := reserveOTPAttempt(db, user.ID, "phone_verification_attempts", phoneCodeMaxAttempts)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+		if !reserved {
+			respondError(c, http.StatusBadRequest, "CODE_EXPIRED", "No valid verification code, request a new one")
+			return
+		}
+
+		hash := phoneCodeHash(userID, strings.TrimSpace(req.Code))
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(user.PhoneVerificationCodeHash)) != 1 {
+			respondError(c, http.StatusBadRequest, "INVALID_CODE", "Invalid verification code")
+			return
+		}
+
+		if err := db.Model(&user).Updates(map[string]interface{}{
+			"phone_verified":                true,
+			"phone_verification_code_hash":  "",
+			"phone_verification_expires_at": nil,
+			"phone_verification_attempts":   0,
+		}).Error; err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to verify phone number")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Phone number verified", "phone_number": user.PhoneNumber})
+	}
+}
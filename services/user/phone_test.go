@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// pendingPhoneUser answers the profile lookup with an unexpired code for
+// userID, and the attempt reservation with reserved
+func pendingPhoneUser(userID, code string, reserved bool) fakeResponder {
+	return func(query string, args []driver.NamedValue) fakeResult {
+		switch {
+		case strings.HasPrefix(query, `SELECT * FROM "users"`):
+			return fakeResult{
+				columns: []string{"id", "phone_verification_code_hash", "phone_verification_expires_at", "phone_verification_attempts"},
+				rows:    [][]driver.Value{{userID, phoneCodeHash(userID, code), time.Now().Add(time.Minute), int64(0)}},
+			}
+		case strings.Contains(query, "phone_verification_attempts < "):
+			if reserved {
+				return fakeResult{affected: 1}
+			}
+			return fakeResult{}
+		}
+		return fakeResult{affected: 1}
+	}
+}
+
+func TestVerifyPhoneReservesAttempt(t *testing.T) {
+	tests := []struct {
+		name       string
+		submitted  string
+		reserved   bool
+		wantStatus int
+		wantCode   string
+		wantVerify bool
+	}{
+		{name: "correct code", submitted: "123456", reserved: true, wantStatus: http.StatusOK, wantVerify: true},
+		{name: "wrong code", submitted: "654321", reserved: true, wantStatus: http.StatusBadRequest, wantCode: "INVALID_CODE"},
+		// Another request took the last attempt after the profile was read
+		{name: "attempts used up", submitted: "123456", reserved: false, wantStatus: http.StatusBadRequest, wantCode: "CODE_EXPIRED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := uuid.NewString()
+			db, fake := newFakeDB(t, pendingPhoneUser(userID, "123456", tt.reserved))
+			w := serve(VerifyPhone(db), "/verify", http.MethodPost, "/verify", `{"code":"`+tt.submitted+`"}`, userID)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantCode != "" {
+				if got := decodeError(t, w).Error.Code; got != tt.wantCode {
+					t.Errorf("code = %q, want %q", got, tt.wantCode)
+				}
+			}
+			if verified := fake.executed(`"phone_verified"=`); verified != tt.wantVerify {
+				t.Errorf("phone marked verified = %v, want %v", verified, tt.wantVerify)
+			}
+		})
+	}
+}
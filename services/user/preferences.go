@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserPreferences holds a user's notification toggles. The row is created on
+// the first update; until then defaultPreferences applies. Language is kept on
+// the user (preferred_language) and only surfaced here.
+type UserPreferences struct {
+	UserID             uuid.UUID `gorm:"type:uuid;primary_key" json:"-"`
+	CreatedAt          time.Time `json:"-"`
+	UpdatedAt          time.Time `json:"updated_at"`
+	MarketingEmails    bool      `gorm:"not null" json:"marketing_emails"`
+	LoginNotifications bool      `gorm:"not null" json:"login_notifications"`
+	Language           string    `gorm:"-" json:"language"`
+}
+
+// UpdatePreferencesRequest changes only the keys that are present; unknown
+// keys are rejected by the JSON decoder
+type UpdatePreferencesRequest struct {
+	MarketingEmails    *bool   `json:"marketing_emails"`
+	LoginNotifications *bool   `json:"login_notifications"`
+	Language           *string `json:"language"`
+}
+
+// languagePattern accepts BCP 47 style tags such as "en" or "pt-BR"
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})?$`)
+
+func defaultPreferences(userID uuid.UUID) UserPreferences {
+	return UserPreferences{UserID: userID, LoginNotifications: true}
+}
+
+// loadPreferences returns the stored preferences, or the defaults when the
+// user has never changed them
+func loadPreferences(db *gorm.DB, userID uuid.UUID) (UserPreferences, error) {
+	var prefs UserPreferences
+	err := db.First(&prefs, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return defaultPreferences(userID), nil
+	}
+	return prefs, err
+}
+
+// GetPreferences returns the caller's preferences
+func GetPreferences(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var user User
+		if err := db.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+
+		prefs, err := loadPreferences(db, user.ID)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch preferences")
+			return
+		}
+		prefs.Language = user.PreferredLanguage
+		c.JSON(http.StatusOK, prefs)
+	}
+}
+
+// UpdatePreferences changes the caller's preferences, creating the row on
+// first use
+func UpdatePreferences(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req UpdatePreferencesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		if req.Language != nil && !languagePattern.MatchString(*req.Language) {
+			respondValidationError(c, "Validation failed",
+				[]FieldError{{Field: "language", Message: "must be a language tag such as en or pt-BR"}})
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+
+		var prefs UserPreferences
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			var err error
+			if prefs, err = loadPreferences(tx, user.ID); err != nil {
+				return err
+			}
+			if req.MarketingEmails != nil {
+				prefs.MarketingEmails = *req.MarketingEmails
+			}
+			if req.LoginNotifications != nil {
+				prefs.LoginNotifications = *req.LoginNotifications
+			}
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "user_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"marketing_emails", "login_notifications", "updated_at"}),
+			}).Create(&prefs).Error; err != nil {
+				return err
+			}
+			if req.Language != nil && *req.Language != user.PreferredLanguage {
+				if err := tx.Model(&user).Update("preferred_language", *req.Language).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update preferences")
+			return
+		}
+
+		prefs.Language = user.PreferredLanguage
+		c.JSON(http.StatusOK, prefs)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+	// Embeds the IANA database so time zones validate the same on hosts
+	// and images without one
+	_ "time/tzdata"
+
+	"golang.org/x/text/language"
+)
+
+// defaultTimezone applies to users who haven't set one (DEFAULT_TIMEZONE)
+func defaultTimezone() string {
+	return getEnv("DEFAULT_TIMEZONE", "UTC")
+}
+
+// defaultLocale applies to users who have set neither a locale nor a
+// preferred language (DEFAULT_LOCALE)
+func defaultLocale() string {
+	return getEnv("DEFAULT_LOCALE", "en")
+}
+
+// normalizeTimezone checks name against the IANA time zone database, such as
+// Europe/Berlin or UTC
+func normalizeTimezone(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	// LoadLocation accepts these for UTC and the host's zone, neither of
+	// which is an IANA name
+	if name == "" || name == "Local" {
+		return "", errors.New("must be an IANA time zone such as Europe/Berlin")
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return "", errors.New("must be an IANA time zone such as Europe/Berlin")
+	}
+	return name, nil
+}
+
+// normalizeLocale checks tag is a well-formed BCP 47 tag and returns it in
+// canonical form, so "pt-br" is stored as "pt-BR"
+func normalizeLocale(tag string) (string, error) {
+	parsed, err := language.Parse(strings.TrimSpace(tag))
+	if err != nil || parsed == language.Und {
+		return "", errors.New("must be a BCP 47 language tag such as en or pt-BR")
+	}
+	return parsed.String(), nil
+}
+
+// EffectiveTimezone is the user's time zone, or the default when unset
+func (u *User) EffectiveTimezone() string {
+	if u.Timezone != "" {
+		return u.Timezone
+	}
+	return defaultTimezone()
+}
+
+// Location is the user's time zone for presenting timestamps
+func (u *User) Location() *time.Location {
+	if loc, err := time.LoadLocation(u.EffectiveTimezone()); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// EffectiveLocale is the user's locale, falling back to the preferred
+// language set before locales existed and then to the default. It picks the
+// language of the user's emails.
+func (u *User) EffectiveLocale() string {
+	switch {
+	case u.Locale != "":
+		return u.Locale
+	case u.PreferredLanguage != "":
+		return u.PreferredLanguage
+	}
+	return defaultLocale()
+}
+
+// localizeProfile fills in the effective time zone and locale and presents
+// the profile's timestamps in that time zone. The instants are unchanged;
+// only their offset differs.
+func localizeProfile(user *User) {
+	user.Timezone = user.EffectiveTimezone()
+	user.Locale = user.EffectiveLocale()
+	loc := user.Location()
+	user.CreatedAt = user.CreatedAt.In(loc)
+	user.UpdatedAt = user.UpdatedAt.In(loc)
+	for i := range user.Addresses {
+		address := &user.Addresses[i]
+		address.CreatedAt = address.CreatedAt.In(loc)
+		address.UpdatedAt = address.UpdatedAt.In(loc)
+		if address.GeocodedAt != nil {
+			geocodedAt := address.GeocodedAt.In(loc)
+			address.GeocodedAt = &geocodedAt
+		}
+	}
+}
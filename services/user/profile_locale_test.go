@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEffectiveLocale(t *testing.T) {
+	t.Setenv("DEFAULT_LOCALE", "de")
+	tests := []struct {
+		name string
+		user User
+		want string
+	}{
+		{name: "locale", user: User{Locale: "fr-CA", PreferredLanguage: "fr"}, want: "fr-CA"},
+		{name: "preferred language", user: User{PreferredLanguage: "fr"}, want: "fr"},
+		{name: "neither", user: User{}, want: "de"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.user.EffectiveLocale(); got != tt.want {
+			t.Errorf("%s: EffectiveLocale() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRegisterLeavesLanguageUnset checks new accounts don't get a language of
+// their own, which would hide DEFAULT_LOCALE
+func TestRegisterLeavesLanguageUnset(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "4")
+	setKeyring(t, "k1:"+testKey(1), "")
+	var inserted []driver.NamedValue
+	db, _ := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		if strings.HasPrefix(query, `INSERT INTO "users"`) {
+			inserted = args
+		}
+		return fakeResult{affected: 1}
+	})
+	w := serve(Register(db, newTestEmailService(t, db), NopCaptchaVerifier{}, NopEventPublisher{}), "/register", http.MethodPost, "/register", registerBody("ada@example.com"), "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d (body %s)", w.Code, http.StatusCreated, w.Body.String())
+	}
+	if inserted == nil {
+		t.Fatal("no user was inserted")
+	}
+	for _, arg := range inserted {
+		if arg.Value == "en" {
+			t.Errorf("user inserted with language %q", arg.Value)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PatchProfileRequest changes only the fields present in the body. Unlike
+// PUT, a present empty string clears optional fields such as bio.
+type PatchProfileRequest struct {
+	FirstName         *string    `json:"first_name"`
+	LastName          *string    `json:"last_name"`
+	PhoneNumber       *string    `json:"phone_number"`
+	DateOfBirth       *time.Time `json:"date_of_birth"`
+	ProfilePicture    *string    `json:"profile_picture"`
+	Bio               *string    `json:"bio"`
+	PreferredLanguage *string    `json:"preferred_language"`
+	Timezone          *string    `json:"timezone"`
+	Locale            *string    `json:"locale"`
+	Version           int        `json:"version"`
+}
+
+// profileUpdates validates the provided fields and maps them to columns
+func (r *PatchProfileRequest) profileUpdates(user *User) (map[string]interface{}, []FieldError) {
+	updates := map[string]interface{}{}
+	var errs []FieldError
+
+	if r.FirstName != nil {
+		if name := strings.TrimSpace(*r.FirstName); name == "" {
+			errs = append(errs, FieldError{Field: "first_name", Message: "must not be empty"})
+		} else {
+			updates["first_name"] = name
+		}
+	}
+	if r.LastName != nil {
+		if name := strings.TrimSpace(*r.LastName); name == "" {
+			errs = append(errs, FieldError{Field: "last_name", Message: "must not be empty"})
+		} else {
+			updates["last_name"] = name
+		}
+	}
+	if r.PhoneNumber != nil {
+		phone := ""
+		if strings.TrimSpace(*r.PhoneNumber) != "" {
+			normalized, err := normalizePhoneNumber(*r.PhoneNumber)
+			if err != nil {
+				errs = append(errs, FieldError{Field: "phone_number", Message: err.Error()})
+			}
+			phone = normalized
+		}
+		// A new number has to be verified again
+		if phone != string(user.PhoneNumber) {
+			updates["phone_number"] = EncryptedString(phone)
+			updates["phone_verified"] = false
+			updates["phone_verification_code_hash"] = ""
+		}
+	}
+	if r.DateOfBirth != nil {
+		if r.DateOfBirth.After(time.Now()) {
+			errs = append(errs, FieldError{Field: "date_of_birth", Message: "must be in the past"})
+		} else {
+			updates["date_of_birth"] = r.DateOfBirth
+		}
+	}
+	if r.ProfilePicture != nil {
+		updates["profile_picture"] = *r.ProfilePicture
+	}
+	if r.Bio != nil {
+		updates["bio"] = *r.Bio
+	}
+	if r.PreferredLanguage != nil {
+		if !languagePattern.MatchString(*r.PreferredLanguage) {
+			errs = append(errs, FieldError{Field: "preferred_language", Message: "must be a language tag such as en or pt-BR"})
+		} else {
+			updates["preferred_language"] = *r.PreferredLanguage
+		}
+	}
+	// An empty string goes back to the default
+	if r.Timezone != nil {
+		if *r.Timezone == "" {
+			updates["timezone"] = ""
+		} else if timezone, err := normalizeTimezone(*r.Timezone); err != nil {
+			errs = append(errs, FieldError{Field: "timezone", Message: err.Error()})
+		} else {
+			updates["timezone"] = timezone
+		}
+	}
+	if r.Locale != nil {
+		if *r.Locale == "" {
+			updates["locale"] = ""
+		} else if locale, err := normalizeLocale(*r.Locale); err != nil {
+			errs = append(errs, FieldError{Field: "locale", Message: err.Error()})
+		} else {
+			updates["locale"] = locale
+		}
+	}
+	return updates, errs
+}
+
+// PatchProfile partially updates the caller's profile, leaving absent fields
+// untouched
+func PatchProfile(db *gorm.DB, events EventPublisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var user User
+		if err := db.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+
+		var req PatchProfileRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		expected, ok := expectedVersion(c, req.Version)
+		if !ok {
+			return
+		}
+
+		updates, errs := req.profileUpdates(&user)
+		if len(errs) > 0 {
+			respondValidationError(c, "Validation failed", errs)
+			return
+		}
+		if len(updates) == 0 {
+			if expected != user.Version {
+				respondVersionConflict(c, user.Version)
+				return
+			}
+			localizeProfile(&user)
+			setETag(c, user.Version)
+			c.JSON(http.StatusOK, user)
+			return
+		}
+
+		saveProfile(c, db, events, &user, expected, updates)
+	}
+}
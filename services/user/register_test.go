@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func newTestEmailService(t *testing.T, db *gorm.DB) *EmailService {
+	t.Helper()
+	templates, err := LoadEmailTemplates("")
+	if err != nil {
+		t.Fatalf("LoadEmailTemplates: %v", err)
+	}
+	return NewEmailService(db, &LogSender{}, templates)
+}
+
+func registerBody(email string) string {
+	return fmt.Sprintf(`{"email":%q,"password":"Correct-Horse-9-Battery","first_name":"Ada","last_name":"Lovelace"}`, email)
+}
+
+func TestTranslateUniqueViolation(t *testing.T) {
+	dialector := translatingDialector{postgres.New(postgres.Config{}).(*postgres.Dialector)}
+
+	err := dialector.Translate(&pgconn.PgError{Code: pgUniqueViolation, ConstraintName: usersEmailIndex})
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Fatalf("Translate(23505) = %v, want it to match gorm.ErrDuplicatedKey", err)
+	}
+	if constraint, ok := uniqueViolation(err); !ok || constraint != usersEmailIndex {
+		t.Errorf("uniqueViolation = %q, %v; want %q, true", constraint, ok, usersEmailIndex)
+	}
+
+	// Other errors are left to the postgres dialector
+	other := dialector.Translate(&pgconn.PgError{Code: "23503", ConstraintName: "fk"})
+	if _, ok := uniqueViolation(other); ok {
+		t.Errorf("foreign key violation %v reported as a unique violation", other)
+	}
+}
+
+func TestRegisterUniqueViolation(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "4")
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "email", err: &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: usersEmailIndex}, wantStatus: http.StatusConflict, wantCode: "EMAIL_TAKEN"},
+		{name: "secondary email", err: &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: userEmailsEmailIndex}, wantStatus: http.StatusConflict, wantCode: "EMAIL_TAKEN"},
+		{name: "username", err: &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: usersUsernameIndex}, wantStatus: http.StatusConflict, wantCode: "USERNAME_TAKEN"},
+		{name: "unknown index", err: &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "idx_other"}, wantStatus: http.StatusConflict, wantCode: "ACCOUNT_EXISTS"},
+		{name: "other failure", err: &pgconn.PgError{Code: "53300"}, wantStatus: http.StatusInternalServerError, wantCode: "INTERNAL_ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+				if strings.HasPrefix(query, `INSERT INTO "users"`) {
+					return fakeResult{err: tt.err}
+				}
+				return fakeResult{}
+			})
+			w := serve(Register(db, newTestEmailService(t, db), NopCaptchaVerifier{}, NopEventPublisher{}), "/register", http.MethodPost, "/register", registerBody("ada@example.com"), "")
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if got := decodeError(t, w).Error.Code; got != tt.wantCode {
+				t.Errorf("code = %q, want %q", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+// TestConcurrentRegistrations races registrations of one email against a
+// real database: the unique index must let exactly one through
+func TestConcurrentRegistrations(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "4")
+	db := testDatabase(t)
+	email := "race-" + uuid.NewString() + "@example.com"
+	t.Cleanup(func() {
+		db.Unscoped().Where("email = ?", email).Delete(&UserEmail{})
+		db.Unscoped().Where("email = ?", email).Delete(&User{})
+	})
+
+	handler := Register(db, newTestEmailService(t, db), NopCaptchaVerifier{}, NopEventPublisher{})
+	const attempts = 8
+	statuses := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- serve(handler, "/register", http.MethodPost, "/register", registerBody(email), "").Code
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusCreated] != 1 || counts[http.StatusConflict] != attempts-1 {
+		t.Fatalf("statuses = %v, want one %d and %d %d", counts, http.StatusCreated, attempts-1, http.StatusConflict)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"path"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// API versioning policy:
+//   - Every route is mounted under /<version> for each version it belongs to,
+//     and the unversioned paths alias the latest stable version.
+//   - Changes that could break clients go into a new version. Add it to
+//     apiVersions, register the new behavior with since set to it, and give
+//     the old route until set to it.
+//   - A route slated for removal gets a deprecation, which adds Deprecation
+//     and Sunset headers, and is removed after its sunset date.
+var apiVersions = []apiVersion{
+	{name: "v1", stable: true},
+}
+
+// apiVersion is one mounted version of the API
+type apiVersion struct {
+	name   string
+	stable bool
+}
+
+// latestStableVersion is the version the unversioned paths serve
+func latestStableVersion() string {
+	for i := len(apiVersions) - 1; i >= 0; i-- {
+		if apiVersions[i].stable {
+			return apiVersions[i].name
+		}
+	}
+	return apiVersions[0].name
+}
+
+// resourceURL is the canonical URL of the resource at path, under the route
+// prefix and the API version serving the request, for Location headers
+func resourceURL(c *gin.Context, path string) string {
+	version := c.GetString(middleware.APIVersionKey)
+	if version == "" {
+		version = latestStableVersion()
+	}
+	return routePrefix() + "/" + version + path
+}
+
+// routeAccess is the authentication a route requires
+type routeAccess int
+
+const (
+	accessPublic routeAccess = iota
+	// accessUser requires a valid access token for an active account
+	accessUser
+	// accessAdmin additionally requires the admin role
+	accessAdmin
+	// accessService requires the shared internal service token
+	accessService
+)
+
+// apiRoute is one row of the routing table
+type apiRoute struct {
+	method   string
+	path     string
+	access   routeAccess
+	handlers []gin.HandlerFunc
+	// since is the first version with the route; empty means the first version
+	since string
+	// until is the first version without it; empty while it is current
+	until       string
+	deprecation *middleware.DeprecationPolicy
+	// maxBodyBytes replaces MAX_BODY_BYTES for the route when set
+	maxBodyBytes int64
+	// upload lets cookie-authenticated requests send multipart/form-data
+	upload bool
+}
+
+// inVersion reports whether the route is mounted in apiVersions[index]
+func (r apiRoute) inVersion(index int) bool {
+	for i, v := range apiVersions {
+		if v.name == r.since && index < i {
+			return false
+		}
+		if v.name == r.until && index >= i {
+			return false
+		}
+	}
+	return true
+}
+
+// routeDeps are the services the API handlers are built from
+type routeDeps struct {
+	db           *gorm.DB
+	tokenService *TokenService
+	emailService *EmailService
+	smsSender    SMSSender
+	storage      Storage
+	captcha      CaptchaVerifier
+	geocoder     Geocoder
+	events       EventPublisher
+	idempotency  middleware.IdempotencyStore
+	features     middleware.FeatureFlags
+	strictLimit  gin.HandlerFunc
+	defaultLimit gin.HandlerFunc
+}
+
+// changePasswordPutDeprecation retires PUT /profile/change-password in
+// favor of POST
+var changePasswordPutDeprecation = &middleware.DeprecationPolicy{
+	Since:  time.Date(2026, time.October, 14, 0, 0, 0, 0, time.UTC),
+	Sunset: time.Date(2027, time.April, 14, 0, 0, 0, 0, time.UTC),
+}
+
+// apiRoutes is the routing table for every API version
+func apiRoutes(d routeDeps) []apiRoute {
+	db, emailService := d.db, d.emailService
+	h := func(handlers ...gin.HandlerFunc) []gin.HandlerFunc { return handlers }
+	secondaryEmails := middleware.RequireFeature(d.features, "secondary_emails")
+	return []apiRoute{
+		// Public routes
+		{method: "POST", path: "/register", handlers: h(d.defaultLimit, middleware.Idempotency(d.idempotency, "register"), Register(db, emailService, d.captcha, d.events))},
+		{method: "GET", path: "/users/check-username", handlers: h(d.defaultLimit, CheckUsername(db))},
+		{method: "POST", path: "/login", handlers: h(d.strictLimit, Login(db, d.tokenService, emailService))},
+		{method: "POST", path: "/login/2fa", handlers: h(d.strictLimit, LoginTwoFactor(db, d.tokenService, emailService))},
+		{method: "POST", path: "/refresh", handlers: h(d.defaultLimit, RefreshAccessToken(db, d.tokenService))},
+		{method: "POST", path: "/forgot-password", handlers: h(d.strictLimit, RequestPasswordReset(db, emailService, d.captcha))},
+		{method: "POST", path: "/reset-password", handlers: h(d.defaultLimit, ResetPassword(db))},
+		{method: "GET", path: "/verify-email", handlers: h(d.defaultLimit, VerifyEmail(db))},
+		{method: "POST", path: "/resend-verification", handlers: h(d.strictLimit, ResendVerification(db, emailService))},
+		{method: "GET", path: "/confirm-email-change", handlers: h(d.defaultLimit, ConfirmEmailChange(db, emailService))},
+		{method: "GET", path: "/verify-secondary-email", handlers: h(d.defaultLimit, VerifySecondaryEmail(db))},
+		{method: "POST", path: "/profile/restore", handlers: h(d.strictLimit, RestoreAccount(db))},
+
+		// Internal routes for other services
+		{method: "POST", path: "/auth/validate", access: accessService, handlers: h(ValidateToken(db, d.tokenService))},
+		{method: "POST", path: "/users/batch", access: accessService, handlers: h(BatchGetUsers(db))},
+
+		{method: "POST", path: "/logout", access: accessUser, handlers: h(Logout(db, d.tokenService))},
+
+		// Profile management
+		{method: "GET", path: "/profile", access: accessUser, handlers: h(GetProfile(db))},
+		{method: "PUT", path: "/profile", access: accessUser, handlers: h(UpdateProfile(db, d.events))},
+		{method: "PATCH", path: "/profile", access: accessUser, handlers: h(PatchProfile(db, d.events))},
+		{method: "POST", path: "/profile/change-password", access: accessUser, handlers: h(ChangePassword(db, emailService))},
+		{method: "PUT", path: "/profile/change-password", access: accessUser, handlers: h(ChangePassword(db, emailService)), deprecation: changePasswordPutDeprecation},
+		{method: "POST", path: "/profile/change-email", access: accessUser, handlers: h(RequestEmailChange(db, emailService))},
+		{method: "GET", path: "/profile/emails", access: accessUser, handlers: h(secondaryEmails, ListEmails(db))},
+		{method: "POST", path: "/profile/emails", access: accessUser, handlers: h(secondaryEmails, AddEmail(db, emailService))},
+		{method: "POST", path: "/profile/emails/:id/primary", access: accessUser, handlers: h(secondaryEmails, PromoteEmail(db, emailService))},
+		{method: "DELETE", path: "/profile/emails/:id", access: accessUser, handlers: h(secondaryEmails, RemoveEmail(db))},
+		{method: "DELETE", path: "/profile", access: accessUser, handlers: h(DeleteAccount(db, d.storage, d.events))},
+		{method: "GET", path: "/profile/export", access: accessUser, handlers: h(ExportUserData(db))},
+		{method: "GET", path: "/profile/summary", access: accessUser, handlers: h(GetAccountSummary(db))},
+		{method: "GET", path: "/profile/login-history", access: accessUser, handlers: h(GetLoginHistory(db))},
+		{method: "GET", path: "/profile/sessions", access: accessUser, handlers: h(ListSessions(db))},
+		{method: "DELETE", path: "/profile/sessions", access: accessUser, handlers: h(RevokeOtherSessions(db))},
+		{method: "DELETE", path: "/profile/sessions/:id", access: accessUser, handlers: h(RevokeSession(db))},
+		{method: "GET", path: "/profile/preferences", access: accessUser, handlers: h(GetPreferences(db))},
+		{method: "PUT", path: "/profile/preferences", access: accessUser, handlers: h(UpdatePreferences(db))},
+		{method: "POST", path: "/profile/avatar", access: accessUser, handlers: h(UploadAvatar(db, d.storage)), maxBodyBytes: avatarMaxBytes() + 64<<10, upload: true},
+		{method: "DELETE", path: "/profile/avatar", access: accessUser, handlers: h(DeleteAvatar(db, d.storage))},
+		{method: "POST", path: "/profile/phone/verify-request", access: accessUser, handlers: h(RequestPhoneVerification(db, d.smsSender))},
+		{method: "POST", path: "/profile/phone/verify", access: accessUser, handlers: h(VerifyPhone(db))},
+
+		// Two-factor authentication
+		{method: "POST", path: "/2fa/enable", access: accessUser, handlers: h(EnableTwoFactor(db))},
+		{method: "POST", path: "/2fa/verify", access: accessUser, handlers: h(VerifyTwoFactor(db))},
+		{method: "POST", path: "/2fa/disable", access: accessUser, handlers: h(DisableTwoFactor(db))},
+
+		// Address management
+		{method: "POST", path: "/addresses", access: accessUser, handlers: h(middleware.Idempotency(d.idempotency, "addresses"), AddAddress(db, d.geocoder))},
+		{method: "POST", path: "/addresses/bulk", access: accessUser, handlers: h(BulkAddAddresses(db, d.geocoder))},
+		{method: "DELETE", path: "/addresses", access: accessUser, handlers: h(BatchDeleteAddresses(db))},
+		{method: "POST", path: "/addresses/restore", access: accessUser, handlers: h(BatchRestoreAddresses(db))},
+		{method: "GET", path: "/addresses", access: accessUser, handlers: h(ListAddresses(db))},
+		{method: "GET", path: "/addresses/:id", access: accessUser, handlers: h(GetAddress(db))},
+		{method: "PUT", path: "/addresses/:id", access: accessUser, handlers: h(UpdateAddress(db, d.geocoder))},
+		{method: "PUT", path: "/addresses/:id/default", access: accessUser, handlers: h(SetDefaultAddress(db))},
+		{method: "DELETE", path: "/addresses/:id", access: accessUser, handlers: h(DeleteAddress(db))},
+
+		// Admin routes
+		{method: "GET", path: "/admin/users", access: accessAdmin, handlers: h(AdminListUsers(db))},
+		{method: "GET", path: "/admin/login-events", access: accessAdmin, handlers: h(AdminListLoginEvents(db))},
+		{method: "PUT", path: "/admin/users/:id/address-limit", access: accessAdmin, handlers: h(AdminSetAddressLimit(db))},
+		{method: "POST", path: "/admin/users/:id/verify", access: accessAdmin, handlers: h(AdminVerifyUser(db))},
+		{method: "POST", path: "/admin/users/:id/lock", access: accessAdmin, handlers: h(AdminLockUser(db))},
+		{method: "POST", path: "/admin/users/:id/unlock", access: accessAdmin, handlers: h(AdminUnlockUser(db))},
+		{method: "POST", path: "/admin/users/:id/suspend", access: accessAdmin, handlers: h(AdminSuspendUser(db))},
+		{method: "POST", path: "/admin/users/:id/unsuspend", access: accessAdmin, handlers: h(AdminUnsuspendUser(db))},
+		{method: "POST", path: "/admin/users/:id/reset-failed-logins", access: accessAdmin, handlers: h(AdminResetFailedLogins(db))},
+		{method: "GET", path: "/admin/audit-events", access: accessAdmin, handlers: h(AdminListAuditEvents(db))},
+		{method: "GET", path: "/admin/health", access: accessAdmin, handlers: h(ReadinessDetails())},
+	}
+}
+
+// mountAPI registers the routing table under /<version> for every version,
+// and unversioned for the latest stable one. access holds the middleware
+// chain enforcing each access level.
+func mountAPI(api *gin.RouterGroup, routes []apiRoute, access map[routeAccess][]gin.HandlerFunc, limits *middleware.BodyLimits) {
+	latest := latestStableVersion()
+	for i, v := range apiVersions {
+		mountVersion(api.Group("/"+v.name), i, routes, access, limits)
+		if v.name == latest {
+			mountVersion(api, i, routes, access, limits)
+		}
+	}
+}
+
+func mountVersion(g *gin.RouterGroup, index int, routes []apiRoute, access map[routeAccess][]gin.HandlerFunc, limits *middleware.BodyLimits) {
+	version := middleware.APIVersion(apiVersions[index].name)
+	for _, route := range routes {
+		if !route.inVersion(index) {
+			continue
+		}
+		// Deprecation headers go on before auth so rejected requests carry them too
+		handlers := []gin.HandlerFunc{version}
+		if route.deprecation != nil {
+			handlers = append(handlers, middleware.Deprecation(*route.deprecation))
+		}
+		if route.upload {
+			handlers = append(handlers, middleware.AllowCookieUpload)
+		}
+		handlers = append(handlers, access[route.access]...)
+		g.Handle(route.method, route.path, append(handlers, route.handlers...)...)
+		// The global BodyLimit checks this before any route handler runs
+		if route.maxBodyBytes > 0 {
+			limits.Allow(route.method, path.Join(g.BasePath(), route.path), route.maxBodyBytes)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestMountAPIRaisesBodyLimit(t *testing.T) {
+	limits := middleware.NewBodyLimits(10)
+	r := gin.New()
+	r.Use(middleware.BodyLimit(limits))
+	ok := []gin.HandlerFunc{func(c *gin.Context) { c.Status(http.StatusOK) }}
+	mountAPI(r.Group("/api"), []apiRoute{
+		{method: http.MethodPost, path: "/upload", handlers: ok, maxBodyBytes: 100},
+		{method: http.MethodPost, path: "/small", handlers: ok},
+	}, nil, limits)
+
+	tests := []struct {
+		path     string
+		wantCode int
+	}{
+		{path: "/api/v1/upload", wantCode: http.StatusOK},
+		{path: "/api/upload", wantCode: http.StatusOK},
+		{path: "/api/v1/small", wantCode: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(strings.Repeat("a", 50))))
+		if w.Code != tt.wantCode {
+			t.Errorf("POST %s: status = %d, want %d", tt.path, w.Code, tt.wantCode)
+		}
+	}
+}
+
+// TestCookieWritesNeedJSON sends cross-site style bodies to routes whose
+// handlers bind with ShouldBindJSON rather than bindBody
+func TestCookieWritesNeedJSON(t *testing.T) {
+	ts := &TokenService{secret: "test-secret", issuer: "user-service", expiry: time.Minute}
+	token, err := ts.GenerateAccessToken(&User{ID: uuid.New(), Role: "user"}, uuid.New())
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+	next := func(c *gin.Context) { c.Next() }
+	r := gin.New()
+	mountAPI(r.Group("/api"), apiRoutes(routeDeps{db: emptyDB(t), tokenService: ts, strictLimit: next, defaultLimit: next}),
+		map[routeAccess][]gin.HandlerFunc{
+			accessUser: {middleware.AuthMiddleware(ts.secret, ts.issuer, nil)},
+		}, middleware.NewBodyLimits(1<<20))
+
+	tests := []struct {
+		path   string
+		cookie string
+	}{
+		{path: "/api/v1/profile/change-email", cookie: middleware.AccessTokenCookie},
+		{path: "/api/v1/2fa/disable", cookie: middleware.AccessTokenCookie},
+		{path: "/api/v1/profile/phone/verify", cookie: middleware.AccessTokenCookie},
+		{path: "/api/v1/refresh", cookie: refreshTokenCookie},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"email":"mallory@example.com","password":"x","code":"123456"}`))
+		req.Header.Set("Content-Type", "text/plain")
+		req.AddCookie(&http.Cookie{Name: tt.cookie, Value: token})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("POST %s: status = %d, want %d (body %s)", tt.path, w.Code, http.StatusUnsupportedMediaType, w.Body.String())
+		}
+	}
+}
+
+// TestRoutesDocumented fails when a route is added without describing it in
+// docs/openapi.json
+func TestRoutesDocumented(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("parse openapi.json: %v", err)
+	}
+
+	for _, route := range apiRoutes(routeDeps{}) {
+		if route.until != "" {
+			continue
+		}
+		// gin's :id parameters are {id} in OpenAPI
+		segments := strings.Split(route.path, "/")
+		for i, segment := range segments {
+			if name, ok := strings.CutPrefix(segment, ":"); ok {
+				segments[i] = "{" + name + "}"
+			}
+		}
+		path := strings.Join(segments, "/")
+		if _, ok := spec.Paths[path][strings.ToLower(route.method)]; !ok {
+			t.Errorf("%s %s is not documented in docs/openapi.json", route.method, path)
+		}
+	}
+}
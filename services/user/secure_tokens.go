@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Token encodings. urlsafe and hex tokens are random bytes; numeric tokens
+// are short codes a user can type from an SMS or a short email.
+const (
+	tokenFormatURLSafe = "urlsafe"
+	tokenFormatHex     = "hex"
+	tokenFormatNumeric = "numeric"
+)
+
+// Bounds on the configurable sizes. Numeric codes are weak by design and are
+// only accepted together with the account's email and an attempt limit.
+const (
+	maxTokenBytes  = 64
+	minTokenDigits = 6
+	maxTokenDigits = 12
+)
+
+// otpDefaultTTL is the default lifetime of numeric reset and verification
+// codes, which are easier to guess than links and so expire sooner
+const otpDefaultTTL = 10 * time.Minute
+
+// TokenPolicy describes how one kind of secret token is generated
+type TokenPolicy struct {
+	Format string
+	// Bytes of randomness for urlsafe and hex tokens
+	Bytes int
+	// Digits of a numeric code
+	Digits int
+}
+
+// Numeric reports whether tokens are short numeric codes
+func (p TokenPolicy) Numeric() bool {
+	return p.Format == tokenFormatNumeric
+}
+
+// Generate returns a new token drawn from crypto/rand. Every secret the
+// service hands out (reset, verification, recovery and refresh tokens and
+// SMS codes) comes from here.
+func (p TokenPolicy) Generate() (string, error) {
+	if p.Numeric() {
+		limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(p.Digits)), nil)
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%0*d", p.Digits, n), nil
+	}
+	raw := make([]byte, p.Bytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	if p.Format == tokenFormatHex {
+		return hex.EncodeToString(raw), nil
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Digest is the stored form of a token. A numeric code is hashed together
+// with the account it was sent to: its few digits are only hard to guess
+// for one account, not across all of them.
+func (p TokenPolicy) Digest(userID uuid.UUID, token string) string {
+	if p.Numeric() {
+		return hashToken(userID.String() + ":" + token)
+	}
+	return hashToken(token)
+}
+
+// tokenKind is a family of tokens whose policy is read from the environment
+// as <prefix>_FORMAT, <prefix>_BYTES and <prefix>_DIGITS
+type tokenKind struct {
+	prefix string
+	// formats lists the accepted formats; the first is the default
+	formats      []string
+	defaultBytes int
+	minBytes     int
+}
+
+var (
+	passwordResetTokens = tokenKind{
+		prefix:       "PASSWORD_RESET_TOKEN",
+		formats:      []string{tokenFormatURLSafe, tokenFormatNumeric},
+		defaultBytes: 32,
+		minBytes:     16,
+	}
+	verificationTokens = tokenKind{
+		prefix:       "EMAIL_VERIFICATION_TOKEN",
+		formats:      []string{tokenFormatURLSafe, tokenFormatNumeric},
+		defaultBytes: 32,
+		minBytes:     16,
+	}
+	// Recovery codes are typed in from a printout, so they default to ten hex
+	// characters; they are single use and only work after the password.
+	recoveryCodeTokens = tokenKind{
+		prefix:       "RECOVERY_CODE",
+		formats:      []string{tokenFormatHex, tokenFormatURLSafe},
+		defaultBytes: 5,
+		minBytes:     5,
+	}
+)
+
+// Fixed policies for tokens that are never typed by hand, and for SMS codes
+var (
+	linkTokenPolicy    = TokenPolicy{Format: tokenFormatURLSafe, Bytes: 32}
+	refreshTokenPolicy = TokenPolicy{Format: tokenFormatURLSafe, Bytes: 32}
+	smsCodePolicy      = TokenPolicy{Format: tokenFormatNumeric, Digits: 6}
+)
+
+// load reads and checks the kind's policy
+func (k tokenKind) load() (TokenPolicy, error) {
+	policy := TokenPolicy{
+		Format: strings.ToLower(getEnv(k.prefix+"_FORMAT", k.formats[0])),
+		Bytes:  getEnvInt(k.prefix+"_BYTES", k.defaultBytes),
+		Digits: getEnvInt(k.prefix+"_DIGITS", minTokenDigits),
+	}
+	known := false
+	for _, format := range k.formats {
+		known = known || policy.Format == format
+	}
+	switch {
+	case !known:
+		return policy, fmt.Errorf("%s_FORMAT must be one of %s", k.prefix, strings.Join(k.formats, ", "))
+	case policy.Numeric() && (policy.Digits < minTokenDigits || policy.Digits > maxTokenDigits):
+		return policy, fmt.Errorf("%s_DIGITS must be between %d and %d", k.prefix, minTokenDigits, maxTokenDigits)
+	case !policy.Numeric() && (policy.Bytes < k.minBytes || policy.Bytes > maxTokenBytes):
+		return policy, fmt.Errorf("%s_BYTES must be between %d and %d", k.prefix, k.minBytes, maxTokenBytes)
+	}
+	return policy, nil
+}
+
+// Policy returns the configured policy. validateTokenPolicies rejects bad
+// settings at startup; should one appear later anyway, the default is used
+// rather than issuing weaker tokens.
+func (k tokenKind) Policy() TokenPolicy {
+	policy, err := k.load()
+	if err != nil {
+		return TokenPolicy{Format: k.formats[0], Bytes: k.defaultBytes, Digits: minTokenDigits}
+	}
+	return policy
+}
+
+// validateTokenPolicies checks every configurable token kind
+func validateTokenPolicies() error {
+	var errs []error
+	for _, kind := range []tokenKind{passwordResetTokens, verificationTokens, recoveryCodeTokens} {
+		if _, err := kind.load(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if otpMaxAttempts() < 1 {
+		errs = append(errs, errors.New("OTP_MAX_ATTEMPTS must be at least 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// otpMaxAttempts is how many wrong numeric codes an outstanding reset or
+// verification code survives (OTP_MAX_ATTEMPTS)
+func otpMaxAttempts() int {
+	return getEnvInt("OTP_MAX_ATTEMPTS", 5)
+}
+
+// reserveOTPAttempt spends one of the user's guesses at an outstanding code
+// before it is compared, reporting false once all max are used. The counter
+// is bumped by a conditional update, so concurrent guesses each claim their
+// own attempt and can never exceed the limit.
+func reserveOTPAttempt(db *gorm.DB, userID uuid.UUID, column string, max int) (bool, error) {
+	result := db.Exec("UPDATE users SET "+column+" = "+column+" + 1 WHERE id = ? AND "+column+" < ?", userID, max)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// discardSpentOTP clears the discard columns and the attempt counter once all
+// OTP_MAX_ATTEMPTS guesses at the outstanding code are reserved, so a wrong
+// last guess retires the code. It is a no-op while guesses remain.
+func discardSpentOTP(db *gorm.DB, userID uuid.UUID, column string, discard map[string]interface{}) error {
+	discard[column] = 0
+	return db.Model(&User{}).Where("id = ? AND "+column+" >= ?", userID, otpMaxAttempts()).Updates(discard).Error
+}
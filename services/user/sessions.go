@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// sessionMetadata describes the client a refresh token is issued to
+type sessionMetadata struct {
+	ID        uuid.UUID
+	StartedAt time.Time
+	IPAddress string
+	UserAgent string
+}
+
+// newSessionMetadata starts a session for the requesting client
+func newSessionMetadata(c *gin.Context) sessionMetadata {
+	return sessionMetadata{
+		ID:        uuid.New(),
+		StartedAt: time.Now(),
+		IPAddress: middleware.ClientIP(c),
+		UserAgent: truncate(c.Request.UserAgent(), maxUserAgentLength),
+	}
+}
+
+var (
+	browserLabels = []struct{ token, label string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	}
+	platformLabels = []struct{ token, label string }{
+		{"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Android", "Android"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}
+)
+
+// deviceLabel summarises a user agent as e.g. "Firefox on Linux"
+func deviceLabel(userAgent string) string {
+	browser, platform := "", ""
+	for _, b := range browserLabels {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.label
+			break
+		}
+	}
+	for _, p := range platformLabels {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.label
+			break
+		}
+	}
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	return "Unknown device"
+}
+
+// Session is one signed-in client, backed by its current refresh token
+type Session struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	IPAddress   string    `json:"ip_address"`
+	UserAgent   string    `json:"user_agent"`
+	DeviceLabel string    `json:"device_label"`
+	Current     bool      `json:"current"`
+}
+
+// activeSessions scopes refresh tokens to the user's active ones; rotation
+// leaves exactly one per session
+func activeSessions(userID string) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&RefreshToken{}).Where("user_id = ? AND revoked = ? AND expires_at > ?", userID, false, time.Now())
+	}
+}
+
+// ListSessions returns the caller's active sessions, most recently started first
+func ListSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var tokens []RefreshToken
+		if err := db.Scopes(activeSessions(c.GetString("user_id"))).
+			Order("session_started_at DESC").
+			Find(&tokens).Error; err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch sessions")
+			return
+		}
+
+		current := c.GetString("session_id")
+		sessions := make([]Session, len(tokens))
+		for i, t := range tokens {
+			// The current refresh token was issued when the session was last refreshed
+			sessions[i] = Session{
+				ID:          t.SessionID,
+				CreatedAt:   t.SessionStartedAt,
+				LastUsedAt:  t.CreatedAt,
+				ExpiresAt:   t.ExpiresAt,
+				IPAddress:   t.IPAddress,
+				UserAgent:   t.UserAgent,
+				DeviceLabel: t.DeviceLabel,
+				Current:     t.SessionID.String() == current,
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+	}
+}
+
+// RevokeSession ends one of the caller's sessions; its refresh token stops
+// working immediately
+func RevokeSession(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		sessionID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_SESSION_ID", "Invalid session ID")
+			return
+		}
+
+		result := db.Scopes(activeSessions(c.GetString("user_id"))).
+			Where("session_id = ?", sessionID).
+			Update("revoked", true)
+		if result.Error != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to revoke session")
+			return
+		}
+		if result.RowsAffected == 0 {
+			respondError(c, http.StatusNotFound, "SESSION_NOT_FOUND", "Session not found")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+	}
+}
+
+// RevokeOtherSessions ends every session of the caller except the one the
+// access token belongs to
+func RevokeOtherSessions(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		query := db.Scopes(activeSessions(c.GetString("user_id")))
+		if current, err := uuid.Parse(c.GetString("session_id")); err == nil {
+			query = query.Where("session_id <> ?", current)
+		}
+		result := query.Update("revoked", true)
+		if result.Error != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to revoke sessions")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked successfully", "revoked": result.RowsAffected})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// localUploadsRoute is where the service serves files from LocalStorage
+const localUploadsRoute = "/uploads"
+
+// Storage persists uploaded files and returns a public URL for them
+type Storage interface {
+	Put(ctx context.Context, key, contentType string, body io.Reader, size int64) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStorage builds the backend selected by STORAGE_PROVIDER (local or s3)
+func NewStorage() (Storage, error) {
+	switch provider := getEnv("STORAGE_PROVIDER", "local"); provider {
+	case "local":
+		return NewLocalStorage(getEnv("STORAGE_LOCAL_DIR", "./uploads"), getEnv("STORAGE_PUBLIC_URL", routePrefix()+localUploadsRoute))
+	case "s3":
+		return NewS3Storage()
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_PROVIDER %q", provider)
+	}
+}
+
+// LocalStorage writes files under a directory that the service serves itself
+type LocalStorage struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocalStorage creates dir if needed; baseURL is the path or URL it is served under
+func NewLocalStorage(dir, baseURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// Dir is the directory files are written to
+func (s *LocalStorage) Dir() string {
+	return s.dir
+}
+
+func (s *LocalStorage) Put(ctx context.Context, key, contentType string, body io.Reader, size int64) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	// Write to a temp file first so readers never see a partial upload
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return s.baseURL + "/" + key, nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// S3Storage stores files in an S3-compatible bucket (AWS, MinIO, R2, ...)
+type S3Storage struct {
+	client  *minio.Client
+	bucket  string
+	baseURL string
+}
+
+// NewS3Storage configures the bucket from S3_* environment variables
+func NewS3Storage() (*S3Storage, error) {
+	endpoint := getEnv("S3_ENDPOINT", "s3.amazonaws.com")
+	bucket := getEnv("S3_BUCKET", "")
+	if bucket == "" {
+		return nil, fmt.Errorf("S3_BUCKET is required when STORAGE_PROVIDER=s3")
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(getEnv("S3_ACCESS_KEY_ID", ""), getEnv("S3_SECRET_ACCESS_KEY", ""), ""),
+		Secure: getEnvBool("S3_USE_SSL", true),
+		Region: getEnv("S3_REGION", ""),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := getEnv("STORAGE_PUBLIC_URL", "")
+	if baseURL == "" {
+		scheme := "https"
+		if !getEnvBool("S3_USE_SSL", true) {
+			scheme = "http"
+		}
+		baseURL = (&url.URL{Scheme: scheme, Host: endpoint, Path: "/" + bucket}).String()
+	}
+	return &S3Storage{client: client, bucket: bucket, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key, contentType string, body io.Reader, size int64) (string, error) {
+	_, err := s.client.PutObject(ctx, s.bucket, key, body, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return "", err
+	}
+	return s.baseURL + "/" + key, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AccountSummary is a dashboard overview of the caller's account
+type AccountSummary struct {
+	CreatedAt        time.Time  `json:"created_at"`
+	AccountAgeDays   int        `json:"account_age_days"`
+	AddressCount     int64      `json:"address_count"`
+	LastLoginAt      *time.Time `json:"last_login_at"`
+	ActiveSessions   int64      `json:"active_sessions"`
+	EmailVerified    bool       `json:"email_verified"`
+	PhoneVerified    bool       `json:"phone_verified"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+}
+
+// accountSummaryQuery gathers the summary in one round trip; sessions are
+// refresh tokens that are neither revoked nor expired
+const accountSummaryQuery = `
+SELECT u.created_at,
+	u.is_verified AS email_verified,
+	u.phone_verified,
+	u.two_factor_enabled,
+	(SELECT COUNT(*) FROM addresses a WHERE a.user_id = u.id AND a.deleted_at IS NULL) AS address_count,
+	(SELECT MAX(l.created_at) FROM login_events l WHERE l.user_id = u.id AND l.outcome = ?) AS last_login_at,
+	(SELECT COUNT(*) FROM refresh_tokens r WHERE r.user_id = u.id AND r.revoked = false AND r.expires_at > ?) AS active_sessions
+FROM users u
+WHERE u.id = ? AND u.deleted_at IS NULL`
+
+// GetAccountSummary returns aggregate information about the caller's account
+func GetAccountSummary(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		now := time.Now()
+
+		var summary AccountSummary
+		result := db.Raw(accountSummaryQuery, LoginOutcomeSuccess, now, c.GetString("user_id")).Scan(&summary)
+		if result.Error != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to fetch account summary")
+			return
+		}
+		if result.RowsAffected == 0 {
+			respondError(c, http.StatusNotFound, "USER_NOT_FOUND", "User not found")
+			return
+		}
+
+		summary.AccountAgeDays = int(now.Sub(summary.CreatedAt).Hours() / 24)
+		c.JSON(http.StatusOK, summary)
+	}
+}
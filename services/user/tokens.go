@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+var errRefreshTokenRotated = errors.New("refresh token already rotated")
+
+// TokenPair holds the credentials issued to a client after authentication
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// TokenService signs access tokens with the configured secret, issuer and lifetime
+type TokenService struct {
+	secret string
+	issuer string
+	expiry time.Duration
+}
+
+// NewTokenService loads the JWT settings from the environment. Outside of
+// production a missing JWT_SECRET falls back to an insecure development key;
+// in production (APP_ENV=production) it is a startup error.
+func NewTokenService() (*TokenService, error) {
+	secret := getEnv("JWT_SECRET", "")
+	if secret == "" {
+		if isProduction() {
+			return nil, errors.New("JWT_SECRET must be set when APP_ENV=production")
+		}
+		zap.L().Warn("JWT_SECRET is not set, using an insecure development secret")
+		secret = "insecure-development-secret"
+	}
+	return &TokenService{
+		secret: secret,
+		issuer: getEnv("JWT_ISSUER", "user-service"),
+		expiry: getEnvDuration("JWT_EXPIRY", 15*time.Minute),
+	}, nil
+}
+
+func isProduction() bool {
+	return getEnv("APP_ENV", "") == "production"
+}
+
+func refreshTokenTTL() time.Duration {
+	return getEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour)
+}
+
+// hashToken returns the hex encoded SHA-256 digest of an opaque token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAccessToken signs a short-lived JWT for the user. sid ties it to
+// the refresh token session it was issued with.
+func (ts *TokenService) GenerateAccessToken(user *User, sessionID uuid.UUID) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti":     uuid.NewString(),
+		"iss":     ts.issuer,
+		"iat":     now.Unix(),
+		"exp":     now.Add(ts.expiry).Unix(),
+		"user_id": user.ID.String(),
+		"role":    user.Role,
+		"sid":     sessionID.String(),
+	})
+	return token.SignedString([]byte(ts.secret))
+}
+
+// createRefreshToken generates a new opaque refresh token in session and persists its hash
+func createRefreshToken(db *gorm.DB, userID uuid.UUID, session sessionMetadata) (string, error) {
+	token, err := refreshTokenPolicy.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	refreshToken := RefreshToken{
+		UserID:           userID,
+		SessionID:        session.ID,
+		SessionStartedAt: session.StartedAt,
+		TokenHash:        hashToken(token),
+		ExpiresAt:        time.Now().Add(refreshTokenTTL()),
+		IPAddress:        session.IPAddress,
+		UserAgent:        session.UserAgent,
+		DeviceLabel:      deviceLabel(session.UserAgent),
+	}
+	if err := db.Create(&refreshToken).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// IssueTokenPair creates a fresh access token and refresh token for the user
+func (ts *TokenService) IssueTokenPair(db *gorm.DB, user *User, session sessionMetadata) (*TokenPair, error) {
+	accessToken, err := ts.GenerateAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := createRefreshToken(db, user.ID, session)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(ts.expiry.Seconds()),
+	}, nil
+}
+
+// revokeUserRefreshTokens invalidates every outstanding refresh token for the user
+func revokeUserRefreshTokens(db *gorm.DB, userID uuid.UUID) error {
+	return db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked = ?", userID, false).
+		Update("revoked", true).Error
+}
+
+// revocationStore checks access token revocations against the database
+type revocationStore struct {
+	db *gorm.DB
+}
+
+func (s *revocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	err := retryRead(ctx, func() error {
+		return s.db.WithContext(ctx).Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// startRevokedTokenCleanup periodically removes revocation entries whose tokens
+// have expired anyway, keeping the table from growing unbounded
+func startRevokedTokenCleanup(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("revoked-token-cleanup", defaultHeartbeatMaxAge(interval))
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("revoked-token-cleanup")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("revoked-token-cleanup")
+				if !leadership.Runs("revoked-token-cleanup") {
+					continue
+				}
+				result := db.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{})
+				if result.Error != nil {
+					zap.L().Error("Failed to clean up revoked tokens", zap.Error(result.Error))
+				} else if result.RowsAffected > 0 {
+					zap.L().Info("Removed expired revoked tokens", zap.Int64("count", result.RowsAffected))
+				}
+			}
+		}
+	}()
+}
+
+type ValidateTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// inactiveTokenReason explains why a token whose account is not active is
+// invalid, or returns "" for an active account
+func inactiveTokenReason(state middleware.AccountState) string {
+	switch {
+	case !state.Exists:
+		return "user not found"
+	case state.Status != UserStatusActive:
+		return "account " + state.Status
+	}
+	return ""
+}
+
+// ValidateToken lets internal services check an access token, including
+// revocation and the account status, without holding the signing secret
+func ValidateToken(db *gorm.DB, tokenService *TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req ValidateTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		claims, err := middleware.ParseAccessToken(c.Request.Context(), req.Token, tokenService.secret, tokenService.issuer, &revocationStore{db: db})
+		if errors.Is(err, middleware.ErrTokenCheckFailed) {
+			respondError(c, http.StatusServiceUnavailable, "TOKEN_CHECK_FAILED", "Failed to verify token")
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"valid":   false,
+				"revoked": errors.Is(err, middleware.ErrTokenRevoked),
+				"reason":  err.Error(),
+			})
+			return
+		}
+		// A valid token of a deleted, suspended or locked account grants nothing
+		state, err := (&accountStore{db: db}).AccountState(c.Request.Context(), claims.UserID)
+		if err != nil {
+			respondError(c, http.StatusServiceUnavailable, "TOKEN_CHECK_FAILED", "Failed to verify token")
+			return
+		}
+		if reason := inactiveTokenReason(state); reason != "" {
+			c.JSON(http.StatusOK, gin.H{"valid": false, "revoked": false, "reason": reason})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"valid":      true,
+			"revoked":    false,
+			"user_id":    claims.UserID,
+			"role":       claims.Role,
+			"jti":        claims.JTI,
+			"expires_at": claims.ExpiresAt,
+		})
+	}
+}
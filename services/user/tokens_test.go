@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/google/uuid"
+)
+
+func TestNewTokenServiceRequiresSecretInProduction(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("APP_ENV", "production")
+	if _, err := NewTokenService(); err == nil {
+		t.Fatal("NewTokenService without JWT_SECRET in production succeeded")
+	}
+
+	t.Setenv("APP_ENV", "development")
+	if _, err := NewTokenService(); err != nil {
+		t.Fatalf("NewTokenService outside production: %v", err)
+	}
+}
+
+func TestAccessTokenClaims(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("JWT_ISSUER", "test-issuer")
+	t.Setenv("JWT_EXPIRY", "5m")
+	ts, err := NewTokenService()
+	if err != nil {
+		t.Fatalf("NewTokenService: %v", err)
+	}
+
+	user := &User{ID: uuid.New(), Role: "user"}
+	token, err := ts.GenerateAccessToken(user, uuid.New())
+	if err != nil {
+		t.Fatalf("GenerateAccessToken: %v", err)
+	}
+
+	claims, err := middleware.ParseAccessToken(context.Background(), token, ts.secret, ts.issuer, nil)
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if claims.UserID != user.ID.String() {
+		t.Errorf("UserID = %q, want %q", claims.UserID, user.ID)
+	}
+	if ttl := time.Until(claims.ExpiresAt); ttl <= 4*time.Minute || ttl > 5*time.Minute {
+		t.Errorf("token expires in %v, want about 5m", ttl)
+	}
+
+	// Tokens from a service with another issuer are refused
+	if _, err := middleware.ParseAccessToken(context.Background(), token, ts.secret, "other-issuer", nil); !errors.Is(err, middleware.ErrInvalidToken) {
+		t.Errorf("wrong issuer: err = %v, want %v", err, middleware.ErrInvalidToken)
+	}
+}
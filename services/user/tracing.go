@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.uber.org/zap"
+)
+
+// initTracing installs the global tracer provider and W3C trace context
+// propagator. Spans are exported over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT;
+// when it is unset spans are still created (so trace IDs propagate) but never
+// exported. The returned function flushes and stops the provider.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceID),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	if getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "") != "" {
+		// The exporter reads the endpoint and headers from the standard OTEL_* variables
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	} else {
+		zap.L().Info("OTEL_EXPORTER_OTLP_ENDPOINT not set, traces will not be exported")
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount     = 10
+	challengeTokenType    = "2fa_challenge"
+	challengeTokenTimeout = 5 * time.Minute
+	// totpPeriod is the lifetime in seconds of one TOTP code
+	totpPeriod = 30
+)
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+// GenerateChallengeToken signs a short-lived token proving the password step
+// of a 2FA login succeeded. It carries no user_id claim, so AuthMiddleware
+// never accepts it as an access token.
+func (ts *TokenService) GenerateChallengeToken(user *User) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": user.ID.String(),
+		"typ": challengeTokenType,
+		"iss": ts.issuer,
+		"iat": now.Unix(),
+		"exp": now.Add(challengeTokenTimeout).Unix(),
+	})
+	return token.SignedString([]byte(ts.secret))
+}
+
+// ParseChallengeToken validates a 2FA challenge token and returns the user ID
+func (ts *TokenService) ParseChallengeToken(tokenString string) (string, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(ts.secret), nil
+	})
+	if err != nil || !token.Valid {
+		return "", errors.New("invalid challenge token")
+	}
+	if claims["typ"] != challengeTokenType || !claims.VerifyIssuer(ts.issuer, true) {
+		return "", errors.New("invalid challenge token")
+	}
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return "", errors.New("invalid challenge token")
+	}
+	return sub, nil
+}
+
+// EnableTwoFactor generates a TOTP secret for the user. 2FA only becomes
+// active after the first code is confirmed via VerifyTwoFactor.
+func EnableTwoFactor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var user User
+		if err := db.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+		if user.TwoFactorEnabled {
+			respondError(c, http.StatusConflict, "2FA_ALREADY_ENABLED", "Two-factor authentication is already enabled")
+			return
+		}
+
+		key, err := totp.Generate(totp.GenerateOpts{
+			Issuer:      getEnv("TOTP_ISSUER", "E-Commerce Platform"),
+			AccountName: user.Email,
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate secret")
+			return
+		}
+
+		// EncryptedString seals the secret on write
+		if err := db.Model(&user).Update("totp_secret", EncryptedString(key.Secret())).Error; err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to store secret")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":           key.Secret(),
+			"provisioning_uri": key.URL(),
+		})
+	}
+}
+
+// VerifyTwoFactor confirms the first TOTP code, activates 2FA and returns a
+// fresh set of recovery codes. The codes are only ever shown here.
+func VerifyTwoFactor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req TwoFactorCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+		if user.TwoFactorEnabled {
+			respondError(c, http.StatusConflict, "2FA_ALREADY_ENABLED", "Two-factor authentication is already enabled")
+			return
+		}
+		if user.TOTPSecret == "" {
+			respondError(c, http.StatusBadRequest, "2FA_NOT_STARTED", "Two-factor setup has not been started")
+			return
+		}
+
+		valid, err := useTOTP(db, &user, req.Code)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+		if !valid {
+			respondError(c, http.StatusUnauthorized, "INVALID_2FA_CODE", "Invalid verification code")
+			return
+		}
+
+		var codes []string
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			var err error
+			if codes, err = replaceRecoveryCodes(tx, user.ID); err != nil {
+				return err
+			}
+			return tx.Model(&user).Update("two_factor_enabled", true).Error
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to enable two-factor authentication")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Two-factor authentication enabled",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// DisableTwoFactor turns 2FA off after re-checking the password and a code
+func DisableTwoFactor(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req DisableTwoFactorRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", c.GetString("user_id")).Error; err != nil {
+			respondLookupError(c, err, "USER_NOT_FOUND", "User not found", "Failed to fetch profile")
+			return
+		}
+		if !user.TwoFactorEnabled {
+			respondError(c, http.StatusBadRequest, "2FA_NOT_ENABLED", "Two-factor authentication is not enabled")
+			return
+		}
+		if err := user.ComparePassword(req.Password); err != nil {
+			respondError(c, http.StatusUnauthorized, "INVALID_CREDENTIALS", "Invalid credentials")
+			return
+		}
+		valid, err := useTOTP(db, &user, req.Code)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+		if !valid {
+			respondError(c, http.StatusUnauthorized, "INVALID_2FA_CODE", "Invalid verification code")
+			return
+		}
+
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&user).Updates(map[string]interface{}{
+				"two_factor_enabled": false,
+				"totp_secret":        "",
+			}).Error
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to disable two-factor authentication")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+	}
+}
+
+// LoginTwoFactor completes a login that was paused for a second factor,
+// accepting either a TOTP code or an unused recovery code
+func LoginTwoFactor(db *gorm.DB, tokenService *TokenService, emailService *EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req LoginTwoFactorRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		if req.Code == "" && req.RecoveryCode == "" {
+			respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Either code or recovery_code is required")
+			return
+		}
+
+		userID, err := tokenService.ParseChallengeToken(req.ChallengeToken)
+		if err != nil {
+			respondError(c, http.StatusUnauthorized, "INVALID_CHALLENGE", "Invalid or expired challenge token")
+			return
+		}
+
+		var user User
+		if err := db.First(&user, "id = ?", userID).Error; err != nil || !user.TwoFactorEnabled {
+			respondError(c, http.StatusUnauthorized, "INVALID_CHALLENGE", "Invalid or expired challenge token")
+			return
+		}
+		if !user.IsActive() {
+			outcome := inactiveLoginOutcome(&user)
+			failedLoginsTotal.WithLabelValues(outcome).Inc()
+			recordLoginEvent(c, db, &user, "", outcome)
+			respondInactiveAccount(c, &user)
+			return
+		}
+		if user.IsLocked() {
+			failedLoginsTotal.WithLabelValues("locked").Inc()
+			recordLoginEvent(c, db, &user, "", LoginOutcomeLocked)
+			respondLocked(c, user.LockRemaining())
+			return
+		}
+
+		var valid bool
+		if req.Code != "" {
+			valid, err = useTOTP(db, &user, req.Code)
+		} else {
+			valid, err = consumeRecoveryCode(db, user.ID, req.RecoveryCode)
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+
+		if !valid {
+			failedLoginsTotal.WithLabelValues("bad_2fa_code").Inc()
+			recordLoginEvent(c, db, &user, "", LoginOutcomeBad2FACode)
+			locked, lockErr := recordFailedLogin(db, &user)
+			if lockErr != nil {
+				respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+				return
+			}
+			if locked {
+				respondLocked(c, user.LockRemaining())
+				return
+			}
+			respondError(c, http.StatusUnauthorized, "INVALID_2FA_CODE", "Invalid verification code")
+			return
+		}
+
+		completeLogin(c, db, tokenService, emailService, &user)
+	}
+}
+
+// matchTOTP returns the time step a code was generated for, checking the
+// current step and one either side to allow for clock drift
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	step := now.Unix() / totpPeriod
+	for _, counter := range []int64{step - 1, step, step + 1} {
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(counter*totpPeriod, 0), opts)
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// useTOTP accepts a code only if its time step is later than the last one
+// used. The step is claimed with a conditional update, so a replayed code, or
+// the same code submitted twice at once, is refused.
+func useTOTP(db *gorm.DB, user *User, code string) (bool, error) {
+	if user.TOTPSecret == "" {
+		return false, nil
+	}
+	counter, ok := matchTOTP(string(user.TOTPSecret), code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	result := db.Model(&User{}).
+		Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+		Update("totp_last_counter", counter)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// replaceRecoveryCodes discards any existing recovery codes and stores hashes
+// of a newly generated set, returning the plaintext codes
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	policy := recoveryCodeTokens.Policy()
+	codes := make([]string, recoveryCodeCount)
+	records := make([]RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := policy.Generate()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		records[i] = RecoveryCode{UserID: userID, CodeHash: hashToken(codes[i])}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// consumeRecoveryCode marks a matching unused recovery code as used
+func consumeRecoveryCode(db *gorm.DB, userID uuid.UUID, code string) (bool, error) {
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(strings.TrimSpace(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
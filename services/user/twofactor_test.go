@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func totpCode(t *testing.T, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(testTOTPSecret, at, totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1})
+	if err != nil {
+		t.Fatalf("generate code: %v", err)
+	}
+	return code
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	step := now.Unix() / totpPeriod
+	tests := []struct {
+		name        string
+		at          time.Time
+		wantCounter int64
+		wantOK      bool
+	}{
+		{name: "current step", at: now, wantCounter: step, wantOK: true},
+		{name: "previous step", at: now.Add(-totpPeriod * time.Second), wantCounter: step - 1, wantOK: true},
+		{name: "next step", at: now.Add(totpPeriod * time.Second), wantCounter: step + 1, wantOK: true},
+		{name: "too old", at: now.Add(-2 * totpPeriod * time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := matchTOTP(testTOTPSecret, " "+totpCode(t, tt.at)+" ", now)
+			if ok != tt.wantOK || counter != tt.wantCounter {
+				t.Errorf("matchTOTP = %d, %v; want %d, %v", counter, ok, tt.wantCounter, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestUseTOTPRejectsReplay(t *testing.T) {
+	claimed := int64(0)
+	db, fake := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		if !strings.Contains(query, "totp_last_counter <") {
+			return fakeResult{}
+		}
+		// The conditional update only matches while the step is unused
+		counter := args[len(args)-1].Value.(int64)
+		if counter <= claimed {
+			return fakeResult{}
+		}
+		claimed = counter
+		return fakeResult{affected: 1}
+	})
+	user := &User{ID: uuid.New(), TOTPSecret: EncryptedString(testTOTPSecret)}
+	code := totpCode(t, time.Now())
+
+	if ok, err := useTOTP(db, user, code); err != nil || !ok {
+		t.Fatalf("first use = %v, %v; want true", ok, err)
+	}
+	if ok, err := useTOTP(db, user, code); err != nil || ok {
+		t.Fatalf("replay = %v, %v; want false", ok, err)
+	}
+	if ok, err := useTOTP(db, user, "not-a-code"); err != nil || ok {
+		t.Fatalf("wrong code = %v, %v; want false", ok, err)
+	}
+	if !fake.executed(`UPDATE "users" SET "totp_last_counter"`) {
+		t.Error("useTOTP did not claim the time step")
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+// inTransaction reports whether db is already bound to an open transaction
+func inTransaction(db *gorm.DB) bool {
+	_, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok
+}
+
+// WithTransaction runs fn in a transaction bound to ctx, committing if fn
+// returns nil and rolling back otherwise, including on panic. Cancelling ctx
+// aborts the transaction. When db is already a transaction, fn runs in it
+// directly so helpers compose without nesting savepoints.
+func WithTransaction(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	if inTransaction(db) {
+		return fn(db.WithContext(ctx))
+	}
+	return db.WithContext(ctx).Transaction(fn, opts...)
+}
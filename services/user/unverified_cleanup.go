@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// unverifiedCleanupLockID is the Postgres advisory lock that keeps the
+	// janitor to one instance at a time
+	unverifiedCleanupLockID = 7400001
+	// unverifiedCleanupBatchSize caps how many accounts one run removes, so
+	// the transaction stays short
+	unverifiedCleanupBatchSize = 500
+)
+
+// unverifiedAccountTTL is how long an account may stay unverified before the
+// janitor removes it (UNVERIFIED_ACCOUNT_TTL)
+func unverifiedAccountTTL() time.Duration {
+	return getEnvDuration("UNVERIFIED_ACCOUNT_TTL", 7*24*time.Hour)
+}
+
+// unverifiedAccountStrategy is how expired accounts are removed: purge
+// (hard delete) or anonymize (UNVERIFIED_ACCOUNT_STRATEGY)
+func unverifiedAccountStrategy() string {
+	if getEnv("UNVERIFIED_ACCOUNT_STRATEGY", DeletionPurge) == DeletionAnonymize {
+		return DeletionAnonymize
+	}
+	return DeletionPurge
+}
+
+// expireUnverifiedAccounts removes one batch of accounts that never verified
+// their email within the TTL, freeing the addresses for re-registration.
+// Admins are never removed. It does nothing while another instance holds the
+// advisory lock.
+func expireUnverifiedAccounts(ctx context.Context, db *gorm.DB, events EventPublisher) (int, error) {
+	cutoff := time.Now().Add(-unverifiedAccountTTL())
+	strategy := unverifiedAccountStrategy()
+	var expired int
+	err := WithTransaction(ctx, db, func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", unverifiedCleanupLockID).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		var users []User
+		if err := tx.Where("is_verified = ? AND created_at < ? AND role <> ?", false, cutoff, RoleAdmin).
+			Order("created_at ASC").
+			Limit(unverifiedCleanupBatchSize).
+			Find(&users).Error; err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, len(users))
+		for i := range users {
+			ids[i] = users[i].ID
+			if err := publishUserEvent(tx, events, EventUserDeleted, &users[i]); err != nil {
+				return err
+			}
+			if strategy == DeletionAnonymize {
+				if err := anonymizeAccount(tx, &users[i]); err != nil {
+					return err
+				}
+			}
+		}
+		if strategy == DeletionPurge {
+			if _, err := hardDeleteAccounts(tx, ids); err != nil {
+				return err
+			}
+		}
+		expired = len(users)
+		return nil
+	})
+	return expired, err
+}
+
+// startUnverifiedAccountCleanup runs expireUnverifiedAccounts on a ticker
+// until ctx is cancelled. It is off when email verification isn't required,
+// since unverified accounts are then in normal use.
+func startUnverifiedAccountCleanup(ctx context.Context, db *gorm.DB, events EventPublisher, interval time.Duration) {
+	if !requireEmailVerification() {
+		zap.L().Info("Email verification is not required, unverified account cleanup is disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("unverified-account-cleanup", defaultHeartbeatMaxAge(interval))
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("unverified-account-cleanup")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("unverified-account-cleanup")
+				if !leadership.Runs("unverified-account-cleanup") {
+					continue
+				}
+				expired, err := expireUnverifiedAccounts(ctx, db, events)
+				if err != nil {
+					zap.L().Error("Failed to expire unverified accounts", zap.Error(err))
+				} else {
+					zap.L().Info("Expired unverified accounts", zap.Int("count", expired), zap.String("strategy", unverifiedAccountStrategy()))
+				}
+			}
+		}
+	}()
+}
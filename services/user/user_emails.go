@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserEmail is one of a user's email addresses. Each user has one primary
+// row, which mirrors User.Email and User.IsVerified; the copy on the user
+// stays so logins can look accounts up without a join. Only a verified
+// address is unique across accounts: several may claim one until one of them
+// verifies it, so nobody can squat an address they can't read.
+type UserEmail struct {
+	ID                    uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+	UserID                uuid.UUID  `gorm:"type:uuid;not null;index;uniqueIndex:idx_user_emails_primary,where:is_primary;uniqueIndex:idx_user_emails_user_email,priority:1" json:"-"`
+	Email                 string     `gorm:"not null;uniqueIndex:idx_user_emails_email,where:verified_at IS NOT NULL;uniqueIndex:idx_user_emails_user_email,priority:2" json:"email"`
+	IsPrimary             bool       `gorm:"default:false;not null" json:"is_primary"`
+	VerifiedAt            *time.Time `json:"verified_at"`
+	VerificationTokenHash string     `gorm:"index" json:"-"`
+	VerificationSentAt    *time.Time `json:"-"`
+}
+
+// IsVerified reports whether the owner has confirmed the address
+func (e *UserEmail) IsVerified() bool {
+	return e.VerifiedAt != nil
+}
+
+// maxEmailsPerUser caps how many addresses, the primary included, one
+// account may hold (MAX_EMAILS_PER_USER)
+func maxEmailsPerUser() int {
+	return getEnvInt("MAX_EMAILS_PER_USER", 5)
+}
+
+// errEmailTaken is returned when an address already belongs to another account
+var errEmailTaken = &UniqueViolationError{Constraint: userEmailsEmailIndex, err: gorm.ErrDuplicatedKey}
+
+// syncPrimaryEmail makes user.Email the user's primary row, carrying over
+// user.IsVerified. A previous primary address is dropped, as an email change
+// releases the old address. Once verified, it fails with a unique violation
+// when another account has already verified the address.
+func syncPrimaryEmail(tx *gorm.DB, user *User) error {
+	if err := tx.Where("user_id = ? AND is_primary AND email <> ?", user.ID, user.Email).Delete(&UserEmail{}).Error; err != nil {
+		return err
+	}
+	row := UserEmail{UserID: user.ID, Email: user.Email, IsPrimary: true}
+	if user.IsVerified {
+		now := time.Now()
+		row.VerifiedAt = &now
+	}
+	// The address may already be one of the user's secondary ones
+	if err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "email"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "is_primary"}, Value: true},
+			{Column: clause.Column{Name: "verified_at"}, Value: gorm.Expr("COALESCE(user_emails.verified_at, excluded.verified_at)")},
+			{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("excluded.updated_at")},
+		},
+	}).Create(&row).Error; err != nil {
+		return err
+	}
+	if !user.IsVerified {
+		return nil
+	}
+	return releaseEmailClaims(tx, user.ID, user.Email)
+}
+
+// releaseEmailClaims drops other accounts' unverified rows for an address
+// that ownerID has just verified
+func releaseEmailClaims(tx *gorm.DB, ownerID uuid.UUID, email string) error {
+	return tx.Where("email = ? AND user_id <> ? AND verified_at IS NULL", email, ownerID).Delete(&UserEmail{}).Error
+}
+
+// userForPasswordReset finds the account a reset link for email may be sent
+// to: the one with it as primary address, or as a verified secondary one
+func userForPasswordReset(db *gorm.DB, email string) (User, error) {
+	var user User
+	err := db.Where("email = ?", email).First(&user).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+	owner := db.Model(&UserEmail{}).Select("user_id").Where("email = ? AND verified_at IS NOT NULL", email)
+	err = db.Where("id = (?)", owner).First(&user).Error
+	return user, err
+}
+
+type AddEmailRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// findUserEmail loads one of the user's addresses by the :id path parameter,
+// writing the 404 or 500 itself when it can't
+func findUserEmail(c *gin.Context, db *gorm.DB, userID string) (*UserEmail, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, "EMAIL_NOT_FOUND", "Email not found")
+		return nil, false
+	}
+	var email UserEmail
+	if err := db.Where("id = ? AND user_id = ?", id, userID).First(&email).Error; err != nil {
+		respondLookupError(c, err, "EMAIL_NOT_FOUND", "Email not found", "Failed to fetch email")
+		return nil, false
+	}
+	return &email, true
+}
+
+// lockUser takes the user's row lock, so concurrent changes to one account's
+// addresses run one at a time
+func lockUser(tx *gorm.DB, userID string) error {
+	var user User
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&user, "id = ?", userID).Error
+}
+
+// ListEmails returns the user's addresses, primary first
+func ListEmails(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+
+		var emails []UserEmail
+		err := retryRead(c.Request.Context(), func() error {
+			return db.Where("user_id = ?", userID).Order("is_primary DESC, created_at ASC").Find(&emails).Error
+		})
+		if err != nil {
+			respondDBError(c, err, "Failed to fetch emails")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"emails": emails})
+	}
+}
+
+// AddEmail adds a secondary address and sends it a verification link. It
+// can't be promoted or receive password resets until verified.
+func AddEmail(db *gorm.DB, emailService *EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+
+		var req AddEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		address, err := validateEmail(req.Email)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "INVALID_EMAIL", "Invalid email address")
+			return
+		}
+
+		token, err := linkTokenPolicy.Generate()
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to generate token")
+			return
+		}
+		sentAt := time.Now()
+		email := UserEmail{
+			UserID:                uuid.MustParse(userID),
+			Email:                 address,
+			VerificationTokenHash: hashToken(token),
+			VerificationSentAt:    &sentAt,
+		}
+
+		var count int64
+		errLimit := errors.New("email limit reached")
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := lockUser(tx, userID); err != nil {
+				return err
+			}
+			if err := tx.Model(&UserEmail{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count >= int64(maxEmailsPerUser()) {
+				return errLimit
+			}
+			// Primary addresses are also on users, including accounts pending deletion
+			if inUse, err := emailInUse(tx, address); err != nil {
+				return err
+			} else if inUse {
+				return errEmailTaken
+			}
+			if err := tx.Create(&email).Error; err != nil {
+				return err
+			}
+			var owner User
+			if err := tx.Select("locale", "preferred_language").First(&owner, "id = ?", userID).Error; err != nil {
+				return err
+			}
+			return emailService.Send(tx, address, owner.EffectiveLocale(), NewSecondaryEmailVerificationEmail(token))
+		})
+		if errors.Is(err, errLimit) {
+			respondError(c, http.StatusConflict, "EMAIL_LIMIT_REACHED", "Email limit reached",
+				gin.H{"limit": maxEmailsPerUser(), "current": count})
+			return
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			respondError(c, http.StatusConflict, "EMAIL_TAKEN", "Email already registered")
+			return
+		}
+		if err != nil {
+			respondDBError(c, err, "Failed to add email")
+			return
+		}
+
+		c.JSON(http.StatusCreated, email)
+	}
+}
+
+// VerifySecondaryEmail confirms an added address from its emailed link
+func VerifySecondaryEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		token := c.Query("token")
+		if token == "" {
+			respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Missing verification token")
+			return
+		}
+
+		var email UserEmail
+		if err := db.Where("verification_token_hash = ?", hashToken(token)).First(&email).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Invalid verification token")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+		if email.VerificationSentAt == nil || time.Now().After(email.VerificationSentAt.Add(verificationTokenTTL())) {
+			respondError(c, http.StatusBadRequest, "TOKEN_EXPIRED", "Token has expired")
+			return
+		}
+
+		// The first account to verify an address owns it; other accounts'
+		// claims on it are dropped
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			var primaries int64
+			if err := tx.Unscoped().Model(&User{}).Where("email = ? AND id <> ?", email.Email, email.UserID).Count(&primaries).Error; err != nil {
+				return err
+			}
+			if primaries > 0 {
+				return errEmailTaken
+			}
+			if err := tx.Model(&email).Updates(map[string]interface{}{
+				"verified_at":             time.Now(),
+				"verification_token_hash": "",
+			}).Error; err != nil {
+				return err
+			}
+			return releaseEmailClaims(tx, email.UserID, email.Email)
+		})
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			respondError(c, http.StatusConflict, "EMAIL_TAKEN", "Email already registered")
+			return
+		}
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to verify email")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully", "email": email.Email})
+	}
+}
+
+// PromoteEmail makes a verified secondary address the primary one, which is
+// then used to sign in. The previous primary stays on the account as a
+// secondary address and is told about the change.
+func PromoteEmail(db *gorm.DB, emailService *EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+
+		email, ok := findUserEmail(c, db, userID)
+		if !ok {
+			return
+		}
+
+		errNotVerified := errors.New("email not verified")
+		var user User
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+				return err
+			}
+			// The row read above may have been removed, promoted or replaced
+			// since, so the checks run on a fresh, locked copy
+			var locked UserEmail
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, "id = ? AND user_id = ?", email.ID, userID).Error; err != nil {
+				return err
+			}
+			*email = locked
+			if email.IsPrimary {
+				return nil
+			}
+			if !email.IsVerified() {
+				return errNotVerified
+			}
+			if err := tx.Model(&UserEmail{}).Where("user_id = ? AND is_primary", userID).Update("is_primary", false).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(email).Update("is_primary", true).Error; err != nil {
+				return err
+			}
+			// A pending email change would replace the address just promoted
+			if err := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+				"email":                   email.Email,
+				"is_verified":             true,
+				"verification_token":      "",
+				"pending_email":           "",
+				"email_change_token_hash": "",
+				"email_change_expires_at": nil,
+			}).Error; err != nil {
+				return err
+			}
+			return emailService.Send(tx, user.Email, user.EffectiveLocale(), EmailChangedEmail{NewEmail: email.Email})
+		})
+		if errors.Is(err, errNotVerified) {
+			respondError(c, http.StatusConflict, "EMAIL_NOT_VERIFIED", "Only a verified email can become primary")
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondError(c, http.StatusNotFound, "EMAIL_NOT_FOUND", "Email not found")
+			return
+		}
+		if err != nil {
+			respondDBError(c, err, "Failed to promote email")
+			return
+		}
+
+		c.JSON(http.StatusOK, email)
+	}
+}
+
+// RemoveEmail deletes a secondary address. The primary one can't be removed,
+// and neither can the account's last verified address.
+func RemoveEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		userID := c.GetString("user_id")
+
+		email, ok := findUserEmail(c, db, userID)
+		if !ok {
+			return
+		}
+		if email.IsPrimary {
+			respondError(c, http.StatusConflict, "PRIMARY_EMAIL", "Promote another email before removing the primary one")
+			return
+		}
+
+		errLastVerified := errors.New("last verified email")
+		err := WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := lockUser(tx, userID); err != nil {
+				return err
+			}
+			if email.IsVerified() {
+				var others int64
+				if err := tx.Model(&UserEmail{}).
+					Where("user_id = ? AND id <> ? AND verified_at IS NOT NULL", userID, email.ID).
+					Count(&others).Error; err != nil {
+					return err
+				}
+				if others == 0 {
+					return errLastVerified
+				}
+			}
+			return tx.Delete(email).Error
+		})
+		if errors.Is(err, errLastVerified) {
+			respondError(c, http.StatusConflict, "LAST_VERIFIED_EMAIL", "An account must keep at least one verified email")
+			return
+		}
+		if err != nil {
+			respondDBError(c, err, "Failed to remove email")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email removed successfully"})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var userEmailColumns = []string{"id", "user_id", "email", "is_primary", "verified_at"}
+
+func TestPromoteEmailRechecksLockedRow(t *testing.T) {
+	userID, emailID := uuid.New(), uuid.New()
+	db, fake := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+		switch {
+		case strings.HasPrefix(query, `SELECT * FROM "user_emails"`) && strings.HasSuffix(query, "FOR UPDATE"):
+			// Another request unverified the address after the first read
+			return fakeResult{columns: userEmailColumns, rows: [][]driver.Value{{emailID.String(), userID.String(), "ada@example.org", false, nil}}}
+		case strings.HasPrefix(query, `SELECT * FROM "user_emails"`):
+			return fakeResult{columns: userEmailColumns, rows: [][]driver.Value{{emailID.String(), userID.String(), "ada@example.org", false, time.Now()}}}
+		case strings.HasPrefix(query, `SELECT * FROM "users"`):
+			return fakeResult{columns: []string{"id", "email"}, rows: [][]driver.Value{{userID.String(), "ada@example.com"}}}
+		}
+		return fakeResult{affected: 1}
+	})
+
+	w := serve(PromoteEmail(db, newTestEmailService(t, db)), "/profile/emails/:id/primary", http.MethodPost, "/profile/emails/"+emailID.String()+"/primary", "", userID.String())
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d (body %s)", w.Code, http.StatusConflict, w.Body.String())
+	}
+	if got := decodeError(t, w).Error.Code; got != "EMAIL_NOT_VERIFIED" {
+		t.Errorf("code = %q, want EMAIL_NOT_VERIFIED", got)
+	}
+	if fake.executed(`UPDATE "users"`) || fake.executed(`UPDATE "user_emails"`) {
+		t.Error("an address that is no longer verified was promoted")
+	}
+}
+
+func TestVerifySecondaryEmailOwnershipConflict(t *testing.T) {
+	t.Setenv("VERIFICATION_TOKEN_TTL", "1h")
+	tests := []struct {
+		name      string
+		primaries int64
+		updateErr error
+	}{
+		{name: "primary on another account", primaries: 1},
+		{name: "verified on another account", updateErr: &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: userEmailsEmailIndex}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+				switch {
+				case strings.HasPrefix(query, `SELECT * FROM "user_emails"`):
+					return fakeResult{
+						columns: []string{"id", "user_id", "email", "verification_sent_at"},
+						rows:    [][]driver.Value{{uuid.NewString(), uuid.NewString(), "ada@example.org", time.Now()}},
+					}
+				case strings.HasPrefix(query, `SELECT count(*) FROM "users"`):
+					return fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{tt.primaries}}}
+				case strings.HasPrefix(query, `UPDATE "user_emails"`):
+					return fakeResult{err: tt.updateErr}
+				}
+				return fakeResult{}
+			})
+
+			w := serve(VerifySecondaryEmail(db), "/verify-secondary-email", http.MethodGet, "/verify-secondary-email?token=abc", "", "")
+			if w.Code != http.StatusConflict {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, http.StatusConflict, w.Body.String())
+			}
+			if got := decodeError(t, w).Error.Code; got != "EMAIL_TAKEN" {
+				t.Errorf("code = %q, want EMAIL_TAKEN", got)
+			}
+			if fake.executed(`DELETE FROM "user_emails"`) {
+				t.Error("other accounts' claims were released without verifying the address")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errInvalidUsername  = errors.New("username must be 3-30 characters of letters, digits, '.', '_' or '-', starting with a letter or digit")
+	errReservedUsername = errors.New("username is reserved")
+)
+
+// usernamePattern applies to the lowercased username
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{2,29}$`)
+
+// reservedUsernames returns the blocked names from USERNAME_RESERVED
+func reservedUsernames() map[string]bool {
+	reserved := map[string]bool{}
+	for _, name := range strings.Split(getEnv("USERNAME_RESERVED", "admin,administrator,root,system,support,api,null"), ",") {
+		if name = normalizeUsername(name); name != "" {
+			reserved[name] = true
+		}
+	}
+	return reserved
+}
+
+// normalizeUsername trims and lowercases a username; usernames are stored
+// lowercased so the unique index is case-insensitive
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// validateUsername normalizes the username and checks format and reserved names
+func validateUsername(username string) (string, error) {
+	normalized := normalizeUsername(username)
+	if !usernamePattern.MatchString(normalized) {
+		return "", errInvalidUsername
+	}
+	if reservedUsernames()[normalized] {
+		return "", errReservedUsername
+	}
+	return normalized, nil
+}
+
+// usernameTaken reports whether any account, including ones pending deletion, uses username
+func usernameTaken(db *gorm.DB, username string) (bool, error) {
+	var count int64
+	err := db.Unscoped().Model(&User{}).Where("username = ?", username).Count(&count).Error
+	return count > 0, err
+}
+
+// CheckUsername reports whether ?username= is valid and free for signup
+func CheckUsername(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		username, err := validateUsername(c.Query("username"))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"username": c.Query("username"), "available": false, "reason": err.Error()})
+			return
+		}
+
+		taken, err := usernameTaken(db, username)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+		if taken {
+			c.JSON(http.StatusOK, gin.H{"username": username, "available": false, "reason": "username is already taken"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"username": username, "available": true})
+	}
+}
+
+// findUserByIdentifier looks a user up by email when identifier contains "@",
+// otherwise by username
+func findUserByIdentifier(db *gorm.DB, identifier string, user *User) error {
+	if strings.Contains(identifier, "@") {
+		return db.Where("email = ?", normalizeEmail(identifier)).First(user).Error
+	}
+	return db.Where("username = ?", normalizeUsername(identifier)).First(user).Error
+}
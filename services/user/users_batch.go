@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func maxBatchUserIDs() int {
+	return getEnvInt("USERS_BATCH_MAX_IDS", 100)
+}
+
+// BatchUsersRequest names the users to look up
+type BatchUsersRequest struct {
+	IDs []string `json:"ids" binding:"required,min=1,dive,uuid"`
+}
+
+// PublicUser is what other services may show about a user, such as the name
+// on an order; it has no contact details or account state
+type PublicUser struct {
+	ID             string  `json:"id"`
+	Username       *string `json:"username,omitempty"`
+	FirstName      string  `json:"first_name"`
+	LastName       string  `json:"last_name"`
+	ProfilePicture string  `json:"profile_picture"`
+}
+
+// BatchGetUsers resolves up to USERS_BATCH_MAX_IDS user IDs in one query,
+// keyed by ID. Unknown and deleted users are left out rather than failing
+// the batch.
+func BatchGetUsers(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req BatchUsersRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+		if limit := maxBatchUserIDs(); len(req.IDs) > limit {
+			respondError(c, http.StatusBadRequest, "BATCH_TOO_LARGE", "Too many user IDs", gin.H{"max": limit})
+			return
+		}
+
+		users := []User{}
+		err := retryRead(c.Request.Context(), func() error {
+			return db.Select("id", "username", "first_name", "last_name", "profile_picture").
+				Where("id IN ?", req.IDs).
+				Find(&users).Error
+		})
+		if err != nil {
+			respondDBError(c, err, "Failed to fetch users")
+			return
+		}
+
+		result := make(map[string]PublicUser, len(users))
+		for _, u := range users {
+			picture := u.ProfilePicture
+			if picture == "" {
+				picture = defaultAvatarURL()
+			}
+			result[u.ID.String()] = PublicUser{
+				ID:             u.ID.String(),
+				Username:       u.Username,
+				FirstName:      u.FirstName,
+				LastName:       u.LastName,
+				ProfilePicture: picture,
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"users": result})
+	}
+}
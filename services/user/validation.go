@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+)
+
+var errInvalidEmail = errors.New("invalid email address")
+
+// normalizeEmail trims and lowercases an address so lookups and the unique
+// index treat "Foo@Example.com " and "foo@example.com" as the same account
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validateEmail normalizes the address and checks it is a bare RFC 5322
+// address (no display name or angle brackets)
+func validateEmail(email string) (string, error) {
+	normalized := normalizeEmail(email)
+	parsed, err := mail.ParseAddress(normalized)
+	if err != nil || parsed.Address != normalized {
+		return "", errInvalidEmail
+	}
+	return normalized, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arohanajit/user-service/middleware"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func requireEmailVerification() bool {
+	return getEnvBool("REQUIRE_EMAIL_VERIFICATION", true)
+}
+
+// verificationTokenTTL is EMAIL_VERIFICATION_TTL, which defaults to 24h for
+// links and to the shorter otpDefaultTTL for numeric codes
+func verificationTokenTTL() time.Duration {
+	if verificationTokens.Policy().Numeric() {
+		return getEnvDuration("EMAIL_VERIFICATION_TTL", otpDefaultTTL)
+	}
+	return getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+}
+
+func verificationResendInterval() time.Duration {
+	return getEnvDuration("VERIFICATION_RESEND_INTERVAL", time.Minute)
+}
+
+// VerifyEmail marks the account owning the token as verified. A numeric code
+// only identifies an account together with ?email=, and wrong codes count
+// towards OTP_MAX_ATTEMPTS.
+func VerifyEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		token := strings.TrimSpace(c.Query("token"))
+		if token == "" {
+			respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Missing verification token")
+			return
+		}
+		numeric := verificationTokens.Policy().Numeric()
+
+		var user User
+		var err error
+		if numeric {
+			email := c.Query("email")
+			if email == "" {
+				respondError(c, http.StatusBadRequest, "INVALID_REQUEST", "Email is required with a verification code")
+				return
+			}
+			err = db.Where("email = ?", normalizeEmail(email)).First(&user).Error
+		} else {
+			err = db.Where("verification_token = ?", token).First(&user).Error
+		}
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Invalid verification token")
+				return
+			}
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+			return
+		}
+
+		// A guess at a numeric code is counted before it is compared, so
+		// concurrent requests can't get more than OTP_MAX_ATTEMPTS at one code
+		if numeric && user.VerificationToken != "" {
+			discard := map[string]interface{}{"verification_token": ""}
+			reserved, err := reserveOTPAttempt(db, user.ID, "verification_attempts", otpMaxAttempts())
+			if err != nil {
+				respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Database error")
+				return
+			}
+			if !reserved || !user.VerificationTokenMatches(token) {
+				if err := discardSpentOTP(db, user.ID, "verification_attempts", discard); err != nil {
+					middleware.Logger(c).Error("Failed to discard verification code", zap.Error(err))
+				}
+				respondError(c, http.StatusBadRequest, "INVALID_TOKEN", "Invalid verification token")
+				return
+			}
+		}
+
+		if !user.IsVerificationTokenValid(token, verificationTokenTTL()) {
+			respondError(c, http.StatusBadRequest, "TOKEN_EXPIRED", "Token has expired")
+			return
+		}
+
+		err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"is_verified":           true,
+				"verification_token":    "",
+				"verification_attempts": 0,
+			}).Error; err != nil {
+				return err
+			}
+			return syncPrimaryEmail(tx, &user)
+		})
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to verify email")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+	}
+}
+
+// ResendVerification issues a new verification token, at most once per resend
+// interval. Every outcome past validation, including a resend skipped for the
+// interval or a failure, gets the same 200 so the response never reveals whether
+// an account exists; callers are rate limited by IP on the route instead.
+func ResendVerification(db *gorm.DB, emailService *EmailService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := db.WithContext(c.Request.Context())
+		var req ResendVerificationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondBindError(c, err)
+			return
+		}
+
+		// Don't reveal whether the email exists or is already verified
+		genericResponse := gin.H{"message": "If your account requires verification, a new email has been sent"}
+
+		var user User
+		if err := db.Where("email = ?", normalizeEmail(req.Email)).First(&user).Error; err != nil || user.IsVerified {
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				middleware.Logger(c).Error("Failed to look up account for verification resend", zap.Error(err))
+			}
+			c.JSON(http.StatusOK, genericResponse)
+			return
+		}
+
+		if user.VerificationSentAt != nil && time.Since(*user.VerificationSentAt) < verificationResendInterval() {
+			c.JSON(http.StatusOK, genericResponse)
+			return
+		}
+
+		token, err := user.GenerateVerificationToken()
+		if err == nil {
+			err = WithTransaction(c.Request.Context(), db, func(tx *gorm.DB) error {
+				if err := tx.Model(&user).Updates(map[string]interface{}{
+					"verification_token":    user.VerificationToken,
+					"verification_sent_at":  user.VerificationSentAt,
+					"verification_attempts": 0,
+				}).Error; err != nil {
+					return err
+				}
+				return emailService.Send(tx, user.Email, user.EffectiveLocale(), NewVerificationEmail(token))
+			})
+		}
+		if err != nil {
+			middleware.Logger(c).Error("Failed to resend verification email", zap.Error(err))
+		}
+
+		c.JSON(http.StatusOK, genericResponse)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestVerifyEmailReservesAttempt(t *testing.T) {
+	t.Setenv("EMAIL_VERIFICATION_TOKEN_FORMAT", "numeric")
+	tests := []struct {
+		name       string
+		submitted  string
+		reserved   bool
+		wantStatus int
+		wantVerify bool
+	}{
+		{name: "correct code", submitted: "123456", reserved: true, wantStatus: http.StatusOK, wantVerify: true},
+		{name: "wrong code", submitted: "654321", reserved: true, wantStatus: http.StatusBadRequest},
+		// Another request took the last attempt after the account was read
+		{name: "attempts used up", submitted: "123456", reserved: false, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := uuid.New()
+			stored := verificationTokenValue(verificationTokens.Policy(), userID, "123456")
+			db, fake := newFakeDB(t, func(query string, args []driver.NamedValue) fakeResult {
+				switch {
+				case strings.HasPrefix(query, `SELECT * FROM "users"`):
+					return fakeResult{
+						columns: []string{"id", "email", "verification_token", "verification_sent_at"},
+						rows:    [][]driver.Value{{userID.String(), "ada@example.com", stored, time.Now()}},
+					}
+				case strings.Contains(query, "verification_attempts < "):
+					if tt.reserved {
+						return fakeResult{affected: 1}
+					}
+					return fakeResult{}
+				}
+				return fakeResult{affected: 1}
+			})
+
+			w := serve(VerifyEmail(db), "/verify-email", http.MethodGet, "/verify-email?email=ada@example.com&token="+tt.submitted, "", "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if got := decodeError(t, w).Error.Code; got != "INVALID_TOKEN" {
+					t.Errorf("code = %q, want INVALID_TOKEN", got)
+				}
+			}
+			if !fake.executed("verification_attempts < ") {
+				t.Error("no attempt was reserved before comparing the code")
+			}
+			if verified := fake.executed(`"is_verified"=`); verified != tt.wantVerify {
+				t.Errorf("email marked verified = %v, want %v", verified, tt.wantVerify)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Lifecycle event types sent to webhook subscribers
+const (
+	EventUserRegistered = "user.registered"
+	EventUserUpdated    = "user.updated"
+	EventUserDeleted    = "user.deleted"
+)
+
+const (
+	// webhookBatchSize is how many deliveries one dispatcher tick claims
+	webhookBatchSize  = 50
+	webhookMaxBackoff = time.Hour
+)
+
+// WebhookDelivery is an outbox row: one event bound for one subscriber URL.
+// Rows are written in the same transaction as the change they describe and
+// stay until delivered, so events survive restarts.
+type WebhookDelivery struct {
+	ID            uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()"`
+	CreatedAt     time.Time
+	EventID       uuid.UUID  `gorm:"type:uuid;index;not null"`
+	EventType     string     `gorm:"not null"`
+	Endpoint      string     `gorm:"not null"`
+	Payload       []byte     `gorm:"not null"`
+	Attempts      int        `gorm:"default:0;not null"`
+	NextAttemptAt time.Time  `gorm:"index;not null"`
+	DeliveredAt   *time.Time `gorm:"index"`
+	LastError     string
+}
+
+func (d WebhookDelivery) outboxID() uuid.UUID { return d.ID }
+
+// WebhookEvent is the JSON body POSTed to subscribers
+type WebhookEvent struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+// webhookUserData is the user representation included in lifecycle events
+type webhookUserData struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Role      string    `json:"role"`
+}
+
+// webhookEndpoints returns the subscriber URLs from WEBHOOK_URLS (comma-separated)
+func webhookEndpoints() []string {
+	return getEnvList("WEBHOOK_URLS", "")
+}
+
+var errWebhookUnsigned = errors.New("WEBHOOK_SECRET is not set, refusing to deliver an unsigned webhook")
+
+// validateWebhookConfig requires WEBHOOK_SECRET whenever WEBHOOK_URLS is set,
+// so subscribers can always authenticate deliveries
+func validateWebhookConfig() error {
+	if len(webhookEndpoints()) > 0 && getEnv("WEBHOOK_SECRET", "") == "" {
+		return errors.New("WEBHOOK_SECRET is required when WEBHOOK_URLS is set")
+	}
+	return nil
+}
+
+// publishUserEvent queues a lifecycle event for every subscriber and for the
+// event broker. Pass the transaction making the change so the event commits
+// or rolls back with it.
+func publishUserEvent(tx *gorm.DB, events EventPublisher, eventType string, user *User) error {
+	event := WebhookEvent{
+		ID:        uuid.New(),
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		Data: webhookUserData{
+			UserID:    user.ID,
+			Email:     user.Email,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Role:      user.Role,
+		},
+	}
+	if err := enqueueDomainEvent(tx, events, event, user.ID); err != nil {
+		return err
+	}
+
+	endpoints := webhookEndpoints()
+	if len(endpoints) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]WebhookDelivery, len(endpoints))
+	for i, endpoint := range endpoints {
+		deliveries[i] = WebhookDelivery{
+			EventID:       event.ID,
+			EventType:     eventType,
+			Endpoint:      endpoint,
+			Payload:       payload,
+			NextAttemptAt: event.Timestamp,
+		}
+	}
+	return tx.Create(&deliveries).Error
+}
+
+// signWebhook computes the X-Webhook-Signature value over "timestamp.payload"
+// so receivers can reject replayed deliveries by checking the timestamp
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher delivers outbox rows with exponential backoff
+type WebhookDispatcher struct {
+	db          *gorm.DB
+	client      *http.Client
+	secret      string
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+// NewWebhookDispatcher reads WEBHOOK_* settings
+func NewWebhookDispatcher(db *gorm.DB) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		db:          db,
+		client:      &http.Client{Timeout: getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)},
+		secret:      getEnv("WEBHOOK_SECRET", ""),
+		maxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10),
+		baseDelay:   getEnvDuration("WEBHOOK_RETRY_BASE_DELAY", 10*time.Second),
+	}
+}
+
+// Start polls the outbox every interval until ctx is cancelled
+func (d *WebhookDispatcher) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	workerHeartbeats.Register("webhook-dispatcher", defaultHeartbeatMaxAge(interval)+d.client.Timeout)
+	go func() {
+		defer ticker.Stop()
+		defer workerHeartbeats.Unregister("webhook-dispatcher")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				workerHeartbeats.Beat("webhook-dispatcher")
+				d.dispatch(ctx)
+			}
+		}
+	}()
+}
+
+func (d *WebhookDispatcher) dispatch(ctx context.Context) {
+	batch, err := claimOutbox[WebhookDelivery](ctx, d.db, d.maxAttempts, webhookBatchSize)
+	if err != nil {
+		zap.L().Error("Failed to claim webhook deliveries", zap.Error(err))
+		return
+	}
+
+	lease := newOutboxLease[WebhookDelivery](d.db, d.client.Timeout)
+	for i, delivery := range batch {
+		if ctx.Err() != nil {
+			return
+		}
+		workerHeartbeats.Beat("webhook-dispatcher")
+		lease.keep(batch[i:])
+		err := d.send(ctx, &delivery)
+		attempts := delivery.Attempts + 1
+		backoff := outboxBackoff(d.baseDelay, webhookMaxBackoff, attempts)
+		if err != nil {
+			log := zap.L().With(zap.String("event_id", delivery.EventID.String()), zap.String("endpoint", delivery.Endpoint), zap.Int("attempt", attempts), zap.Error(err))
+			if attempts >= d.maxAttempts {
+				log.Error("Giving up on webhook delivery")
+			} else {
+				log.Warn("Webhook delivery failed, will retry", zap.Duration("retry_in", backoff))
+			}
+		}
+		recordOutboxAttempt(d.db, delivery, "webhook", attempts, d.maxAttempts, backoff, err)
+	}
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, delivery *WebhookDelivery) error {
+	if d.secret == "" {
+		return errWebhookUnsigned
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Endpoint, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", delivery.EventID.String())
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(d.secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("subscriber returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateWebhookConfig(t *testing.T) {
+	tests := []struct {
+		urls, secret string
+		wantErr      bool
+	}{
+		{urls: "", secret: ""},
+		{urls: "https://hooks.example.com", secret: "s3cret"},
+		{urls: "https://hooks.example.com", secret: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("WEBHOOK_URLS", tt.urls)
+		t.Setenv("WEBHOOK_SECRET", tt.secret)
+		if err := validateWebhookConfig(); (err != nil) != tt.wantErr {
+			t.Errorf("urls %q secret %q: err = %v, want error %v", tt.urls, tt.secret, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookSendSigns(t *testing.T) {
+	var signature, timestamp string
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		signature = r.Header.Get("X-Webhook-Signature")
+		timestamp = r.Header.Get("X-Webhook-Timestamp")
+	}))
+	defer server.Close()
+
+	delivery := &WebhookDelivery{EventID: uuid.New(), EventType: "user.registered", Endpoint: server.URL, Payload: []byte(`{"id":"1"}`)}
+	d := &WebhookDispatcher{client: server.Client(), secret: "s3cret"}
+	if err := d.send(context.Background(), delivery); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if want := signWebhook("s3cret", timestamp, delivery.Payload); signature != want {
+		t.Errorf("signature = %q, want %q", signature, want)
+	}
+
+	// Without a secret nothing is sent
+	d.secret = ""
+	if err := d.send(context.Background(), delivery); !errors.Is(err, errWebhookUnsigned) {
+		t.Fatalf("unsigned send: err = %v, want %v", err, errWebhookUnsigned)
+	}
+	if hits != 1 {
+		t.Errorf("subscriber received %d deliveries, want only the signed one", hits)
+	}
+}